@@ -0,0 +1,170 @@
+package actionscachetest
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	actionscache "github.com/tonistiigi/go-actions-cache"
+)
+
+// FlakyTransport sends requests with Base after a random latency and fails
+// them at random like a flaky cache service, to soak-test retries and
+// timeouts. Failures are drawn from a source seeded with Seed, so a run
+// can be reproduced with the same requests.
+type FlakyTransport struct {
+	// Base defaults to http.DefaultTransport when nil.
+	Base http.RoundTripper
+	// Match defaults to matching every request when nil. Other requests
+	// are sent without latency or failures.
+	Match func(*http.Request) bool
+	// Latency delays every request by Latency plus up to Jitter.
+	Latency time.Duration
+	Jitter  time.Duration
+	// Clock sleeps the latency, the system clock when nil.
+	Clock actionscache.Clock
+	// RateLimit is the probability of responding 429 with a Retry-After of
+	// RetryAfter, rounded to seconds.
+	RateLimit  float64
+	RetryAfter time.Duration
+	// ServerError is the probability of responding 500, 502, 503 or 504.
+	ServerError float64
+	// Reset is the probability of failing the request with a connection
+	// reset before it is sent.
+	Reset float64
+	Seed  int64
+
+	mu    sync.Mutex
+	rnd   *rand.Rand
+	stats FlakyStats
+}
+
+// FlakyStats counts the requests matched by a FlakyTransport.
+type FlakyStats struct {
+	Requests     int
+	RateLimited  int
+	ServerErrors int
+	Resets       int
+}
+
+// serverErrors are the statuses of the server errors of a FlakyTransport.
+var serverErrors = []int{
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+type flakyFault int
+
+const (
+	faultNone flakyFault = iota
+	faultRateLimit
+	faultServerError
+	faultReset
+)
+
+// RoundTrip delays req and fails it or sends it with Base.
+func (t *FlakyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if t.Match != nil && !t.Match(req) {
+		return base.RoundTrip(req)
+	}
+	delay, fault, status := t.draw()
+	if delay > 0 {
+		if err := t.sleep(req, delay); err != nil {
+			closeBody(req)
+			return nil, err
+		}
+	}
+	switch fault {
+	case faultReset:
+		closeBody(req)
+		return nil, &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}
+	case faultRateLimit, faultServerError:
+		closeBody(req)
+		resp := statusResponse(req, status)
+		if fault == faultRateLimit && t.RetryAfter > 0 {
+			resp.Header.Set("Retry-After", strconv.Itoa(int((t.RetryAfter+time.Second-1)/time.Second)))
+		}
+		return resp, nil
+	}
+	return base.RoundTrip(req)
+}
+
+// draw returns the latency and fault of a request.
+func (t *FlakyTransport) draw() (time.Duration, flakyFault, int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.rnd == nil {
+		t.rnd = rand.New(rand.NewSource(t.Seed))
+	}
+	t.stats.Requests++
+	delay := t.Latency
+	if t.Jitter > 0 {
+		delay += time.Duration(t.rnd.Int63n(int64(t.Jitter) + 1))
+	}
+	p := t.rnd.Float64()
+	switch {
+	case p < t.Reset:
+		t.stats.Resets++
+		return delay, faultReset, 0
+	case p < t.Reset+t.RateLimit:
+		t.stats.RateLimited++
+		return delay, faultRateLimit, http.StatusTooManyRequests
+	case p < t.Reset+t.RateLimit+t.ServerError:
+		t.stats.ServerErrors++
+		return delay, faultServerError, serverErrors[t.rnd.Intn(len(serverErrors))]
+	}
+	return delay, faultNone, 0
+}
+
+func (t *FlakyTransport) sleep(req *http.Request, d time.Duration) error {
+	if t.Clock != nil {
+		return t.Clock.Sleep(req.Context(), d)
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-req.Context().Done():
+		return req.Context().Err()
+	}
+}
+
+// Stats returns the counts of the requests matched so far.
+func (t *FlakyTransport) Stats() FlakyStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.stats
+}
+
+func closeBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
+
+func statusResponse(req *http.Request, status int) *http.Response {
+	return &http.Response{
+		Status:     http.StatusText(status),
+		StatusCode: status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Content-Type": []string{"text/plain"}},
+		Body:       ioutil.NopCloser(strings.NewReader(http.StatusText(status))),
+		Request:    req,
+	}
+}
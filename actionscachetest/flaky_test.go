@@ -0,0 +1,75 @@
+package actionscachetest
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	actionscache "github.com/tonistiigi/go-actions-cache"
+)
+
+func TestFlakyTransport(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	ctx := context.TODO()
+
+	clock := NewClock(time.Unix(1600000000, 0))
+	tr := &FlakyTransport{
+		Latency:     100 * time.Millisecond,
+		Jitter:      50 * time.Millisecond,
+		Clock:       clock,
+		RateLimit:   0.1,
+		RetryAfter:  time.Second,
+		ServerError: 0.1,
+		Reset:       0.1,
+		Seed:        4,
+	}
+	c, err := s.Cache(actionscache.WithClock(clock), actionscache.WithTransport(tr), actionscache.WithUploadChunkSize(1024))
+	require.NoError(t, err)
+	c.RetryPolicy = &actionscache.RetryPolicy{MaxAttempts: 20, MinBackoff: time.Second, MaxBackoff: time.Second}
+
+	dt := bytes.Repeat([]byte("foobar"), 2000)
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+	ce, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	buf := &bytes.Buffer{}
+	require.NoError(t, ce.Download(ctx, buf))
+	require.Equal(t, dt, buf.Bytes())
+
+	st := tr.Stats()
+	require.Greater(t, st.Requests, 0)
+	require.Greater(t, st.RateLimited, 0)
+	require.Greater(t, st.ServerErrors, 0)
+	require.Greater(t, st.Resets, 0)
+	var latencies int
+	for _, d := range clock.Sleeps() {
+		if d >= 100*time.Millisecond && d <= 150*time.Millisecond {
+			latencies++
+		}
+	}
+	require.Equal(t, st.Requests, latencies)
+}
+
+func TestFlakyTransportMatch(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	tr := &FlakyTransport{
+		Match:       func(r *http.Request) bool { return r.Method == "GET" },
+		ServerError: 1,
+	}
+	c, err := s.Cache(actionscache.WithTransport(tr))
+	require.NoError(t, err)
+	c.RetryPolicy = &actionscache.RetryPolicy{MaxAttempts: 1}
+
+	ctx := context.TODO()
+	require.NoError(t, c.Save(ctx, "foo", strings.NewReader("foo"), 3))
+	_, err = c.Load(ctx, "foo")
+	require.Error(t, err)
+	require.Equal(t, FlakyStats{Requests: 1, ServerErrors: 1}, tr.Stats())
+}
@@ -0,0 +1,274 @@
+package actionscachetest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// Interaction is a request and its response recorded by a Recorder.
+type Interaction struct {
+	Method         string      `json:"method"`
+	URL            string      `json:"url"`
+	RequestHeader  http.Header `json:"requestHeader,omitempty"`
+	RequestBody    Body        `json:"requestBody,omitempty"`
+	Status         int         `json:"status"`
+	ResponseHeader http.Header `json:"responseHeader,omitempty"`
+	ResponseBody   Body        `json:"responseBody,omitempty"`
+}
+
+// Body is a recorded body. It is encoded as a JSON string if it is valid
+// UTF-8, so fixtures can be reviewed, and as {"base64": "..."} otherwise.
+type Body []byte
+
+func (b Body) MarshalJSON() ([]byte, error) {
+	if utf8.Valid(b) {
+		return json.Marshal(string(b))
+	}
+	return json.Marshal(struct {
+		Base64 []byte `json:"base64"`
+	}{b})
+}
+
+func (b *Body) UnmarshalJSON(dt []byte) error {
+	var s string
+	if err := json.Unmarshal(dt, &s); err == nil {
+		*b = Body(s)
+		return nil
+	}
+	var v struct {
+		Base64 []byte `json:"base64"`
+	}
+	if err := json.Unmarshal(dt, &v); err != nil {
+		return errors.WithStack(err)
+	}
+	*b = v.Base64
+	return nil
+}
+
+// SecretHeaders are removed from recorded interactions by Sanitize.
+var SecretHeaders = []string{"Authorization", "Cookie", "Set-Cookie"}
+
+// SecretParams are the query parameters whose values Sanitize replaces, in
+// URLs and in bodies, eg. the signatures of Azure SAS URLs.
+var SecretParams = []string{"sig"}
+
+// Redacted replaces secret values in sanitized interactions.
+const Redacted = "REDACTED"
+
+// Sanitize removes the SecretHeaders from in and redacts the values of the
+// SecretParams so recorded fixtures can be committed.
+func Sanitize(in *Interaction) {
+	for _, k := range SecretHeaders {
+		in.RequestHeader.Del(k)
+		in.ResponseHeader.Del(k)
+	}
+	in.URL = sanitizeURL(in.URL)
+	in.RequestBody = sanitizeBody(in.RequestBody)
+	in.ResponseBody = sanitizeBody(in.ResponseBody)
+}
+
+func sanitizeURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return s
+	}
+	q := u.Query()
+	changed := false
+	for _, k := range SecretParams {
+		if q.Get(k) != "" {
+			q.Set(k, Redacted)
+			changed = true
+		}
+	}
+	if changed {
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
+}
+
+func sanitizeBody(dt []byte) []byte {
+	for _, k := range SecretParams {
+		re := regexp.MustCompile(`([?&]|\\u0026)` + regexp.QuoteMeta(k) + `=[^&"\s\\]*`)
+		dt = re.ReplaceAll(dt, []byte("${1}"+k+"="+Redacted))
+	}
+	return dt
+}
+
+// Recorder sends requests with Base and records them with their responses,
+// eg. to create fixtures for a Replayer from the real cache service with a
+// Cache created with actionscache.WithTransport.
+type Recorder struct {
+	// Base defaults to http.DefaultTransport when nil.
+	Base http.RoundTripper
+	// Sanitize is called with every recorded interaction, the package
+	// Sanitize when nil.
+	Sanitize func(*Interaction)
+
+	mu           sync.Mutex
+	interactions []Interaction
+}
+
+// RoundTrip sends req with Base and records it.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		dt, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		reqBody = dt
+		req = req.Clone(req.Context())
+		req.Body = ioutil.NopCloser(bytes.NewReader(dt))
+	}
+	base := r.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+
+	in := Interaction{
+		Method:         req.Method,
+		URL:            req.URL.String(),
+		RequestHeader:  req.Header.Clone(),
+		RequestBody:    reqBody,
+		Status:         resp.StatusCode,
+		ResponseHeader: resp.Header.Clone(),
+		ResponseBody:   respBody,
+	}
+	sanitize := r.Sanitize
+	if sanitize == nil {
+		sanitize = Sanitize
+	}
+	sanitize(&in)
+	r.mu.Lock()
+	r.interactions = append(r.interactions, in)
+	r.mu.Unlock()
+	return resp, nil
+}
+
+// Interactions returns the interactions recorded so far.
+func (r *Recorder) Interactions() []Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Interaction(nil), r.interactions...)
+}
+
+// Save writes the recorded interactions as JSON to path, the format read
+// by LoadReplayer.
+func (r *Recorder) Save(path string) error {
+	dt, err := json.MarshalIndent(r.Interactions(), "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(ioutil.WriteFile(path, dt, 0644))
+}
+
+// Replayer responds to requests with the responses of recorded
+// interactions, without a network or a token. A request is answered by the
+// first unused interaction with its method, path and query, sanitized like
+// the recording. The host is ignored, so the cache URL of the replaying
+// Cache may differ from the recorded one.
+type Replayer struct {
+	mu           sync.Mutex
+	interactions []Interaction
+	used         []bool
+}
+
+// NewReplayer returns a Replayer of interactions.
+func NewReplayer(interactions []Interaction) *Replayer {
+	return &Replayer{interactions: interactions, used: make([]bool, len(interactions))}
+}
+
+// LoadReplayer returns a Replayer of the interactions saved to path by
+// Recorder.Save.
+func LoadReplayer(path string) (*Replayer, error) {
+	dt, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var interactions []Interaction
+	if err := json.Unmarshal(dt, &interactions); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse fixtures %s", path)
+	}
+	return NewReplayer(interactions), nil
+}
+
+// RoundTrip responds to req with a recorded response. Requests without one
+// fail.
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	want, err := replayKey(req.Method, sanitizeURL(req.URL.String()))
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, in := range r.interactions {
+		if r.used[i] {
+			continue
+		}
+		if k, err := replayKey(in.Method, in.URL); err != nil || k != want {
+			continue
+		}
+		r.used[i] = true
+		h := in.ResponseHeader.Clone()
+		if h == nil {
+			h = http.Header{}
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", in.Status, http.StatusText(in.Status)),
+			StatusCode:    in.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        h,
+			Body:          ioutil.NopCloser(bytes.NewReader(in.ResponseBody)),
+			ContentLength: int64(len(in.ResponseBody)),
+			Request:       req,
+		}, nil
+	}
+	return nil, errors.Errorf("no recorded response for %s", want)
+}
+
+// Unused returns the interactions that were not replayed, eg. to check
+// that a test sent all the recorded requests.
+func (r *Replayer) Unused() []Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []Interaction
+	for i, in := range r.interactions {
+		if !r.used[i] {
+			out = append(out, in)
+		}
+	}
+	return out
+}
+
+func replayKey(method, s string) (string, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return method + " " + u.EscapedPath() + "?" + u.Query().Encode(), nil
+}
@@ -0,0 +1,69 @@
+package actionscachetest
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	actionscache "github.com/tonistiigi/go-actions-cache"
+)
+
+func TestRecordReplay(t *testing.T) {
+	ctx := context.TODO()
+	dt := bytes.Repeat([]byte("foobar"), 100)
+	run := func(c *actionscache.Cache) {
+		require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+		ce, err := c.Load(ctx, "foo")
+		require.NoError(t, err)
+		require.NotNil(t, ce)
+		buf := &bytes.Buffer{}
+		require.NoError(t, ce.Download(ctx, buf))
+		require.Equal(t, dt, buf.Bytes())
+		ce, err = c.Load(ctx, "bar")
+		require.NoError(t, err)
+		require.Nil(t, ce)
+	}
+
+	for _, v2 := range []bool{false, true} {
+		newCache := actionscache.New
+		if v2 {
+			newCache = actionscache.NewV2
+		}
+		s := NewServer()
+		token, u := s.Token(), s.URL+"/"
+		rec := &Recorder{}
+		c, err := newCache(token, u, actionscache.WithTransport(rec))
+		require.NoError(t, err)
+		run(c)
+		s.Close()
+
+		p := filepath.Join(t.TempDir(), "fixtures.json")
+		require.NoError(t, rec.Save(p))
+		fixtures, err := ioutil.ReadFile(p)
+		require.NoError(t, err)
+		require.NotContains(t, string(fixtures), token)
+		require.NotContains(t, string(fixtures), "sig=actionscachetest")
+		require.Contains(t, string(fixtures), "foobar")
+		for _, in := range rec.Interactions() {
+			require.Empty(t, in.RequestHeader.Get("Authorization"))
+			require.NotContains(t, in.URL, "sig=actionscachetest")
+			require.NotContains(t, string(in.ResponseBody), "sig=actionscachetest")
+		}
+
+		rp, err := LoadReplayer(p)
+		require.NoError(t, err)
+		c, err = newCache(token, u, actionscache.WithTransport(rp))
+		require.NoError(t, err)
+		run(c)
+		require.Empty(t, rp.Unused())
+
+		req, err := http.NewRequest("GET", u+"unknown", nil)
+		require.NoError(t, err)
+		_, err = rp.RoundTrip(req)
+		require.Error(t, err)
+	}
+}
@@ -0,0 +1,282 @@
+// Package actionscachetest provides an in-memory implementation of the
+// GitHub Actions cache service for testing code that uses actionscache
+// without a runner token. Clock and the transports simulate time and
+// failing requests to test retries without sleeping or a network. Recorder
+// and Replayer capture the requests of a real service as fixtures and
+// replay them in tests.
+package actionscachetest
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	actionscache "github.com/tonistiigi/go-actions-cache"
+	"github.com/tonistiigi/go-actions-cache/internal/cachestore"
+)
+
+const twirpCacheService = "/twirp/github.actions.results.api.v1.CacheService/"
+
+// DefaultScope is the scope of tokens returned by Token without scopes.
+var DefaultScope = actionscache.Scope{
+	Scope:      "refs/heads/main",
+	Permission: actionscache.PermissionRead | actionscache.PermissionWrite,
+}
+
+// Server serves the v1 and v2 cache service APIs and the blob storage
+// entries are uploaded to and downloaded from. Entries are kept in memory
+// until the Server is closed.
+type Server struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	store *cachestore.Store
+}
+
+// NewServer starts a Server. The caller must Close it.
+func NewServer() *Server {
+	s := &Server{store: cachestore.New()}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Token returns a runtime token granting scopes, DefaultScope if none are
+// given. The token is not signed by GitHub and only useful with Server.
+func (s *Server) Token(scopes ...actionscache.Scope) string {
+	if len(scopes) == 0 {
+		scopes = []actionscache.Scope{DefaultScope}
+	}
+	dt, err := json.Marshal(scopes)
+	if err != nil {
+		panic(err)
+	}
+	tk, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"ac":  string(dt),
+		"iss": "vstoken.actions.githubusercontent.com",
+	}).SignedString([]byte("actionscachetest"))
+	if err != nil {
+		panic(err)
+	}
+	return tk
+}
+
+// Cache returns a Cache using the v1 API of s.
+func (s *Server) Cache(opts ...actionscache.Opt) (*actionscache.Cache, error) {
+	return actionscache.New(s.Token(), s.URL+"/", opts...)
+}
+
+// CacheV2 returns a Cache using the v2 API of s.
+func (s *Server) CacheV2(opts ...actionscache.Opt) (*actionscache.Cache, error) {
+	return actionscache.NewV2(s.Token(), s.URL+"/", opts...)
+}
+
+// Keys returns the keys of the committed entries.
+func (s *Server) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store.Keys()
+}
+
+// Data returns the stored payload of the newest entry of key.
+func (s *Server) Data(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.store.Latest(key)
+	if e == nil {
+		return nil, false
+	}
+	return append([]byte(nil), e.Data...), true
+}
+
+// Delete removes the entries of key, eg. to simulate eviction.
+func (s *Server) Delete(key string) {
+	s.mu.Lock()
+	s.store.Delete(key)
+	s.mu.Unlock()
+}
+
+func (s *Server) blobURL(e *cachestore.Entry) string {
+	return s.URL + "/blob/" + url.PathEscape(e.Key) + "?version=" + url.QueryEscape(e.Version)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	p := strings.TrimPrefix(r.URL.Path, "/_apis/artifactcache/")
+	switch {
+	case r.Method == "GET" && p == "cache":
+		q := r.URL.Query()
+		if e := s.store.Find(q.Get("version"), strings.Split(q.Get("keys"), ",")...); e != nil {
+			json.NewEncoder(w).Encode(actionscache.Entry{Key: e.Key, Scope: DefaultScope.Scope, URL: s.blobURL(e), CreationTime: e.Created})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == "POST" && p == "caches":
+		var req actionscache.ReserveCacheReq
+		if err := json.Unmarshal(body, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		id, ok := s.store.Reserve(req.Key, req.Version)
+		if !ok {
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprintf(w, `{"message":"Cache already exists.","typeKey":"ArtifactCacheItemAlreadyExistsException"}`)
+			return
+		}
+		json.NewEncoder(w).Encode(actionscache.ReserveCacheResp{CacheID: id})
+	case strings.HasPrefix(p, "caches/"):
+		id, err := strconv.Atoi(strings.TrimPrefix(p, "caches/"))
+		u, ok := s.store.Upload(id)
+		if err != nil || !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch r.Method {
+		case "PATCH":
+			var start, end int64
+			if _, err := fmt.Sscanf(r.Header.Get("Content-Range"), "bytes %d-%d/*", &start, &end); err != nil || end-start+1 != int64(len(body)) {
+				http.Error(w, "invalid content range", http.StatusBadRequest)
+				return
+			}
+			if int64(len(u.Data)) < end+1 {
+				u.Data = append(u.Data, make([]byte, end+1-int64(len(u.Data)))...)
+			}
+			copy(u.Data[start:], body)
+		case "POST":
+			var req actionscache.CommitCacheReq
+			if err := json.Unmarshal(body, &req); err != nil || req.Size > int64(len(u.Data)) {
+				http.Error(w, "invalid commit", http.StatusBadRequest)
+				return
+			}
+			u.Data = u.Data[:req.Size]
+			s.store.Commit(id)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	case strings.HasPrefix(r.URL.Path, twirpCacheService):
+		s.serveTwirp(w, strings.TrimPrefix(r.URL.Path, twirpCacheService), body)
+	case r.Method == "PUT" && strings.HasPrefix(r.URL.Path, "/upload/"):
+		s.serveUpload(w, r, body)
+	case r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/blob/"):
+		key, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/blob/"))
+		e := s.store.Get(key, r.URL.Query().Get("version"))
+		if err != nil || e == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(e.Data))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// serveUpload implements the Azure blob uploads of the v2 API, both single
+// requests and staged blocks.
+func (s *Server) serveUpload(w http.ResponseWriter, r *http.Request, body []byte) {
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/upload/"))
+	u, ok := s.store.Upload(id)
+	if err != nil || !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	switch q.Get("comp") {
+	case "block":
+		if u.Blocks == nil {
+			u.Blocks = map[string][]byte{}
+		}
+		u.Blocks[q.Get("blockid")] = body
+	case "blocklist":
+		var bl struct {
+			Latest []string `xml:"Latest"`
+		}
+		if err := xml.Unmarshal(body, &bl); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var data []byte
+		for _, id := range bl.Latest {
+			dt, ok := u.Blocks[id]
+			if !ok {
+				http.Error(w, "block "+id+" not staged", http.StatusBadRequest)
+				return
+			}
+			data = append(data, dt...)
+		}
+		u.Data = data
+	default:
+		u.Data = body
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) serveTwirp(w http.ResponseWriter, method string, body []byte) {
+	var req struct {
+		Key         string   `json:"key"`
+		RestoreKeys []string `json:"restore_keys"`
+		Version     string   `json:"version"`
+		SizeBytes   string   `json:"size_bytes"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"code":"malformed","msg":%q}`, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	switch method {
+	case "CreateCacheEntry":
+		id, ok := s.store.Reserve(req.Key, req.Version)
+		if !ok {
+			fmt.Fprint(w, `{"ok":false}`)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"ok":                true,
+			"signed_upload_url": fmt.Sprintf("%s/upload/%d?sv=2020-04-08&sig=actionscachetest", s.URL, id),
+		})
+	case "FinalizeCacheEntryUpload":
+		id, u, ok := s.store.UploadOf(req.Key, req.Version)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"code":"not_found","msg":"upload not found"}`)
+			return
+		}
+		if strconv.Itoa(len(u.Data)) != req.SizeBytes {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"code":"invalid_argument","msg":"size does not match the upload"}`)
+			return
+		}
+		s.store.Commit(id)
+		fmt.Fprintf(w, `{"ok":true,"entry_id":"%d"}`, id)
+	case "GetCacheEntryDownloadURL":
+		e := s.store.Find(req.Version, append([]string{req.Key}, req.RestoreKeys...)...)
+		if e == nil {
+			fmt.Fprint(w, `{"ok":false}`)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"ok":                  true,
+			"signed_download_url": s.blobURL(e),
+			"matched_key":         e.Key,
+		})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"code":"bad_route","msg":"no handler for method"}`)
+	}
+}
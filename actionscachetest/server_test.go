@@ -0,0 +1,106 @@
+package actionscachetest
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	actionscache "github.com/tonistiigi/go-actions-cache"
+)
+
+func TestServer(t *testing.T) {
+	for _, v2 := range []bool{false, true} {
+		s := NewServer()
+		defer s.Close()
+		newCache := s.Cache
+		if v2 {
+			newCache = s.CacheV2
+		}
+		c, err := newCache()
+		require.NoError(t, err)
+
+		ctx := context.TODO()
+		dt := bytes.Repeat([]byte("foobar"), 1000)
+		require.NoError(t, c.Save(ctx, "foo-1", bytes.NewReader(dt), int64(len(dt))))
+		require.Equal(t, []string{"foo-1"}, s.Keys())
+		stored, ok := s.Data("foo-1")
+		require.True(t, ok)
+		require.Equal(t, dt, stored)
+
+		err = c.Save(ctx, "foo-1", bytes.NewReader(dt), int64(len(dt)))
+		require.ErrorIs(t, err, actionscache.ErrReserveConflict)
+
+		ce, err := c.Load(ctx, "foo-2", "foo-")
+		require.NoError(t, err)
+		require.NotNil(t, ce)
+		require.Equal(t, "foo-1", ce.Key)
+		buf := &bytes.Buffer{}
+		require.NoError(t, ce.Download(ctx, buf))
+		require.Equal(t, dt, buf.Bytes())
+
+		s.Delete("foo-1")
+		ce, err = c.Load(ctx, "foo-1")
+		require.NoError(t, err)
+		require.Nil(t, ce)
+	}
+}
+
+func TestServerKeys(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	ctx := context.TODO()
+
+	a, err := s.Cache(actionscache.WithVersion("a"))
+	require.NoError(t, err)
+	b, err := s.Cache(actionscache.WithVersion("b"))
+	require.NoError(t, err)
+
+	key := "linux/x64 #1?"
+	require.NoError(t, a.Save(ctx, key, bytes.NewReader([]byte("aaa")), 3))
+	require.NoError(t, b.Save(ctx, key, bytes.NewReader([]byte("bbb")), 3))
+
+	for c, exp := range map[*actionscache.Cache]string{a: "aaa", b: "bbb"} {
+		ce, err := c.Load(ctx, key)
+		require.NoError(t, err)
+		require.NotNil(t, ce)
+		buf := &bytes.Buffer{}
+		require.NoError(t, ce.Download(ctx, buf))
+		require.Equal(t, exp, buf.String())
+	}
+}
+
+func TestClockAndTransport(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	ctx := context.TODO()
+
+	clock := NewClock(time.Unix(1600000000, 0))
+	tr := &FailTransport{
+		Match:  func(r *http.Request) bool { return r.Method == "GET" },
+		Status: http.StatusServiceUnavailable,
+		Count:  2,
+	}
+	c, err := s.Cache(actionscache.WithClock(clock), actionscache.WithTransport(tr))
+	require.NoError(t, err)
+	c.RetryPolicy = &actionscache.RetryPolicy{MaxAttempts: 3, MinBackoff: time.Second, MaxBackoff: time.Minute}
+
+	ce, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	require.Nil(t, ce)
+	require.Equal(t, 2, tr.Failed())
+	require.Equal(t, []time.Duration{time.Second, 2 * time.Second}, clock.Sleeps())
+	require.Equal(t, time.Unix(1600000003, 0), clock.Now())
+
+	c, err = s.Cache(actionscache.WithTransport(RoundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return nil, errors.New("offline")
+	})))
+	require.NoError(t, err)
+	c.RetryPolicy = &actionscache.RetryPolicy{MaxAttempts: 1}
+	_, err = c.Load(ctx, "foo")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "offline")
+}
@@ -0,0 +1,62 @@
+package actionscachetest
+
+import (
+	"net/http"
+	"sync"
+)
+
+// RoundTripFunc is an http.RoundTripper calling itself, eg. to script the
+// responses of a Cache created with actionscache.WithTransport.
+type RoundTripFunc func(*http.Request) (*http.Response, error)
+
+// RoundTrip calls f.
+func (f RoundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// FailTransport responds to the first Count requests matched by Match
+// with Status and sends the others with Base.
+type FailTransport struct {
+	// Base defaults to http.DefaultTransport when nil.
+	Base http.RoundTripper
+	// Match defaults to matching every request when nil.
+	Match  func(*http.Request) bool
+	Status int
+	Count  int
+
+	mu     sync.Mutex
+	failed int
+}
+
+// RoundTrip fails req or sends it with Base.
+func (t *FailTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.fail(req) {
+		closeBody(req)
+		return statusResponse(req, t.Status), nil
+	}
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
+
+func (t *FailTransport) fail(req *http.Request) bool {
+	if t.Match != nil && !t.Match(req) {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.failed >= t.Count {
+		return false
+	}
+	t.failed++
+	return true
+}
+
+// Failed returns the number of requests t failed.
+func (t *FailTransport) Failed() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.failed
+}
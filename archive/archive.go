@@ -0,0 +1,263 @@
+// Package archive packs directories into the tar archives stored by
+// actionscache.Cache.SaveDir.
+package archive
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Pack writes the contents of the directory at root to w as a tar archive.
+// Regular files, directories and symlinks are stored with their permissions
+// and modification times, other file types are skipped.
+func Pack(w io.Writer, root string) error {
+	tw := tar.NewWriter(w)
+	err := filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		var link string
+		switch {
+		case fi.Mode()&os.ModeSymlink != 0:
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+			// archives use slashes on every platform
+			link = filepath.ToSlash(link)
+		case fi.IsDir(), fi.Mode().IsRegular():
+		default:
+			return nil
+		}
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to pack %s", root)
+	}
+	return errors.WithStack(tw.Close())
+}
+
+// checkNoSymlinkParents makes sure extracting name does not write through a
+// symlink extracted earlier.
+func checkNoSymlinkParents(root, name string) error {
+	p := root
+	parts := strings.Split(name, string(filepath.Separator))
+	for _, part := range parts[:len(parts)-1] {
+		p = filepath.Join(p, part)
+		fi, err := os.Lstat(p)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return errors.WithStack(err)
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return errors.Errorf("invalid path %q through symlink in archive", name)
+		}
+	}
+	return nil
+}
+
+// Unpack extracts a tar archive written by Pack from r to the directory at
+// root, creating it if needed. Entries escaping root, directly or through a
+// symlink extracted earlier, are rejected. Directories stay writable by the
+// owner until all entries are extracted and get their modes last.
+//
+// Symlinks that can not be created, eg. on Windows without the privilege,
+// are replaced with copies of their targets inside root. Links to targets
+// outside of root or that do not exist are skipped.
+func Unpack(r io.Reader, root string) error {
+	// absolute paths are not limited to MAX_PATH on Windows
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return errors.WithStack(err)
+	}
+	type dirAttrs struct {
+		path  string
+		mode  os.FileMode
+		mtime time.Time
+	}
+	var dirs []dirAttrs
+	var links [][2]string
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read archive")
+		}
+		name := filepath.FromSlash(strings.TrimSuffix(hdr.Name, "/"))
+		if filepath.IsAbs(name) || filepath.VolumeName(name) != "" || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) || filepath.Clean(name) != name {
+			return errors.Errorf("invalid path %q in archive", hdr.Name)
+		}
+		if err := checkNoSymlinkParents(root, name); err != nil {
+			return err
+		}
+		p := filepath.Join(root, name)
+		mode := os.FileMode(hdr.Mode).Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if fi, err := os.Lstat(p); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+				return errors.Errorf("invalid directory %q over symlink in archive", hdr.Name)
+			}
+			if err := os.MkdirAll(p, 0700); err != nil {
+				return errors.WithStack(err)
+			}
+			// a read-only mode would block extracting the contents
+			if err := os.Chmod(p, mode|0700); err != nil {
+				return errors.WithStack(err)
+			}
+			dirs = append(dirs, dirAttrs{p, mode, hdr.ModTime})
+			continue
+		case tar.TypeReg:
+			os.Remove(p)
+			f, err := os.OpenFile(p, os.O_CREATE|os.O_WRONLY|os.O_EXCL, mode)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			_, err = io.Copy(f, tr)
+			if err2 := f.Close(); err == nil {
+				err = err2
+			}
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			// umask may have masked the permissions
+			if err := os.Chmod(p, mode); err != nil {
+				return errors.WithStack(err)
+			}
+		case tar.TypeSymlink:
+			os.Remove(p)
+			target := filepath.FromSlash(hdr.Linkname)
+			if err := symlink(target, p); err != nil {
+				if !errors.Is(err, errSymlinkNotPermitted) {
+					return errors.WithStack(err)
+				}
+				links = append(links, [2]string{target, p})
+			}
+			// modification times of symlinks can not be set portably
+			continue
+		default:
+			continue
+		}
+		if err := os.Chtimes(p, hdr.ModTime, hdr.ModTime); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	for _, l := range links {
+		if err := copyLinkTarget(root, l[0], l[1]); err != nil {
+			return err
+		}
+	}
+	// directories are modified by their contents, set their modes and times
+	// last
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Chmod(dirs[i].path, dirs[i].mode); err != nil {
+			return errors.WithStack(err)
+		}
+		if err := os.Chtimes(dirs[i].path, dirs[i].mtime, dirs[i].mtime); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// symlink is replaced by tests to simulate missing privileges.
+var symlink = os.Symlink
+
+// copyLinkTarget creates a copy of target at the path p of a symlink that
+// could not be created. Targets outside of root are not copied.
+func copyLinkTarget(root, target, p string) error {
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(p), target)
+	}
+	rel, err := filepath.Rel(root, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil
+	}
+	fi, err := os.Stat(target)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if !fi.IsDir() {
+		return copyFile(target, p, fi)
+	}
+	err = filepath.Walk(target, func(src string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(target, src)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(p, rel)
+		switch {
+		case fi.IsDir():
+			return os.MkdirAll(dst, fi.Mode().Perm()|0700)
+		case fi.Mode().IsRegular():
+			return copyFile(src, dst, fi)
+		}
+		return nil
+	})
+	return errors.Wrapf(err, "failed to copy symlink target %s", target)
+}
+
+func copyFile(src, dst string, fi os.FileInfo) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_EXCL, fi.Mode().Perm())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = io.Copy(out, in)
+	if err2 := out.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.Chtimes(dst, fi.ModTime(), fi.ModTime()))
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+// +build !windows
+
+package archive
+
+import "syscall"
+
+// errSymlinkNotPermitted is returned by filesystems without symlinks, eg.
+// FAT or some network filesystems.
+var errSymlinkNotPermitted error = syscall.EPERM
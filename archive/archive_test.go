@@ -0,0 +1,132 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnpackEscape(t *testing.T) {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	outside := t.TempDir()
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: "escape", Linkname: outside}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "escape/file", Mode: 0644, Size: 1}))
+	_, err := tw.Write([]byte("x"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+
+	err = Unpack(bytes.NewReader(buf.Bytes()), t.TempDir())
+	require.Error(t, err)
+	_, err = os.Stat(filepath.Join(outside, "file"))
+	require.True(t, os.IsNotExist(err))
+
+	buf.Reset()
+	tw = tar.NewWriter(buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "../file", Mode: 0644}))
+	require.NoError(t, tw.Close())
+	require.Error(t, Unpack(bytes.NewReader(buf.Bytes()), t.TempDir()))
+}
+
+func TestUnpackDirOverSymlink(t *testing.T) {
+	outside := t.TempDir()
+	require.NoError(t, os.Chmod(outside, 0755))
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: "a", Linkname: outside}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "a/", Mode: 0700}))
+	require.NoError(t, tw.Close())
+
+	require.Error(t, Unpack(bytes.NewReader(buf.Bytes()), t.TempDir()))
+	fi, err := os.Stat(outside)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0755), fi.Mode().Perm())
+}
+
+func TestUnpackReadOnlyDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("directory permissions are not supported on Windows")
+	}
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "ro/", Mode: 0555}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "ro/file", Mode: 0444, Size: 1}))
+	_, err := tw.Write([]byte("x"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+
+	root := t.TempDir()
+	require.NoError(t, Unpack(bytes.NewReader(buf.Bytes()), root))
+	t.Cleanup(func() { os.Chmod(filepath.Join(root, "ro"), 0755) })
+	fi, err := os.Stat(filepath.Join(root, "ro"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0555), fi.Mode().Perm())
+	dt, err := ioutil.ReadFile(filepath.Join(root, "ro", "file"))
+	require.NoError(t, err)
+	require.Equal(t, "x", string(dt))
+}
+
+func TestPackSymlinkSlashes(t *testing.T) {
+	src := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "sub", "deep"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(src, "sub", "deep", "file"), []byte("x"), 0644))
+	if err := os.Symlink(filepath.Join("sub", "deep", "file"), filepath.Join(src, "link")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	buf := &bytes.Buffer{}
+	require.NoError(t, Pack(buf, src))
+
+	names := map[string]string{}
+	tr := tar.NewReader(buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		names[hdr.Name] = hdr.Linkname
+	}
+	require.Contains(t, names, "sub/deep/file")
+	require.Equal(t, "sub/deep/file", names["link"])
+}
+
+func TestUnpackSymlinkFallback(t *testing.T) {
+	symlink = func(oldname, newname string) error {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: errSymlinkNotPermitted}
+	}
+	defer func() { symlink = os.Symlink }()
+
+	outside := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0644))
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: "filelink", Linkname: "dir/file"}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: "dirlink", Linkname: "dir"}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: "outside", Linkname: filepath.ToSlash(filepath.Join(outside, "secret"))}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: "dangling", Linkname: "missing"}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "dir/", Mode: 0755}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "dir/file", Mode: 0644, Size: 1}))
+	_, err := tw.Write([]byte("x"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+
+	root := t.TempDir()
+	require.NoError(t, Unpack(bytes.NewReader(buf.Bytes()), root))
+	dt, err := ioutil.ReadFile(filepath.Join(root, "filelink"))
+	require.NoError(t, err)
+	require.Equal(t, "x", string(dt))
+	dt, err = ioutil.ReadFile(filepath.Join(root, "dirlink", "file"))
+	require.NoError(t, err)
+	require.Equal(t, "x", string(dt))
+	_, err = os.Lstat(filepath.Join(root, "outside"))
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Lstat(filepath.Join(root, "dangling"))
+	require.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,7 @@
+package archive
+
+import "syscall"
+
+// errSymlinkNotPermitted is returned when creating symlinks requires a
+// privilege or developer mode that the process does not have.
+var errSymlinkNotPermitted error = syscall.Errno(1314) // ERROR_PRIVILEGE_NOT_HELD
@@ -0,0 +1,229 @@
+package actionscache
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const twirpArtifactService = "twirp/github.actions.results.api.v1.ArtifactService/"
+
+// Store is implemented by Cache and Artifacts, so the same code can save a
+// payload to the cache, that evicts entries, or as a durable artifact.
+type Store interface {
+	Load(ctx context.Context, keys ...string) (*Entry, error)
+	Save(ctx context.Context, key string, ra io.ReaderAt, size int64, opts ...SaveOpt) error
+}
+
+var (
+	_ Store = &Cache{}
+	_ Store = &Artifacts{}
+)
+
+// Artifacts saves and loads payloads as artifacts of the workflow run with
+// the v4 artifact service. Unlike cache entries, artifacts are kept for
+// their retention period, but they are only found by the jobs of the same
+// workflow run. Payloads are stored as they are, not zipped like the
+// artifacts of actions/upload-artifact.
+type Artifacts struct {
+	c *Cache
+}
+
+// NewArtifacts returns an Artifacts client. url is the value of
+// ACTIONS_RESULTS_URL. The options configure requests like for a Cache,
+// options that transform payloads, like compression, encryption or a local
+// cache, are not applied to artifacts.
+func NewArtifacts(token, url string, opts ...Opt) (*Artifacts, error) {
+	c, err := NewV2(token, url, opts...)
+	if err != nil {
+		return nil, err
+	}
+	c.Compression = ""
+	c.EncryptionKey = nil
+	c.LocalCacheDir = ""
+	c.Backend = nil
+	return &Artifacts{c: c}, nil
+}
+
+// TryArtifactsEnv returns an Artifacts client for the runtime of the job, or
+// nil if ACTIONS_RUNTIME_TOKEN or ACTIONS_RESULTS_URL are not set.
+func TryArtifactsEnv(opts ...Opt) (*Artifacts, error) {
+	token, ok := os.LookupEnv("ACTIONS_RUNTIME_TOKEN")
+	if !ok {
+		return nil, nil
+	}
+	resultsURL, ok := os.LookupEnv("ACTIONS_RESULTS_URL")
+	if !ok {
+		return nil, nil
+	}
+	return NewArtifacts(token, resultsURL, opts...)
+}
+
+type artifactRequest struct {
+	WorkflowRunBackendID    string `json:"workflow_run_backend_id"`
+	WorkflowJobRunBackendID string `json:"workflow_job_run_backend_id"`
+	Name                    string `json:"name"`
+}
+
+type createArtifactRequest struct {
+	artifactRequest
+	Version int `json:"version"`
+}
+
+type createArtifactResponse struct {
+	OK              bool   `json:"ok"`
+	SignedUploadURL string `json:"signed_upload_url"`
+}
+
+type finalizeArtifactRequest struct {
+	artifactRequest
+	Size int64 `json:"size,string"`
+}
+
+type finalizeArtifactResponse struct {
+	OK         bool       `json:"ok"`
+	ArtifactID twirpInt64 `json:"artifact_id"`
+}
+
+type listArtifactsRequest struct {
+	WorkflowRunBackendID    string `json:"workflow_run_backend_id"`
+	WorkflowJobRunBackendID string `json:"workflow_job_run_backend_id"`
+	NameFilter              string `json:"name_filter"`
+}
+
+type listArtifactsResponse struct {
+	Artifacts []struct {
+		Name       string     `json:"name"`
+		DatabaseID twirpInt64 `json:"database_id"`
+		Size       twirpInt64 `json:"size"`
+		CreatedAt  time.Time  `json:"created_at"`
+	} `json:"artifacts"`
+}
+
+type getSignedArtifactURLResponse struct {
+	SignedURL string `json:"signed_url"`
+}
+
+// artifactRequest returns the request fields of the artifact name. The run
+// and job are identified by the Actions.Results claim of the token.
+func (a *Artifacts) artifactRequest(name string) (artifactRequest, error) {
+	if err := validateArtifactName(name); err != nil {
+		return artifactRequest{}, err
+	}
+	tc := a.c.claims()
+	if tc.WorkflowRunBackendID == "" || tc.WorkflowJobRunBackendID == "" {
+		return artifactRequest{}, errors.New("runtime token does not identify the workflow run of artifacts")
+	}
+	return artifactRequest{
+		WorkflowRunBackendID:    tc.WorkflowRunBackendID,
+		WorkflowJobRunBackendID: tc.WorkflowJobRunBackendID,
+		Name:                    name,
+	}, nil
+}
+
+// validateArtifactName refuses the names the artifact service refuses.
+func validateArtifactName(name string) error {
+	if name == "" {
+		return errors.New("empty artifact name")
+	}
+	if i := strings.IndexAny(name, "\":<>|*?\r\n\\/"); i >= 0 {
+		return errors.Errorf("invalid artifact name %q: character %q is not allowed", name, name[i])
+	}
+	return nil
+}
+
+// Save uploads size bytes of ra as the artifact name. Saving a name that
+// exists in the workflow run fails with ErrReserveConflict. opts are
+// accepted for the Store interface and are not used.
+func (a *Artifacts) Save(ctx context.Context, name string, ra io.ReaderAt, size int64, opts ...SaveOpt) (err error) {
+	ctx, done := a.c.startOp(ctx, "save", name)
+	defer func() { err = done(size, false, err) }()
+	ar, err := a.artifactRequest(name)
+	if err != nil {
+		return err
+	}
+	var cr createArtifactResponse
+	if err := a.c.twirpService(ctx, twirpArtifactService, "CreateArtifact", createArtifactRequest{artifactRequest: ar, Version: 4}, &cr); err != nil {
+		return err
+	}
+	if !cr.OK {
+		return errors.Wrapf(ErrReserveConflict, "failed to create artifact %s", name)
+	}
+	upload := a.c.uploadBlob
+	if isBlobSASURL(cr.SignedUploadURL) && size > 0 {
+		upload = a.c.uploadBlocks
+	}
+	if err := upload(ctx, cr.SignedUploadURL, ra, size); err != nil {
+		return err
+	}
+	var fr finalizeArtifactResponse
+	if err := a.c.twirpService(ctx, twirpArtifactService, "FinalizeArtifact", finalizeArtifactRequest{artifactRequest: ar, Size: size}, &fr); err != nil {
+		return err
+	}
+	if !fr.OK {
+		return errors.Errorf("failed to finalize artifact %s", name)
+	}
+	return nil
+}
+
+// Load returns the first artifact of the workflow run named by names, or
+// nil if there is none. Names are matched exactly, not as prefixes.
+func (a *Artifacts) Load(ctx context.Context, names ...string) (ce *Entry, err error) {
+	ctx, done := a.c.startOp(ctx, "load", strings.Join(names, ","))
+	defer func() { err = done(0, ce != nil, err) }()
+	for i, name := range names {
+		ce, err := a.load(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if ce != nil {
+			ce.Exact = i == 0
+			return ce, nil
+		}
+	}
+	return nil, nil
+}
+
+func (a *Artifacts) load(ctx context.Context, name string) (*Entry, error) {
+	ar, err := a.artifactRequest(name)
+	if err != nil {
+		return nil, err
+	}
+	var lr listArtifactsResponse
+	if err := a.c.twirpService(ctx, twirpArtifactService, "ListArtifacts", listArtifactsRequest{
+		WorkflowRunBackendID:    ar.WorkflowRunBackendID,
+		WorkflowJobRunBackendID: ar.WorkflowJobRunBackendID,
+		NameFilter:              name,
+	}, &lr); err != nil {
+		return nil, err
+	}
+	ce := &Entry{Key: name, MatchedKey: name, Size: -1, c: a.c}
+	var id twirpInt64
+	found := false
+	for _, art := range lr.Artifacts {
+		// the newest artifact wins if a name was uploaded again
+		if art.Name != name || (found && art.DatabaseID < id) {
+			continue
+		}
+		found = true
+		id = art.DatabaseID
+		ce.Size = int64(art.Size)
+		ce.CreationTime = art.CreatedAt
+	}
+	if !found {
+		return nil, nil
+	}
+	var ur getSignedArtifactURLResponse
+	if err := a.c.twirpService(ctx, twirpArtifactService, "GetSignedArtifactURL", ar, &ur); err != nil {
+		return nil, err
+	}
+	if ur.SignedURL == "" {
+		return nil, errors.Errorf("no download URL for artifact %s", name)
+	}
+	ce.URL = ur.SignedURL
+	return ce, nil
+}
@@ -0,0 +1,56 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArtifacts(t *testing.T) {
+	ts := newTestServer(t)
+	tk, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"ac":              `[{"Scope":"refs/heads/main","Permission":3}]`,
+		"Actions.Results": "run-1:job-1",
+	}).SignedString([]byte("secret"))
+	require.NoError(t, err)
+	a, err := NewArtifacts(tk, ts.URL+"/", WithCompression("gzip"))
+	require.NoError(t, err)
+
+	ctx := context.TODO()
+	ce, err := a.Load(ctx, "report")
+	require.NoError(t, err)
+	require.Nil(t, ce)
+
+	var s Store = a
+	dt := []byte("foobar")
+	require.NoError(t, s.Save(ctx, "report", bytes.NewReader(dt), int64(len(dt))))
+	require.ErrorIs(t, s.Save(ctx, "report", bytes.NewReader(dt), int64(len(dt))), ErrReserveConflict)
+	require.NotNil(t, ts.entry("report"))
+
+	ce, err = s.Load(ctx, "missing", "report")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	require.Equal(t, "report", ce.Key)
+	require.False(t, ce.Exact)
+	require.Equal(t, int64(len(dt)), ce.Size)
+	require.Equal(t, time.Date(2021, 1, 1, 0, 0, 1, 0, time.UTC), ce.CreationTime.UTC())
+	buf := &bytes.Buffer{}
+	require.NoError(t, ce.Download(ctx, buf))
+	require.Equal(t, dt, buf.Bytes(), "payloads are stored as they are")
+
+	// artifacts are not cache entries
+	c := ts.newCacheV2(t)
+	ce, err = c.Load(ctx, "report")
+	require.NoError(t, err)
+	require.Nil(t, ce)
+
+	require.Error(t, a.Save(ctx, "a/b", bytes.NewReader(dt), int64(len(dt))))
+	a, err = NewArtifacts(testToken(t, Scope{Scope: "refs/heads/main", Permission: PermissionRead}), ts.URL+"/")
+	require.NoError(t, err)
+	_, err = a.Load(ctx, "report")
+	require.Error(t, err)
+}
@@ -0,0 +1,128 @@
+package actionscache
+
+import (
+	"context"
+	"io"
+)
+
+// Backend stores cache entries. Caches use the v1 cache service unless a
+// Backend is set, eg. to target a self-hosted cache server or a local
+// directory. List, Delete, Abandon and Diagnose always use the service.
+type Backend interface {
+	// Reserve reserves key for a new entry of version and returns the id
+	// passed to UploadChunk and Commit. Keys that already exist or are
+	// being uploaded fail with ErrReserveConflict.
+	Reserve(ctx context.Context, key, version string) (int, error)
+	// UploadChunk stores n bytes of ra at off for the reserved entry id.
+	// Chunks may be uploaded in parallel and in any order.
+	UploadChunk(ctx context.Context, id int, ra io.ReaderAt, off, n int64) error
+	// Commit makes the entry id of size bytes available to Lookup.
+	Commit(ctx context.Context, id int, size int64) error
+	// Lookup returns the entry of version for the first of keys that
+	// matches exactly or as a prefix. A miss is reported as a nil Entry or
+	// an error matching ErrCacheNotFound. The URL of the entry is passed to
+	// Download.
+	Lookup(ctx context.Context, keys []string, version string) (*Entry, error)
+	// Download writes the archive at url to w.
+	Download(ctx context.Context, url string, w io.Writer) error
+}
+
+// WithBackend sets the Backend storing the entries.
+func WithBackend(b Backend) Opt {
+	return func(c *Cache) {
+		c.Backend = b
+	}
+}
+
+// NewWithBackend returns a Cache that stores its entries in b. It has no
+// runtime token so it has no scopes.
+func NewWithBackend(b Backend, opts ...Opt) *Cache {
+	c := &Cache{Backend: b}
+	for _, o := range opts {
+		o(c)
+	}
+	return c
+}
+
+// NewServiceBackend returns a Backend for the v1 cache service at url,
+// configured like a Cache created with New.
+func NewServiceBackend(token, url string, opts ...Opt) (Backend, error) {
+	c, err := New(token, url, opts...)
+	if err != nil {
+		return nil, err
+	}
+	c.Backend = nil
+	return serviceBackend{c: c}, nil
+}
+
+func (c *Cache) backend() Backend {
+	if c.Backend != nil {
+		return cacheBackend{b: c.Backend, c: c}
+	}
+	return serviceBackend{c: c}
+}
+
+type backendCacheKey struct{}
+
+// cacheOf returns the Cache calling a Backend with ctx, nil if ctx is not
+// of a Cache.
+func cacheOf(ctx context.Context) *Cache {
+	c, _ := ctx.Value(backendCacheKey{}).(*Cache)
+	return c
+}
+
+// cacheBackend calls b with the Cache c in the contexts, so the backends of
+// this package use its settings, eg. its transport and chunk size.
+type cacheBackend struct {
+	b Backend
+	c *Cache
+}
+
+func (cb cacheBackend) ctx(ctx context.Context) context.Context {
+	return context.WithValue(ctx, backendCacheKey{}, cb.c)
+}
+
+func (cb cacheBackend) Reserve(ctx context.Context, key, version string) (int, error) {
+	return cb.b.Reserve(cb.ctx(ctx), key, version)
+}
+
+func (cb cacheBackend) UploadChunk(ctx context.Context, id int, ra io.ReaderAt, off, n int64) error {
+	return cb.b.UploadChunk(cb.ctx(ctx), id, ra, off, n)
+}
+
+func (cb cacheBackend) Commit(ctx context.Context, id int, size int64) error {
+	return cb.b.Commit(cb.ctx(ctx), id, size)
+}
+
+func (cb cacheBackend) Lookup(ctx context.Context, keys []string, version string) (*Entry, error) {
+	return cb.b.Lookup(cb.ctx(ctx), keys, version)
+}
+
+func (cb cacheBackend) Download(ctx context.Context, url string, w io.Writer) error {
+	return cb.b.Download(cb.ctx(ctx), url, w)
+}
+
+// serviceBackend is the Backend of the v1 cache service.
+type serviceBackend struct {
+	c *Cache
+}
+
+func (b serviceBackend) Reserve(ctx context.Context, key, version string) (int, error) {
+	return b.c.reserveService(ctx, key, version)
+}
+
+func (b serviceBackend) UploadChunk(ctx context.Context, id int, ra io.ReaderAt, off, n int64) error {
+	return b.c.uploadChunkRanges(ctx, id, ra, off, n)
+}
+
+func (b serviceBackend) Commit(ctx context.Context, id int, size int64) error {
+	return b.c.commitService(ctx, id, size)
+}
+
+func (b serviceBackend) Lookup(ctx context.Context, keys []string, version string) (*Entry, error) {
+	return b.c.lookupService(ctx, keys, version)
+}
+
+func (b serviceBackend) Download(ctx context.Context, url string, w io.Writer) error {
+	return httpDownloader{c: b.c}.Download(ctx, url, w)
+}
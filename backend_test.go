@@ -0,0 +1,55 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type countingBackend struct {
+	Backend
+	calls map[string]int
+}
+
+func (b *countingBackend) Reserve(ctx context.Context, key, version string) (int, error) {
+	b.calls["Reserve"]++
+	return b.Backend.Reserve(ctx, key, version)
+}
+
+func (b *countingBackend) Lookup(ctx context.Context, keys []string, version string) (*Entry, error) {
+	b.calls["Lookup"]++
+	return b.Backend.Lookup(ctx, keys, version)
+}
+
+func (b *countingBackend) Download(ctx context.Context, url string, w io.Writer) error {
+	b.calls["Download"]++
+	return b.Backend.Download(ctx, url, w)
+}
+
+func TestServiceBackend(t *testing.T) {
+	ts := newTestServer(t)
+	sb, err := NewServiceBackend(testToken(t, Scope{Scope: "refs/heads/main", Permission: PermissionRead | PermissionWrite}), ts.URL+"/")
+	require.NoError(t, err)
+	b := &countingBackend{Backend: sb, calls: map[string]int{}}
+	c := NewWithBackend(b)
+
+	ctx := context.TODO()
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader([]byte("hello")), 5))
+	require.Equal(t, "hello", string(ts.entry("foo").Data))
+
+	ce, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	buf := &bytes.Buffer{}
+	require.NoError(t, ce.Download(ctx, buf))
+	require.Equal(t, "hello", buf.String())
+
+	ce, err = c.Load(ctx, "bar")
+	require.NoError(t, err)
+	require.Nil(t, ce)
+
+	require.Equal(t, map[string]int{"Reserve": 1, "Lookup": 2, "Download": 1}, b.calls)
+}
@@ -0,0 +1,262 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// isBlobSASURL reports if u is an Azure Blob URL authenticated with a shared
+// access signature.
+func isBlobSASURL(u string) bool {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+	q := pu.Query()
+	return q.Get("sig") != "" && q.Get("sv") != ""
+}
+
+// uploadBlob uploads the payload to a signed URL with a single Put Blob
+// request. The signed URL authenticates the request.
+func (c *Cache) uploadBlob(ctx context.Context, url string, ra io.ReaderAt, size int64) error {
+	release, err := acquireUploadSlot(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	releaseMem, err := c.acquireUploadMemory(ctx, size)
+	if err != nil {
+		return err
+	}
+	defer releaseMem()
+	req, err := http.NewRequest("PUT", url, io.NewSectionReader(ra, 0, size))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	req.ContentLength = size
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(io.NewSectionReader(ra, 0, size)), nil
+	}
+	if size == 0 {
+		// sent with Content-Length: 0 instead of an empty chunked body
+		req.Body = http.NoBody
+		req.GetBody = func() (io.ReadCloser, error) { return http.NoBody, nil }
+	}
+	req.Header.Set("x-ms-blob-type", "BlockBlob")
+	if err := c.setContentMD5(req, ra, 0, size); err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	c.debug(ctx, "upload cache blob", F("size", size))
+	if err := c.doBlob(req); err != nil {
+		return err
+	}
+	recordUpload(ctx, size)
+	reportProgress(ctx, size)
+	return nil
+}
+
+// uploadBlocks uploads the payload to an Azure Blob SAS URL as blocks of
+// UploadChunkSize staged in parallel and committed with a block list. The
+// first failed block cancels the uploads of the others.
+func (c *Cache) uploadBlocks(ctx context.Context, url string, ra io.ReaderAt, size int64) error {
+	chunkSize := int64(c.uploadChunkSize())
+	var ids []string
+	for off := int64(0); off < size; off += chunkSize {
+		ids = append(ids, blockID(len(ids)))
+	}
+
+	var mu sync.Mutex
+	var acked rangeSet
+	next := 0
+	var eg errgroup.Group
+	egctx, failures := newChunkFailures(ctx)
+	for i, n := 0, c.uploadConcurrency(); i < n; i++ {
+		eg.Go(func() error {
+			for {
+				mu.Lock()
+				idx := next
+				if idx >= len(ids) || egctx.Err() != nil {
+					mu.Unlock()
+					return egctx.Err()
+				}
+				next++
+				mu.Unlock()
+				start := int64(idx) * chunkSize
+				end := start + chunkSize
+				if end > size {
+					end = size
+				}
+				if err := c.putBlock(egctx, url, ids[idx], ra, start, end-start); err != nil {
+					return failures.fail(start, err)
+				}
+				mu.Lock()
+				acked.add(start, end)
+				mu.Unlock()
+				recordUpload(ctx, end-start)
+				reportProgress(ctx, end-start)
+			}
+		})
+	}
+	err := eg.Wait()
+	if err := failures.result(0, len(ids)-next, err); err != nil {
+		return err
+	}
+	if missing := acked.missing(size); len(missing) > 0 {
+		return errors.Errorf("refusing to commit block list, ranges not uploaded: %v", missing)
+	}
+	return c.putBlockList(ctx, url, ids)
+}
+
+// blockID returns the i-th block ID. Azure requires IDs of a blob to have
+// the same length.
+func blockID(i int) string {
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("block-%08d", i)))
+}
+
+func (c *Cache) putBlock(ctx context.Context, u, id string, ra io.ReaderAt, off, n int64) (err error) {
+	release, err := acquireUploadSlot(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	releaseMem, err := c.acquireUploadMemory(ctx, n)
+	if err != nil {
+		return err
+	}
+	defer releaseMem()
+	defer func(start time.Time) { c.measureChunk(n, start, err) }(c.clock().Now())
+	defer func(done func(error)) { done(err) }(c.chunkEvents(ctx, 0, off, n))
+	req, err := http.NewRequest("PUT", u+"&comp=block&blockid="+url.QueryEscape(id), io.NewSectionReader(ra, off, n))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	req.ContentLength = n
+	if err := c.setContentMD5(req, ra, off, n); err != nil {
+		return err
+	}
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(io.NewSectionReader(ra, off, n)), nil
+	}
+	req = req.WithContext(ctx)
+	c.debug(ctx, "upload cache block", F("block", id), F("offset", off), F("size", n))
+	return c.doBlob(req)
+}
+
+type blockList struct {
+	XMLName xml.Name `xml:"BlockList"`
+	Latest  []string `xml:"Latest"`
+}
+
+func (c *Cache) putBlockList(ctx context.Context, u string, ids []string) error {
+	dt, err := xml.Marshal(blockList{Latest: ids})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	dt = append([]byte(xml.Header), dt...)
+	req, err := http.NewRequest("PUT", u+"&comp=blocklist", bytes.NewReader(dt))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	req.Header.Set("Content-Type", "application/xml")
+	req = req.WithContext(ctx)
+	c.debug(ctx, "commit cache block list", F("blocks", len(ids)))
+	return c.doBlob(req)
+}
+
+// doBlob sends a request to blob storage. These requests are authenticated
+// by the signed URL and must not carry the runtime token.
+func (c *Cache) doBlob(req *http.Request) error {
+	resp, err := c.doRetry(req, c.doHTTP)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer c.closeResponse(resp)
+	recordHeaders(req.Context(), resp)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		dt, _ := ioutil.ReadAll(io.LimitReader(resp.Body, c.maxErrorBodySize()))
+		ae := c.unexpectedResponse(resp, dt)
+		return errors.Errorf("blob request %s %s failed: %s: %s", req.Method, redactURL(req.URL), resp.Status, ae.Body)
+	}
+	return nil
+}
+
+// redactURL returns u without its query that may contain a signature.
+func redactURL(u *url.URL) string {
+	u2 := *u
+	u2.RawQuery = ""
+	return u2.String()
+}
+
+// blobRangeMD5Limit is the largest range Azure returns the MD5 of.
+const blobRangeMD5Limit = 4 * 1024 * 1024
+
+// setRange requests the bytes of url from off to end, or to the end of the
+// data if end is negative. Azure Blob SAS URLs use x-ms-range, and ask for
+// the MD5 of the range if md5 is set and the range is small enough.
+func setRange(req *http.Request, u string, off, end int64, md5 bool) {
+	v := fmt.Sprintf("bytes=%d-", off)
+	if end >= 0 {
+		v += strconv.FormatInt(end, 10)
+	}
+	if !isBlobSASURL(u) {
+		req.Header.Set("Range", v)
+		return
+	}
+	req.Header.Set("x-ms-range", v)
+	if md5 && end >= 0 && end-off+1 <= blobRangeMD5Limit {
+		req.Header.Set("x-ms-range-get-content-md5", "true")
+	}
+}
+
+// md5Body computes the MD5 of a response body as it is read so it can be
+// checked against the Content-MD5 computed by Azure.
+type md5Body struct {
+	r    io.Reader
+	h    hash.Hash
+	want string
+}
+
+// newMD5Body returns the body of resp, checking the Content-MD5 of
+// responses of Azure Blob SAS URLs. Azure only returns it for the whole blob
+// or for ranges whose MD5 was requested.
+func newMD5Body(resp *http.Response) *md5Body {
+	b := &md5Body{r: resp.Body, h: md5.New()}
+	if resp.Request != nil && isBlobSASURL(resp.Request.URL.String()) {
+		b.want = resp.Header.Get("Content-MD5")
+	}
+	return b
+}
+
+func (b *md5Body) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.h.Write(p[:n])
+	return n, err
+}
+
+// check returns an error matching ErrChecksumMismatch if the data read does
+// not match the Content-MD5 of the response. Responses without it pass.
+func (b *md5Body) check(what string) error {
+	if b.want == "" {
+		return nil
+	}
+	if actual := base64.StdEncoding.EncodeToString(b.h.Sum(nil)); actual != b.want {
+		return errors.Wrapf(ErrChecksumMismatch, "MD5 of %s is %s, expected %s", what, actual, b.want)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package actionscache
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrBackendUnavailable is returned without sending a request while the
+// circuit breaker of a Cache is open after repeated failures.
+var ErrBackendUnavailable = errors.New("cache backend unavailable")
+
+// WithCircuitBreaker makes requests to the cache service fail fast with
+// ErrBackendUnavailable for cooldown once threshold requests in a row have
+// failed after retries, eg. during an incident of the service. After the
+// cooldown requests are sent again and the next failure reopens the
+// breaker. A threshold of zero disables the breaker.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) Opt {
+	return func(c *Cache) {
+		c.BreakerThreshold = threshold
+		c.BreakerCooldown = cooldown
+	}
+}
+
+// breakerAllow returns ErrBackendUnavailable while the breaker is open.
+func (c *Cache) breakerAllow() error {
+	if c.BreakerThreshold <= 0 {
+		return nil
+	}
+	c.mu.Lock()
+	until := c.breakerOpenUntil
+	c.mu.Unlock()
+	if now := c.clock().Now(); now.Before(until) {
+		return errors.Wrapf(ErrBackendUnavailable, "circuit breaker open for %s", until.Sub(now).Round(time.Millisecond))
+	}
+	return nil
+}
+
+// breakerRecord counts the outcome of a request to the cache service and
+// opens the breaker once the threshold of consecutive failures is reached.
+func (c *Cache) breakerRecord(ctx context.Context, resp *http.Response, err error) {
+	if c.BreakerThreshold <= 0 || ctx.Err() != nil {
+		return
+	}
+	failed := err != nil || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	c.mu.Lock()
+	if !failed {
+		c.breakerFailures = 0
+		c.mu.Unlock()
+		return
+	}
+	c.breakerFailures++
+	opened := c.breakerFailures >= c.BreakerThreshold
+	var until time.Time
+	if opened {
+		until = c.clock().Now().Add(c.BreakerCooldown)
+		c.breakerOpenUntil = until
+	}
+	failures := c.breakerFailures
+	c.mu.Unlock()
+	if opened {
+		c.warn(ctx, "cache service failing, opening circuit breaker", F("failures", failures), F("until", until))
+	}
+}
@@ -0,0 +1,59 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	ts := newTestServer(t)
+	var requests int32
+	failing := int32(1)
+	ts.fail = func(r *http.Request) int {
+		atomic.AddInt32(&requests, 1)
+		if atomic.LoadInt32(&failing) == 1 {
+			return http.StatusServiceUnavailable
+		}
+		return 0
+	}
+	c := ts.newCache(t)
+	clock := newTestClock()
+	c.Clock = clock
+	c.RetryPolicy = &RetryPolicy{MaxAttempts: 1}
+	WithCircuitBreaker(2, time.Minute)(c)
+
+	ctx := context.TODO()
+	for i := 0; i < 2; i++ {
+		_, err := c.Load(ctx, "foo")
+		require.Error(t, err)
+		require.NotErrorIs(t, err, ErrBackendUnavailable)
+	}
+	require.Equal(t, int32(2), atomic.LoadInt32(&requests))
+
+	_, err := c.Load(ctx, "foo")
+	require.ErrorIs(t, err, ErrBackendUnavailable)
+	err = c.Save(ctx, "foo", bytes.NewReader([]byte("foo")), 3)
+	require.ErrorIs(t, err, ErrBackendUnavailable)
+	require.Equal(t, int32(2), atomic.LoadInt32(&requests))
+
+	// a failure after the cooldown reopens the breaker
+	clock.Advance(time.Minute)
+	_, err = c.Load(ctx, "foo")
+	require.NotErrorIs(t, err, ErrBackendUnavailable)
+	_, err = c.Load(ctx, "foo")
+	require.ErrorIs(t, err, ErrBackendUnavailable)
+	require.Equal(t, int32(3), atomic.LoadInt32(&requests))
+
+	clock.Advance(time.Minute)
+	atomic.StoreInt32(&failing, 0)
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader([]byte("foo")), 3))
+	ce, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+}
@@ -0,0 +1,36 @@
+package actionscache
+
+import "sync"
+
+// chunkBuffers pools the buffers SaveWriter collects chunks in, one pool per
+// chunk size, so that concurrent uploads reuse memory instead of allocating
+// a buffer for every chunk.
+var chunkBuffers sync.Map // map[int]*sync.Pool
+
+func chunkBufferPool(size int) *sync.Pool {
+	if p, ok := chunkBuffers.Load(size); ok {
+		return p.(*sync.Pool)
+	}
+	p, _ := chunkBuffers.LoadOrStore(size, &sync.Pool{
+		New: func() interface{} {
+			b := make([]byte, 0, size)
+			return &b
+		},
+	})
+	return p.(*sync.Pool)
+}
+
+// getChunkBuffer returns an empty buffer with a capacity of size.
+func getChunkBuffer(size int) []byte {
+	return (*chunkBufferPool(size).Get().(*[]byte))[:0]
+}
+
+// putChunkBuffer returns b to its pool once no request reads it anymore. The
+// buffers of failed uploads are not returned as the transport may keep
+// reading a request body after RoundTrip returns.
+func putChunkBuffer(b []byte) {
+	if cap(b) == 0 {
+		return
+	}
+	chunkBufferPool(cap(b)).Put(&b)
+}
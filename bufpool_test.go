@@ -0,0 +1,62 @@
+package actionscache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestChunkBuffers(t *testing.T) {
+	b := getChunkBuffer(16)
+	require.Equal(t, 0, len(b))
+	require.Equal(t, 16, cap(b))
+	putChunkBuffer(append(b, "foo"...))
+	require.Equal(t, 0, len(getChunkBuffer(16)))
+	putChunkBuffer(nil)
+}
+
+func TestSaveWriterPooledChunks(t *testing.T) {
+	ts := newTestServer(t)
+	ts.verify = func(r *http.Request, body []byte) {
+		if r.Method == "PATCH" {
+			require.Equal(t, int64(len(body)), r.ContentLength)
+			require.Empty(t, r.TransferEncoding)
+		}
+	}
+	c := ts.newCache(t)
+	c.UploadChunkSize = 5
+
+	// concurrent writers must not see each other's pooled buffers
+	ctx := context.TODO()
+	var eg errgroup.Group
+	for i := 0; i < 4; i++ {
+		key := fmt.Sprintf("foo-%d", i)
+		dt := []byte(strings.Repeat(key, 7))
+		eg.Go(func() error {
+			w, err := c.SaveWriter(ctx, key)
+			if err != nil {
+				return err
+			}
+			for off := 0; off < len(dt); off += 3 {
+				end := off + 3
+				if end > len(dt) {
+					end = len(dt)
+				}
+				if _, err := w.Write(dt[off:end]); err != nil {
+					return err
+				}
+			}
+			return w.Close()
+		})
+	}
+	require.NoError(t, eg.Wait())
+	for i := 0; i < 4; i++ {
+		key := fmt.Sprintf("foo-%d", i)
+		require.Equal(t, []byte(strings.Repeat(key, 7)), ts.entry(key).Data)
+	}
+}
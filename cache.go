@@ -3,28 +3,53 @@ package actionscache
 import (
 	"bytes"
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/pkg/errors"
 	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+	"golang.org/x/sync/singleflight"
 )
 
+// UploadConcurrency is the default number of parallel chunk uploads.
 var UploadConcurrency = 4
+
+// UploadChunkSize is the default size of uploaded chunks.
 var UploadChunkSize = 32 * 1024 * 1024
 
+// LoadMissTTL is the duration a Load that found no entry is remembered for.
+// Repeated Loads for the same keys within that window return a miss without
+// contacting the service. Zero disables the negative cache.
+var LoadMissTTL time.Duration
+
+// Log is the default logger of every Cache without a Logger.
+//
+// Deprecated: set Cache.Logger, LoggerFunc adapts functions like Log.
 var Log = func(string, ...interface{}) {}
 
-func TryEnv() (*Cache, error) {
+// TryEnv returns a Cache for the cache service of the job, nil if the
+// environment has none. ACTIONS_CACHE_S3_BUCKET selects an S3Backend
+// instead, see S3ConfigFromEnv.
+func TryEnv(opts ...Opt) (*Cache, error) {
+	if cfg, ok := S3ConfigFromEnv(); ok {
+		b, err := NewS3Backend(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return NewWithBackend(b, opts...), nil
+	}
 	token, ok := os.LookupEnv("ACTIONS_RUNTIME_TOKEN")
 	if !ok {
 		return nil, nil
@@ -32,42 +57,52 @@ func TryEnv() (*Cache, error) {
 
 	// ACTIONS_CACHE_URL=https://artifactcache.actions.githubusercontent.com/xxx/
 	cacheURL, ok := os.LookupEnv("ACTIONS_CACHE_URL")
+
+	// ACTIONS_RESULTS_URL=https://results-receiver.actions.githubusercontent.com/
+	resultsURL, ok2 := os.LookupEnv("ACTIONS_RESULTS_URL")
+	if ok2 && (os.Getenv("ACTIONS_CACHE_SERVICE_V2") != "" || !ok) {
+		return NewV2(token, resultsURL, opts...)
+	}
 	if !ok {
 		return nil, nil
 	}
 
-	return New(token, cacheURL)
+	c, err := New(token, cacheURL, opts...)
+	if err != nil {
+		return nil, err
+	}
+	c.GHES = c.GHES || isGHESEnv()
+	return c, nil
 }
 
-func New(token, url string) (*Cache, error) {
-	tk, _, err := new(jwt.Parser).ParseUnverified(token, jwt.MapClaims{})
+func New(token, url string, opts ...Opt) (*Cache, error) {
+	c, err := newCache(token, url, opts)
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, err
 	}
-	claims, ok := tk.Claims.(jwt.MapClaims)
-	if !ok {
-		return nil, errors.Errorf("invalid token without claims map")
+	c.startWarmUp()
+	return c, nil
+}
+
+// newCache returns a Cache of url like New without warming it up.
+func newCache(token, url string, opts []Opt) (*Cache, error) {
+	c := &Cache{
+		URL: url,
 	}
-	ac, ok := claims["ac"]
-	if !ok {
-		return nil, errors.Errorf("invalid token without access controls")
+	for _, o := range opts {
+		o(c)
 	}
-	acs, ok := ac.(string)
-	if !ok {
-		return nil, errors.Errorf("invalid token without access controls type")
+	if _, err := getHash(c.hashName()); err != nil {
+		return nil, err
 	}
-
-	scopes := []Scope{}
-	if err := json.Unmarshal([]byte(acs), &scopes); err != nil {
-		return nil, errors.Wrap(err, "failed to parse token access controls")
+	tk, scopes, err := parseToken(token, c.RelaxedToken)
+	if err != nil {
+		return nil, err
 	}
-	Log("parsed token: scopes %+v", scopes)
-
-	return &Cache{
-		scopes: scopes,
-		URL:    url,
-		Token:  tk,
-	}, nil
+	c.scopes, c.Token = scopes, tk
+	c.debug(context.TODO(), "parsed token", F("scopes", scopes))
+	c.warnInsecure(context.TODO())
+	return c, nil
 }
 
 type Scope struct {
@@ -100,144 +135,1203 @@ type Cache struct {
 	scopes []Scope
 	URL    string
 	Token  *jwt.Token
+	// Clock defaults to the system clock when nil.
+	Clock Clock
+	// SoftTimeout makes Load and Entry.Download return ErrTimedOutSoft when
+	// they take longer. An abandoned Load keeps running in the background
+	// until HardTimeout, a Download is canceled as its writer is owned by
+	// the caller.
+	SoftTimeout time.Duration
+	// HardTimeout cancels the requests of Load, Save and Entry.Download
+	// when they take longer.
+	HardTimeout time.Duration
+	// ReserveTimeout, CommitTimeout and ChunkTimeout limit each reserve,
+	// commit and chunk upload attempt when positive. A chunk that times out
+	// is uploaded again like other failed chunks.
+	ReserveTimeout time.Duration
+	CommitTimeout  time.Duration
+	ChunkTimeout   time.Duration
+	// CompressRequests enables gzip encoding of JSON request bodies. It is
+	// disabled automatically if the server does not support it.
+	CompressRequests bool
+	// RetryPolicy defaults to DefaultRetryPolicy when nil.
+	RetryPolicy *RetryPolicy
+	// Downloader fetches entry archives instead of a plain GET when set.
+	Downloader Downloader
+	// Signer is called for every request to the cache service when set.
+	Signer Signer
+	// LoadPolicy, SavePolicy and DeletePolicy configure failure handling of
+	// Load, of Save, SaveValue and SaveReader, and of Abandon when set.
+	LoadPolicy   *OperationPolicy
+	SavePolicy   *OperationPolicy
+	DeletePolicy *OperationPolicy
+	// Compression is the name of a registered Compression applied to
+	// payloads of Save and Download when set. Only entries saved with the
+	// same compression are found by Load.
+	Compression string
+	// VersionSalt and VersionPaths namespace the cache version of entries,
+	// like the paths of actions/toolkit. Entries are only found by clients
+	// using the same values.
+	VersionSalt  string
+	VersionPaths []string
+	// Hash is the name of the registered hash used for versions and
+	// content digests, DefaultHash when empty.
+	Hash string
+	// Progress receives the progress of saves and downloads when set.
+	Progress ProgressFunc
+	// ProgressInterval makes downloads report progress at least that often
+	// when positive, see WithProgressInterval.
+	ProgressInterval time.Duration
+	// Tenant identifies the owner of the token, eg. "owner/repo". Operations
+	// with a different tenant attached by ForTenant are refused.
+	Tenant string
+	// RefreshExpiredURLs makes Entry downloads look up the entry again when
+	// its signed URL has expired instead of returning ErrURLExpired, and
+	// resume downloads whose URL is rejected with 403 with a fresh one.
+	RefreshExpiredURLs bool
+	// MetadataHeaderPrefix enables sending the context Metadata as request
+	// headers, eg. "X-Cache-Meta-", when set.
+	MetadataHeaderPrefix string
+	// MissTTL defaults to the package LoadMissTTL when 0, a negative value
+	// disables the negative cache.
+	MissTTL time.Duration
+	// UploadConcurrency defaults to the package UploadConcurrency when 0.
+	UploadConcurrency int
+	// UploadChunkSize defaults to the package UploadChunkSize when 0.
+	UploadChunkSize int
+	// MaxUploadMemory defaults to the package MaxUploadMemory when 0.
+	MaxUploadMemory int64
+	// AdaptiveChunkSize makes uploads adapt the size of their chunks, up
+	// to UploadChunkSize, to the duration and retries of the chunks.
+	AdaptiveChunkSize bool
+	// AdaptiveChunkTarget defaults to the package AdaptiveChunkTarget when
+	// 0.
+	AdaptiveChunkTarget time.Duration
+	// DownloadConcurrency defaults to the package DownloadConcurrency when 0.
+	DownloadConcurrency int
+	// DownloadChunkSize defaults to the package DownloadChunkSize when 0.
+	DownloadChunkSize int
+	// UploadIdleTimeout defaults to the package UploadIdleTimeout when 0.
+	UploadIdleTimeout time.Duration
+	// DownloadIdleTimeout defaults to the package DownloadIdleTimeout when 0.
+	DownloadIdleTimeout time.Duration
+	// DownloadResumeAttempts defaults to the package DownloadResumeAttempts
+	// when 0, a negative value disables resuming.
+	DownloadResumeAttempts int
+	// MaxChunkRetries defaults to the package MaxChunkRetries when 0, a
+	// negative value disables uploading failed chunks again.
+	MaxChunkRetries int
+	// MaxChunkRangeRetries defaults to the package MaxChunkRangeRetries
+	// when 0, a negative value disables uploading unconfirmed ranges again.
+	MaxChunkRangeRetries int
+	// MaxLookupKeys defaults to the package MaxLookupKeys when 0, a
+	// negative value sends all keys in one lookup.
+	MaxLookupKeys int
+	// LoadAllConcurrency defaults to the package LoadAllConcurrency when 0.
+	LoadAllConcurrency int
+	// SaveReaderMemoryLimit defaults to the package SaveReaderMemoryLimit
+	// when 0, a negative value spools every payload to a temporary file.
+	SaveReaderMemoryLimit int
+	// ReaderAtBlockSize defaults to the package ReaderAtBlockSize when 0.
+	ReaderAtBlockSize int
+	// MaxResponseSize defaults to the package MaxResponseSize when 0.
+	MaxResponseSize int64
+	// ResponseLimits overrides MaxResponseSize for the responses of
+	// endpoints, see WithResponseLimit.
+	ResponseLimits map[string]int64
+	// MaxErrorBodySize defaults to the package MaxErrorBodySize when 0.
+	MaxErrorBodySize int64
+	// HTTPClient is used for all requests to the cache service and blob
+	// storage. When nil the Cache uses its own client whose transport keeps
+	// MaxIdleConnsPerHost idle connections.
+	HTTPClient *http.Client
+	// RootCAs are the certificate authorities trusted by the client of a
+	// Cache without an HTTPClient, the system ones when nil.
+	RootCAs *x509.CertPool
+	// InsecureSkipVerify disables the verification of certificates by the
+	// client of a Cache without an HTTPClient.
+	InsecureSkipVerify bool
+	// DialContext opens the connections of the client of a Cache without
+	// an HTTPClient, see WithDialContext.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+	// Resolver resolves the hosts dialed by the client of a Cache without
+	// an HTTPClient or DialContext.
+	Resolver *net.Resolver
+	// MaxIdleConnsPerHost defaults to the package MaxIdleConnsPerHost when
+	// zero.
+	MaxIdleConnsPerHost int
+	// KeepAlive defaults to the package KeepAlive when zero, a negative
+	// value disables keep-alive probes.
+	KeepAlive time.Duration
+	// Logger defaults to the package Log when nil.
+	Logger Logger
+	// UserAgent is sent with every request when set.
+	UserAgent string
+	// WarmUpOnCreate makes New and TryEnv warm up the connection to the
+	// cache service like the package WarmUp.
+	WarmUpOnCreate bool
+	// OperationLog receives an OperationRecord per operation when set.
+	OperationLog io.Writer
+	// Tracer starts spans for cache operations when set.
+	Tracer Tracer
+	// AbandonFailedSaves makes failed saves Abandon their reservation so
+	// the key is not left locked.
+	AbandonFailedSaves bool
+	// VerifyCommits makes saves check the entry and stored size after
+	// committing, see WithCommitVerification.
+	VerifyCommits bool
+	// VerifyChecksums makes saves store the digest of the data and Download
+	// verify it.
+	VerifyChecksums bool
+	// EncryptionKey encrypts payloads with AES-GCM when set.
+	EncryptionKey []byte
+	// APIVersion pins the api-version of v1 requests when set.
+	APIVersion string
+	// APIVersions are the api-versions negotiated for v1 requests, newest
+	// first. The built-in versions are used when empty.
+	APIVersions []string
+	// APIPathPrefix defaults to DefaultAPIPathPrefix when empty.
+	APIPathPrefix string
+	// URLJoiner builds request URLs from URL and the path of an endpoint
+	// when set. By default the path is appended to URL, which does not need
+	// a trailing slash.
+	URLJoiner func(base, p string) string
+	// SingleChunkUploads uploads each entry in one request.
+	SingleChunkUploads bool
+	// GHES is set by TryEnv when GITHUB_SERVER_URL is a GitHub Enterprise
+	// Server instance. It does not change any requests, WithGHES applies
+	// the compatibility settings.
+	GHES bool
+	// BandwidthLimit limits the bytes per second transferred by all
+	// requests when positive.
+	BandwidthLimit int64
+	// DebugHTTP logs every request and response at debug level.
+	DebugHTTP bool
+	// Metrics receives measurements of requests and transfers when set.
+	Metrics Metrics
+	// Events receives the lifecycle events of operations when set.
+	Events Events
+	// QuotaSource is queried for the remaining cache storage before saves
+	// when set, see WithQuota.
+	QuotaSource QuotaSource
+	// QuotaFailFast fails saves larger than the remaining cache storage
+	// with ErrQuotaExceeded instead of logging a warning.
+	QuotaFailFast bool
+	// QuotaTTL defaults to the package QuotaTTL when 0, a negative value
+	// queries the QuotaSource before every save.
+	QuotaTTL time.Duration
+	// TokenSource refreshes Token before it expires and after the service
+	// rejects it when set.
+	TokenSource TokenSource
+	// ChunkMD5 sends the Content-MD5 of every uploaded chunk and checks
+	// the MD5 of the ranges downloaded from Azure Blob SAS URLs.
+	ChunkMD5 bool
+	// RequestRateLimit limits the requests per second sent to the cache
+	// service when positive.
+	RequestRateLimit float64
+	// AuthScheme is the scheme of the Authorization header, "Bearer" when
+	// empty.
+	AuthScheme string
+	// RelaxedToken accepts runtime tokens that are not JWTs or have no
+	// access controls claim. Scopes are not checked for such tokens.
+	RelaxedToken bool
+	// LocalCacheDir keeps copies of saved and downloaded entries when set,
+	// see WithLocalCache.
+	LocalCacheDir string
+	// Backend stores the entries instead of the v1 cache service when set.
+	// It is not used by caches created with NewV2.
+	Backend Backend
+	// ReservationTTL defaults to the package ReservationTTL when 0, a
+	// negative value means reservations do not expire.
+	ReservationTTL time.Duration
+	// MaxOrphanedReservations defaults to the package
+	// MaxOrphanedReservations when 0, a negative value disables the limit.
+	MaxOrphanedReservations int
+	// KeyTransforms rewrite the keys of entries in order before they are
+	// stored, see WithKeyTransform.
+	KeyTransforms []KeyTransform
+	// DryRun makes saves validate and log what they would upload without
+	// changing the cache.
+	DryRun bool
+	// ReadOnly makes saves fail with a *ReadOnlyError.
+	ReadOnly bool
+	// HedgeDelay makes Load send a second lookup request when the first
+	// has not returned after it, when positive.
+	HedgeDelay time.Duration
+	// BreakerThreshold is the number of consecutive failed requests to the
+	// cache service after which requests fail with ErrBackendUnavailable
+	// for BreakerCooldown. Zero disables the circuit breaker.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	opLogMu          sync.Mutex
+	tokenMu          sync.Mutex
+	mu               sync.Mutex
+	misses           map[string]time.Time
+	pending          map[*ReservationInfo]struct{}
+	orphaned         []ReservationInfo
+	apiVersions      map[endpoint]int
+	noCompression    bool
+	rateLimiter      *rateLimiter
+	reqLimiter       *rateLimiter
+	rateLimitedUntil time.Time
+	breakerFailures  int
+	breakerOpenUntil time.Time
+	loadOrSave       singleflight.Group
+	clientOnce       sync.Once
+	budgetOnce       sync.Once
+	budget           *semaphore.Weighted
+	budgetSize       int64
+	client           *http.Client
+	quota            *CacheQuota
+	quotaAt          time.Time
+	quotaSaved       int64
+	v2               bool
 }
 
 func (c *Cache) Scopes() []Scope {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	return c.scopes
 }
 
+func (c *Cache) checkScope(scope string, p Permission) error {
+	granted, ok := c.permission(scope)
+	if !ok {
+		return errors.Errorf("scope %s not found in token", scope)
+	}
+	if granted&p != p {
+		return errors.Errorf("token has no %s permission for scope %s", p, scope)
+	}
+	return nil
+}
+
+func (c *Cache) writeScope() string {
+	for _, s := range c.Scopes() {
+		if s.Permission&PermissionWrite != 0 {
+			return s.Scope
+		}
+	}
+	return ""
+}
+
+type LoadOpt func(*loadOpt)
+
+type loadOpt struct {
+	scopes   []string
+	freshest bool
+	stat     bool
+	salt     *string
+	// noFallback restricts the scopes to the ref of the job
+	noFallback bool
+	maxAge     time.Duration
+}
+
+// LoadScope restricts Load to entries stored in scope. The token needs read
+// permission for the scope.
+func LoadScope(scope string) LoadOpt {
+	return LoadScopes(scope)
+}
+
+// LoadScopes restricts Load to entries stored in one of scopes, eg. only
+// the ref of the current branch to ignore entries the service falls back to
+// from the default branch. Entries of other scopes are reported as misses.
+// The token needs read permission for every scope.
+func LoadScopes(scopes ...string) LoadOpt {
+	return func(o *loadOpt) {
+		o.scopes = append(o.scopes, scopes...)
+	}
+}
+
+// inScope reports if an entry stored in scope passes the scope filter.
+func (o *loadOpt) inScope(scope string) bool {
+	if len(o.scopes) == 0 {
+		return true
+	}
+	for _, s := range o.scopes {
+		if normalizeScope(s) == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadFreshest looks up every key separately and returns the most recently
+// created match instead of the first key that matches. An exact match of
+// the first key is still preferred.
+func LoadFreshest() LoadOpt {
+	return func(o *loadOpt) {
+		o.freshest = true
+	}
+}
+
+// Load returns the entry of the first of keys that matches. A miss is
+// reported as a nil Entry and nil error, however the service signaled it.
 func (c *Cache) Load(ctx context.Context, keys ...string) (*Entry, error) {
+	return c.LoadWithOpts(ctx, keys)
+}
+
+// Lookup is LoadWithOpts reporting explicitly if an entry was found. The
+// service signals misses with empty responses, 204 No Content or 404 Not
+// Found depending on the protocol and backend, all of them are reported as
+// not found.
+func (c *Cache) Lookup(ctx context.Context, keys []string, opts ...LoadOpt) (*Entry, bool, error) {
+	ce, err := c.LoadWithOpts(ctx, keys, opts...)
+	return ce, ce != nil, err
+}
+
+func (c *Cache) LoadWithOpts(ctx context.Context, keys []string, opts ...LoadOpt) (*Entry, error) {
+	ctx = withLoadVersion(ctx, opts)
+	ctx, span := c.startSpan(ctx, "Load", F("cache.key", strings.Join(keys, ",")))
+	ctx, done := c.startOp(ctx, "load", strings.Join(keys, ","))
+	ctx, cancel := withPolicy(ctx, c.LoadPolicy)
+	defer cancel()
+	var ce *Entry
+	err := c.withTimeouts(ctx, true, func(ctx context.Context) error {
+		var err error
+		ce, err = c.load(ctx, keys, opts)
+		return err
+	})
+	if err != nil {
+		span.End(err)
+		err = done(0, false, err)
+		return nil, c.bestEffort(ctx, c.LoadPolicy, "load cache "+strings.Join(keys, ","), err)
+	}
+	span.SetAttributes(F("cache.hit", ce != nil))
+	span.End(nil)
+	done(0, ce != nil, nil)
+	if ce != nil {
+		c.events().OnLoadHit(ctx, LoadEvent{Keys: keys, Entry: ce})
+	} else {
+		c.events().OnLoadMiss(ctx, LoadEvent{Keys: keys})
+	}
+	return ce, nil
+}
+
+func (c *Cache) load(ctx context.Context, keys []string, opts []LoadOpt) (*Entry, error) {
+	if err := c.checkTenant(ctx); err != nil {
+		return nil, err
+	}
+	if err := c.validateKeys(keys...); err != nil {
+		return nil, err
+	}
+	var lo loadOpt
+	for _, o := range opts {
+		o(&lo)
+	}
+	if lo.freshest && c.v2 {
+		return nil, errors.Errorf("freshest load is not supported by the v2 cache service")
+	}
+	if lo.noFallback {
+		scope := c.ownScope()
+		if scope == "" {
+			return nil, errors.Errorf("load without fallback needs a token with scopes")
+		}
+		lo.scopes = []string{scope}
+	}
+	if len(lo.scopes) > 0 && c.v2 {
+		return nil, errors.Errorf("load scope is not supported by the v2 cache service")
+	}
+	for _, scope := range lo.scopes {
+		if err := c.checkScope(scope, PermissionRead); err != nil {
+			return nil, err
+		}
+	}
+
+	missKey := c.keyVersion(ctx, keys[0]) + "|" + strings.Join(escapeKeys(keys), ",")
+	if c.isMiss(missKey) {
+		c.debug(ctx, "load cache: recent miss", F("keys", strings.Join(keys, ",")))
+		return nil, nil
+	}
+
+	headers := http.Header{}
+	ctx = withHeaderSink(ctx, headers)
+	var ce *Entry
+	var err error
+	if c.v2 {
+		ce, err = c.lookupV2(ctx, keys, missKey)
+	} else if lo.freshest && len(keys) > 1 {
+		ce, err = c.lookupFreshest(ctx, keys, missKey)
+	} else {
+		ce, err = c.lookup(ctx, keys, missKey)
+	}
+	if err != nil || ce == nil {
+		return nil, err
+	}
+	ce.Headers = headers
+	if !lo.inScope(ce.Scope) {
+		c.info(ctx, "load cache: ignoring entry from other scope", F("key", ce.Key), F("scope", ce.Scope))
+		return nil, nil
+	}
+	ce.c = c
+	ce.enc = c.encoding()
+	if salt, ok := versionSalt(ctx); ok {
+		ce.salt = &salt
+	}
+	ce.MatchedKey, ce.Exact = matchKey(keys, ce.Key)
+	if expired, err := c.expired(ctx, ce, &lo); err != nil || expired {
+		return nil, err
+	}
+	if lo.stat {
+		if err := ce.stat(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return ce, nil
+}
+
+// matchKey returns the requested key that matched key and whether it was an
+// exact match of the primary key. The service matches keys in order, each
+// exactly first and then as a prefix.
+func matchKey(keys []string, key string) (string, bool) {
+	for _, k := range keys {
+		if k == key {
+			return k, k == keys[0]
+		}
+		if strings.HasPrefix(key, k) {
+			return k, false
+		}
+	}
+	return "", false
+}
+
+func (c *Cache) lookup(ctx context.Context, keys []string, missKey string) (*Entry, error) {
+	version := c.keyVersion(ctx, keys[0])
+	for _, batch := range lookupBatches(keys, c.maxLookupKeys()) {
+		batch := batch
+		ce, err := c.hedged(ctx, func(ctx context.Context) (*Entry, error) {
+			return c.lookupTransformed(ctx, batch, func(keys []string) (*Entry, error) {
+				return c.backend().Lookup(ctx, keys, version)
+			})
+		})
+		if errors.Is(err, ErrCacheNotFound) {
+			c.debug(ctx, "load cache: not found", F("keys", strings.Join(batch, ",")), F("error", err))
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if ce != nil {
+			return ce, nil
+		}
+	}
+	return c.miss(missKey)
+}
+
+// lookupService returns the entry of the first of keys that matches from
+// the cache service, nil on a miss.
+func (c *Cache) lookupService(ctx context.Context, keys []string, version string) (*Entry, error) {
 	req, err := http.NewRequest("GET", c.url("cache"), nil)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 	c.auth(req)
-	c.accept(req)
 	q := req.URL.Query()
-	q.Set("keys", strings.Join(keys, ","))
-	q.Set("version", version(keys[0]))
+	q.Set("keys", strings.Join(escapeKeys(keys), ","))
+	q.Set("version", version)
 	req.URL.RawQuery = q.Encode()
 	req = req.WithContext(ctx)
-	Log("load cache %s", req.URL.String())
-	resp, err := http.DefaultClient.Do(req)
+	c.debug(ctx, "load cache", F("url", req.URL.String()))
+	resp, err := c.do(endpointLookup, req)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
+	defer c.closeResponse(resp)
+	if err := c.checkResponse(resp); err != nil {
+		return nil, errors.Wrap(err, "failed to load cache")
+	}
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
 	var ce Entry
-	if err := json.NewDecoder(resp.Body).Decode(&ce); err != nil && !errors.Is(err, io.EOF) {
-		return nil, errors.WithStack(err)
+	if err := c.decodeResponse(resp, &ce); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
 	}
-	if ce.Key == "" {
+	// empty bodies, null and entries without a key or location are misses
+	if ce.Key == "" || ce.URL == "" {
 		return nil, nil
 	}
+	ce.Key = unescapeKey(ce.Key)
 	return &ce, nil
 }
 
-func (c *Cache) Save(ctx context.Context, key string, ra io.ReaderAt, size int64) error {
-	dt, err := json.Marshal(ReserveCacheReq{Key: key, Version: version(key)})
+func (c *Cache) lookupFreshest(ctx context.Context, keys []string, missKey string) (*Entry, error) {
+	var best *Entry
+	for i, k := range keys {
+		ce, err := c.lookup(ctx, []string{k}, c.keyVersion(ctx, keys[0])+"|"+escapeKey(k))
+		if err != nil {
+			return nil, err
+		}
+		if ce == nil {
+			continue
+		}
+		if i == 0 && ce.Key == k {
+			return ce, nil
+		}
+		if best == nil || ce.CreationTime.After(best.CreationTime) {
+			best = ce
+		}
+	}
+	if best == nil {
+		c.addMiss(missKey)
+	}
+	return best, nil
+}
+
+// miss records a miss of missKey and returns the result of Load for it.
+func (c *Cache) miss(missKey string) (*Entry, error) {
+	c.addMiss(missKey)
+	return nil, nil
+}
+
+// WithMissTTL sets how long a Load that found no entry is remembered for,
+// a negative d disables the negative cache.
+func WithMissTTL(d time.Duration) Opt {
+	return func(c *Cache) {
+		c.MissTTL = d
+	}
+}
+
+func (c *Cache) missTTL() time.Duration {
+	if c.MissTTL != 0 {
+		return c.MissTTL
+	}
+	return LoadMissTTL
+}
+
+func (c *Cache) isMiss(k string) bool {
+	ttl := c.missTTL()
+	if ttl <= 0 {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t, ok := c.misses[k]
+	if !ok {
+		return false
+	}
+	if c.clock().Now().Sub(t) > ttl {
+		delete(c.misses, k)
+		return false
+	}
+	return true
+}
+
+func (c *Cache) addMiss(k string) {
+	if c.missTTL() <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.misses == nil {
+		c.misses = map[string]time.Time{}
+	}
+	c.misses[k] = c.clock().Now()
+}
+
+// clearMisses forgets remembered misses that a save of key would now satisfy.
+func (c *Cache) clearMisses(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k := range c.misses {
+		keys := strings.SplitN(k, "|", 2)[1]
+		for _, p := range strings.Split(keys, ",") {
+			if strings.HasPrefix(key, unescapeKey(p)) {
+				delete(c.misses, k)
+				break
+			}
+		}
+	}
+}
+
+type SaveOpt func(*saveOpt)
+
+type saveOpt struct {
+	scope          string
+	headers        http.Header
+	codec          string
+	ignoreExisting bool
+	dedup          bool
+	raw            bool
+	manifest       string
+	onSaved        func(key string)
+	result         *SaveResult
+	ifMissing      bool
+	entryMetadata  *EntryMetadata
+	maxSize        int64
+	maxSizeSet     bool
+	salt           *string
+	sharded        bool
+	shardSize      int64
+}
+
+// SaveScope validates that the token has write permission for scope and that
+// the service would store the entry there before saving.
+func SaveScope(scope string) SaveOpt {
+	return func(o *saveOpt) {
+		o.scope = scope
+	}
+}
+
+// SaveIgnoreAlreadyExists makes a save of a key that already exists, or that
+// another job is saving at the same time, succeed without uploading.
+func SaveIgnoreAlreadyExists() SaveOpt {
+	return func(o *saveOpt) {
+		o.ignoreExisting = true
+	}
+}
+
+func (c *Cache) saveOpts(ctx context.Context, opts []SaveOpt) (*saveOpt, error) {
+	if err := c.checkTenant(ctx); err != nil {
+		return nil, err
+	}
+	if c.ReadOnly {
+		return nil, errors.WithStack(&ReadOnlyError{Op: "save"})
+	}
+	var so saveOpt
+	for _, o := range opts {
+		o(&so)
+	}
+	if name := c.compression(); name != "" && !so.raw {
+		if _, err := getCompression(name); err != nil {
+			return nil, err
+		}
+	}
+	if so.scope != "" {
+		if err := c.checkWriteScope(so.scope); err != nil {
+			return nil, err
+		}
+	}
+	return &so, nil
+}
+
+// skipExisting reports if a save that failed with err is skipped because of
+// SaveIgnoreAlreadyExists. Keys locked by this Cache are not skipped.
+func (so *saveOpt) skipExisting(err error) bool {
+	return so.ignoreExisting && errors.Is(err, ErrReserveConflict) && !errors.Is(err, ErrKeyLocked)
+}
+
+// Save saves size bytes of ra under key. A *SpooledReaderAt is closed when
+// Save returns.
+func (c *Cache) Save(ctx context.Context, key string, ra io.ReaderAt, size int64, opts ...SaveOpt) error {
+	if s, ok := ra.(*SpooledReaderAt); ok {
+		defer s.Close()
+	}
+	ctx = withSaveVersion(ctx, opts)
+	ctx, span := c.startSpan(ctx, "Save", F("cache.key", key), F("cache.bytes", size))
+	ctx, done := c.startOp(ctx, "save", key)
+	ctx, cancel := withPolicy(ctx, c.SavePolicy)
+	defer cancel()
+	opts, saveMetadata := c.withEntryMetadata(ctx, key, opts)
+	opts, finish := c.checksummed(ctx, key, opts)
+	var digest func() (string, error)
+	if finish != nil {
+		digest = c.readerDigestAsync(io.NewSectionReader(ra, 0, size))
+	}
+	err := c.save(ctx, key, ra, size, opts)
+	if digest != nil {
+		// ra must not be read after Save returns
+		d, derr := digest()
+		if err == nil {
+			if err = derr; err == nil {
+				err = finish(d)
+			}
+		}
+	}
+	if err == nil && saveMetadata != nil {
+		saveMetadata()
+	}
+	if err == nil {
+		c.storeLocal(ctx, key, ra, size)
+	}
+	span.End(err)
+	err = done(size, false, err)
+	return c.bestEffort(ctx, c.SavePolicy, "save cache "+key, err)
+}
+
+func (c *Cache) save(ctx context.Context, key string, ra io.ReaderAt, size int64, opts []SaveOpt) (err error) {
+	if err := c.validateKeys(key); err != nil {
+		return err
+	}
+	so, err := c.saveOpts(ctx, opts)
 	if err != nil {
-		return errors.WithStack(err)
+		return err
+	}
+	if err := c.checkQuota(ctx, key, size); err != nil {
+		return err
+	}
+	if limit := so.shardLimit(); limit > 0 && size > limit {
+		return c.saveShards(ctx, key, ra, size, limit, opts)
+	}
+	if err := so.checkSize(key, size); err != nil {
+		return err
+	}
+	if c.DryRun {
+		c.logDryRun(ctx, key, size, so)
+		return nil
+	}
+	if so.headers != nil {
+		ctx = withHeaderSink(ctx, so.headers)
+	}
+	ctx, finishStats := c.withSaveStats(ctx, so.result)
+	defer finishStats()
+	if c.HardTimeout > 0 {
+		var cancel func()
+		ctx, cancel = context.WithTimeout(ctx, c.HardTimeout)
+		defer cancel()
+	}
+
+	if so.ifMissing {
+		if ok, err := c.exists(ctx, key); err != nil || ok {
+			return err
+		}
+		opts = append(opts, existenceChecked)
+	}
+	if so.dedup {
+		return c.saveDedup(ctx, key, ra, size, opts)
+	}
+	if enc := c.encoding(); !enc.plain() && !so.raw {
+		r := encodeTo(enc, ra, size)
+		defer r.Close()
+		return c.saveReader(ctx, key, r, append(opts, noEncode))
+	}
+
+	defer func() {
+		if err == nil {
+			c.addQuotaUsage(size)
+		}
+	}()
+	ctx = c.withProgress(ctx, "save", key, size)
+	if c.v2 {
+		err := c.saveV2(ctx, key, ra, size)
+		if so.skipExisting(err) {
+			c.info(ctx, "save cache: already exists, skipping", F("key", key))
+			return nil
+		}
+		if err == nil {
+			err = c.verifyCommit(ctx, key, size)
+		}
+		if err == nil {
+			so.saved(key)
+		}
+		return err
+	}
+
+	// the settings are read once so changes do not affect a running save
+	chunkSize := c.uploadChunkSize()
+	if c.SingleChunkUploads && size > 0 {
+		chunkSize = int(size)
+	}
+	m, err := loadManifest(so.manifest, key, size, chunkSize, c.hashName())
+	if err != nil {
+		return err
+	}
+	rereserved := false
+	for {
+		var id int
+		resumed := m != nil && m.CacheID != 0
+		if resumed {
+			id = m.CacheID
+			c.info(ctx, "save cache: resuming upload", F("key", key), F("cacheID", id))
+		} else {
+			id, err = c.reserve(ctx, key)
+			if err != nil {
+				if so.skipExisting(err) {
+					c.info(ctx, "save cache: already exists, skipping", F("key", key))
+					return nil
+				}
+				return err
+			}
+			if err := m.start(id); err != nil {
+				return err
+			}
+		}
+		r := c.trackReserve(id, key)
+		var acked rangeSet
+		err := c.upload(ctx, id, ra, size, chunkSize, &acked, m)
+		if err == nil {
+			err = emptyRejected(key, size, c.commitEntry(ctx, key, id, size))
+		}
+		if err != nil && resumed && isStaleUpload(ctx, err) {
+			// the reservation of the manifest expired or was committed
+			c.trackCommit(r)
+			c.warn(ctx, "save cache: resumed upload is gone, reserving again", F("key", key), F("cacheID", id), F("error", err))
+			m.CacheID = 0
+			continue
+		}
+		if err != nil && !resumed && !rereserved && c.pastExpiry(*r) && isStaleUpload(ctx, err) {
+			// the upload took longer than the reservation was valid
+			c.trackCommit(r)
+			c.warn(ctx, "save cache: reservation expired during upload, reserving again", F("key", key), F("cacheID", id), F("created", r.Created), F("error", err))
+			rereserved = true
+			if m != nil {
+				m.CacheID = 0
+			}
+			continue
+		}
+		if err != nil {
+			err = interrupted(ctx, err, key, id, size, &acked)
+			c.trackOrphan(ctx, r, err)
+			c.abandonFailed(ctx, *r, m)
+			return err
+		}
+		recordCacheID(ctx, int64(id))
+		m.remove()
+		c.trackCommit(r)
+		c.clearMisses(key)
+		if err := c.verifyCommit(ctx, key, size); err != nil {
+			return err
+		}
+		so.saved(key)
+		return nil
+	}
+}
+
+// isStaleUpload reports if err of a resumed upload means the service no
+// longer knows its cache ID.
+func isStaleUpload(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var ae *GithubAPIError
+	return errors.Is(err, ErrCacheNotFound) || (errors.As(err, &ae) && ae.StatusCode == http.StatusBadRequest)
+}
+
+func (c *Cache) reserve(ctx context.Context, key string) (id int, err error) {
+	ctx, span := c.startSpan(ctx, "reserve", F("cache.key", key))
+	defer func() {
+		span.SetAttributes(F("cache.id", id))
+		span.End(err)
+		c.events().OnReserve(ctx, ReserveEvent{Key: key, ID: id, Err: err})
+	}()
+	if err := c.checkReserve(); err != nil {
+		return 0, err
+	}
+	err = withStepTimeout(ctx, c.ReserveTimeout, "reserve cache "+key, func(ctx context.Context) error {
+		var err error
+		id, err = c.backend().Reserve(ctx, c.transformKey(key), c.keyVersion(ctx, key))
+		return err
+	})
+	return id, err
+}
+
+func (c *Cache) reserveService(ctx context.Context, key, version string) (int, error) {
+	dt, err := json.Marshal(ReserveCacheReq{Key: escapeKey(key), Version: version})
+	if err != nil {
+		return 0, errors.WithStack(err)
 	}
 	req, err := http.NewRequest("POST", c.url("caches"), bytes.NewReader(dt))
 	if err != nil {
-		return errors.WithStack(err)
+		return 0, errors.WithStack(err)
 	}
 	c.auth(req)
-	c.accept(req)
 	req.Header.Set("Content-Type", "application/json")
 	req = req.WithContext(ctx)
-	Log("save cache %s", req.URL.String())
-	Log("body: %s", dt)
-	resp, err := http.DefaultClient.Do(req)
+	c.debug(ctx, "reserve cache", F("url", req.URL.String()), F("body", string(dt)))
+	resp, err := c.do(endpointReserve, req)
 	if err != nil {
-		return errors.WithStack(err)
+		return 0, errors.WithStack(err)
+	}
+	defer c.closeResponse(resp)
+	if err := c.checkResponse(resp); err != nil {
+		return 0, c.keyLocked(key, errors.Wrapf(err, "failed to reserve cache %s", key))
 	}
-	dec := json.NewDecoder(resp.Body)
 	var cr ReserveCacheResp
-	if err := dec.Decode(&cr); err != nil {
-		io.Copy(os.Stderr, dec.Buffered())
-		return errors.WithStack(err)
+	if err := c.decodeResponse(resp, &cr); err != nil {
+		return 0, errors.Wrapf(err, "failed to decode reserve response of %s", key)
 	}
+	return cr.CacheID, nil
+}
 
+// upload uploads ra in parallel chunks, adding the acknowledged ranges to
+// acked. Chunks recorded in m are skipped if their data is unchanged. The
+// first failed chunk cancels the uploads of the others.
+func (c *Cache) upload(ctx context.Context, id int, ra io.ReaderAt, size int64, chunkSize int, acked *rangeSet, m *resumeManifest) error {
 	var mu sync.Mutex
-	eg, ctx := errgroup.WithContext(ctx)
+	var eg errgroup.Group
+	ctx, failures := newChunkFailures(ctx)
+	var sizer *chunkSizer
+	if m == nil {
+		// resumable uploads keep the chunk offsets of the manifest
+		sizer = c.newChunkSizer(chunkSize)
+	}
 	offset := int64(0)
-	for i := 0; i < UploadConcurrency; i++ {
+	for i, n := 0, c.uploadConcurrency(); i < n; i++ {
 		eg.Go(func() error {
-			mu.Lock()
-			start := offset
-			if start >= size {
+			for {
+				mu.Lock()
+				start := offset
+				if start >= size || ctx.Err() != nil {
+					mu.Unlock()
+					return ctx.Err()
+				}
+				if sizer != nil {
+					chunkSize = sizer.next()
+				}
+				end := start + int64(chunkSize)
+				if end > size {
+					end = size
+				}
+				offset = end
 				mu.Unlock()
-				return nil
-			}
-			end := start + int64(UploadChunkSize)
-			if end > size {
-				end = size
-			}
-			offset = end
-			mu.Unlock()
 
-			return c.uploadChunk(ctx, cr.CacheID, ra, start, end-start)
+				ok, err := m.uploaded(ra, start, end)
+				if err != nil {
+					return failures.fail(start, err)
+				}
+				if !ok {
+					if err := c.uploadSizedChunk(ctx, sizer, id, ra, start, end-start); err != nil {
+						return failures.fail(start, err)
+					}
+					if err := m.record(ra, start, end); err != nil {
+						return failures.fail(start, err)
+					}
+				}
+				mu.Lock()
+				acked.add(start, end)
+				mu.Unlock()
+				reportProgress(ctx, end-start)
+			}
 		})
 	}
 
-	if err := eg.Wait(); err != nil {
+	err := eg.Wait()
+	if err := failures.result(id, chunksIn(size-offset, chunkSize), err); err != nil {
 		return err
 	}
+	return verifyUploaded(id, acked, size)
+}
+
+// uploadSizedChunk uploads a chunk and reports its duration and retries to
+// sizer if it is not nil.
+func (c *Cache) uploadSizedChunk(ctx context.Context, sizer *chunkSizer, id int, ra io.ReaderAt, off, n int64) error {
+	if sizer == nil {
+		return c.uploadChunk(ctx, id, ra, off, n)
+	}
+	ctx, retries := withRetryCounter(ctx)
+	start := c.clock().Now()
+	if err := c.uploadChunk(ctx, id, ra, off, n); err != nil {
+		return err
+	}
+	sizer.observe(int(n), c.clock().Now().Sub(start), retries() > 0)
+	return nil
+}
+
+// chunksIn returns the number of chunks of chunkSize in n bytes.
+func chunksIn(n int64, chunkSize int) int {
+	if n <= 0 {
+		return 0
+	}
+	return int((n + int64(chunkSize) - 1) / int64(chunkSize))
+}
+
+func verifyUploaded(id int, acked *rangeSet, size int64) error {
+	if missing := acked.missing(size); len(missing) > 0 {
+		return errors.Errorf("refusing to commit cache %d, ranges not uploaded: %v", id, missing)
+	}
+	return nil
+}
 
-	dt, err = json.Marshal(CommitCacheReq{Size: size})
+func (c *Cache) commit(ctx context.Context, id int, size int64) (err error) {
+	ctx, span := c.startSpan(ctx, "commit", F("cache.id", id), F("cache.bytes", size))
+	defer func() { span.End(err) }()
+	return withStepTimeout(ctx, c.CommitTimeout, fmt.Sprintf("commit cache %d", id), func(ctx context.Context) error {
+		return c.backend().Commit(ctx, id, size)
+	})
+}
+
+func (c *Cache) commitService(ctx context.Context, id int, size int64) error {
+	dt, err := json.Marshal(CommitCacheReq{Size: size})
 	if err != nil {
 		return errors.WithStack(err)
 	}
-	req, err = http.NewRequest("POST", c.url(fmt.Sprintf("caches/%d", cr.CacheID)), bytes.NewReader(dt))
+	req, err := http.NewRequest("POST", c.url(fmt.Sprintf("caches/%d", id)), bytes.NewReader(dt))
 	if err != nil {
 		return errors.WithStack(err)
 	}
 	c.auth(req)
-	c.accept(req)
 	req.Header.Set("Content-Type", "application/json")
-	Log("commit cache %s, size %d", req.URL.String(), size)
-	resp, err = http.DefaultClient.Do(req)
+	req = req.WithContext(ctx)
+	c.debug(ctx, "commit cache", F("url", req.URL.String()), F("cacheID", id), F("size", size))
+	resp, err := c.do(endpointCommit, req)
 	if err != nil {
-		return errors.Wrapf(err, "error committing cache %d", cr.CacheID)
+		return errors.Wrapf(err, "error committing cache %d", id)
+	}
+	defer c.closeResponse(resp)
+	if err := c.checkResponse(resp); err != nil {
+		return errors.Wrapf(err, "error committing cache %d", id)
 	}
-	io.Copy(os.Stderr, resp.Body)
-	return resp.Body.Close()
+	return nil
 }
 
-func (c *Cache) uploadChunk(ctx context.Context, id int, ra io.ReaderAt, off, n int64) error {
+// MaxChunkRangeRetries is how many times the parts of a chunk that a server
+// did not confirm in its Content-Range response are uploaded again.
+var MaxChunkRangeRetries = 3
+
+// MaxChunkRetries is how many times a chunk whose upload failed after the
+// retries of the RetryPolicy is uploaded again before the save is given up.
+// Only the failed chunk is uploaded again, chunks uploaded by then are kept.
+var MaxChunkRetries = 2
+
+// WithMaxChunkRetries sets how many times a failed chunk is uploaded
+// again, a negative n disables it.
+func WithMaxChunkRetries(n int) Opt {
+	return func(c *Cache) {
+		c.MaxChunkRetries = n
+	}
+}
+
+// WithMaxChunkRangeRetries sets how many times the unconfirmed parts of a
+// chunk are uploaded again, a negative n disables it.
+func WithMaxChunkRangeRetries(n int) Opt {
+	return func(c *Cache) {
+		c.MaxChunkRangeRetries = n
+	}
+}
+
+func (c *Cache) maxChunkRetries() int {
+	if c != nil && c.MaxChunkRetries != 0 {
+		return c.MaxChunkRetries
+	}
+	return MaxChunkRetries
+}
+
+func (c *Cache) maxChunkRangeRetries() int {
+	if c != nil && c.MaxChunkRangeRetries != 0 {
+		return c.MaxChunkRangeRetries
+	}
+	return MaxChunkRangeRetries
+}
+
+func (c *Cache) uploadChunk(ctx context.Context, id int, ra io.ReaderAt, off, n int64) (err error) {
+	release, err := acquireUploadSlot(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	releaseMem, err := c.acquireUploadMemory(ctx, n)
+	if err != nil {
+		return err
+	}
+	defer releaseMem()
+	ctx, span := c.startSpan(ctx, "uploadChunk", F("cache.id", id), F("cache.offset", off), F("cache.bytes", n))
+	start := c.clock().Now()
+	chunkDone := c.chunkEvents(ctx, id, off, n)
+	defer func() {
+		c.measureChunk(n, start, err)
+		chunkDone(err)
+		span.End(err)
+	}()
+	p := c.retryPolicy(ctx)
+	for attempt := 1; ; attempt++ {
+		err := withStepTimeout(ctx, c.ChunkTimeout, fmt.Sprintf("upload cache chunk %d-%d", off, off+n-1), func(ctx context.Context) error {
+			return c.backend().UploadChunk(ctx, id, ra, off, n)
+		})
+		if err == nil {
+			recordUpload(ctx, n)
+			return nil
+		}
+		d := p.Backoff(attempt)
+		if attempt > c.maxChunkRetries() || !isChunkRetryable(ctx, err) || !retryFits(ctx, d) {
+			return err
+		}
+		recordRetry(ctx)
+		c.events().OnRetry(ctx, RetryEvent{Endpoint: string(endpointUpload), Attempt: attempt + 1, Delay: d, Reason: err.Error()})
+		c.warn(ctx, "upload cache chunk failed, retrying", F("cacheID", id), F("offset", off), F("size", n), F("attempt", attempt), F("error", err))
+		if err := c.clock().Sleep(ctx, d); err != nil {
+			return err
+		}
+	}
+}
+
+// isChunkRetryable reports if a chunk that failed with err can be uploaded
+// again. Client errors other than timeouts and throttling are permanent.
+func isChunkRetryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var ae *GithubAPIError
+	if errors.As(err, &ae) {
+		return ae.StatusCode == http.StatusRequestTimeout || ae.StatusCode == http.StatusTooManyRequests || ae.StatusCode >= 500
+	}
+	return true
+}
+
+// uploadChunkRanges uploads a chunk, uploading again the ranges the server
+// did not confirm.
+func (c *Cache) uploadChunkRanges(ctx context.Context, id int, ra io.ReaderAt, off, n int64) error {
+	todo := []byteRange{{Start: off, End: off + n}}
+	for attempt := 0; ; attempt++ {
+		var missing []byteRange
+		for _, r := range todo {
+			acked, err := c.uploadRange(ctx, id, ra, r.Start, r.End-r.Start)
+			if err != nil {
+				return err
+			}
+			if acked == nil {
+				continue
+			}
+			missing = append(missing, acked.missingIn(r.Start, r.End)...)
+		}
+		if len(missing) == 0 {
+			return nil
+		}
+		if attempt >= c.maxChunkRangeRetries() {
+			return errors.Errorf("failed to upload cache chunk %d-%d, ranges not confirmed: %v", off, off+n-1, missing)
+		}
+		c.warn(ctx, "upload cache chunk: ranges not confirmed, retrying", F("cacheID", id), F("offset", off), F("size", n), F("missing", missing))
+		todo = missing
+	}
+}
+
+// uploadRange uploads a part of the payload and returns the ranges the server
+// confirmed in its response, or nil if the response did not include any.
+func (c *Cache) uploadRange(ctx context.Context, id int, ra io.ReaderAt, off, n int64) (*rangeSet, error) {
 	r := io.NewSectionReader(ra, off, n)
 	req, err := http.NewRequest("PATCH", c.url(fmt.Sprintf("caches/%d", id)), r)
 	if err != nil {
-		return errors.WithStack(err)
+		return nil, errors.WithStack(err)
 	}
 	c.auth(req)
-	c.accept(req)
 	req.Header.Set("Content-Type", "application/octet-stream")
+	// a known length sends the chunk without chunked transfer encoding
+	req.ContentLength = n
 	req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/*", off, off+n-1))
+	if err := c.setContentMD5(req, ra, off, n); err != nil {
+		return nil, err
+	}
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(io.NewSectionReader(ra, off, n)), nil
+	}
+	req = req.WithContext(ctx)
 
-	Log("upload cache chunk %s, range %d-%d", req.URL.String(), off, off+n-1)
-	resp, err := http.DefaultClient.Do(req)
+	c.debug(ctx, "upload cache chunk", F("url", req.URL.String()), F("cacheID", id), F("offset", off), F("size", n))
+	resp, err := c.do(endpointUpload, req)
 	if err != nil {
-		return errors.WithStack(err)
+		return nil, errors.WithStack(err)
+	}
+	defer c.closeResponse(resp)
+	if err := c.checkResponse(resp); err != nil {
+		return nil, errors.Wrapf(err, "failed to upload cache chunk %d-%d", off, off+n-1)
 	}
-	_, err = io.Copy(os.Stderr, resp.Body)
+	cr := resp.Header.Get("Content-Range")
+	if cr == "" {
+		return nil, nil
+	}
+	ranges, err := parseContentRanges(cr)
 	if err != nil {
-		return errors.WithStack(err)
+		c.warn(ctx, "ignoring invalid Content-Range response", F("contentRange", cr), F("error", err))
+		return nil, nil
 	}
-	return resp.Body.Close()
+	return &rangeSet{ranges: ranges}, nil
 }
 
 func (c *Cache) auth(r *http.Request) {
-	r.Header.Add("Authorization", "Bearer "+c.Token.Raw)
-}
-
-func (c *Cache) accept(r *http.Request) {
-	r.Header.Add("Accept", "application/json;api-version=6.0-preview.1")
+	r.Header.Add("Authorization", c.authorization())
 }
 
 func (c *Cache) url(p string) string {
-	return c.URL + "_apis/artifactcache/" + p
+	return c.endpointURL(c.apiPathPrefix() + p)
 }
 
 type ReserveCacheReq struct {
@@ -257,26 +1351,96 @@ type Entry struct {
 	Key   string `json:"cacheKey"`
 	Scope string `json:"scope"`
 	URL   string `json:"archiveLocation"`
+	// CreationTime is reported by the v1 service only.
+	CreationTime time.Time `json:"creationTime"`
+	// Headers are the ResponseHeaders of the lookup response.
+	Headers http.Header `json:"-"`
+	// MatchedKey is the key passed to Load that matched Key, exactly or as
+	// a prefix.
+	MatchedKey string `json:"-"`
+	// Exact is set if Key is the primary key passed to Load. Callers may
+	// want to save again after other matches.
+	Exact bool `json:"-"`
+	// Size, ContentType and LastModified describe the stored archive. They
+	// are set by Stat or Load with LoadStat. Size is -1 if the server did not
+	// report it.
+	Size         int64     `json:"-"`
+	ContentType  string    `json:"-"`
+	LastModified time.Time `json:"-"`
+
+	c   *Cache
+	enc encoding
+	// salt is the version salt the entry was loaded with, nil for the salt
+	// of c.
+	salt *string
 }
 
 func (ce *Entry) Download(ctx context.Context, w io.Writer) error {
-	req, err := http.NewRequest("GET", ce.URL, nil)
-	if err != nil {
-		return errors.WithStack(err)
+	ctx = ce.withVersion(ctx)
+	ctx, span := ce.c.startSpan(ctx, "Download", F("cache.key", ce.Key))
+	ctx, done := ce.c.startOp(ctx, "download", ce.Key)
+	cw := &countWriter{w: w}
+	err := ce.downloadLocal(ctx, cw, ce.downloadVerified)
+	span.SetAttributes(F("cache.bytes", cw.n))
+	span.End(err)
+	return done(cw.n, false, err)
+}
+
+func (ce *Entry) download(ctx context.Context, w io.Writer) error {
+	var d Downloader = httpDownloader{c: ce.c}
+	if ce.c != nil && ce.c.Downloader != nil {
+		d = ce.c.Downloader
+	} else if ce.c != nil && ce.c.Backend != nil {
+		d = DownloaderFunc(ce.c.backend().Download)
+	}
+	if err := ce.checkExpiry(ctx); err != nil {
+		return err
+	}
+	ce.c.debug(ctx, "download cache", F("key", ce.Key))
+	ctx = ce.c.withProgress(ctx, "download", ce.Key, ce.progressSize())
+	defer ce.c.tickProgress(ctx)()
+	ctx = withURLRefresher(ctx, ce.urlRefresher())
+	aw := &aliasWriter{w: &progressWriter{ctx: ctx, w: w}, marked: func(dt []byte) (bool, error) {
+		return ce.marked(ctx, dt)
+	}}
+	download := func(ctx context.Context) error {
+		if err := ce.fetch(ctx, d, aw); err != nil {
+			return err
+		}
+		return aw.finish()
+	}
+	var err error
+	if ce.c == nil {
+		err = download(ctx)
+	} else {
+		err = ce.c.withTimeouts(ctx, false, download)
 	}
-	req = req.WithContext(ctx)
-	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return errors.WithStack(err)
+		return err
+	}
+	if aw.shards != nil {
+		return ce.downloadShards(ctx, aw.shards, w)
+	}
+	if aw.target == "" {
+		return nil
 	}
-	_, err = io.Copy(w, resp.Body)
-	return errors.WithStack(err)
+	return ce.downloadAlias(ctx, aw.target, w)
 }
 
-func version(k string) string {
-	h := sha256.New()
-	// h.Write([]byte(k))
-	// upstream uses paths in version, we don't seem to have anything that is unique like this
-	h.Write([]byte("|go-actionscache-1.0"))
-	return hex.EncodeToString(h.Sum(nil))
+// progressSize is the size of the download of ce if Stat reported it, -1
+// otherwise. Encoded payloads are larger or smaller than the data written.
+func (ce *Entry) progressSize() int64 {
+	if ce.Size > 0 && ce.enc.plain() {
+		return ce.Size
+	}
+	return -1
+}
+
+// fetch downloads the payload of ce into w, decoding it if needed.
+func (ce *Entry) fetch(ctx context.Context, d Downloader, w io.Writer) error {
+	if ce.enc.plain() {
+		return d.Download(ctx, ce.URL, w)
+	}
+	dw, wait := decodeTo(ce.enc, w)
+	return wait(d.Download(ctx, ce.URL, dw))
 }
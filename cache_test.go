@@ -3,9 +3,14 @@ package actionscache
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
+	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/moby/buildkit/identity"
 	"github.com/stretchr/testify/require"
 )
@@ -14,6 +19,17 @@ func init() {
 	Log = log.Printf
 }
 
+func testToken(t *testing.T, scopes ...Scope) string {
+	dt, err := json.Marshal(scopes)
+	require.NoError(t, err)
+	tk, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"ac":  string(dt),
+		"iss": "vstoken.actions.githubusercontent.com",
+	}).SignedString([]byte("secret"))
+	require.NoError(t, err)
+	return tk
+}
+
 func TestTokenScopes(t *testing.T) {
 	// this token is expired
 	c, err := New("eyJ0eXAiOiJKV1QiLCJhbGciOiJSUzI1NiIsIng1dCI6InNiM29QbEhYRi0tV3lEZFBwM0FXRzEtWFhJdyJ9.eyJuYW1laWQiOiJkZGRkZGRkZC1kZGRkLWRkZGQtZGRkZC1kZGRkZGRkZGRkZGQiLCJzY3AiOiJBY3Rpb25zLkdlbmVyaWNSZWFkOjAwMDAwMDAwLTAwMDAtMDAwMC0wMDAwLTAwMDAwMDAwMDAwMCBBY3Rpb25zLlVwbG9hZEFydGlmYWN0czowMDAwMDAwMC0wMDAwLTAwMDAtMDAwMC0wMDAwMDAwMDAwMDAvMTpCdWlsZC9CdWlsZC83NiBMb2NhdGlvblNlcnZpY2UuQ29ubmVjdCBSZWFkQW5kVXBkYXRlQnVpbGRCeVVyaTowMDAwMDAwMC0wMDAwLTAwMDAtMDAwMC0wMDAwMDAwMDAwMDAvMTpCdWlsZC9CdWlsZC83NiIsIklkZW50aXR5VHlwZUNsYWltIjoiU3lzdGVtOlNlcnZpY2VJZGVudGl0eSIsImh0dHA6Ly9zY2hlbWFzLnhtbHNvYXAub3JnL3dzLzIwMDUvMDUvaWRlbnRpdHkvY2xhaW1zL3NpZCI6IkRERERERERELUREREQtRERERC1ERERELURERERERERERERERCIsImh0dHA6Ly9zY2hlbWFzLm1pY3Jvc29mdC5jb20vd3MvMjAwOC8wNi9pZGVudGl0eS9jbGFpbXMvcHJpbWFyeXNpZCI6ImRkZGRkZGRkLWRkZGQtZGRkZC1kZGRkLWRkZGRkZGRkZGRkZCIsImF1aSI6IjVkYzhiNDZmLWQzODctNDYxOS04MTM0LTgyNTAzM2I0NWM1MSIsInNpZCI6ImVjMTY4YTc5LWVmZTctNDc0OC05NjZjLTgwYTdkMjJmNTQ0NyIsImFjIjoiW3tcIlNjb3BlXCI6XCJyZWZzL2hlYWRzL3Rlc3RcIixcIlBlcm1pc3Npb25cIjozfSx7XCJTY29wZVwiOlwicmVmcy9oZWFkcy9tYXN0ZXJcIixcIlBlcm1pc3Npb25cIjoxfV0iLCJvcmNoaWQiOiIyNzEyOTAzZi01NzJjLTQxMjEtYmQwMC1kZDJhOTI0MDczMDIuaGVsbG9fd29ybGRfam9iLl9fZGVmYXVsdCIsImlzcyI6InZzdG9rZW4uYWN0aW9ucy5naXRodWJ1c2VyY29udGVudC5jb20iLCJhdWQiOiJ2c3Rva2VuLmFjdGlvbnMuZ2l0aHVidXNlcmNvbnRlbnQuY29tfHZzbzpmMTE5YzYyNS0yYzU1LTQ1MTgtYThmZC1jZGIyMzliYTNjMGYiLCJuYmYiOjE2MDY4MTI2MjksImV4cCI6MTYwNjgzNTQyOX0.lYlDkfZ6VHimS8Y5NdEmLdIqYwekB3pGBhtg6hLEb3s-Vdm6hLOP-8Ukmi0PWipSaFA33LqC5T-i1OSdM1eRCpcwZ0CES9ii4HcBrsE5JfoyGLHiYiUa5HvRJNDUd9Cbt0w_oghDV7fZ-kMOx7r4mfvaeUQDVS_fs9tCi6LFyG6h6ItYdddTsBfV9yPwjbyBSZIGTXiuaEhEYfJl24P9TRMjPUWYDeA0t_ERohowOlVCnHqJfOfrBtwEipsUN3OujLozYdoiPddhmzmer0D-HLo9VwGQllmyiaEF7MdVi7hjA44phULph62IWiTPbr-1ktOhLMTP1V-8CvF1nse59w", "")
@@ -68,3 +84,204 @@ func TestSaveLoad(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, "foobar", buf.String())
 }
+
+func TestLoadMissCache(t *testing.T) {
+	var loads int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		loads++
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	old := LoadMissTTL
+	LoadMissTTL = time.Minute
+	defer func() { LoadMissTTL = old }()
+
+	c, err := New(testToken(t, Scope{Scope: "refs/heads/main", Permission: PermissionRead}), ts.URL+"/")
+	require.NoError(t, err)
+	clock := newTestClock()
+	c.Clock = clock
+
+	ctx := context.TODO()
+	for i := 0; i < 3; i++ {
+		ce, err := c.Load(ctx, "foo", "fo")
+		require.NoError(t, err)
+		require.Nil(t, ce)
+	}
+	require.Equal(t, 1, loads)
+
+	_, err = c.Load(ctx, "bar")
+	require.NoError(t, err)
+	require.Equal(t, 2, loads)
+
+	c.clearMisses("foo-1")
+	_, err = c.Load(ctx, "foo", "fo")
+	require.NoError(t, err)
+	require.Equal(t, 3, loads)
+
+	_, err = c.Load(ctx, "bar")
+	require.NoError(t, err)
+	require.Equal(t, 3, loads)
+
+	clock.Advance(2 * time.Minute)
+	_, err = c.Load(ctx, "bar")
+	require.NoError(t, err)
+	require.Equal(t, 4, loads)
+}
+
+func TestMissTTL(t *testing.T) {
+	loads := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		loads++
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	token := testToken(t, Scope{Scope: "refs/heads/main", Permission: PermissionRead})
+	c, err := New(token, ts.URL+"/", WithMissTTL(time.Minute))
+	require.NoError(t, err)
+	other, err := New(token, ts.URL+"/")
+	require.NoError(t, err)
+
+	ctx := context.TODO()
+	for i := 0; i < 2; i++ {
+		_, err = c.Load(ctx, "foo")
+		require.NoError(t, err)
+		_, err = other.Load(ctx, "foo")
+		require.NoError(t, err)
+	}
+	require.Equal(t, 3, loads)
+}
+
+func TestScopeOpts(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(Entry{Key: "foo", Scope: "refs/heads/main", URL: "http://example.invalid"})
+	}))
+	defer ts.Close()
+
+	c, err := New(testToken(t,
+		Scope{Scope: "refs/heads/feature", Permission: PermissionRead | PermissionWrite},
+		Scope{Scope: "refs/heads/main", Permission: PermissionRead},
+	), ts.URL+"/")
+	require.NoError(t, err)
+
+	ctx := context.TODO()
+	ce, err := c.LoadWithOpts(ctx, []string{"foo"}, LoadScope("refs/heads/main"))
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+
+	ce, err = c.LoadWithOpts(ctx, []string{"foo"}, LoadScope("refs/heads/feature"))
+	require.NoError(t, err)
+	require.Nil(t, ce)
+
+	ce, err = c.LoadWithOpts(ctx, []string{"foo"}, LoadScopes("feature", "main"))
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+
+	_, err = c.LoadWithOpts(ctx, []string{"foo"}, LoadScope("refs/heads/other"))
+	require.Error(t, err)
+	_, err = c.LoadWithOpts(ctx, []string{"foo"}, LoadScopes("main", "other"))
+	require.Error(t, err)
+
+	dt := []byte("foo")
+	err = c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt)), SaveScope("refs/heads/main"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "no Write permission")
+}
+
+func TestSaveAllChunks(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+
+	oldSize, oldConcurrency := UploadChunkSize, UploadConcurrency
+	UploadChunkSize, UploadConcurrency = 3, 2
+	defer func() { UploadChunkSize, UploadConcurrency = oldSize, oldConcurrency }()
+
+	ctx := context.TODO()
+	dt := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	err := c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt)))
+	require.NoError(t, err)
+	require.Equal(t, 12, ts.count("PATCH "))
+
+	ce, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, ce.Download(ctx, buf))
+	require.Equal(t, string(dt), buf.String())
+}
+
+func TestUploadChunkRetriesUnconfirmedRanges(t *testing.T) {
+	var ranges []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cr := r.Header.Get("Content-Range")
+		ranges = append(ranges, cr)
+		if cr == "bytes 0-9/*" {
+			w.Header().Set("Content-Range", "bytes 0-3,6-9/*")
+		} else {
+			w.Header().Set("Content-Range", cr)
+		}
+	}))
+	defer ts.Close()
+
+	c, err := New(testToken(t, Scope{Scope: "refs/heads/main", Permission: PermissionRead | PermissionWrite}), ts.URL+"/")
+	require.NoError(t, err)
+
+	err = c.uploadChunk(context.TODO(), 1, bytes.NewReader([]byte("0123456789")), 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{"bytes 0-9/*", "bytes 4-5/*"}, ranges)
+}
+
+func TestLoadFreshest(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+
+	ctx := context.TODO()
+	dt := []byte("foobar")
+	for _, k := range []string{"linux-old", "build-main-1", "linux-new"} {
+		require.NoError(t, c.Save(ctx, k, bytes.NewReader(dt), int64(len(dt))))
+	}
+
+	keys := []string{"build-feature", "build-main-", "linux-"}
+	ce, err := c.Load(ctx, keys...)
+	require.NoError(t, err)
+	require.Equal(t, "build-main-1", ce.Key)
+
+	ce, err = c.LoadWithOpts(ctx, keys, LoadFreshest())
+	require.NoError(t, err)
+	require.Equal(t, "linux-new", ce.Key)
+	require.False(t, ce.CreationTime.IsZero())
+
+	ce, err = c.LoadWithOpts(ctx, []string{"build-main-1", "linux-"}, LoadFreshest())
+	require.NoError(t, err)
+	require.Equal(t, "build-main-1", ce.Key)
+
+	ce, err = c.LoadWithOpts(ctx, []string{"foo", "bar"}, LoadFreshest())
+	require.NoError(t, err)
+	require.Nil(t, ce)
+}
+
+func TestLoadMatchedKey(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+
+	ctx := context.TODO()
+	dt := []byte("foobar")
+	require.NoError(t, c.Save(ctx, "go-mod-abc", bytes.NewReader(dt), int64(len(dt))))
+
+	ce, err := c.Load(ctx, "go-mod-abc", "go-mod-")
+	require.NoError(t, err)
+	require.Equal(t, "go-mod-abc", ce.MatchedKey)
+	require.True(t, ce.Exact)
+
+	ce, err = c.Load(ctx, "go-mod-def", "go-mod-")
+	require.NoError(t, err)
+	require.Equal(t, "go-mod-abc", ce.Key)
+	require.Equal(t, "go-mod-", ce.MatchedKey)
+	require.False(t, ce.Exact)
+
+	ce, err = c.Load(ctx, "go-mod-def", "go-mod-abc")
+	require.NoError(t, err)
+	require.Equal(t, "go-mod-abc", ce.MatchedKey)
+	require.False(t, ce.Exact)
+}
@@ -0,0 +1,236 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+const twirpCacheService = "twirp/github.actions.results.api.v1.CacheService/"
+
+const endpointTwirp endpoint = "twirp"
+
+// NewV2 returns a Cache for the v2 cache service. url is the value of
+// ACTIONS_RESULTS_URL.
+func NewV2(token, url string, opts ...Opt) (*Cache, error) {
+	c, err := newCache(token, url, opts)
+	if err != nil {
+		return nil, err
+	}
+	c.v2 = true
+	c.startWarmUp()
+	return c, nil
+}
+
+// Protocol returns the version of the cache service API used, "v1" or "v2".
+func (c *Cache) Protocol() string {
+	if c.v2 {
+		return "v2"
+	}
+	return "v1"
+}
+
+type createCacheEntryRequest struct {
+	Key     string `json:"key"`
+	Version string `json:"version"`
+}
+
+type createCacheEntryResponse struct {
+	OK              bool   `json:"ok"`
+	SignedUploadURL string `json:"signed_upload_url"`
+}
+
+type finalizeCacheEntryUploadRequest struct {
+	Key       string `json:"key"`
+	Version   string `json:"version"`
+	SizeBytes int64  `json:"size_bytes,string"`
+}
+
+type finalizeCacheEntryUploadResponse struct {
+	OK      bool       `json:"ok"`
+	EntryID twirpInt64 `json:"entry_id"`
+}
+
+type getCacheEntryDownloadURLRequest struct {
+	Key         string   `json:"key"`
+	RestoreKeys []string `json:"restore_keys"`
+	Version     string   `json:"version"`
+}
+
+type getCacheEntryDownloadURLResponse struct {
+	OK                bool   `json:"ok"`
+	SignedDownloadURL string `json:"signed_download_url"`
+	MatchedKey        string `json:"matched_key"`
+}
+
+// twirpInt64 decodes int64 values that protobuf JSON encodes as strings.
+type twirpInt64 int64
+
+func (v *twirpInt64) UnmarshalJSON(dt []byte) error {
+	s := string(bytes.Trim(dt, `"`))
+	if s == "" || s == "null" {
+		*v = 0
+		return nil
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	*v = twirpInt64(n)
+	return nil
+}
+
+type twirpError struct {
+	Code string `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+func (e *twirpError) Error() string {
+	return e.Code + ": " + e.Msg
+}
+
+func (e *twirpError) Is(target error) bool {
+	switch target {
+	case ErrCacheNotFound:
+		return e.Code == "not_found"
+	case ErrCacheAlreadyExists, ErrReserveConflict:
+		return e.Code == "already_exists"
+	case ErrTooManyRequests:
+		return e.Code == "resource_exhausted"
+	}
+	return false
+}
+
+// twirp calls method of the v2 cache service. Errors reported by the
+// service are returned as *twirpError.
+func (c *Cache) twirp(ctx context.Context, method string, in, out interface{}) error {
+	return c.twirpService(ctx, twirpCacheService, method, in, out)
+}
+
+// twirpService calls method of the twirp service at path service of the
+// results service.
+func (c *Cache) twirpService(ctx context.Context, service, method string, in, out interface{}) error {
+	dt, err := json.Marshal(in)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	req, err := http.NewRequest("POST", c.endpointURL(service+method), bytes.NewReader(dt))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	c.auth(req)
+	req.Header.Set("Content-Type", "application/json")
+	req = req.WithContext(ctx)
+	c.debug(ctx, "twirp request", F("method", method), F("body", string(dt)))
+	resp, err := c.do(endpointTwirp, req)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer c.closeResponse(resp)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		dt, _ := ioutil.ReadAll(io.LimitReader(resp.Body, c.maxErrorBodySize()))
+		var te twirpError
+		if err := json.Unmarshal(dt, &te); err != nil || te.Code == "" {
+			return errors.Wrapf(c.unexpectedResponse(resp, dt), "%s failed", method)
+		}
+		return errors.Wrapf(&te, "%s failed", method)
+	}
+	// an empty body is the zero value of out, eg. ok: false
+	if err := c.decodeResponse(resp, out); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+func (c *Cache) lookupV2(ctx context.Context, keys []string, missKey string) (*Entry, error) {
+	version := c.keyVersion(ctx, keys[0])
+	for _, batch := range lookupBatches(keys, c.maxLookupKeys()) {
+		batch := batch
+		ce, err := c.hedged(ctx, func(ctx context.Context) (*Entry, error) {
+			return c.lookupTransformed(ctx, batch, func(keys []string) (*Entry, error) {
+				return c.lookupV2Batch(ctx, keys, version)
+			})
+		})
+		if errors.Is(err, ErrCacheNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if ce != nil {
+			return ce, nil
+		}
+	}
+	return c.miss(missKey)
+}
+
+// lookupV2Batch looks up keys with one request, nil on a miss.
+func (c *Cache) lookupV2Batch(ctx context.Context, keys []string, version string) (*Entry, error) {
+	var resp getCacheEntryDownloadURLResponse
+	err := c.twirp(ctx, "GetCacheEntryDownloadURL", getCacheEntryDownloadURLRequest{
+		Key:         escapeKey(keys[0]),
+		RestoreKeys: escapeKeys(keys[1:]),
+		Version:     version,
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.OK || resp.SignedDownloadURL == "" {
+		return nil, nil
+	}
+	return &Entry{Key: unescapeKey(resp.MatchedKey), URL: resp.SignedDownloadURL}, nil
+}
+
+func (c *Cache) saveV2(ctx context.Context, key string, ra io.ReaderAt, size int64) error {
+	var cr createCacheEntryResponse
+	if err := c.twirp(ctx, "CreateCacheEntry", createCacheEntryRequest{Key: escapeKey(c.transformKey(key)), Version: c.keyVersion(ctx, key)}, &cr); err != nil {
+		c.events().OnReserve(ctx, ReserveEvent{Key: key, Err: err})
+		return err
+	}
+	if !cr.OK {
+		// the service refuses keys that exist or are being saved
+		err := c.keyLocked(key, errors.Wrapf(ErrReserveConflict, "failed to create cache entry for %s", key))
+		c.events().OnReserve(ctx, ReserveEvent{Key: key, Err: err})
+		return err
+	}
+	c.events().OnReserve(ctx, ReserveEvent{Key: key})
+	r := c.trackReserve(0, key)
+	upload := c.uploadBlob
+	// an empty payload is a single empty PUT instead of an empty block list
+	if isBlobSASURL(cr.SignedUploadURL) && size > 0 {
+		upload = c.uploadBlocks
+	}
+	if err := upload(ctx, cr.SignedUploadURL, ra, size); err != nil {
+		c.trackOrphan(ctx, r, err)
+		return err
+	}
+	var fr finalizeCacheEntryUploadResponse
+	fctx, retries := withRetryCounter(ctx)
+	err := c.twirp(fctx, "FinalizeCacheEntryUpload", finalizeCacheEntryUploadRequest{Key: escapeKey(c.transformKey(key)), Version: c.keyVersion(ctx, key), SizeBytes: size}, &fr)
+	if retries() > 0 && ((err != nil && isCommitRefused(err)) || (err == nil && !fr.OK)) && c.committedAnyway(ctx, key, size, err) {
+		// a finalize that timed out was applied before it was retried
+		c.info(ctx, "save cache: retried finalize refused, entry was committed", F("key", key))
+		err, fr.OK = nil, true
+	}
+	if err == nil && !fr.OK {
+		err = errors.Errorf("failed to finalize cache entry for %s", key)
+		if size == 0 {
+			err = errors.WithStack(&EmptyEntryError{Key: key, Err: err})
+		}
+	}
+	c.events().OnCommit(ctx, CommitEvent{Key: key, ID: int(fr.EntryID), Size: size, Err: err})
+	if err != nil {
+		c.trackOrphan(ctx, r, err)
+		return err
+	}
+	recordCacheID(ctx, int64(fr.EntryID))
+	c.trackCommit(r)
+	c.clearMisses(key)
+	return nil
+}
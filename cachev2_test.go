@@ -0,0 +1,121 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaveLoadV2(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCacheV2(t)
+	require.Equal(t, "v2", c.Protocol())
+
+	ctx := context.TODO()
+	ce, err := c.Load(ctx, "foo-1")
+	require.NoError(t, err)
+	require.Nil(t, ce)
+
+	dt := []byte("foobar")
+	ts.plainUploads = true
+	require.NoError(t, c.Save(ctx, "foo-1", bytes.NewReader(dt), int64(len(dt))))
+	require.Equal(t, 1, ts.count("PUT /upload/"))
+	require.Empty(t, c.Stats().Pending)
+
+	ce, err = c.Load(ctx, "foo-2", "foo-")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	require.Equal(t, "foo-1", ce.Key)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, ce.Download(ctx, buf))
+	require.Equal(t, "foobar", buf.String())
+
+	_, err = c.LoadWithOpts(ctx, []string{"foo-1"}, LoadScope("refs/heads/main"))
+	require.Error(t, err)
+
+	d, err := c.Diagnose(ctx)
+	require.NoError(t, err)
+	require.True(t, d.Reachable)
+	require.Equal(t, "v2", d.Protocol)
+	require.Equal(t, 200, d.StatusCode)
+}
+
+func TestTryEnvProtocol(t *testing.T) {
+	token := testToken(t, Scope{Scope: "refs/heads/main", Permission: PermissionRead})
+	env := map[string]string{
+		"ACTIONS_RUNTIME_TOKEN":    token,
+		"ACTIONS_CACHE_URL":        "https://artifactcache.example.com/",
+		"ACTIONS_RESULTS_URL":      "https://results.example.com/",
+		"ACTIONS_CACHE_SERVICE_V2": "",
+	}
+	for k := range env {
+		if v, ok := os.LookupEnv(k); ok {
+			defer os.Setenv(k, v)
+		} else {
+			defer os.Unsetenv(k)
+		}
+	}
+	setEnv := func(m map[string]string) {
+		for k, v := range m {
+			if v == "" {
+				os.Unsetenv(k)
+			} else {
+				os.Setenv(k, v)
+			}
+		}
+	}
+
+	setEnv(env)
+	c, err := TryEnv()
+	require.NoError(t, err)
+	require.Equal(t, "v1", c.Protocol())
+	require.Equal(t, "https://artifactcache.example.com/", c.URL)
+
+	setEnv(map[string]string{"ACTIONS_CACHE_SERVICE_V2": "True"})
+	c, err = TryEnv()
+	require.NoError(t, err)
+	require.Equal(t, "v2", c.Protocol())
+	require.Equal(t, "https://results.example.com/", c.URL)
+
+	setEnv(map[string]string{"ACTIONS_CACHE_SERVICE_V2": "", "ACTIONS_CACHE_URL": ""})
+	c, err = TryEnv()
+	require.NoError(t, err)
+	require.Equal(t, "v2", c.Protocol())
+
+	setEnv(map[string]string{"ACTIONS_RESULTS_URL": ""})
+	c, err = TryEnv()
+	require.NoError(t, err)
+	require.Nil(t, c)
+}
+
+func TestSaveV2Blocks(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCacheV2(t)
+
+	oldSize := UploadChunkSize
+	UploadChunkSize = 4
+	defer func() { UploadChunkSize = oldSize }()
+
+	ctx := context.TODO()
+	dt := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+	// 9 blocks and the block list
+	require.Equal(t, 10, ts.count("PUT /upload/"))
+
+	ce, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	buf := &bytes.Buffer{}
+	require.NoError(t, ce.Download(ctx, buf))
+	require.Equal(t, string(dt), buf.String())
+}
+
+func TestIsBlobSASURL(t *testing.T) {
+	require.True(t, isBlobSASURL("https://acct.blob.core.windows.net/c/b?sv=2020-04-08&se=2024&sig=abc"))
+	require.False(t, isBlobSASURL("https://acct.blob.core.windows.net/c/b"))
+	require.False(t, isBlobSASURL("https://example.com/upload?sig=abc"))
+}
@@ -0,0 +1,248 @@
+package actionscache
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// WritePolicy selects the backends of a ChainBackend that saves write to.
+type WritePolicy int
+
+const (
+	// WriteAll writes to every backend, a save fails if any of them fails.
+	WriteAll WritePolicy = iota
+	// WriteFirst writes to the first backend only.
+	WriteFirst
+	// WriteAny writes to every backend, a save succeeds if at least one of
+	// them commits the entry. Backends that fail are skipped for the rest
+	// of the save.
+	WriteAny
+)
+
+// ChainBackend is a Backend reading from an ordered list of backends, eg.
+// a local FSBackend, the cache service and an S3Backend mirror, so
+// restores survive the outage of one of them. Lookups return the first
+// hit, backends failing with other errors than ErrCacheNotFound are
+// skipped. Saves write to the backends selected by the WritePolicy.
+type ChainBackend struct {
+	backends []Backend
+	policy   WritePolicy
+	// Populate copies entries downloaded from a backend into the backends
+	// before it that missed them, eg. to fill a local directory or a
+	// mirror. Failures to copy are ignored.
+	Populate bool
+
+	mu      sync.Mutex
+	nextID  int
+	uploads map[int]*chainUpload
+	// entries maps the URLs returned by Lookup to their hit
+	entries map[string]*chainHit
+}
+
+type chainUpload struct {
+	mu  sync.Mutex
+	ids []int
+	// active are the backends the entry is written to
+	active []bool
+}
+
+type chainHit struct {
+	backend int
+	key     string
+	version string
+}
+
+// NewChainBackend returns a ChainBackend over backends in the order they
+// are read.
+func NewChainBackend(policy WritePolicy, backends ...Backend) *ChainBackend {
+	return &ChainBackend{
+		backends: backends,
+		policy:   policy,
+		uploads:  map[int]*chainUpload{},
+		entries:  map[string]*chainHit{},
+	}
+}
+
+func (b *ChainBackend) Reserve(ctx context.Context, key, version string) (int, error) {
+	if len(b.backends) == 0 {
+		return 0, errors.Errorf("no backends to save cache %s to", key)
+	}
+	n := len(b.backends)
+	if b.policy == WriteFirst {
+		n = 1
+	}
+	u := &chainUpload{ids: make([]int, n), active: make([]bool, n)}
+	var firstErr error
+	for i := 0; i < n; i++ {
+		id, err := b.backends[i].Reserve(ctx, key, version)
+		if err != nil {
+			if b.policy != WriteAny {
+				return 0, err
+			}
+			// conflicts win so SaveIgnoreAlreadyExists applies
+			if firstErr == nil || errors.Is(err, ErrReserveConflict) {
+				firstErr = err
+			}
+			continue
+		}
+		u.ids[i], u.active[i] = id, true
+	}
+	if !u.anyActive() {
+		return 0, firstErr
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nextID++
+	b.uploads[b.nextID] = u
+	return b.nextID, nil
+}
+
+func (b *ChainBackend) upload(id int) (*chainUpload, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	u, ok := b.uploads[id]
+	if !ok {
+		return nil, errors.Wrapf(ErrCacheNotFound, "no upload %d", id)
+	}
+	return u, nil
+}
+
+func (b *ChainBackend) UploadChunk(ctx context.Context, id int, ra io.ReaderAt, off, n int64) error {
+	u, err := b.upload(id)
+	if err != nil {
+		return err
+	}
+	return b.each(u, func(i, bid int) error {
+		return b.backends[i].UploadChunk(ctx, bid, ra, off, n)
+	})
+}
+
+func (b *ChainBackend) Commit(ctx context.Context, id int, size int64) error {
+	u, err := b.upload(id)
+	if err != nil {
+		return err
+	}
+	err = b.each(u, func(i, bid int) error {
+		return b.backends[i].Commit(ctx, bid, size)
+	})
+	b.mu.Lock()
+	delete(b.uploads, id)
+	b.mu.Unlock()
+	return err
+}
+
+// each calls fn for the backends u is written to. With WriteAny backends
+// failing fn are dropped and only the failure of the last one is returned.
+func (b *ChainBackend) each(u *chainUpload, fn func(i, id int) error) error {
+	u.mu.Lock()
+	active := append([]bool(nil), u.active...)
+	u.mu.Unlock()
+	for i, ok := range active {
+		if !ok {
+			continue
+		}
+		if err := fn(i, u.ids[i]); err != nil {
+			if b.policy != WriteAny {
+				return err
+			}
+			u.mu.Lock()
+			u.active[i] = false
+			last := !u.anyActive()
+			u.mu.Unlock()
+			if last {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (u *chainUpload) anyActive() bool {
+	for _, ok := range u.active {
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *ChainBackend) Lookup(ctx context.Context, keys []string, version string) (*Entry, error) {
+	var firstErr error
+	missed := false
+	for i, be := range b.backends {
+		ce, err := be.Lookup(ctx, keys, version)
+		if errors.Is(err, ErrCacheNotFound) || (err == nil && ce == nil) {
+			missed = true
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		b.mu.Lock()
+		b.entries[ce.URL] = &chainHit{backend: i, key: ce.Key, version: version}
+		b.mu.Unlock()
+		return ce, nil
+	}
+	if missed || firstErr == nil {
+		return nil, nil
+	}
+	return nil, firstErr
+}
+
+func (b *ChainBackend) Download(ctx context.Context, url string, w io.Writer) error {
+	b.mu.Lock()
+	hit, ok := b.entries[url]
+	b.mu.Unlock()
+	if !ok {
+		return errors.Errorf("cache archive %s was not looked up by the chain", url)
+	}
+	be := b.backends[hit.backend]
+	if !b.Populate || hit.backend == 0 {
+		return be.Download(ctx, url, w)
+	}
+	f, err := ioutil.TempFile("", "actionscache-chain-")
+	if err != nil {
+		return be.Download(ctx, url, w)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	cw := &countWriter{w: f}
+	if err := be.Download(ctx, url, io.MultiWriter(w, cw)); err != nil {
+		return err
+	}
+	for i := 0; i < hit.backend; i++ {
+		b.populate(ctx, b.backends[i], hit, f, cw.n)
+	}
+	return nil
+}
+
+// populate saves the downloaded archive of hit in be. Errors are ignored,
+// the entry is looked up in the next backend again.
+func (b *ChainBackend) populate(ctx context.Context, be Backend, hit *chainHit, ra io.ReaderAt, size int64) {
+	id, err := be.Reserve(ctx, hit.key, hit.version)
+	if err != nil {
+		return
+	}
+	chunk := int64(UploadChunkSize)
+	for off := int64(0); off < size; off += chunk {
+		n := chunk
+		if off+n > size {
+			n = size - off
+		}
+		if err := be.UploadChunk(ctx, id, ra, off, n); err != nil {
+			return
+		}
+	}
+	be.Commit(ctx, id, size)
+}
@@ -0,0 +1,96 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// failingBackend fails every call, like a service that is down.
+type failingBackend struct{}
+
+var errBackendDown = errors.New("backend down")
+
+func (failingBackend) Reserve(ctx context.Context, key, version string) (int, error) {
+	return 0, errBackendDown
+}
+
+func (failingBackend) UploadChunk(ctx context.Context, id int, ra io.ReaderAt, off, n int64) error {
+	return errBackendDown
+}
+
+func (failingBackend) Commit(ctx context.Context, id int, size int64) error {
+	return errBackendDown
+}
+
+func (failingBackend) Lookup(ctx context.Context, keys []string, version string) (*Entry, error) {
+	return nil, errBackendDown
+}
+
+func (failingBackend) Download(ctx context.Context, url string, w io.Writer) error {
+	return errBackendDown
+}
+
+func TestChainBackend(t *testing.T) {
+	local, err := NewFSBackend(t.TempDir())
+	require.NoError(t, err)
+	mirror, err := NewFSBackend(t.TempDir())
+	require.NoError(t, err)
+	ctx := context.TODO()
+	dt := []byte("0123456789")
+
+	// the mirror has an entry the local directory does not
+	require.NoError(t, NewWithBackend(mirror).Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+
+	chain := NewChainBackend(WriteAll, local, failingBackend{}, mirror)
+	chain.Populate = true
+	c := NewWithBackend(chain)
+	ce, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	var buf bytes.Buffer
+	require.NoError(t, ce.Download(ctx, &buf))
+	require.Equal(t, dt, buf.Bytes())
+
+	// the download populated the local directory
+	ce, err = NewWithBackend(local).Load(ctx, "foo")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	buf.Reset()
+	require.NoError(t, ce.Download(ctx, &buf))
+	require.Equal(t, dt, buf.Bytes())
+
+	ce, err = c.Load(ctx, "bar")
+	require.NoError(t, err)
+	require.Nil(t, ce)
+
+	// only failing backends report their error
+	_, err = NewWithBackend(NewChainBackend(WriteAll, failingBackend{})).Load(ctx, "foo")
+	require.ErrorIs(t, err, errBackendDown)
+
+	err = c.Save(ctx, "all", bytes.NewReader(dt), int64(len(dt)))
+	require.ErrorIs(t, err, errBackendDown)
+
+	c = NewWithBackend(NewChainBackend(WriteAny, local, failingBackend{}, mirror))
+	require.NoError(t, c.Save(ctx, "any", bytes.NewReader(dt), int64(len(dt))))
+	for _, b := range []Backend{local, mirror} {
+		ce, err := NewWithBackend(b).Load(ctx, "any")
+		require.NoError(t, err)
+		require.NotNil(t, ce)
+	}
+	require.ErrorIs(t, c.Save(ctx, "any", bytes.NewReader(dt), int64(len(dt))), ErrReserveConflict)
+	require.NoError(t, c.Save(ctx, "any", bytes.NewReader(dt), int64(len(dt)), SaveIgnoreAlreadyExists()))
+
+	c = NewWithBackend(NewChainBackend(WriteFirst, local, mirror))
+	require.NoError(t, c.Save(ctx, "first", bytes.NewReader(dt), int64(len(dt))))
+	ce, err = NewWithBackend(mirror).Load(ctx, "first")
+	require.NoError(t, err)
+	require.Nil(t, ce)
+	ce, err = c.Load(ctx, "first")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+}
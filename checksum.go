@@ -0,0 +1,227 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// checksumKeyPrefix prefixes the keys of the entries storing the digest of
+// another entry.
+const checksumKeyPrefix = "actionscache-checksum-"
+
+// ErrChecksumMismatch is matched by errors of downloads whose data does not
+// match the digest stored when it was saved.
+var ErrChecksumMismatch = errors.New("cache checksum mismatch")
+
+// ChecksumMismatchError is returned by Download when the downloaded data
+// does not match its stored digest. The data has been written already and
+// must be discarded.
+type ChecksumMismatchError struct {
+	Key      string
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("checksum of cache %s is %s, expected %s", e.Key, e.Actual, e.Expected)
+}
+
+func (e *ChecksumMismatchError) Is(target error) bool {
+	return target == ErrChecksumMismatch
+}
+
+// WithChecksums makes saves store the digest of the data in a separate entry
+// and downloads verify it. DownloadAt downloads sequentially to verify the
+// data and ReaderAt refuses entries with a digest, as random access can not
+// be verified. Entries saved without a digest are not verified.
+func WithChecksums() Opt {
+	return func(c *Cache) {
+		c.VerifyChecksums = true
+	}
+}
+
+// checksumKeyBase is the key prefix shared by the digest entries of key. The
+// length of key keeps the prefixes of different keys apart.
+func checksumKeyBase(key string) string {
+	return checksumKeyPrefix + strconv.Itoa(len(key)) + ":" + key + "@"
+}
+
+// checksumKey is the key of the entry storing digest for key. The digest is
+// part of the key so entries saved again after an eviction get a new one.
+func checksumKey(key, digest string) string {
+	return checksumKeyBase(key) + digest
+}
+
+// reportSaved calls fn with the keys that are uploaded and committed, after
+// any function set before.
+func reportSaved(fn func(key string)) SaveOpt {
+	return func(o *saveOpt) {
+		prev := o.onSaved
+		o.onSaved = func(key string) {
+			if prev != nil {
+				prev(key)
+			}
+			fn(key)
+		}
+	}
+}
+
+// saved reports that key was uploaded and committed.
+func (so *saveOpt) saved(key string) {
+	if so.onSaved != nil {
+		so.onSaved(key)
+	}
+}
+
+// checksummed returns the options to save key with and a function storing
+// the digest of the saved data. The function is nil if checksums are not
+// enabled. Saves skipped for an existing entry do not store a digest.
+func (c *Cache) checksummed(ctx context.Context, key string, opts []SaveOpt) ([]SaveOpt, func(digest string) error) {
+	if !c.VerifyChecksums {
+		return opts, nil
+	}
+	var saved bool
+	opts = append(opts[:len(opts):len(opts)], reportSaved(func(k string) {
+		if k == key {
+			saved = true
+		}
+	}))
+	var so saveOpt
+	for _, o := range opts {
+		o(&so)
+	}
+	return opts, func(digest string) error {
+		if !saved {
+			return nil
+		}
+		dt := []byte(digest)
+		sopts := []SaveOpt{SaveIgnoreAlreadyExists()}
+		if so.scope != "" {
+			sopts = append(sopts, SaveScope(so.scope))
+		}
+		if err := c.save(ctx, checksumKey(key, digest), bytes.NewReader(dt), int64(len(dt)), sopts); err != nil {
+			c.warn(ctx, "failed to save cache checksum", F("key", key), F("error", err))
+		}
+		return nil
+	}
+}
+
+// expectedDigest returns the stored digest of ce or an empty string if it
+// was saved without one. The newest digest entry of the key is used, digests
+// older than ce belong to an evicted entry and are ignored.
+func (ce *Entry) expectedDigest(ctx context.Context) (string, error) {
+	if ce.c == nil || !ce.c.VerifyChecksums {
+		return "", nil
+	}
+	k := checksumKeyBase(ce.Key)
+	sc, err := ce.c.load(ctx, []string{k}, nil)
+	if err != nil || sc == nil || !strings.HasPrefix(sc.Key, k) {
+		return "", err
+	}
+	if !ce.CreationTime.IsZero() && !sc.CreationTime.IsZero() && sc.CreationTime.Before(ce.CreationTime) {
+		ce.c.warn(ctx, "ignoring checksum of evicted cache", F("key", ce.Key))
+		return "", nil
+	}
+	buf := &bytes.Buffer{}
+	if err := sc.download(ctx, buf); err != nil {
+		return "", err
+	}
+	if digest := buf.String(); sc.Key == checksumKey(ce.Key, digest) {
+		return digest, nil
+	}
+	return "", errors.Errorf("invalid checksum entry %s", sc.Key)
+}
+
+// readerDigestAsync computes the digest of r in the background, eg. while r
+// is uploaded. wait returns the result.
+func (c *Cache) readerDigestAsync(r io.Reader) (wait func() (string, error)) {
+	type result struct {
+		digest string
+		err    error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		digest, err := c.readerDigest(r)
+		ch <- result{digest, err}
+	}()
+	return func() (string, error) {
+		res := <-ch
+		return res.digest, res.err
+	}
+}
+
+// checksumWriter returns a writer saving key that stores the digest of the
+// data on Close when checksums are enabled.
+func (c *Cache) checksumWriter(ctx context.Context, key string, opts []SaveOpt) (io.WriteCloser, error) {
+	opts, finish := c.checksummed(ctx, key, opts)
+	if finish == nil {
+		return c.saveWriter(ctx, key, opts)
+	}
+	h, err := c.newHash()
+	if err != nil {
+		return nil, err
+	}
+	w, err := c.saveWriter(ctx, key, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &checksumWriter{w: w, h: h, name: c.hashName(), finish: finish}, nil
+}
+
+// downloadVerified downloads ce into w and compares the data with its
+// stored digest.
+func (ce *Entry) downloadVerified(ctx context.Context, w io.Writer) error {
+	expected, err := ce.expectedDigest(ctx)
+	if err != nil {
+		return err
+	}
+	if expected == "" {
+		return ce.download(ctx, w)
+	}
+	name, h, err := digestHash(expected)
+	if err != nil {
+		return err
+	}
+	if err := ce.download(ctx, io.MultiWriter(w, h)); err != nil {
+		return err
+	}
+	if actual := hashDigest(name, h); actual != expected {
+		return errors.WithStack(&ChecksumMismatchError{Key: ce.Key, Expected: expected, Actual: actual})
+	}
+	return nil
+}
+
+// checksumWriter hashes the data of a SaveWriter and stores its digest once
+// the entry is committed.
+type checksumWriter struct {
+	w      io.WriteCloser
+	h      hash.Hash
+	name   string
+	finish func(string) error
+}
+
+func (cw *checksumWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.h.Write(p[:n])
+	return n, err
+}
+
+func (cw *checksumWriter) Close() error {
+	if err := cw.w.Close(); err != nil {
+		return err
+	}
+	return cw.finish(hashDigest(cw.name, cw.h))
+}
+
+func (cw *checksumWriter) abort(err error) {
+	if a, ok := cw.w.(interface{ abort(error) }); ok {
+		a.abort(err)
+	}
+}
@@ -0,0 +1,78 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecksums(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	WithChecksums()(c)
+
+	ctx := context.TODO()
+	dt := []byte("foobar")
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+	require.NoError(t, c.SaveReader(ctx, "bar", bytes.NewReader(dt)))
+	w, err := c.SaveWriter(ctx, "qux")
+	require.NoError(t, err)
+	_, err = w.Write(dt)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	for _, k := range []string{"foo", "bar", "qux"} {
+		require.NotNil(t, ts.entry(escapeKey(checksumKey(k, "sha256:c3ab8ff13720e8ad9047dd39466b3c8974e592c2fa383d4a3960714caef0c4f2"))))
+		ce, err := c.Load(ctx, k)
+		require.NoError(t, err)
+		buf := &bytes.Buffer{}
+		require.NoError(t, ce.Download(ctx, buf))
+		require.Equal(t, dt, buf.Bytes())
+	}
+
+	ts.entry("foo").Data = []byte("fooBAR")
+	ce, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	err = ce.Download(ctx, &bytes.Buffer{})
+	require.ErrorIs(t, err, ErrChecksumMismatch)
+	var me *ChecksumMismatchError
+	require.True(t, errors.As(err, &me))
+	require.Equal(t, "foo", me.Key)
+
+	// range downloads are verified, random access is refused
+	err = ce.DownloadAt(ctx, &bufferAt{})
+	require.ErrorIs(t, err, ErrChecksumMismatch)
+	_, err = ce.ReaderAt(ctx).ReadAt(make([]byte, 3), 0)
+	require.Error(t, err)
+
+	// entries evicted and saved again get a new checksum
+	ts.evict("foo")
+	other := []byte("other")
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(other), int64(len(other))))
+	ce, err = c.Load(ctx, "foo")
+	require.NoError(t, err)
+	buf := &bytes.Buffer{}
+	require.NoError(t, ce.Download(ctx, buf))
+	require.Equal(t, other, buf.Bytes())
+
+	// stale checksums of evicted entries are ignored
+	ts.evict("foo")
+	c3 := ts.newCache(t)
+	require.NoError(t, c3.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+	ce, err = c.Load(ctx, "foo")
+	require.NoError(t, err)
+	buf.Reset()
+	require.NoError(t, ce.Download(ctx, buf))
+	require.Equal(t, dt, buf.Bytes())
+
+	// entries saved without a checksum are not verified
+	c2 := ts.newCache(t)
+	require.NoError(t, c2.Save(ctx, "baz", bytes.NewReader(dt), int64(len(dt))))
+	ts.entry("baz").Data = []byte("fooBAR")
+	ce, err = c.Load(ctx, "baz")
+	require.NoError(t, err)
+	require.NoError(t, ce.Download(ctx, &bytes.Buffer{}))
+}
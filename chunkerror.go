@@ -0,0 +1,82 @@
+package actionscache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// ChunkUploadError is returned when a chunk of a parallel upload fails. The
+// chunk uploads in flight at that time are canceled and the remaining ones
+// are not started.
+type ChunkUploadError struct {
+	// CacheID is zero for the v2 service.
+	CacheID int
+	// Offset is the offset of the failed chunk.
+	Offset int64
+	// Canceled is the number of chunk uploads canceled in flight.
+	Canceled int
+	// Skipped is the number of chunks that were not started.
+	Skipped int
+	// Err is the error of the failed chunk.
+	Err error
+}
+
+func (e *ChunkUploadError) Error() string {
+	return fmt.Sprintf("failed to upload chunk at offset %d of cache %d (%d in flight canceled, %d not started): %v", e.Offset, e.CacheID, e.Canceled, e.Skipped, e.Err)
+}
+
+func (e *ChunkUploadError) Unwrap() error {
+	return e.Err
+}
+
+// chunkFailures records the first failed chunk of a parallel upload and
+// cancels the context of its siblings.
+type chunkFailures struct {
+	parent context.Context
+	cancel func()
+
+	mu       sync.Mutex
+	err      error
+	offset   int64
+	canceled int
+}
+
+func newChunkFailures(ctx context.Context) (context.Context, *chunkFailures) {
+	cctx, cancel := context.WithCancel(ctx)
+	return cctx, &chunkFailures{parent: ctx, cancel: cancel}
+}
+
+// fail records the failure of the chunk at off and returns err.
+func (f *chunkFailures) fail(off int64, err error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case f.parent.Err() != nil:
+	case f.err == nil:
+		f.err = err
+		f.offset = off
+		f.cancel()
+	default:
+		f.canceled++
+	}
+	return err
+}
+
+// result returns a *ChunkUploadError if a chunk failed, err otherwise. It
+// releases the context of the upload.
+func (f *chunkFailures) result(id int, skipped int, err error) error {
+	f.cancel()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err == nil {
+		return err
+	}
+	return &ChunkUploadError{
+		CacheID:  id,
+		Offset:   f.offset,
+		Canceled: f.canceled,
+		Skipped:  skipped,
+		Err:      f.err,
+	}
+}
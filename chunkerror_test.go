@@ -0,0 +1,56 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunkUploadError(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	c.UploadConcurrency = 4
+	c.UploadChunkSize = 4
+
+	inflight := make(chan struct{}, 3)
+	release := make(chan struct{})
+	h := ts.Config.Handler
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PATCH" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if strings.HasPrefix(r.Header.Get("Content-Range"), "bytes 0-") {
+			for i := 0; i < 3; i++ {
+				<-inflight
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// siblings hang until the test ends, Save must not wait for them
+		inflight <- struct{}{}
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	})
+	defer close(release)
+
+	dt := []byte("0123456789abcdefghijklmnopqrstuvwxyzABCD")
+	err := c.Save(context.TODO(), "foo", bytes.NewReader(dt), int64(len(dt)))
+	var ce *ChunkUploadError
+	require.True(t, errors.As(err, &ce), "%+v", err)
+	require.Equal(t, 1, ce.CacheID)
+	require.Equal(t, int64(0), ce.Offset)
+	require.Equal(t, 3, ce.Canceled)
+	require.Equal(t, 6, ce.Skipped)
+	var ae *GithubAPIError
+	require.True(t, errors.As(err, &ae))
+	require.Equal(t, http.StatusBadRequest, ae.StatusCode)
+	require.Len(t, c.Stats().Orphaned, 1)
+}
@@ -0,0 +1,35 @@
+package actionscache
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"io"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// WithChunkMD5 sends the Content-MD5 of every uploaded chunk so the server
+// rejects chunks corrupted in transit instead of committing a broken
+// archive. The data of each chunk is read twice. Downloads from Azure Blob
+// SAS URLs request ranges of at most 4 MiB and check them against the MD5
+// that Azure returns for each.
+func WithChunkMD5() Opt {
+	return func(c *Cache) {
+		c.ChunkMD5 = true
+	}
+}
+
+// setContentMD5 sets the Content-MD5 header of req to the digest of n
+// bytes of ra at off if c sends chunk checksums.
+func (c *Cache) setContentMD5(req *http.Request, ra io.ReaderAt, off, n int64) error {
+	if !c.ChunkMD5 {
+		return nil
+	}
+	h := md5.New()
+	if _, err := io.Copy(h, io.NewSectionReader(ra, off, n)); err != nil {
+		return errors.WithStack(err)
+	}
+	req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(h.Sum(nil)))
+	return nil
+}
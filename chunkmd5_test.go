@@ -0,0 +1,70 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// corruptingTransport flips the first byte of chunk uploads.
+type corruptingTransport struct{}
+
+func (corruptingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == "PATCH" || (req.Method == "PUT" && req.URL.Query().Get("comp") == "block") {
+		dt, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		dt[0] ^= 0xff
+		req = req.Clone(req.Context())
+		req.Body = ioutil.NopCloser(bytes.NewReader(dt))
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestChunkMD5(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	c.HTTPClient = &http.Client{Transport: corruptingTransport{}}
+	c.RetryPolicy = &RetryPolicy{MaxAttempts: 1}
+
+	ctx := context.TODO()
+	dt := []byte("foobar")
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+	require.NotEqual(t, dt, ts.entry("foo").Data)
+
+	WithChunkMD5()(c)
+	err := c.Save(ctx, "bar", bytes.NewReader(dt), int64(len(dt)))
+	require.Error(t, err)
+	require.Nil(t, ts.entry("bar"))
+
+	// the failed upload keeps "bar" reserved
+	c.HTTPClient = nil
+	require.NoError(t, c.Save(ctx, "baz", bytes.NewReader(dt), int64(len(dt))))
+	require.Equal(t, dt, ts.entry("baz").Data)
+}
+
+func TestChunkMD5Blocks(t *testing.T) {
+	ts := newTestServer(t)
+	blocks := 0
+	ts.verify = func(r *http.Request, body []byte) {
+		if strings.HasPrefix(r.URL.Path, "/upload/") && r.URL.Query().Get("comp") == "block" {
+			require.NotEmpty(t, r.Header.Get("Content-MD5"))
+			blocks++
+		}
+	}
+	c := ts.newCacheV2(t)
+	c.UploadChunkSize = 4
+	WithChunkMD5()(c)
+
+	ctx := context.TODO()
+	dt := []byte("0123456789")
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+	require.Equal(t, 3, blocks)
+	require.Equal(t, dt, ts.entry("foo").Data)
+}
@@ -0,0 +1,106 @@
+package actionscache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MinAdaptiveChunkSize is the size of the first chunks of uploads with
+// adaptive chunk sizes, they never get smaller.
+var MinAdaptiveChunkSize = 4 * 1024 * 1024
+
+// AdaptiveChunkTarget is the default duration adaptive chunk sizes aim for
+// per chunk upload.
+var AdaptiveChunkTarget = 10 * time.Second
+
+// WithAdaptiveChunkSize makes chunked uploads start with chunks of
+// MinAdaptiveChunkSize and adapt the size to the link. The size doubles,
+// up to the upload chunk size, while chunks are uploaded in less than half
+// of target and is halved when a chunk takes longer than target or has to
+// be retried. target defaults to AdaptiveChunkTarget when 0.
+//
+// Chunks keep a fixed size for streaming saves, resumable uploads, single
+// chunk uploads, the v2 service and custom Backends.
+func WithAdaptiveChunkSize(target time.Duration) Opt {
+	return func(c *Cache) {
+		c.AdaptiveChunkSize = true
+		c.AdaptiveChunkTarget = target
+	}
+}
+
+func (c *Cache) adaptiveChunkTarget() time.Duration {
+	if c != nil && c.AdaptiveChunkTarget > 0 {
+		return c.AdaptiveChunkTarget
+	}
+	return AdaptiveChunkTarget
+}
+
+// chunkSizer picks the size of the next chunk of an upload from the
+// duration and retries of the chunks uploaded before.
+type chunkSizer struct {
+	mu     sync.Mutex
+	size   int
+	min    int
+	max    int
+	target time.Duration
+}
+
+// newChunkSizer returns the sizer of an upload with chunks of up to max
+// bytes, nil if c uses chunks of a fixed size.
+func (c *Cache) newChunkSizer(max int) *chunkSizer {
+	if c == nil || !c.AdaptiveChunkSize || c.Backend != nil {
+		return nil
+	}
+	min := MinAdaptiveChunkSize
+	if min <= 0 || min > max {
+		min = max
+	}
+	return &chunkSizer{size: min, min: min, max: max, target: c.adaptiveChunkTarget()}
+}
+
+func (s *chunkSizer) next() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// observe adapts the size after a chunk of n bytes was uploaded in d.
+// Chunks smaller than the current size, eg. the last one or ones started
+// before the size grew, only make it shrink.
+func (s *chunkSizer) observe(n int, d time.Duration, retried bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case retried || d > s.target:
+		if n >= s.size/2 && s.size > s.min {
+			s.size /= 2
+			if s.size < s.min {
+				s.size = s.min
+			}
+		}
+	case d < s.target/2 && n >= s.size && s.size < s.max:
+		s.size *= 2
+		if s.size > s.max {
+			s.size = s.max
+		}
+	}
+}
+
+type retryCounterKey struct{}
+
+// withRetryCounter returns a context whose retried requests are counted
+// by the returned function.
+func withRetryCounter(ctx context.Context) (context.Context, func() int32) {
+	n := new(int32)
+	return context.WithValue(ctx, retryCounterKey{}, n), func() int32 {
+		return atomic.LoadInt32(n)
+	}
+}
+
+func countRetry(ctx context.Context) {
+	if n, ok := ctx.Value(retryCounterKey{}).(*int32); ok {
+		atomic.AddInt32(n, 1)
+	}
+}
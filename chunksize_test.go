@@ -0,0 +1,83 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunkSizer(t *testing.T) {
+	s := &chunkSizer{size: 4, min: 4, max: 32, target: 10 * time.Second}
+	s.observe(4, time.Second, false)
+	require.Equal(t, 8, s.next())
+	s.observe(4, time.Second, false)
+	require.Equal(t, 8, s.next(), "stale chunks do not grow the size")
+	s.observe(8, 6*time.Second, false)
+	require.Equal(t, 8, s.next(), "chunks within target keep the size")
+	s.observe(8, time.Second, false)
+	s.observe(16, time.Second, false)
+	s.observe(32, time.Second, false)
+	require.Equal(t, 32, s.next())
+	s.observe(32, time.Second, true)
+	require.Equal(t, 16, s.next())
+	s.observe(16, 11*time.Second, false)
+	require.Equal(t, 8, s.next())
+	s.observe(8, time.Minute, false)
+	s.observe(4, time.Minute, false)
+	require.Equal(t, 4, s.next())
+
+	c := &Cache{AdaptiveChunkSize: true}
+	require.Nil(t, (&Cache{}).newChunkSizer(32))
+	require.Equal(t, 32, c.newChunkSizer(32).next(), "the minimum is capped at the chunk size")
+	c.Backend = &FSBackend{}
+	require.Nil(t, c.newChunkSizer(1<<30))
+}
+
+func TestAdaptiveChunkSize(t *testing.T) {
+	oldMin := MinAdaptiveChunkSize
+	MinAdaptiveChunkSize = 4
+	defer func() { MinAdaptiveChunkSize = oldMin }()
+
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	WithAdaptiveChunkSize(0)(c)
+	clock := newTestClock()
+	c.Clock = clock
+	c.UploadChunkSize = 32
+	c.UploadConcurrency = 1
+
+	var mu sync.Mutex
+	var sizes []int
+	ts.verify = func(r *http.Request, body []byte) {
+		if r.Method != "PATCH" {
+			return
+		}
+		var start, end int
+		_, err := fmt.Sscanf(r.Header.Get("Content-Range"), "bytes %d-%d/*", &start, &end)
+		require.NoError(t, err)
+		mu.Lock()
+		sizes = append(sizes, end-start+1)
+		if len(sizes) == 4 {
+			clock.Advance(time.Minute)
+		}
+		mu.Unlock()
+	}
+
+	ctx := context.TODO()
+	dt := bytes.Repeat([]byte("a"), 100)
+	require.NoError(t, c.Save(ctx, "adaptive", bytes.NewReader(dt), int64(len(dt))))
+	require.Equal(t, []int{4, 8, 16, 32, 16, 24}, sizes)
+
+	ce, err := c.Load(ctx, "adaptive")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	var buf bytes.Buffer
+	require.NoError(t, ce.Download(ctx, &buf))
+	require.Equal(t, dt, buf.Bytes())
+}
@@ -0,0 +1,60 @@
+package actionscache
+
+import (
+	"context"
+	"time"
+)
+
+// Clock is the source of time for TTLs, backoff, progress and soft timeouts.
+// Tests and embedders can replace it with WithClock to simulate time without
+// sleeping. Hard and step timeouts use the deadlines of their contexts.
+type Clock interface {
+	Now() time.Time
+	// Sleep waits for d or until ctx is done, returning ctx.Err() in the
+	// latter case.
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+// WithClock sets the clock of the Cache, eg. a fake one that advances on
+// Sleep to test retries and backoff without waiting.
+func WithClock(clk Clock) Opt {
+	return func(c *Cache) {
+		c.Clock = clk
+	}
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) Sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *Cache) clock() Clock {
+	if c != nil && c.Clock != nil {
+		return c.Clock
+	}
+	return systemClock{}
+}
+
+// afterFunc calls f in its own goroutine once d has passed on the clock of
+// c. Calling stop before that prevents the call.
+func (c *Cache) afterFunc(d time.Duration, f func()) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		if err := c.clock().Sleep(ctx, d); err == nil {
+			f()
+		}
+	}()
+	return cancel
+}
@@ -0,0 +1,197 @@
+// Command gha-cache saves and restores GitHub Actions cache entries from
+// shell steps. The runtime token and cache URL are read from the environment
+// variables the runner exposes to actions, listing and deleting entries uses
+// the REST API with GITHUB_TOKEN and GITHUB_REPOSITORY.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	actionscache "github.com/tonistiigi/go-actions-cache"
+)
+
+const usage = `usage: gha-cache [-v] [-cacert file] [-insecure] <command> [args]
+
+commands:
+  save <key> <path>                 save a file or directory, "-" reads stdin
+  restore [-x] <path> <key> [prefix...]
+                                    restore the first matching entry to a file,
+                                    "-" writes stdout, -x extracts a directory
+  ls [-ref ref] [prefix]            list the entries of the repository
+  rm [-ref ref] <key>               delete the entries of key
+`
+
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	if err := run(ctx, os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "gha-cache: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("gha-cache", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+	}
+	verbose := fs.Bool("v", false, "log requests to stderr")
+	cacert := fs.String("cacert", "", "trust the PEM certificates of file for cache requests")
+	insecure := fs.Bool("insecure", false, "do not verify the certificates of cache requests")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.Errorf("no command")
+	}
+	var opts []actionscache.Opt
+	if *verbose {
+		opts = append(opts, actionscache.WithLogger(actionscache.LoggerFunc(log.New(os.Stderr, "", log.LstdFlags).Printf)))
+	}
+	if *cacert != "" {
+		pool, err := actionscache.LoadRootCAs(*cacert)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, actionscache.WithRootCAs(pool))
+	}
+	if *insecure {
+		opts = append(opts, actionscache.WithInsecureSkipVerify())
+	}
+
+	cmd, args := fs.Arg(0), fs.Args()[1:]
+	switch cmd {
+	case "save":
+		return save(ctx, args, opts)
+	case "restore":
+		return restore(ctx, args, stdout, opts)
+	case "ls":
+		return list(ctx, args, stdout)
+	case "rm":
+		return remove(ctx, args)
+	}
+	fs.Usage()
+	return errors.Errorf("unknown command %q", cmd)
+}
+
+func newCache(opts []actionscache.Opt) (*actionscache.Cache, error) {
+	c, err := actionscache.TryEnv(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return nil, errors.Errorf("ACTIONS_RUNTIME_TOKEN and ACTIONS_CACHE_URL or ACTIONS_RESULTS_URL must be set")
+	}
+	return c, nil
+}
+
+func newRestAPI() (*actionscache.RestAPI, error) {
+	api, err := actionscache.RestAPIFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if api == nil {
+		return nil, errors.Errorf("GITHUB_TOKEN and GITHUB_REPOSITORY must be set")
+	}
+	return api, nil
+}
+
+func save(ctx context.Context, args []string, opts []actionscache.Opt) error {
+	if len(args) != 2 {
+		return errors.Errorf("usage: gha-cache save <key> <path>")
+	}
+	key, path := args[0], args[1]
+	c, err := newCache(opts)
+	if err != nil {
+		return err
+	}
+	if path == "-" {
+		return c.SaveReader(ctx, key, os.Stdin)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if fi.IsDir() {
+		return c.SaveDir(ctx, key, path)
+	}
+	return c.SaveFile(ctx, key, path)
+}
+
+func restore(ctx context.Context, args []string, stdout io.Writer, opts []actionscache.Opt) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	extract := fs.Bool("x", false, "extract a directory saved with save")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 2 {
+		return errors.Errorf("usage: gha-cache restore [-x] <path> <key> [prefix...]")
+	}
+	path, keys := fs.Arg(0), fs.Args()[1:]
+	c, err := newCache(opts)
+	if err != nil {
+		return err
+	}
+	ce, err := c.Load(ctx, keys...)
+	if err != nil {
+		return err
+	}
+	if ce == nil {
+		return errors.Errorf("no cache entry found for %s", keys[0])
+	}
+	if *extract {
+		return actionscache.RestoreDir(ctx, ce, path)
+	}
+	if path == "-" {
+		return ce.Download(ctx, stdout)
+	}
+	return ce.DownloadToFile(ctx, path)
+}
+
+func list(ctx context.Context, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("ls", flag.ContinueOnError)
+	ref := fs.String("ref", "", "only list entries of a git reference")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	api, err := newRestAPI()
+	if err != nil {
+		return err
+	}
+	entries, err := api.List(ctx, fs.Arg(0), *ref)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tREF\tSIZE\tLAST ACCESSED")
+	for _, e := range entries {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", e.Key, e.Ref, e.SizeInBytes, e.LastAccessedAt.Format(time.RFC3339))
+	}
+	return tw.Flush()
+}
+
+func remove(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("rm", flag.ContinueOnError)
+	ref := fs.String("ref", "", "only delete entries of a git reference")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.Errorf("usage: gha-cache rm [-ref ref] <key>")
+	}
+	api, err := newRestAPI()
+	if err != nil {
+		return err
+	}
+	return api.DeleteKey(ctx, fs.Arg(0), *ref)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tonistiigi/go-actions-cache/actionscachetest"
+)
+
+func setEnv(t *testing.T, env map[string]string) {
+	for k, v := range env {
+		if old, ok := os.LookupEnv(k); ok {
+			t.Cleanup(func() { os.Setenv(k, old) })
+		} else {
+			t.Cleanup(func() { os.Unsetenv(k) })
+		}
+		if v == "" {
+			os.Unsetenv(k)
+		} else {
+			os.Setenv(k, v)
+		}
+	}
+}
+
+func TestSaveRestore(t *testing.T) {
+	s := actionscachetest.NewServer()
+	defer s.Close()
+	setEnv(t, map[string]string{
+		"ACTIONS_RUNTIME_TOKEN": s.Token(),
+		"ACTIONS_CACHE_URL":     s.URL + "/",
+		"ACTIONS_RESULTS_URL":   "",
+		"GITHUB_SERVER_URL":     "",
+	})
+
+	dir, err := ioutil.TempDir("", "gha-cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "sub"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(src, "sub", "foo"), []byte("foo"), 0644))
+
+	ctx := context.TODO()
+	require.NoError(t, run(ctx, []string{"save", "file-1", filepath.Join(src, "sub", "foo")}, ioutil.Discard))
+	require.NoError(t, run(ctx, []string{"save", "dir-1", src}, ioutil.Discard))
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, run(ctx, []string{"restore", "-", "file-2", "file-"}, buf))
+	require.Equal(t, "foo", buf.String())
+
+	dst := filepath.Join(dir, "dst")
+	require.NoError(t, run(ctx, []string{"restore", "-x", dst, "dir-1"}, ioutil.Discard))
+	dt, err := ioutil.ReadFile(filepath.Join(dst, "sub", "foo"))
+	require.NoError(t, err)
+	require.Equal(t, "foo", string(dt))
+
+	require.Error(t, run(ctx, []string{"restore", "-", "missing"}, ioutil.Discard))
+	require.Error(t, run(ctx, []string{"unknown"}, ioutil.Discard))
+	require.Error(t, run(ctx, []string{"-cacert", filepath.Join(dir, "missing.pem"), "restore", "-", "file-1"}, ioutil.Discard))
+	require.NoError(t, run(ctx, []string{"-insecure", "restore", "-", "file-1"}, ioutil.Discard))
+}
@@ -0,0 +1,137 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Codec serializes values stored with SaveValue and LoadValue.
+type Codec interface {
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(dt []byte, v interface{}) error
+}
+
+// DefaultCodec is the name of the codec SaveValue uses without SaveCodec.
+var DefaultCodec = "json"
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{}
+)
+
+func init() {
+	RegisterCodec(jsonCodec{})
+	RegisterCodec(gobCodec{})
+}
+
+// RegisterCodec makes a codec available to SaveValue and LoadValue,
+// replacing any codec registered with the same name.
+func RegisterCodec(c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[c.Name()] = c
+}
+
+func getCodec(name string) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecs[name]
+	if !ok {
+		return nil, errors.Errorf("codec %q not registered", name)
+	}
+	return c, nil
+}
+
+// SaveCodec selects the registered codec SaveValue serializes with.
+func SaveCodec(name string) SaveOpt {
+	return func(o *saveOpt) {
+		o.codec = name
+	}
+}
+
+// SaveValue serializes v and saves it under key. The codec name is stored
+// with the data so LoadValue can decode it without knowing the codec.
+func (c *Cache) SaveValue(ctx context.Context, key string, v interface{}, opts ...SaveOpt) error {
+	so, err := c.saveOpts(ctx, opts)
+	if err != nil {
+		return err
+	}
+	name := so.codec
+	if name == "" {
+		name = DefaultCodec
+	}
+	codec, err := getCodec(name)
+	if err != nil {
+		return err
+	}
+	dt, err := codec.Marshal(v)
+	if err != nil {
+		return errors.Wrapf(err, "failed to marshal with %s codec", name)
+	}
+	dt = append([]byte(name+"\n"), dt...)
+	return c.Save(ctx, key, bytes.NewReader(dt), int64(len(dt)), opts...)
+}
+
+// LoadValue loads the first entry matching keys and decodes it into v. It
+// returns nil without modifying v if no entry was found.
+func (c *Cache) LoadValue(ctx context.Context, v interface{}, keys ...string) (*Entry, error) {
+	ce, err := c.Load(ctx, keys...)
+	if err != nil || ce == nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	if err := ce.Download(ctx, buf); err != nil {
+		return nil, err
+	}
+	dt := buf.Bytes()
+	i := bytes.IndexByte(dt, '\n')
+	if i < 0 {
+		return nil, errors.Errorf("invalid value entry %s without codec", ce.Key)
+	}
+	codec, err := getCodec(string(dt[:i]))
+	if err != nil {
+		return nil, err
+	}
+	if err := codec.Unmarshal(dt[i+1:], v); err != nil {
+		return nil, errors.Wrapf(err, "failed to unmarshal %s with %s codec", ce.Key, codec.Name())
+	}
+	return ce, nil
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(dt []byte, v interface{}) error {
+	return json.Unmarshal(dt, v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string {
+	return "gob"
+}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(dt []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(dt)).Decode(v)
+}
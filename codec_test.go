@@ -0,0 +1,38 @@
+package actionscache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testRecord struct {
+	Name  string
+	Count int
+}
+
+func TestSaveLoadValue(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	ctx := context.TODO()
+
+	var rec testRecord
+	ce, err := c.LoadValue(ctx, &rec, "foo")
+	require.NoError(t, err)
+	require.Nil(t, ce)
+
+	require.NoError(t, c.SaveValue(ctx, "foo", testRecord{Name: "foo", Count: 1}))
+	require.NoError(t, c.SaveValue(ctx, "bar", testRecord{Name: "bar", Count: 2}, SaveCodec("gob")))
+	require.Error(t, c.SaveValue(ctx, "baz", testRecord{}, SaveCodec("msgpack")))
+
+	ce, err = c.LoadValue(ctx, &rec, "foo")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	require.Equal(t, testRecord{Name: "foo", Count: 1}, rec)
+
+	ce, err = c.LoadValue(ctx, &rec, "bar")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	require.Equal(t, testRecord{Name: "bar", Count: 2}, rec)
+}
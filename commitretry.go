@@ -0,0 +1,60 @@
+package actionscache
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// commitEntry commits the reservation id of key with size bytes. A commit
+// that timed out may have been applied by the service anyway, its retry is
+// then refused as the reservation is already committed. The entry of key
+// is looked up in that case and the commit succeeds if it is stored with
+// size bytes, so the save is not failed for an entry that is fine.
+func (c *Cache) commitEntry(ctx context.Context, key string, id int, size int64) (err error) {
+	defer func() { c.events().OnCommit(ctx, CommitEvent{Key: key, ID: id, Size: size, Err: err}) }()
+	rctx, retries := withRetryCounter(ctx)
+	err = c.commit(rctx, id, size)
+	if err == nil || retries() == 0 || !isCommitRefused(err) {
+		return err
+	}
+	if !c.committedAnyway(ctx, key, size, err) {
+		return err
+	}
+	c.info(ctx, "save cache: retried commit refused, entry was committed", F("key", key), F("cacheID", id))
+	return nil
+}
+
+// committedAnyway reports if the entry of key is stored with size bytes
+// after a retried commit or finalize request failed with err.
+func (c *Cache) committedAnyway(ctx context.Context, key string, size int64, err error) bool {
+	// a miss remembered before the save would hide the entry
+	c.clearMisses(key)
+	if cerr := c.checkCommitted(ctx, key, size); cerr != nil {
+		c.debug(ctx, "save cache: entry of refused commit not found", F("key", key), F("error", err), F("check", cerr))
+		return false
+	}
+	return true
+}
+
+// isCommitRefused reports if err is the response of the service to the
+// commit of a reservation that does not exist anymore.
+func isCommitRefused(err error) bool {
+	var ae *GithubAPIError
+	if errors.As(err, &ae) {
+		switch ae.StatusCode {
+		case http.StatusBadRequest, http.StatusNotFound, http.StatusConflict:
+			return true
+		}
+		return false
+	}
+	var te *twirpError
+	if errors.As(err, &te) {
+		switch te.Code {
+		case "not_found", "already_exists", "failed_precondition", "invalid_argument":
+			return true
+		}
+	}
+	return false
+}
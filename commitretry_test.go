@@ -0,0 +1,96 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommitRetryAlreadyCommitted(t *testing.T) {
+	ts := newTestServer(t)
+	h := ts.Config.Handler
+	// lost commits are applied but respond with a timeout, refused ones are
+	// not applied and respond not found
+	lost, refused := 0, 0
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		commit := r.Method == "POST" && strings.Contains(r.URL.Path, "/caches/")
+		finalize := strings.HasSuffix(r.URL.Path, "/FinalizeCacheEntryUpload")
+		switch {
+		case lost > 0 && (commit || finalize):
+			lost--
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			http.Error(w, "gateway timeout", http.StatusGatewayTimeout)
+		case refused > 0 && (commit || finalize):
+			refused--
+			io.Copy(ioutil.Discard, r.Body)
+			if refused%2 == 1 {
+				http.Error(w, "gateway timeout", http.StatusGatewayTimeout)
+				return
+			}
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"code":"not_found","msg":"upload not found"}`))
+		default:
+			h.ServeHTTP(w, r)
+		}
+	})
+	policy := &RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	ctx := context.TODO()
+	dt := []byte("committed")
+
+	for _, v2 := range []bool{false, true} {
+		c := ts.newCache(t)
+		key := "commit-retry"
+		if v2 {
+			c = ts.newCacheV2(t)
+			key = "commit-retry-v2"
+		}
+		c.RetryPolicy = policy
+
+		lost = 1
+		require.NoError(t, c.Save(ctx, key, bytes.NewReader(dt), int64(len(dt))))
+		require.Equal(t, 0, lost)
+		require.Equal(t, dt, ts.entry(key).Data)
+		require.Empty(t, c.Stats().Orphaned)
+
+		if !v2 {
+			lost = 1
+			w, err := c.SaveWriter(ctx, key+"-writer")
+			require.NoError(t, err)
+			_, err = w.Write(dt)
+			require.NoError(t, err)
+			require.NoError(t, w.Close())
+			require.Equal(t, dt, ts.entry(key+"-writer").Data)
+		}
+
+		// a retried commit that was never applied still fails
+		refused = 2
+		err := c.Save(ctx, key+"-refused", bytes.NewReader(dt), int64(len(dt)))
+		require.Error(t, err)
+		require.Equal(t, 0, refused)
+		require.Nil(t, ts.entry(key+"-refused"))
+	}
+}
+
+func TestCommitRefusedWithoutRetry(t *testing.T) {
+	ts := newTestServer(t)
+	h := ts.Config.Handler
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" && strings.Contains(r.URL.Path, "/caches/") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+	c := ts.newCache(t)
+	err := c.Save(context.TODO(), "refused", bytes.NewReader([]byte("foo")), 3)
+	require.Error(t, err)
+	require.Nil(t, ts.entry("refused"))
+}
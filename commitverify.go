@@ -0,0 +1,80 @@
+package actionscache
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// ErrCommitMismatch is matched by errors of saves whose committed entry
+// could not be found or does not have the uploaded size.
+var ErrCommitMismatch = errors.New("committed cache does not match upload")
+
+// CommitMismatchError is returned by saves with WithCommitVerification when
+// the committed entry is missing or its stored size differs from the
+// uploaded size. The entry may be committed anyway and should not be
+// trusted.
+type CommitMismatchError struct {
+	Key string
+	// Size is the number of uploaded bytes.
+	Size int64
+	// StoredSize is the size of the stored archive, -1 if the entry was not
+	// found.
+	StoredSize int64
+}
+
+func (e *CommitMismatchError) Error() string {
+	if e.StoredSize < 0 {
+		return fmt.Sprintf("committed cache %s not found", e.Key)
+	}
+	return fmt.Sprintf("committed cache %s has %d bytes, uploaded %d", e.Key, e.StoredSize, e.Size)
+}
+
+func (e *CommitMismatchError) Is(target error) bool {
+	return target == ErrCommitMismatch
+}
+
+// WithCommitVerification makes saves look up every committed entry and
+// probe its archive to check that the stored size matches the uploaded
+// size, catching truncated uploads before another job restores them. With
+// a custom Backend only the existence of the entry is checked.
+func WithCommitVerification() Opt {
+	return func(c *Cache) {
+		c.VerifyCommits = true
+	}
+}
+
+// verifyCommit checks the entry of key committed with size bytes.
+func (c *Cache) verifyCommit(ctx context.Context, key string, size int64) error {
+	if !c.VerifyCommits {
+		return nil
+	}
+	if err := c.checkCommitted(ctx, key, size); err != nil {
+		return err
+	}
+	c.debug(ctx, "verified committed cache", F("key", key), F("size", size))
+	return nil
+}
+
+// checkCommitted returns a *CommitMismatchError if the entry of key is not
+// stored with size bytes.
+func (c *Cache) checkCommitted(ctx context.Context, key string, size int64) error {
+	ce, err := c.load(ctx, []string{key}, nil)
+	if err != nil {
+		return errors.Wrapf(err, "failed to verify committed cache %s", key)
+	}
+	if ce == nil || ce.Key != key {
+		return errors.WithStack(&CommitMismatchError{Key: key, Size: size, StoredSize: -1})
+	}
+	if c.Backend != nil {
+		return nil
+	}
+	if err := ce.stat(ctx); err != nil {
+		return errors.Wrapf(err, "failed to verify committed cache %s", key)
+	}
+	if ce.Size >= 0 && ce.Size != size {
+		return errors.WithStack(&CommitMismatchError{Key: key, Size: size, StoredSize: ce.Size})
+	}
+	return nil
+}
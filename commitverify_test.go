@@ -0,0 +1,74 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommitVerification(t *testing.T) {
+	ts := newTestServer(t)
+	h := ts.Config.Handler
+	ctx := context.TODO()
+	dt := []byte("foobar")
+
+	for _, v2 := range []bool{false, true} {
+		ts.Config.Handler = h
+		c := ts.newCache(t)
+		prefix := "verify-v1-"
+		if v2 {
+			c = ts.newCacheV2(t)
+			prefix = "verify-v2-"
+		}
+		WithCommitVerification()(c)
+
+		require.NoError(t, c.Save(ctx, prefix+"ok", bytes.NewReader(dt), int64(len(dt))))
+
+		// the blob reports a truncated archive
+		ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "GET" && (r.Header.Get("Range") != "" || r.Header.Get("x-ms-range") != "") {
+				w.Header().Set("Content-Range", "bytes 0-0/3")
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write(dt[:1])
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+		err := c.Save(ctx, prefix+"truncated", bytes.NewReader(dt), int64(len(dt)))
+		require.ErrorIs(t, err, ErrCommitMismatch)
+		var ce *CommitMismatchError
+		require.True(t, errors.As(err, &ce))
+		require.Equal(t, int64(6), ce.Size)
+		require.Equal(t, int64(3), ce.StoredSize)
+
+		// the committed entry is not found
+		ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.Contains(r.URL.RawQuery, "missing") {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			if strings.HasSuffix(r.URL.Path, "/GetCacheEntryDownloadURL") {
+				w.Write([]byte(`{"ok":false}`))
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+		err = c.Save(ctx, prefix+"missing", bytes.NewReader(dt), int64(len(dt)))
+		require.ErrorIs(t, err, ErrCommitMismatch)
+		require.Contains(t, err.Error(), "not found")
+	}
+
+	ts.Config.Handler = h
+	c := ts.newCache(t)
+	WithCommitVerification()(c)
+	w, err := c.SaveWriter(ctx, "verify-writer")
+	require.NoError(t, err)
+	_, err = w.Write(dt)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+}
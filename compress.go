@@ -0,0 +1,59 @@
+package actionscache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// compressRequest returns a gzip compressed copy of a JSON request if
+// Cache.CompressRequests is enabled. Responses are decompressed
+// transparently by net/http that requests gzip encoding by default.
+func (c *Cache) compressRequest(req *http.Request) (*http.Request, bool, error) {
+	if !c.CompressRequests || req.GetBody == nil || !strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+		return req, false, nil
+	}
+	c.mu.Lock()
+	disabled := c.noCompression
+	c.mu.Unlock()
+	if disabled {
+		return req, false, nil
+	}
+
+	rc, err := req.GetBody()
+	if err != nil {
+		return nil, false, errors.WithStack(err)
+	}
+	defer rc.Close()
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	if _, err := io.Copy(zw, rc); err != nil {
+		return nil, false, errors.WithStack(err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, false, errors.WithStack(err)
+	}
+	dt := buf.Bytes()
+
+	req = req.Clone(req.Context())
+	req.Body = ioutil.NopCloser(bytes.NewReader(dt))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(dt)), nil
+	}
+	req.ContentLength = int64(len(dt))
+	req.Header.Set("Content-Encoding", "gzip")
+	return req, true, nil
+}
+
+// disableCompression stops compressing requests after the server rejected
+// a compressed body.
+func (c *Cache) disableCompression() {
+	c.mu.Lock()
+	c.noCompression = true
+	c.mu.Unlock()
+}
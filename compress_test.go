@@ -0,0 +1,56 @@
+package actionscache
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressRequests(t *testing.T) {
+	var encodings []string
+	supported := true
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		enc := r.Header.Get("Content-Encoding")
+		encodings = append(encodings, enc)
+		body := r.Body
+		if enc == "gzip" {
+			if !supported {
+				w.WriteHeader(http.StatusUnsupportedMediaType)
+				return
+			}
+			zr, err := gzip.NewReader(r.Body)
+			require.NoError(t, err)
+			body = zr
+		}
+		dt, err := ioutil.ReadAll(body)
+		require.NoError(t, err)
+		var req ReserveCacheReq
+		require.NoError(t, json.Unmarshal(dt, &req))
+		require.Equal(t, "foo", req.Key)
+		json.NewEncoder(w).Encode(ReserveCacheResp{CacheID: 1})
+	}))
+	defer ts.Close()
+
+	c, err := New(testToken(t, Scope{Scope: "refs/heads/main", Permission: PermissionRead | PermissionWrite}), ts.URL+"/")
+	require.NoError(t, err)
+	c.CompressRequests = true
+
+	ctx := context.TODO()
+	_, err = c.reserve(ctx, "foo")
+	require.NoError(t, err)
+	require.Equal(t, []string{"gzip"}, encodings)
+
+	supported = false
+	encodings = nil
+	for i := 0; i < 2; i++ {
+		_, err = c.reserve(ctx, "foo")
+		require.NoError(t, err)
+	}
+	require.Equal(t, []string{"gzip", "", ""}, encodings)
+}
@@ -0,0 +1,231 @@
+package actionscache
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Compression compresses cache payloads. Implementations are registered
+// with RegisterCompression and selected with Cache.Compression.
+type Compression interface {
+	Name() string
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+var (
+	compressionsMu sync.RWMutex
+	compressions   = map[string]Compression{}
+)
+
+func init() {
+	RegisterCompression(gzipCompression{})
+}
+
+// RegisterCompression makes a Compression available by its name, replacing
+// a previous one of the same name. Only "gzip" is built in, other names like
+// "zstd" need an implementation registered before saving with them.
+func RegisterCompression(c Compression) {
+	compressionsMu.Lock()
+	defer compressionsMu.Unlock()
+	compressions[c.Name()] = c
+}
+
+func getCompression(name string) (Compression, error) {
+	compressionsMu.RLock()
+	defer compressionsMu.RUnlock()
+	c, ok := compressions[name]
+	if !ok {
+		return nil, errors.Errorf("unknown compression %q", name)
+	}
+	return c, nil
+}
+
+// WithCompression sets the Compression of saved and loaded payloads.
+func WithCompression(name string) Opt {
+	return func(c *Cache) {
+		c.Compression = name
+	}
+}
+
+// compression returns the name of the compression in use.
+func (c *Cache) compression() string {
+	if c == nil || c.Compression == "" {
+		return ""
+	}
+	return c.Compression
+}
+
+// encoding is how payloads are transformed before upload, compressed and
+// then encrypted.
+type encoding struct {
+	compression string
+	key         []byte
+}
+
+func (c *Cache) encoding() encoding {
+	return encoding{compression: c.compression(), key: c.encryptionKey()}
+}
+
+// plain reports if payloads are stored as they are.
+func (e encoding) plain() bool {
+	return e.compression == "" && e.key == nil
+}
+
+// newWriter returns a writer encoding into w. Closing it does not close w.
+func (e encoding) newWriter(w io.Writer) (io.WriteCloser, error) {
+	var closers []io.Closer
+	if e.key != nil {
+		ew, err := newEncryptWriter(e.key, w)
+		if err != nil {
+			return nil, err
+		}
+		w = ew
+		closers = append(closers, ew)
+	}
+	if e.compression != "" {
+		comp, err := getCompression(e.compression)
+		if err != nil {
+			return nil, err
+		}
+		zw, err := comp.NewWriter(w)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		w = zw
+		closers = append([]io.Closer{zw}, closers...)
+	}
+	return &encodingWriter{Writer: w, closers: closers}, nil
+}
+
+// newReader returns a reader decoding r.
+func (e encoding) newReader(r io.Reader) (io.ReadCloser, error) {
+	rc := ioutil.NopCloser(r)
+	if e.key != nil {
+		dr, err := newDecryptReader(e.key, r)
+		if err != nil {
+			return nil, err
+		}
+		rc = ioutil.NopCloser(dr)
+	}
+	if e.compression != "" {
+		comp, err := getCompression(e.compression)
+		if err != nil {
+			return nil, err
+		}
+		zr, err := comp.NewReader(rc)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decompress with %s", e.compression)
+		}
+		rc = zr
+	}
+	return rc, nil
+}
+
+type encodingWriter struct {
+	io.Writer
+	closers []io.Closer
+}
+
+func (ew *encodingWriter) Close() error {
+	for _, c := range ew.closers {
+		if err := c.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// noEncode saves a payload that is already encoded.
+func noEncode(o *saveOpt) {
+	o.raw = true
+}
+
+// encodedWriter encodes into a SaveWriter and commits it on Close.
+type encodedWriter struct {
+	ew io.WriteCloser
+	w  io.WriteCloser
+}
+
+func (cw *encodedWriter) Write(p []byte) (int, error) {
+	return cw.ew.Write(p)
+}
+
+func (cw *encodedWriter) Close() error {
+	if err := cw.ew.Close(); err != nil {
+		cw.abort(err)
+		return errors.WithStack(err)
+	}
+	return cw.w.Close()
+}
+
+func (cw *encodedWriter) abort(err error) {
+	if a, ok := cw.w.(interface{ abort(error) }); ok {
+		a.abort(err)
+	}
+}
+
+// encodeTo returns a reader of ra encoded with e.
+func encodeTo(e encoding, ra io.ReaderAt, size int64) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		ew, err := e.newWriter(pw)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		if _, err := io.Copy(ew, io.NewSectionReader(ra, 0, size)); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		pw.CloseWithError(ew.Close())
+	}()
+	return pr
+}
+
+// decodeTo returns a writer decoding with e into w. The returned wait
+// function closes the writer and returns the first error.
+func decodeTo(e encoding, w io.Writer) (io.Writer, func(error) error) {
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- func() error {
+			r, err := e.newReader(pr)
+			if err != nil {
+				return err
+			}
+			defer r.Close()
+			if _, err := io.Copy(w, r); err != nil {
+				return errors.Wrap(err, "failed to decode cache")
+			}
+			return nil
+		}()
+		pr.Close()
+	}()
+	wait := func(err error) error {
+		pw.CloseWithError(err)
+		if err2 := <-done; err == nil {
+			err = err2
+		}
+		return err
+	}
+	return pw, wait
+}
+
+type gzipCompression struct{}
+
+func (gzipCompression) Name() string {
+	return "gzip"
+}
+
+func (gzipCompression) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+func (gzipCompression) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
@@ -0,0 +1,78 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompression(t *testing.T) {
+	for _, v2 := range []bool{false, true} {
+		ts := newTestServer(t)
+		c := ts.newCache(t)
+		if v2 {
+			c = ts.newCacheV2(t)
+		}
+		ctx := context.TODO()
+		c.Compression = "zstd"
+		err := c.Save(ctx, "comp", bytes.NewReader([]byte("foo")), 3)
+		require.Error(t, err)
+		require.Zero(t, ts.count("POST "))
+
+		c.Compression = "gzip"
+		dt := bytes.Repeat([]byte("compressible payload "), 1000)
+		require.NoError(t, c.Save(ctx, "comp", bytes.NewReader(dt), int64(len(dt))))
+		require.Less(t, len(ts.entry("comp").Data), len(dt))
+
+		ce, err := c.Load(ctx, "comp")
+		require.NoError(t, err)
+		require.NotNil(t, ce)
+		buf := &bytes.Buffer{}
+		require.NoError(t, ce.Download(ctx, buf))
+		require.Equal(t, dt, buf.Bytes())
+
+		out := &bufferAt{}
+		require.NoError(t, ce.DownloadAt(ctx, out))
+		require.Equal(t, dt, out.buf)
+
+		_, err = ce.ReaderAt(ctx).ReadAt(make([]byte, 1), 0)
+		require.Error(t, err)
+
+		plain := ts.newCache(t)
+		if v2 {
+			plain = ts.newCacheV2(t)
+		}
+		ce, err = plain.Load(ctx, "comp")
+		require.NoError(t, err)
+		require.Nil(t, ce)
+	}
+}
+
+func TestCompressionWriter(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	WithCompression("gzip")(c)
+
+	ctx := context.TODO()
+	w, err := c.SaveWriter(ctx, "stream")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("streamed"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	ce, err := c.Load(ctx, "stream")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	buf := &bytes.Buffer{}
+	require.NoError(t, ce.Download(ctx, buf))
+	require.Equal(t, "streamed", buf.String())
+
+	require.NoError(t, c.SaveReader(ctx, "reader", bytes.NewReader([]byte("read"))))
+	ce, err = c.Load(ctx, "reader")
+	require.NoError(t, err)
+	buf.Reset()
+	require.NoError(t, ce.Download(ctx, buf))
+	require.Equal(t, "read", buf.String())
+}
@@ -0,0 +1,115 @@
+package actionscache
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// CorrelationIDHeader is the request header carrying the correlation ID of
+// the operation a request belongs to. Azure Blob Storage logs it as the
+// client request ID.
+const CorrelationIDHeader = "x-ms-client-request-id"
+
+// CorrelationError is returned by failed Load, Save and Download operations.
+// The ID was sent with every request of the operation, GitHub support can
+// find the requests with it.
+type CorrelationError struct {
+	CorrelationID string
+	Err           error
+}
+
+func (e *CorrelationError) Error() string {
+	return fmt.Sprintf("%v (correlation ID %s)", e.Err, e.CorrelationID)
+}
+
+func (e *CorrelationError) Unwrap() error {
+	return e.Err
+}
+
+func (e *CorrelationError) Cause() error {
+	return e.Err
+}
+
+type correlationIDKey struct{}
+
+// WithCorrelationID returns a context whose operations use id as their
+// correlation ID, eg. the ID of the build calling them. Operations without
+// one get a random ID.
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationIDKey{}, id)
+}
+
+// CorrelationIDFromContext returns the correlation ID of ctx.
+func CorrelationIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(correlationIDKey{}).(string)
+	return id, ok && id != ""
+}
+
+// withCorrelationID returns ctx with a new correlation ID unless it has one.
+func withCorrelationID(ctx context.Context) context.Context {
+	if _, ok := CorrelationIDFromContext(ctx); ok {
+		return ctx
+	}
+	return WithCorrelationID(ctx, newCorrelationID())
+}
+
+// newCorrelationID returns a random UUID.
+func newCorrelationID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return ""
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+type operationIDKey struct{}
+
+var lastOperationID uint64
+
+// withOperationID returns ctx with a new operation ID unless it belongs to
+// an operation already. Unlike the correlation ID, that callers may share
+// between many operations, it tells apart the log lines of concurrent
+// operations of the process.
+func withOperationID(ctx context.Context) context.Context {
+	if _, ok := operationID(ctx); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, operationIDKey{}, strconv.FormatUint(atomic.AddUint64(&lastOperationID, 1), 10))
+}
+
+func operationID(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(operationIDKey{}).(string)
+	return id, ok
+}
+
+// detachLogContext returns ctx with the IDs and metadata that the log lines
+// of parent carry, for work that outlives the context of an operation.
+func detachLogContext(ctx, parent context.Context) context.Context {
+	if id, ok := CorrelationIDFromContext(parent); ok {
+		ctx = WithCorrelationID(ctx, id)
+	}
+	if id, ok := operationID(parent); ok {
+		ctx = context.WithValue(ctx, operationIDKey{}, id)
+	}
+	return WithMetadata(ctx, MetadataFromContext(parent))
+}
+
+// correlate adds the correlation ID of ctx to err.
+func correlate(ctx context.Context, err error) error {
+	id, ok := CorrelationIDFromContext(ctx)
+	if err == nil || !ok {
+		return err
+	}
+	var ce *CorrelationError
+	if errors.As(err, &ce) {
+		return err
+	}
+	return &CorrelationError{CorrelationID: id, Err: err}
+}
@@ -0,0 +1,46 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCorrelationID(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	c.RetryPolicy = &RetryPolicy{MaxAttempts: 1}
+	l := &recordingLogger{}
+	c.Logger = l
+	var ids []string
+	ts.verify = func(r *http.Request, body []byte) {
+		ids = append(ids, r.Header.Get(CorrelationIDHeader))
+	}
+
+	ctx := context.TODO()
+	dt := []byte("foobar")
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+	require.Len(t, ids, 3)
+	require.Regexp(t, regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`), ids[0])
+	require.Equal(t, ids[0], ids[1])
+	require.Equal(t, ids[0], ids[2])
+	require.Equal(t, ids[0], l.find("commit cache").fields["correlationID"])
+
+	ids = nil
+	ts.fail = func(r *http.Request) int {
+		return http.StatusBadGateway
+	}
+	_, err := c.Load(WithCorrelationID(ctx, "build-1"), "foo")
+	var ce *CorrelationError
+	require.ErrorAs(t, err, &ce)
+	require.Equal(t, "build-1", ce.CorrelationID)
+	require.Contains(t, err.Error(), "(correlation ID build-1)")
+	require.Equal(t, []string{"build-1"}, ids)
+	var ae *GithubAPIError
+	require.ErrorAs(t, err, &ae)
+	require.Equal(t, http.StatusBadGateway, ae.StatusCode)
+}
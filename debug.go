@@ -0,0 +1,55 @@
+package actionscache
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+)
+
+// DebugInfo is the client state exposed by DebugHandler and Var.
+type DebugInfo struct {
+	Protocol string `json:"protocol"`
+	Stats    Stats  `json:"stats"`
+	// Misses is the number of remembered Load misses.
+	Misses int `json:"misses"`
+	// APIVersions are the api-versions negotiated per endpoint.
+	APIVersions map[string]string `json:"apiVersions,omitempty"`
+}
+
+// DebugInfo returns a snapshot of the client state.
+func (c *Cache) DebugInfo() DebugInfo {
+	di := DebugInfo{
+		Protocol: c.Protocol(),
+		Stats:    c.Stats(),
+	}
+	c.mu.Lock()
+	di.Misses = len(c.misses)
+	c.mu.Unlock()
+	if !c.v2 {
+		di.APIVersions = map[string]string{}
+		for ep := range apiVersions {
+			v, _ := c.apiVersion(ep)
+			di.APIVersions[string(ep)] = v
+		}
+	}
+	return di
+}
+
+// Var returns an expvar.Var reporting the DebugInfo of c, eg. for
+// expvar.Publish("actionscache", c.Var()).
+func (c *Cache) Var() expvar.Var {
+	return expvar.Func(func() interface{} {
+		return c.DebugInfo()
+	})
+}
+
+// DebugHandler serves the DebugInfo of c as JSON so daemons can mount it on
+// their debug mux.
+func (c *Cache) DebugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(c.DebugInfo())
+	})
+}
@@ -0,0 +1,33 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDebugHandler(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+
+	ctx := context.TODO()
+	dt := []byte("foobar")
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+
+	rec := httptest.NewRecorder()
+	c.DebugHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/actionscache", nil))
+	require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var di DebugInfo
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &di))
+	require.Equal(t, "v1", di.Protocol)
+	require.Equal(t, "6.0-preview.1", di.APIVersions["reserve"])
+	require.Len(t, di.Stats.Pending, 0)
+
+	var di2 DebugInfo
+	require.NoError(t, json.Unmarshal([]byte(c.Var().String()), &di2))
+	require.Equal(t, di, di2)
+}
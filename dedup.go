@@ -0,0 +1,197 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// aliasMagic starts the payload of an entry that points to another entry
+// with the same content.
+const aliasMagic = "actionscache-alias:v1\n"
+
+// dedupKeyPrefix is the key prefix of the entries mapping a payload digest
+// to the key it was saved under.
+const dedupKeyPrefix = "actionscache-dedup-"
+
+// maxAliasSize limits the payload read as an alias.
+const maxAliasSize = 4096
+
+// SaveDedup makes Save look up the digest of the payload in an index kept in
+// the cache. If another job already saved the same content under a
+// different key, only a small alias entry pointing at it is saved, along
+// with an entry marking it as an alias. Download of a marked alias
+// transparently downloads the entry it points to.
+func SaveDedup() SaveOpt {
+	return func(o *saveOpt) {
+		o.dedup = true
+	}
+}
+
+func noDedup(o *saveOpt) {
+	o.dedup = false
+}
+
+func (c *Cache) saveDedup(ctx context.Context, key string, ra io.ReaderAt, size int64, opts []SaveOpt) error {
+	opts = append(opts, noDedup)
+	digest, err := c.readerDigest(io.NewSectionReader(ra, 0, size))
+	if err != nil {
+		return err
+	}
+	idx := dedupKeyPrefix + strings.TrimPrefix(digest, "sha256:")
+	target, err := c.dedupTarget(ctx, idx)
+	if err != nil {
+		c.warn(ctx, "save cache: dedup lookup failed, saving", F("key", key), F("error", err))
+	}
+	if target != "" && target != key {
+		// the index outlives evicted entries, aliases must point to one that exists
+		te, err := c.load(ctx, []string{target}, nil)
+		switch {
+		case err != nil:
+			c.warn(ctx, "save cache: dedup target lookup failed, saving", F("key", key), F("target", target), F("error", err))
+		case te == nil || te.Key != target:
+			c.info(ctx, "save cache: dedup target no longer exists, saving", F("key", key), F("target", target))
+		default:
+			dt := []byte(aliasMagic + target)
+			if err := c.saveMarker(ctx, key, dt, opts); err != nil {
+				c.warn(ctx, "save cache: failed to mark alias, saving", F("key", key), F("target", target), F("error", err))
+				break
+			}
+			c.info(ctx, "save cache: content already saved, saving alias", F("key", key), F("target", target))
+			return c.save(ctx, key, bytes.NewReader(dt), int64(len(dt)), opts)
+		}
+	}
+	if err := c.save(ctx, key, ra, size, opts); err != nil {
+		return err
+	}
+	dt := []byte(key)
+	ictx, iopts := withoutSaveStats(ctx, append(opts, SaveIgnoreAlreadyExists()))
+	if err := c.save(ictx, idx, bytes.NewReader(dt), int64(len(dt)), iopts); err != nil {
+		c.warn(ctx, "save cache: failed to save dedup index", F("key", key), F("error", err))
+	}
+	return nil
+}
+
+// dedupTarget returns the key saved in the dedup index idx or "".
+func (c *Cache) dedupTarget(ctx context.Context, idx string) (string, error) {
+	ce, err := c.load(ctx, []string{idx}, nil)
+	if err != nil || ce == nil || ce.Key != idx {
+		return "", err
+	}
+	buf := &bytes.Buffer{}
+	if err := ce.Download(ctx, buf); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// downloadAlias downloads the entry target that ce is an alias of.
+func (ce *Entry) downloadAlias(ctx context.Context, target string, w io.Writer) error {
+	te, err := ce.loadAlias(ctx, target)
+	if err != nil {
+		return err
+	}
+	return te.Download(ctx, w)
+}
+
+// aliasWriter passes data through to w unless it is an alias payload or
+// lists the parts of a sharded entry, in which case target or shards is set
+// by finish. Payloads are only resolved if marked reports them.
+type aliasWriter struct {
+	w           io.Writer
+	marked      func(dt []byte) (bool, error)
+	buf         []byte
+	passthrough bool
+	target      string
+	shards      *shards
+}
+
+func (aw *aliasWriter) Write(p []byte) (int, error) {
+	if aw.passthrough {
+		return aw.w.Write(p)
+	}
+	aw.buf = append(aw.buf, p...)
+	if !(hasMagicPrefix(aw.buf, aliasMagic) || hasMagicPrefix(aw.buf, shardsMagic)) || len(aw.buf) > maxAliasSize {
+		if err := aw.flush(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (aw *aliasWriter) flush() error {
+	aw.passthrough = true
+	buf := aw.buf
+	aw.buf = nil
+	_, err := aw.w.Write(buf)
+	return err
+}
+
+func (aw *aliasWriter) finish() error {
+	if aw.passthrough {
+		return nil
+	}
+	target, isAlias := aliasTarget(aw.buf)
+	sh, isShards := parseShards(aw.buf)
+	if !isAlias && !isShards {
+		return aw.flush()
+	}
+	marked, err := aw.marked(aw.buf)
+	if err != nil {
+		return err
+	}
+	if !marked {
+		return aw.flush()
+	}
+	aw.target, aw.shards = target, sh
+	return nil
+}
+
+// hasMagicPrefix reports if dt starts with magic or with the start of it.
+func hasMagicPrefix(dt []byte, magic string) bool {
+	n := len(dt)
+	if n > len(magic) {
+		n = len(magic)
+	}
+	return string(dt[:n]) == magic[:n]
+}
+
+// aliasTarget returns the key that the payload dt is an alias of.
+func aliasTarget(dt []byte) (string, bool) {
+	if len(dt) > len(aliasMagic) && len(dt) <= maxAliasSize && strings.HasPrefix(string(dt), aliasMagic) {
+		return string(dt[len(aliasMagic):]), true
+	}
+	return "", false
+}
+
+// aliasError is returned by the range downloads of an alias entry or of a
+// sharded entry.
+type aliasError struct {
+	target string
+	shards *shards
+}
+
+func (e *aliasError) Error() string {
+	if e.shards != nil {
+		return "cache is sharded"
+	}
+	return "cache is an alias of " + e.target
+}
+
+// loadAlias returns the entry target that ce is an alias of.
+func (ce *Entry) loadAlias(ctx context.Context, target string) (*Entry, error) {
+	if ce.c == nil {
+		return nil, errors.Errorf("cache %s is an alias of %s that can not be loaded without a Cache", ce.Key, target)
+	}
+	te, err := ce.c.load(ctx, []string{target}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if te == nil || te.Key != target {
+		return nil, errors.Wrapf(ErrCacheNotFound, "cache %s is an alias of missing %s", ce.Key, target)
+	}
+	return te, nil
+}
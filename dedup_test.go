@@ -0,0 +1,108 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaveDedup(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+
+	ctx := context.TODO()
+	dt := bytes.Repeat([]byte("payload"), 100)
+	require.NoError(t, c.Save(ctx, "job-a", bytes.NewReader(dt), int64(len(dt)), SaveDedup()))
+	require.NoError(t, c.Save(ctx, "job-b", bytes.NewReader(dt), int64(len(dt)), SaveDedup()))
+	require.Less(t, len(ts.entry("job-b").Data), 100)
+
+	other := []byte("other")
+	require.NoError(t, c.Save(ctx, "job-c", bytes.NewReader(other), int64(len(other)), SaveDedup()))
+
+	for k, v := range map[string][]byte{"job-a": dt, "job-b": dt, "job-c": other} {
+		ce, err := c.Load(ctx, k)
+		require.NoError(t, err)
+		require.NotNil(t, ce)
+		buf := &bytes.Buffer{}
+		require.NoError(t, ce.Download(ctx, buf))
+		require.Equal(t, string(v), buf.String(), k)
+	}
+
+	// aliases are resolved by range downloads and random access too
+	ce, err := c.Load(ctx, "job-b")
+	require.NoError(t, err)
+	ba := &bufferAt{}
+	require.NoError(t, ce.DownloadAt(ctx, ba))
+	require.Equal(t, string(dt), string(ba.buf))
+	r := ce.ReaderAt(ctx)
+	size, err := r.Size()
+	require.NoError(t, err)
+	require.Equal(t, int64(len(dt)), size)
+	p := make([]byte, 7)
+	_, err = r.ReadAt(p, 7)
+	require.NoError(t, err)
+	require.Equal(t, "payload", string(p))
+
+	ce, err = c.Load(ctx, "job-c")
+	require.NoError(t, err)
+	ba = &bufferAt{}
+	require.NoError(t, ce.DownloadAt(ctx, ba))
+	require.Equal(t, "other", string(ba.buf))
+
+	ts.evict("job-a")
+	ce, err = c.Load(ctx, "job-b")
+	require.NoError(t, err)
+	require.ErrorIs(t, ce.Download(ctx, &bytes.Buffer{}), ErrCacheNotFound)
+	require.ErrorIs(t, ce.DownloadAt(ctx, &bufferAt{}), ErrCacheNotFound)
+
+	// the index still points at the evicted entry, the content is saved again
+	require.NoError(t, c.Save(ctx, "job-d", bytes.NewReader(dt), int64(len(dt)), SaveDedup()))
+	require.Equal(t, dt, ts.entry("job-d").Data)
+}
+
+func TestUnmarkedAlias(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+
+	ctx := context.TODO()
+	dt := []byte("payload")
+	require.NoError(t, c.Save(ctx, "target", bytes.NewReader(dt), int64(len(dt)), SaveDedup()))
+
+	// payloads not saved as an alias by SaveDedup are data
+	alias := []byte(aliasMagic + "target")
+	require.NoError(t, c.Save(ctx, "look-alike", bytes.NewReader(alias), int64(len(alias))))
+
+	ce, err := c.Load(ctx, "look-alike")
+	require.NoError(t, err)
+	buf := &bytes.Buffer{}
+	require.NoError(t, ce.Download(ctx, buf))
+	require.Equal(t, alias, buf.Bytes())
+	ba := &bufferAt{}
+	require.NoError(t, ce.DownloadAt(ctx, ba))
+	require.Equal(t, alias, ba.buf)
+	p := make([]byte, len(alias))
+	_, err = ce.ReaderAt(ctx).ReadAt(p, 0)
+	require.NoError(t, err)
+	require.Equal(t, alias, p)
+}
+
+func TestSaveDedupLongKey(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+
+	ctx := context.TODO()
+	dt := bytes.Repeat([]byte("payload"), 100)
+	key := strings.Repeat("k", MaxKeyLength-10)
+	require.NoError(t, c.Save(ctx, "target", bytes.NewReader(dt), int64(len(dt)), SaveDedup()))
+	require.NoError(t, c.Save(ctx, key, bytes.NewReader(dt), int64(len(dt)), SaveDedup()))
+	require.Less(t, len(ts.entry(key).Data), 100)
+
+	ce, err := c.Load(ctx, key)
+	require.NoError(t, err)
+	buf := &bytes.Buffer{}
+	require.NoError(t, ce.Download(ctx, buf))
+	require.Equal(t, dt, buf.Bytes())
+}
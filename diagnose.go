@@ -0,0 +1,212 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+var diagnoseEnv = []string{"ACTIONS_RUNTIME_TOKEN", "ACTIONS_CACHE_URL", "ACTIONS_RESULTS_URL", "ACTIONS_CACHE_SERVICE_V2"}
+
+// Diagnosis is a report about the cache client environment and connectivity.
+// It never contains the token itself so it is safe to include in issues.
+type Diagnosis struct {
+	Env             map[string]bool `json:"env"`
+	Claims          TokenClaims     `json:"claims"`
+	Protocol        string          `json:"protocol"`
+	URL             string          `json:"url"`
+	Reachable       bool            `json:"reachable"`
+	StatusCode      int             `json:"statusCode,omitempty"`
+	RTT             time.Duration   `json:"rtt"`
+	Error           string          `json:"error,omitempty"`
+	WritePermission bool            `json:"writePermission"`
+}
+
+// TokenClaims are the non-secret claims parsed from the runtime token.
+// Claims missing from the token are left empty.
+type TokenClaims struct {
+	Issuer    string    `json:"issuer,omitempty"`
+	Subject   string    `json:"subject,omitempty"`
+	Audience  []string  `json:"audience,omitempty"`
+	NotBefore time.Time `json:"notBefore,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+	Scopes    []Scope   `json:"scopes"`
+	// WorkflowRunBackendID and WorkflowJobRunBackendID identify the run and
+	// job the token was issued for, from the Actions.Results claim of
+	// runner tokens.
+	WorkflowRunBackendID    string `json:"workflowRunBackendId,omitempty"`
+	WorkflowJobRunBackendID string `json:"workflowJobRunBackendId,omitempty"`
+	// OrchestrationID is the orchid claim of runner tokens.
+	OrchestrationID string `json:"orchestrationId,omitempty"`
+	// BillingOwnerID is the billingOwnerId claim of runner tokens.
+	BillingOwnerID string `json:"billingOwnerId,omitempty"`
+	// RunID, Repository, RepositoryID and RepositoryOwner are set from the
+	// run_id, repository, repository_id and repository_owner claims that
+	// tokens of proxies and self-hosted services may carry. Runner tokens
+	// do not have them.
+	RunID           string `json:"runId,omitempty"`
+	Repository      string `json:"repository,omitempty"`
+	RepositoryID    string `json:"repositoryId,omitempty"`
+	RepositoryOwner string `json:"repositoryOwner,omitempty"`
+}
+
+func (d *Diagnosis) String() string {
+	dt, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err.Error()
+	}
+	return string(dt)
+}
+
+// Diagnose checks the environment, token and reachability of the cache
+// service. Failures to reach the service are reported in the Diagnosis
+// instead of being returned as an error.
+func (c *Cache) Diagnose(ctx context.Context) (*Diagnosis, error) {
+	d := &Diagnosis{
+		Env:      map[string]bool{},
+		Claims:   c.claims(),
+		Protocol: c.Protocol(),
+		URL:      c.URL,
+	}
+	for _, k := range diagnoseEnv {
+		_, ok := os.LookupEnv(k)
+		d.Env[k] = ok
+	}
+	d.WritePermission = c.writeScope() != ""
+
+	req, ep, err := c.diagnoseRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.debug(ctx, "diagnose", F("url", req.URL.String()))
+
+	start := c.clock().Now()
+	// single attempt without retries so RTT is meaningful
+	resp, err := c.send(ep, req)
+	d.RTT = c.clock().Now().Sub(start)
+	if err != nil {
+		d.Error = err.Error()
+		return d, nil
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	d.Reachable = true
+	d.StatusCode = resp.StatusCode
+	if resp.StatusCode >= 400 {
+		d.Error = resp.Status
+	}
+	return d, nil
+}
+
+// diagnoseRequest returns a lookup request for a key that does not exist.
+func (c *Cache) diagnoseRequest(ctx context.Context) (*http.Request, endpoint, error) {
+	key := "diagnose-" + randomID()
+	if c.v2 {
+		dt, err := json.Marshal(getCacheEntryDownloadURLRequest{Key: key, Version: c.version(key)})
+		if err != nil {
+			return nil, "", errors.WithStack(err)
+		}
+		req, err := http.NewRequest("POST", c.endpointURL(twirpCacheService+"GetCacheEntryDownloadURL"), bytes.NewReader(dt))
+		if err != nil {
+			return nil, "", errors.WithStack(err)
+		}
+		c.auth(req)
+		req.Header.Set("Content-Type", "application/json")
+		return req.WithContext(ctx), endpointTwirp, nil
+	}
+	req, err := http.NewRequest("GET", c.url("cache"), nil)
+	if err != nil {
+		return nil, "", errors.WithStack(err)
+	}
+	c.auth(req)
+	q := req.URL.Query()
+	q.Set("keys", key)
+	q.Set("version", c.version(key))
+	req.URL.RawQuery = q.Encode()
+	return req.WithContext(ctx), endpointLookup, nil
+}
+
+// Claims returns the non-secret claims of the current runtime token, eg. to
+// log the run a Cache belongs to or to refresh the token before it expires.
+func (c *Cache) Claims() TokenClaims {
+	return c.claims()
+}
+
+// Repository returns the repository of the runtime token as "owner/name",
+// or "" if the token does not name one. Runner tokens do not.
+func (c *Cache) Repository() string {
+	return c.claims().Repository
+}
+
+func (c *Cache) claims() TokenClaims {
+	tc := TokenClaims{Scopes: c.Scopes()}
+	tk := c.token()
+	if tk == nil {
+		return tc
+	}
+	claims, ok := tk.Claims.(jwt.MapClaims)
+	if !ok {
+		return tc
+	}
+	str := func(name string) string {
+		switch v := claims[name].(type) {
+		case string:
+			return v
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		}
+		return ""
+	}
+	tc.Issuer = str("iss")
+	tc.Subject = str("sub")
+	switch v := claims["aud"].(type) {
+	case string:
+		tc.Audience = []string{v}
+	case []interface{}:
+		for _, a := range v {
+			if s, ok := a.(string); ok {
+				tc.Audience = append(tc.Audience, s)
+			}
+		}
+	}
+	if v := str("Actions.Results"); v != "" {
+		parts := strings.SplitN(v, ":", 2)
+		tc.WorkflowRunBackendID = parts[0]
+		if len(parts) == 2 {
+			tc.WorkflowJobRunBackendID = parts[1]
+		}
+	}
+	tc.OrchestrationID = str("orchid")
+	tc.BillingOwnerID = str("billingOwnerId")
+	tc.RunID = str("run_id")
+	tc.Repository = str("repository")
+	tc.RepositoryID = str("repository_id")
+	tc.RepositoryOwner = str("repository_owner")
+	if v, ok := claims["nbf"].(float64); ok {
+		tc.NotBefore = time.Unix(int64(v), 0)
+	}
+	if v, ok := claims["exp"].(float64); ok {
+		tc.ExpiresAt = time.Unix(int64(v), 0)
+	}
+	return tc
+}
+
+func randomID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,36 @@
+package actionscache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiagnose(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/_apis/artifactcache/cache", r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	c, err := New(testToken(t, Scope{Scope: "refs/heads/main", Permission: PermissionRead | PermissionWrite}), ts.URL+"/")
+	require.NoError(t, err)
+
+	d, err := c.Diagnose(context.TODO())
+	require.NoError(t, err)
+	require.True(t, d.Reachable)
+	require.True(t, d.WritePermission)
+	require.Equal(t, http.StatusNoContent, d.StatusCode)
+	require.Equal(t, "vstoken.actions.githubusercontent.com", d.Claims.Issuer)
+	require.Equal(t, "v1", d.Protocol)
+	require.Len(t, d.Claims.Scopes, 1)
+
+	ts.Close()
+	d, err = c.Diagnose(context.TODO())
+	require.NoError(t, err)
+	require.False(t, d.Reachable)
+	require.NotEmpty(t, d.Error)
+}
@@ -0,0 +1,35 @@
+package actionscache
+
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+	"github.com/tonistiigi/go-actions-cache/archive"
+)
+
+// SaveDir saves the contents of the directory at path as a tar archive
+// under key. Regular files, directories and symlinks are stored with their
+// permissions and modification times, other file types are skipped.
+func (c *Cache) SaveDir(ctx context.Context, key, path string, opts ...SaveOpt) error {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(archive.Pack(pw, path))
+	}()
+	err := c.SaveReader(ctx, key, pr, opts...)
+	pr.CloseWithError(errors.New("save finished"))
+	return err
+}
+
+// RestoreDir downloads the archive of ce saved with SaveDir and extracts it
+// to the directory at path, creating it if needed. Symlinks are copied on
+// platforms where they can not be created, see archive.Unpack.
+func RestoreDir(ctx context.Context, ce *Entry, path string) error {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(ce.Download(ctx, pw))
+	}()
+	err := archive.Unpack(pr, path)
+	pr.CloseWithError(errors.New("restore finished"))
+	return err
+}
@@ -0,0 +1,57 @@
+package actionscache
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaveRestoreDir(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+
+	src := t.TempDir()
+	mtime := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "sub", "deep"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(src, "sub", "deep", "file"), []byte("data"), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(src, "exec"), []byte("#!/bin/sh"), 0755))
+	require.NoError(t, os.Symlink("sub/deep/file", filepath.Join(src, "link")))
+	require.NoError(t, os.Chtimes(filepath.Join(src, "exec"), mtime, mtime))
+	require.NoError(t, os.Chtimes(filepath.Join(src, "sub"), mtime, mtime))
+
+	ctx := context.TODO()
+	require.NoError(t, c.SaveDir(ctx, "dir", src))
+
+	ce, err := c.Load(ctx, "dir")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+
+	dst := filepath.Join(t.TempDir(), "restored")
+	require.NoError(t, RestoreDir(ctx, ce, dst))
+
+	dt, err := ioutil.ReadFile(filepath.Join(dst, "sub", "deep", "file"))
+	require.NoError(t, err)
+	require.Equal(t, "data", string(dt))
+
+	fi, err := os.Stat(filepath.Join(dst, "sub", "deep", "file"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	fi, err = os.Stat(filepath.Join(dst, "exec"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0755), fi.Mode().Perm())
+	require.True(t, mtime.Equal(fi.ModTime()))
+
+	fi, err = os.Stat(filepath.Join(dst, "sub"))
+	require.NoError(t, err)
+	require.True(t, mtime.Equal(fi.ModTime()))
+
+	link, err := os.Readlink(filepath.Join(dst, "link"))
+	require.NoError(t, err)
+	require.Equal(t, "sub/deep/file", link)
+}
@@ -0,0 +1,19 @@
+// Package actionscache is a client for the GitHub Actions cache service.
+//
+// Cache saves and loads entries through the runtime cache service, both the
+// v1 API and the v2 results API. RestAPI lists and deletes entries through
+// the GitHub REST API, it is an alias of restapi.Client kept for existing
+// callers, like the errors shared by both clients.
+//
+// A Cache is safe for concurrent use by multiple goroutines, which may save
+// and load entries in parallel. Its fields must not be changed once it is in
+// use. The package variables are the defaults of every Cache and should only
+// be set during initialization.
+//
+// The other parts of the module are importable on their own:
+//
+//	restapi           client for the cache endpoints of the GitHub REST API
+//	archive           packs directories into the tar archives of SaveDir
+//	actionscachetest  in-memory cache service for tests without a runner
+//	cmd/gha-cache     command line tool for saving and restoring in shell steps
+package actionscache
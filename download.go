@@ -0,0 +1,482 @@
+package actionscache
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// Downloader fetches the archive of a cache entry from its archiveLocation
+// URL, eg. with a segmented downloader or through a caching proxy.
+type Downloader interface {
+	Download(ctx context.Context, url string, w io.Writer) error
+}
+
+// DownloaderFunc adapts a function to the Downloader interface.
+type DownloaderFunc func(ctx context.Context, url string, w io.Writer) error
+
+func (f DownloaderFunc) Download(ctx context.Context, url string, w io.Writer) error {
+	return f(ctx, url, w)
+}
+
+type httpDownloader struct {
+	c *Cache
+}
+
+// DownloadResumeAttempts is the default number of times a download that was
+// interrupted while receiving data is resumed from the received offset.
+var DownloadResumeAttempts = 3
+
+// Download fetches url into w. Transfers interrupted while reading the
+// response are resumed with a range request for the missing data, as are
+// transfers whose URL is rejected if ctx has a function to refresh it.
+func (d httpDownloader) Download(ctx context.Context, url string, w io.Writer) error {
+	cw := &countWriter{w: w}
+	refresh := urlRefresherFromContext(ctx)
+	refreshes := 0
+	for attempt := 1; ; attempt++ {
+		err := d.get(ctx, url, cw)
+		if refresh != nil && isURLRejected(ctx, err) && refreshes < d.c.downloadResumeAttempts() {
+			refreshes++
+			d.c.warn(ctx, "download URL rejected, refreshing", F("offset", cw.n), F("error", err))
+			if url, err = refresh(ctx); err != nil {
+				return err
+			}
+			continue
+		}
+		var re *readError
+		if err == nil || !errors.As(err, &re) || ctx.Err() != nil || attempt > d.c.downloadResumeAttempts() {
+			return err
+		}
+		d.c.warn(ctx, "download interrupted, resuming", F("offset", cw.n), F("attempt", attempt), F("error", re.err))
+	}
+}
+
+// get writes the data of url from the offset of w to w. Failures reading the
+// response are returned as readError.
+func (d httpDownloader) get(ctx context.Context, url string, w *countWriter) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if w.n > 0 {
+		setRange(req, url, w.n, -1, false)
+	}
+	resp, err := d.c.sendDownload(req.WithContext(ctx))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+	if err := d.c.checkResponse(resp); err != nil {
+		return errors.Wrap(err, "failed to download cache")
+	}
+	if w.n > 0 && resp.StatusCode != http.StatusPartialContent {
+		// ranges not supported, skip the data already written
+		if _, err := io.CopyN(ioutil.Discard, resp.Body, w.n); err != nil {
+			return errors.WithStack(&readError{err: err})
+		}
+	}
+	var body io.Reader = resp.Body
+	var mb *md5Body
+	if w.n == 0 && resp.StatusCode == http.StatusOK {
+		mb = newMD5Body(resp)
+		body = mb
+	}
+	_, err = io.Copy(w, readerFunc(func(p []byte) (int, error) {
+		n, err := body.Read(p)
+		if err != nil && err != io.EOF {
+			err = &readError{err: err}
+		}
+		return n, err
+	}))
+	if err == nil && mb != nil {
+		err = mb.check("cache blob")
+	}
+	return errors.WithStack(err)
+}
+
+// readError is a failure reading a download response after some of it may
+// have been written.
+type readError struct {
+	err error
+}
+
+func (e *readError) Error() string {
+	return e.err.Error()
+}
+
+func (e *readError) Unwrap() error {
+	return e.err
+}
+
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) {
+	return f(p)
+}
+
+// DownloadConcurrency is the default number of parallel range requests of
+// Entry.DownloadAt.
+var DownloadConcurrency = 4
+
+// DownloadChunkSize is the default size of the range requests of
+// Entry.DownloadAt.
+var DownloadChunkSize = 32 * 1024 * 1024
+
+// DownloadAt downloads the archive into w with up to DownloadConcurrency
+// parallel range requests of DownloadChunkSize. Servers that do not support
+// ranges are read sequentially. With a custom Downloader or Backend the data
+// is written sequentially from offset 0, as is the payload of compressed and
+// encrypted entries and of entries whose checksum is verified.
+func (ce *Entry) DownloadAt(ctx context.Context, w io.WriterAt) error {
+	ctx = ce.withVersion(ctx)
+	ctx, done := ce.c.startOp(ctx, "download", ce.Key)
+	cw := &countWriterAt{w: w}
+	err := ce.downloadAtLocal(ctx, cw, ce.downloadAtWithTimeouts)
+	return done(atomic.LoadInt64(&cw.n), false, err)
+}
+
+func (ce *Entry) downloadAtWithTimeouts(ctx context.Context, w io.WriterAt) error {
+	if !ce.enc.plain() || (ce.c != nil && (ce.c.Downloader != nil || ce.c.Backend != nil || ce.c.VerifyChecksums)) {
+		return ce.Download(ctx, &offsetWriter{w: w})
+	}
+	if err := ce.checkExpiry(ctx); err != nil {
+		return err
+	}
+	ce.c.debug(ctx, "download cache with ranges", F("key", ce.Key))
+	pctx := ce.c.withProgress(ctx, "download", ce.Key, ce.progressSize())
+	defer ce.c.tickProgress(pctx)()
+	var err error
+	if ce.c == nil {
+		err = ce.downloadAt(pctx, w)
+	} else {
+		err = ce.c.withTimeouts(pctx, false, func(ctx context.Context) error {
+			return ce.downloadAt(ctx, w)
+		})
+	}
+	var ae *aliasError
+	if !errors.As(err, &ae) {
+		return err
+	}
+	if ae.shards != nil {
+		// the digests of the parts are verified in order
+		return ce.downloadShards(ctx, ae.shards, &offsetWriter{w: w})
+	}
+	te, err := ce.loadAlias(ctx, ae.target)
+	if err != nil {
+		return err
+	}
+	return te.downloadAtWithTimeouts(ctx, w)
+}
+
+// writeSmall writes a payload of ce small enough to be an alias to w, or
+// returns an aliasError if it is one.
+func (ce *Entry) writeSmall(ctx context.Context, w io.WriterAt, r io.Reader, n int64) error {
+	dt, err := ioutil.ReadAll(io.LimitReader(r, n))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if int64(len(dt)) != n {
+		return errors.Errorf("short read for cache: %d of %d bytes", len(dt), n)
+	}
+	target, isAlias := aliasTarget(dt)
+	sh, isShards := parseShards(dt)
+	if isAlias || isShards {
+		marked, err := ce.marked(ctx, dt)
+		if err != nil {
+			return err
+		}
+		if marked {
+			return &aliasError{target: target, shards: sh}
+		}
+	}
+	_, err = w.WriteAt(dt, 0)
+	return errors.WithStack(err)
+}
+
+func (ce *Entry) downloadAt(ctx context.Context, w io.WriterAt) error {
+	chunk := int64(ce.c.downloadChunkSize())
+	if ce.c.rangeMD5(ce.URL) && chunk > blobRangeMD5Limit {
+		chunk = blobRangeMD5Limit
+	}
+	var mu sync.Mutex
+	u := ce.URL
+	// the URL is refreshed once for all ranges rejected with the same URL
+	getRange := func(ctx context.Context, off, n int64) (*http.Response, error) {
+		mu.Lock()
+		cur := u
+		mu.Unlock()
+		resp, err := ce.getRangeURL(ctx, cur, off, n)
+		if err != nil || resp.StatusCode != http.StatusForbidden || ce.urlRefresher() == nil || ctx.Err() != nil {
+			return resp, err
+		}
+		resp.Body.Close()
+		mu.Lock()
+		if u == cur {
+			ce.c.warn(ctx, "download URL rejected, refreshing", F("offset", off))
+			nu, err := ce.freshURL(ctx)
+			if err != nil {
+				mu.Unlock()
+				return nil, err
+			}
+			u = nu
+		}
+		cur = u
+		mu.Unlock()
+		return ce.getRangeURL(ctx, cur, off, n)
+	}
+	defer func() {
+		ce.URL = u
+	}()
+	resp, err := getRange(ctx, 0, chunk)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// ranges not supported
+		setProgressSize(ctx, resp.ContentLength)
+		if resp.ContentLength >= 0 && resp.ContentLength <= maxAliasSize {
+			return ce.writeSmall(ctx, w, resp.Body, resp.ContentLength)
+		}
+		_, err := io.Copy(&progressWriter{ctx: ctx, w: &offsetWriter{w: w}}, resp.Body)
+		return errors.WithStack(err)
+	case http.StatusRequestedRangeNotSatisfiable:
+		// empty archive
+		return nil
+	case http.StatusPartialContent:
+	default:
+		return errors.Errorf("failed to download cache: %s", resp.Status)
+	}
+	size, err := contentRangeSize(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return err
+	}
+	setProgressSize(ctx, size)
+	if size <= maxAliasSize && size <= chunk {
+		mb := newMD5Body(resp)
+		if err := ce.writeSmall(ctx, w, mb, size); err != nil {
+			return err
+		}
+		return mb.check(fmt.Sprintf("cache %s range 0-%d", ce.Key, size-1))
+	}
+	if err := ce.copyRange(w, resp, 0, minInt64(chunk, size)); err != nil {
+		return err
+	}
+	reportProgress(ctx, minInt64(chunk, size))
+	resp.Body.Close()
+
+	offset := chunk
+	eg, ctx := errgroup.WithContext(ctx)
+	for i := 0; i < ce.c.downloadConcurrency(); i++ {
+		eg.Go(func() error {
+			for {
+				mu.Lock()
+				start := offset
+				offset += chunk
+				mu.Unlock()
+				if start >= size {
+					return nil
+				}
+				n := minInt64(chunk, size-start)
+				resp, err := getRange(ctx, start, n)
+				if err != nil {
+					return err
+				}
+				if resp.StatusCode != http.StatusPartialContent {
+					resp.Body.Close()
+					return errors.Errorf("failed to download cache range %d-%d: %s", start, start+n-1, resp.Status)
+				}
+				err = ce.copyRange(w, resp, start, n)
+				resp.Body.Close()
+				if err != nil {
+					return err
+				}
+				reportProgress(ctx, n)
+			}
+		})
+	}
+	return eg.Wait()
+}
+
+func (ce *Entry) getRange(ctx context.Context, off, n int64) (*http.Response, error) {
+	return ce.getRangeURL(ctx, ce.URL, off, n)
+}
+
+func (ce *Entry) getRangeURL(ctx context.Context, u string, off, n int64) (*http.Response, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	setRange(req, u, off, off+n-1, ce.c.rangeMD5(u))
+	resp, err := ce.c.sendDownload(req.WithContext(ctx))
+	return resp, errors.WithStack(err)
+}
+
+// copyRange writes the n bytes at off of a range response to w, checking
+// them against the MD5 Azure returned for the range.
+func (ce *Entry) copyRange(w io.WriterAt, resp *http.Response, off, n int64) error {
+	mb := newMD5Body(resp)
+	if err := copyRange(w, mb, off, n); err != nil {
+		return err
+	}
+	return mb.check(fmt.Sprintf("cache %s range %d-%d", ce.Key, off, off+n-1))
+}
+
+// rangeMD5 reports if range downloads of u are checked with the MD5 that
+// Azure computes for ranges of up to 4 MiB. It is done for Azure Blob SAS
+// URLs when the Cache sends chunk checksums.
+func (c *Cache) rangeMD5(u string) bool {
+	return c != nil && c.ChunkMD5 && isBlobSASURL(u)
+}
+
+// DownloadIdleTimeout is the default time a download may receive no data
+// before it is aborted with ErrDownloadStalled. Zero disables it.
+var DownloadIdleTimeout time.Duration
+
+// sendDownload sends a download request. Reading the response body fails
+// once the context of req is done or no data arrived for the idle timeout.
+func (c *Cache) sendDownload(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	req = req.WithContext(withEndpoint(ctx, endpointDownload))
+	var resp *http.Response
+	var err error
+	if c != nil {
+		resp, err = c.doRetry(req, c.doHTTP)
+	} else {
+		resp, err = c.doHTTP(req)
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	b := &idleBody{ctx: ctx, body: resp.Body, timeout: c.downloadIdleTimeout(), clock: c.clock(), cancel: cancel}
+	if b.timeout > 0 {
+		b.touch()
+		go b.watch()
+	}
+	resp.Body = b
+	return resp, nil
+}
+
+// idleBody is the body of a download response that cancels the request if
+// no data arrives for timeout.
+type idleBody struct {
+	ctx     context.Context
+	body    io.ReadCloser
+	timeout time.Duration
+	clock   Clock
+	cancel  func()
+	stalled int32
+	// last is the time data last arrived in UnixNano
+	last int64
+}
+
+func (b *idleBody) touch() {
+	atomic.StoreInt64(&b.last, b.clock.Now().UnixNano())
+}
+
+// watch cancels the request once no data arrived for timeout. It returns
+// when the request context is done.
+func (b *idleBody) watch() {
+	d := b.timeout
+	for {
+		if err := b.clock.Sleep(b.ctx, d); err != nil {
+			return
+		}
+		idle := b.clock.Now().Sub(time.Unix(0, atomic.LoadInt64(&b.last)))
+		if idle >= b.timeout {
+			b.stall()
+			return
+		}
+		d = b.timeout - idle
+	}
+}
+
+func (b *idleBody) stall() {
+	atomic.StoreInt32(&b.stalled, 1)
+	b.cancel()
+}
+
+func (b *idleBody) Read(p []byte) (int, error) {
+	if err := b.ctx.Err(); err != nil {
+		return 0, b.err(err)
+	}
+	n, err := b.body.Read(p)
+	if n > 0 && b.timeout > 0 {
+		b.touch()
+	}
+	if err != nil && err != io.EOF {
+		err = b.err(err)
+	}
+	return n, err
+}
+
+func (b *idleBody) err(err error) error {
+	if atomic.LoadInt32(&b.stalled) == 1 {
+		return errors.Wrapf(ErrDownloadStalled, "no data received for %v", b.timeout)
+	}
+	return err
+}
+
+func (b *idleBody) Close() error {
+	err := b.body.Close()
+	b.cancel()
+	return err
+}
+
+// copyRange writes exactly n bytes from r to w at off.
+func copyRange(w io.WriterAt, r io.Reader, off, n int64) error {
+	copied, err := io.Copy(&offsetWriter{w: w, off: off}, io.LimitReader(r, n))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if copied != n {
+		return errors.Errorf("short read for cache range %d-%d: %d bytes", off, off+n-1, copied)
+	}
+	return nil
+}
+
+// contentRangeSize returns the complete length from a Content-Range value
+// like "bytes 0-99/1000".
+func contentRangeSize(s string) (int64, error) {
+	i := strings.LastIndexByte(s, '/')
+	if i < 0 {
+		return 0, errors.Errorf("invalid Content-Range %q", s)
+	}
+	size, err := strconv.ParseInt(s[i+1:], 10, 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid Content-Range %q", s)
+	}
+	return size, nil
+}
+
+// offsetWriter adapts an io.WriterAt to sequential writes starting at off.
+type offsetWriter struct {
+	w   io.WriterAt
+	off int64
+}
+
+func (ow *offsetWriter) Write(p []byte) (int, error) {
+	n, err := ow.w.WriteAt(p, ow.off)
+	ow.off += int64(n)
+	return n, err
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,241 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloader(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+
+	ctx := context.TODO()
+	dt := []byte("foobar")
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+
+	var urls []string
+	c.Downloader = DownloaderFunc(func(ctx context.Context, url string, w io.Writer) error {
+		urls = append(urls, url)
+		_, err := w.Write([]byte("external"))
+		return err
+	})
+
+	ce, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	buf := &bytes.Buffer{}
+	require.NoError(t, ce.Download(ctx, buf))
+	require.Equal(t, "external", buf.String())
+	require.Equal(t, []string{ce.URL}, urls)
+
+	ce.URL = ts.URL + "/blob/missing"
+	c.Downloader = nil
+	require.Error(t, ce.Download(ctx, buf))
+}
+
+type bufferAt struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (b *bufferAt) WriteAt(p []byte, off int64) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if end := off + int64(len(p)); end > int64(len(b.buf)) {
+		b.buf = append(b.buf, make([]byte, end-int64(len(b.buf)))...)
+	}
+	copy(b.buf[off:], p)
+	return len(p), nil
+}
+
+func TestDownloadAt(t *testing.T) {
+	defer func(v int) { DownloadChunkSize = v }(DownloadChunkSize)
+	DownloadChunkSize = 7
+
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+
+	ctx := context.TODO()
+	dt := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+	require.NoError(t, c.Save(ctx, "empty", bytes.NewReader(nil), 0))
+
+	ce, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	buf := &bufferAt{}
+	require.NoError(t, ce.DownloadAt(ctx, buf))
+	require.Equal(t, string(dt), string(buf.buf))
+	require.Equal(t, 6, ts.count("GET /blob/foo"))
+
+	ts.noRanges = true
+	buf = &bufferAt{}
+	require.NoError(t, ce.DownloadAt(ctx, buf))
+	require.Equal(t, string(dt), string(buf.buf))
+	ts.noRanges = false
+
+	ce, err = c.Load(ctx, "empty")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	buf = &bufferAt{}
+	require.NoError(t, ce.DownloadAt(ctx, buf))
+	require.Len(t, buf.buf, 0)
+}
+
+func TestDownloadIdleTimeout(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	WithDownloadIdleTimeout(50 * time.Millisecond)(c)
+
+	ctx := context.TODO()
+	dt := []byte("foobar")
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+	ce, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+
+	release := make(chan struct{})
+	defer close(release)
+	h := ts.Config.Handler
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/blob/") {
+			h.ServeHTTP(w, r)
+			return
+		}
+		// send part of the data and hang
+		w.Header().Set("Content-Length", strconv.Itoa(len(dt)))
+		w.Write(dt[:3])
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	})
+
+	buf := &bytes.Buffer{}
+	err = ce.Download(ctx, buf)
+	require.ErrorIs(t, err, ErrDownloadStalled)
+	require.Equal(t, "foo", buf.String())
+	require.ErrorIs(t, ce.DownloadAt(ctx, &bufferAt{}), ErrDownloadStalled)
+
+	c.DownloadIdleTimeout = 0
+	cctx, cancel := context.WithCancel(ctx)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	require.ErrorIs(t, ce.Download(cctx, &bytes.Buffer{}), context.Canceled)
+}
+
+func TestDownloadResume(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+
+	ctx := context.TODO()
+	dt := []byte("0123456789abcdefghij")
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+	ce, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+
+	var ranges []string
+	interrupts := 0
+	h := ts.Config.Handler
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/blob/") || interrupts >= 2 {
+			ranges = append(ranges, r.Header.Get("Range"))
+			h.ServeHTTP(w, r)
+			return
+		}
+		interrupts++
+		ranges = append(ranges, r.Header.Get("Range"))
+		// send part of the data and drop the connection
+		w.Header().Set("Content-Length", strconv.Itoa(len(dt)))
+		w.Write(dt[:5])
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	})
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, ce.Download(ctx, buf))
+	require.Equal(t, string(dt), buf.String())
+	// the second response ignores the range and is skipped forward
+	require.Equal(t, []string{"", "bytes=5-", "bytes=5-"}, ranges)
+
+	interrupts, ranges = 0, nil
+	ts.noRanges = true
+	buf.Reset()
+	require.NoError(t, ce.Download(ctx, buf))
+	require.Equal(t, string(dt), buf.String())
+
+	interrupts, ranges = 0, nil
+	WithDownloadResumeAttempts(-1)(c)
+	require.Error(t, ce.Download(ctx, &bytes.Buffer{}))
+	require.Len(t, ranges, 1)
+}
+
+// md5Transport replaces the Content-MD5 of responses.
+type md5Transport struct {
+	md5 string
+}
+
+func (t *md5Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err == nil && resp.Header.Get("Content-MD5") != "" {
+		resp.Header.Set("Content-MD5", t.md5)
+	}
+	return resp, err
+}
+
+func TestDownloadBlobSAS(t *testing.T) {
+	defer func(v int) { DownloadChunkSize = v }(DownloadChunkSize)
+	DownloadChunkSize = 7
+
+	ts := newTestServer(t)
+	ts.sasDownloads = true
+	c := ts.newCache(t)
+	WithChunkMD5()(c)
+
+	ctx := context.TODO()
+	dt := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+
+	var mu sync.Mutex
+	var rangeMD5 int
+	ts.verify = func(r *http.Request, _ []byte) {
+		if r.Header.Get("x-ms-range-get-content-md5") == "true" {
+			mu.Lock()
+			rangeMD5++
+			mu.Unlock()
+		}
+	}
+
+	ce, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	buf := &bufferAt{}
+	require.NoError(t, ce.DownloadAt(ctx, buf))
+	require.Equal(t, string(dt), string(buf.buf))
+	require.Equal(t, 6, ts.count("GET /blob/foo"))
+	require.Equal(t, 6, rangeMD5)
+
+	b := &bytes.Buffer{}
+	require.NoError(t, ce.Download(ctx, b))
+	require.Equal(t, string(dt), b.String())
+
+	WithHTTPClient(&http.Client{Transport: &md5Transport{md5: "AAAAAAAAAAAAAAAAAAAAAA=="}})(c)
+	require.ErrorIs(t, ce.DownloadAt(ctx, &bufferAt{}), ErrChecksumMismatch)
+	require.ErrorIs(t, ce.Download(ctx, &bytes.Buffer{}), ErrChecksumMismatch)
+
+	// without chunk checksums only whole blobs are checked
+	c.ChunkMD5 = false
+	require.NoError(t, ce.DownloadAt(ctx, &bufferAt{}))
+	require.ErrorIs(t, ce.Download(ctx, &bytes.Buffer{}), ErrChecksumMismatch)
+}
@@ -0,0 +1,67 @@
+package actionscache
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// ErrReadOnly is matched by errors of writes to a read-only Cache.
+var ErrReadOnly = errors.New("cache is read-only")
+
+// ReadOnlyError is returned by operations that would change a Cache created
+// with WithReadOnly.
+type ReadOnlyError struct {
+	Op string
+}
+
+func (e *ReadOnlyError) Error() string {
+	return fmt.Sprintf("cache %s refused: cache is read-only", e.Op)
+}
+
+func (e *ReadOnlyError) Is(target error) bool {
+	return target == ErrReadOnly
+}
+
+// WithDryRun makes saves validate their keys and options and log the key,
+// version, size and chunk plan of the upload instead of uploading. Loads
+// still read from the cache.
+func WithDryRun() Opt {
+	return func(c *Cache) {
+		c.DryRun = true
+	}
+}
+
+// WithReadOnly makes saves and Abandon fail with a *ReadOnlyError, eg. for
+// pull requests from forks that only get read tokens.
+func WithReadOnly() Opt {
+	return func(c *Cache) {
+		c.ReadOnly = true
+	}
+}
+
+// logDryRun logs the upload a save of key would make. size is negative for
+// streaming saves.
+func (c *Cache) logDryRun(ctx context.Context, key string, size int64, so *saveOpt) {
+	fields := []Field{F("key", key), F("version", c.keyVersion(ctx, key))}
+	if size >= 0 {
+		fields = append(fields, F("size", size))
+	}
+	if so.scope != "" {
+		fields = append(fields, F("scope", so.scope))
+	}
+	switch {
+	case c.v2:
+		fields = append(fields, F("service", "v2"))
+	case c.SingleChunkUploads:
+		fields = append(fields, F("chunks", 1))
+	default:
+		chunkSize := int64(c.uploadChunkSize())
+		fields = append(fields, F("chunkSize", chunkSize), F("concurrency", c.uploadConcurrency()))
+		if size >= 0 {
+			fields = append(fields, F("chunks", (size+chunkSize-1)/chunkSize))
+		}
+	}
+	c.info(ctx, "save cache: dry run, not uploading", fields...)
+}
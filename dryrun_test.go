@@ -0,0 +1,62 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDryRun(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	l := &recordingLogger{}
+	c.Logger = l
+	c.UploadChunkSize = 4
+	WithDryRun()(c)
+
+	ctx := context.TODO()
+	dt := []byte("0123456789")
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+	e := l.find("save cache: dry run, not uploading")
+	require.NotNil(t, e)
+	require.Equal(t, "foo", e.fields["key"])
+	require.Equal(t, c.version("foo"), e.fields["version"])
+	require.Equal(t, int64(10), e.fields["size"])
+	require.Equal(t, int64(3), e.fields["chunks"])
+
+	w, err := c.SaveWriter(ctx, "bar")
+	require.NoError(t, err)
+	_, err = w.Write(dt)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	require.ErrorIs(t, c.Save(ctx, "", bytes.NewReader(dt), int64(len(dt))), ErrInvalidKey)
+	require.Equal(t, 0, ts.count("POST "))
+	require.Equal(t, 0, ts.count("PATCH "))
+	require.Nil(t, ts.entry("foo"))
+}
+
+func TestReadOnly(t *testing.T) {
+	ts := newTestServer(t)
+	ctx := context.TODO()
+	dt := []byte("foobar")
+	require.NoError(t, ts.newCache(t).Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+
+	c := ts.newCache(t)
+	WithReadOnly()(c)
+	err := c.Save(ctx, "bar", bytes.NewReader(dt), int64(len(dt)))
+	require.ErrorIs(t, err, ErrReadOnly)
+	var roe *ReadOnlyError
+	require.ErrorAs(t, err, &roe)
+	require.Equal(t, "save", roe.Op)
+	_, err = c.SaveWriter(ctx, "bar")
+	require.ErrorIs(t, err, ErrReadOnly)
+	require.ErrorIs(t, c.SaveReader(ctx, "bar", bytes.NewReader(dt)), ErrReadOnly)
+	require.ErrorIs(t, c.Abandon(ctx, ReservationInfo{ID: 1, Key: "bar"}), ErrReadOnly)
+
+	ce, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+}
@@ -0,0 +1,54 @@
+package actionscache
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// ErrEmptyEntryRejected is matched by errors of saves of empty payloads
+// that the service or Backend refused to commit.
+var ErrEmptyEntryRejected = errors.New("empty cache entry rejected")
+
+// EmptyEntryError is returned when committing an empty payload fails with
+// a client error, eg. because the service does not accept entries of size
+// 0. Callers can save a placeholder instead or skip the entry.
+type EmptyEntryError struct {
+	Key string
+	Err error
+}
+
+func (e *EmptyEntryError) Error() string {
+	return fmt.Sprintf("empty cache %s rejected: %v", e.Key, e.Err)
+}
+
+func (e *EmptyEntryError) Is(target error) bool {
+	return target == ErrEmptyEntryRejected
+}
+
+func (e *EmptyEntryError) Unwrap() error {
+	return e.Err
+}
+
+// emptyRejected returns err as an *EmptyEntryError if it is the failure to
+// commit an empty entry of key with a client error other than
+// authorization, conflicts and rate limiting. Other errors are returned
+// as they are.
+func emptyRejected(key string, size int64, err error) error {
+	if err == nil || size != 0 {
+		return err
+	}
+	var ae *GithubAPIError
+	if !errors.As(err, &ae) {
+		return err
+	}
+	switch ae.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusConflict, http.StatusTooManyRequests:
+		return err
+	}
+	if ae.StatusCode < 400 || ae.StatusCode >= 500 {
+		return err
+	}
+	return errors.WithStack(&EmptyEntryError{Key: key, Err: err})
+}
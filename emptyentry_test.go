@@ -0,0 +1,99 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmptyEntry(t *testing.T) {
+	ts := newTestServer(t)
+	ctx := context.TODO()
+
+	var chunked []string
+	ts.verify = func(r *http.Request, _ []byte) {
+		if len(r.TransferEncoding) > 0 {
+			chunked = append(chunked, r.Method+" "+r.URL.Path)
+		}
+	}
+
+	for _, v2 := range []bool{false, true} {
+		c := ts.newCache(t)
+		key := "empty-v1"
+		if v2 {
+			c = ts.newCacheV2(t)
+			key = "empty-v2"
+		}
+		patches := ts.count("PATCH ")
+		require.NoError(t, c.Save(ctx, key, bytes.NewReader(nil), 0))
+		require.Equal(t, patches, ts.count("PATCH "), "no chunks are uploaded")
+
+		ce, err := c.LoadWithOpts(ctx, []string{key}, LoadStat())
+		require.NoError(t, err)
+		require.NotNil(t, ce)
+		require.True(t, ce.Exact)
+		require.Equal(t, int64(0), ce.Size)
+		var buf bytes.Buffer
+		require.NoError(t, ce.Download(ctx, &buf))
+		require.Equal(t, 0, buf.Len())
+		at := &bufferAt{}
+		require.NoError(t, ce.DownloadAt(ctx, at))
+		require.Len(t, at.buf, 0)
+	}
+	require.Empty(t, chunked)
+
+	c := ts.newCache(t)
+	w, err := c.SaveWriter(ctx, "empty-writer")
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	ce, err := c.Load(ctx, "empty-writer")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+}
+
+func TestEmptyEntryRejected(t *testing.T) {
+	ts := newTestServer(t)
+	h := ts.Config.Handler
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" && strings.Contains(r.URL.Path, "/caches/") {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"$id":"1","innerException":null,"message":"Cache size of ~0 MB (0 B) is not allowed.","typeName":"System.ArgumentException","typeKey":"ArgumentException","errorCode":0,"eventId":3000}`))
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/FinalizeCacheEntryUpload") {
+			w.Write([]byte(`{"ok":false}`))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+	ctx := context.TODO()
+
+	c := ts.newCache(t)
+	c.RetryPolicy = &RetryPolicy{MaxAttempts: 1}
+	err := c.Save(ctx, "empty", bytes.NewReader(nil), 0)
+	require.ErrorIs(t, err, ErrEmptyEntryRejected)
+	var ee *EmptyEntryError
+	require.True(t, errors.As(err, &ee))
+	require.Equal(t, "empty", ee.Key)
+	var ae *GithubAPIError
+	require.True(t, errors.As(err, &ae))
+	require.Equal(t, http.StatusBadRequest, ae.StatusCode)
+
+	w, err := c.SaveWriter(ctx, "empty-writer")
+	require.NoError(t, err)
+	require.ErrorIs(t, w.Close(), ErrEmptyEntryRejected)
+
+	dt := []byte("foobar")
+	err = c.Save(ctx, "full", bytes.NewReader(dt), int64(len(dt)))
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrEmptyEntryRejected)
+
+	c = ts.newCacheV2(t)
+	require.ErrorIs(t, c.Save(ctx, "empty-v2", bytes.NewReader(nil), 0), ErrEmptyEntryRejected)
+	require.NotErrorIs(t, c.Save(ctx, "full-v2", bytes.NewReader(dt), int64(len(dt))), ErrEmptyEntryRejected)
+}
@@ -0,0 +1,198 @@
+package actionscache
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// encryptionSegment is the size of the plaintext segments sealed separately
+// so that payloads can be encrypted and decrypted as streams.
+const encryptionSegment = 64 * 1024
+
+// WithEncryptionKey encrypts payloads with AES-GCM before they are uploaded
+// and decrypts them on download. key must be 16, 24 or 32 bytes. A
+// fingerprint of the key is part of the cache version so that entries
+// encrypted with other keys are not found by Load.
+func WithEncryptionKey(key []byte) Opt {
+	return func(c *Cache) {
+		c.EncryptionKey = append([]byte(nil), key...)
+	}
+}
+
+func (c *Cache) encryptionKey() []byte {
+	if c == nil || len(c.EncryptionKey) == 0 {
+		return nil
+	}
+	return c.EncryptionKey
+}
+
+// keyFingerprint identifies key in cache versions without revealing it.
+func keyFingerprint(key []byte) string {
+	h := sha256.New()
+	h.Write([]byte("go-actionscache-encryption|"))
+	h.Write(key)
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid encryption key")
+	}
+	aead, err := cipher.NewGCM(b)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return aead, nil
+}
+
+// segmentNonce returns the nonce of segment n. The random prefix is written
+// at the start of the payload, the last byte marks the final segment so
+// that truncated payloads are detected.
+func segmentNonce(prefix []byte, n uint32, final bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[7:], n)
+	if final {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// encryptWriter seals data written to it in segments into w.
+type encryptWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	prefix []byte
+	n      uint32
+	buf    []byte
+}
+
+func newEncryptWriter(key []byte, w io.Writer) (*encryptWriter, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, 7)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if _, err := w.Write(prefix); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{w: w, aead: aead, prefix: prefix, buf: make([]byte, 0, encryptionSegment+1)}, nil
+}
+
+func (ew *encryptWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		// a full segment is only sealed once more data follows, the last
+		// one is sealed as final on Close
+		if len(ew.buf) == encryptionSegment {
+			if err := ew.seal(false); err != nil {
+				return n, err
+			}
+		}
+		l := encryptionSegment - len(ew.buf)
+		if l > len(p) {
+			l = len(p)
+		}
+		ew.buf = append(ew.buf, p[:l]...)
+		p = p[l:]
+		n += l
+	}
+	return n, nil
+}
+
+func (ew *encryptWriter) seal(final bool) error {
+	if ew.n == math.MaxUint32 {
+		return errors.Errorf("payload too large to encrypt")
+	}
+	ct := ew.aead.Seal(nil, segmentNonce(ew.prefix, ew.n, final), ew.buf, nil)
+	ew.n++
+	ew.buf = ew.buf[:0]
+	_, err := ew.w.Write(ct)
+	return err
+}
+
+func (ew *encryptWriter) Close() error {
+	return ew.seal(true)
+}
+
+// decryptReader opens the segments of an encrypted payload read from r.
+type decryptReader struct {
+	r      *bufio.Reader
+	aead   cipher.AEAD
+	prefix []byte
+	n      uint32
+	buf    []byte
+	final  bool
+}
+
+func newDecryptReader(key []byte, r io.Reader) (*decryptReader, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{r: bufio.NewReader(r), aead: aead}, nil
+}
+
+func (dr *decryptReader) Read(p []byte) (int, error) {
+	for len(dr.buf) == 0 {
+		if dr.final {
+			return 0, io.EOF
+		}
+		if err := dr.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, dr.buf)
+	dr.buf = dr.buf[n:]
+	return n, nil
+}
+
+func (dr *decryptReader) open() error {
+	if dr.prefix == nil {
+		dr.prefix = make([]byte, 7)
+		if _, err := io.ReadFull(dr.r, dr.prefix); err != nil {
+			return errors.Wrap(truncated(err), "failed to decrypt cache")
+		}
+	}
+	ct := make([]byte, encryptionSegment+dr.aead.Overhead())
+	n, err := io.ReadFull(dr.r, ct)
+	switch {
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		dr.final = true
+	case err != nil:
+		return errors.Wrap(truncated(err), "failed to decrypt cache")
+	default:
+		if _, err := dr.r.Peek(1); errors.Is(err, io.EOF) {
+			dr.final = true
+		} else if err != nil {
+			return err
+		}
+	}
+	pt, err := dr.aead.Open(nil, segmentNonce(dr.prefix, dr.n, dr.final), ct[:n], nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to decrypt cache")
+	}
+	dr.n++
+	dr.buf = pt
+	return nil
+}
+
+func truncated(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return errors.Errorf("encrypted payload is truncated")
+	}
+	return err
+}
@@ -0,0 +1,88 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryption(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	dt := make([]byte, 3*encryptionSegment/2)
+	_, err := rand.Read(dt)
+	require.NoError(t, err)
+
+	for _, v2 := range []bool{false, true} {
+		for _, comp := range []string{"", "gzip"} {
+			ts := newTestServer(t)
+			c := ts.newCache(t)
+			if v2 {
+				c = ts.newCacheV2(t)
+			}
+			WithEncryptionKey(key)(c)
+			c.Compression = comp
+
+			ctx := context.TODO()
+			require.NoError(t, c.Save(ctx, "enc", bytes.NewReader(dt), int64(len(dt))))
+			stored := ts.entry("enc").Data
+			require.False(t, bytes.Contains(stored, dt[:64]))
+
+			ce, err := c.Load(ctx, "enc")
+			require.NoError(t, err)
+			require.NotNil(t, ce)
+			buf := &bytes.Buffer{}
+			require.NoError(t, ce.Download(ctx, buf))
+			require.Equal(t, dt, buf.Bytes())
+
+			other := ts.newCache(t)
+			if v2 {
+				other = ts.newCacheV2(t)
+			}
+			WithEncryptionKey(bytes.Repeat([]byte{2}, 32))(other)
+			other.Compression = comp
+			ce, err = other.Load(ctx, "enc")
+			require.NoError(t, err)
+			require.Nil(t, ce)
+
+			ts.entry("enc").Data = stored[:len(stored)-1]
+			ce, err = c.Load(ctx, "enc")
+			require.NoError(t, err)
+			require.Error(t, ce.Download(ctx, ioutil.Discard))
+		}
+	}
+}
+
+func TestEncryptionStream(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 16)
+	for _, n := range []int{0, 1, encryptionSegment - 1, encryptionSegment, encryptionSegment + 1, 2 * encryptionSegment} {
+		dt := bytes.Repeat([]byte{'a'}, n)
+		buf := &bytes.Buffer{}
+		ew, err := newEncryptWriter(key, buf)
+		require.NoError(t, err)
+		_, err = ew.Write(dt)
+		require.NoError(t, err)
+		require.NoError(t, ew.Close())
+		enc := buf.Bytes()
+
+		dr, err := newDecryptReader(key, bytes.NewReader(enc))
+		require.NoError(t, err)
+		out, err := ioutil.ReadAll(dr)
+		require.NoError(t, err)
+		require.Equal(t, dt, out, "size %d", n)
+
+		// dropping the final segment is detected
+		if n > encryptionSegment {
+			dr, err = newDecryptReader(key, bytes.NewReader(enc[:7+encryptionSegment+16]))
+			require.NoError(t, err)
+			_, err = ioutil.ReadAll(dr)
+			require.Error(t, err, "size %d", n)
+		}
+	}
+
+	_, err := newEncryptWriter([]byte("short"), ioutil.Discard)
+	require.Error(t, err)
+}
@@ -0,0 +1,127 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// entryMetadataKeyPrefix prefixes the keys of the entries storing the
+// metadata of another entry.
+const entryMetadataKeyPrefix = "actionscache-metadata-"
+
+// EntryMetadata describes how a cache entry was produced. It is stored in a
+// small separate entry when saved with SaveMetadata and read back with
+// Entry.Metadata.
+type EntryMetadata struct {
+	// CreatedAt defaults to the time of the save when zero.
+	CreatedAt time.Time `json:"createdAt"`
+	// Producer names what saved the entry, eg. a workflow or tool.
+	Producer string            `json:"producer,omitempty"`
+	GitSHA   string            `json:"gitSHA,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+}
+
+// SaveMetadata makes Save store md alongside the saved entry. Saves skipped
+// for an existing entry do not store it. Failing to store md is logged and does
+// not fail the save.
+func SaveMetadata(md EntryMetadata) SaveOpt {
+	return func(o *saveOpt) {
+		o.entryMetadata = &md
+	}
+}
+
+// entryMetadataKeyBase is the key prefix shared by the metadata entries of
+// key. The length of key keeps the prefixes of different keys apart. Keys
+// too long to be followed by a timestamp are replaced by their digest.
+func entryMetadataKeyBase(key string) string {
+	return auxKeyBase(entryMetadataKeyPrefix, key, len("9223372036854775807"))
+}
+
+// auxKeyBase returns the key prefix of the entries stored next to the entry
+// of key under prefix, leaving room for n more bytes.
+func auxKeyBase(prefix, key string, n int) string {
+	base := prefix + strconv.Itoa(len(key)) + ":" + key + "@"
+	if len(escapeKey(base))+n <= MaxKeyLength {
+		return base
+	}
+	sum := sha256.Sum256([]byte(key))
+	return prefix + "sha256:" + hex.EncodeToString(sum[:]) + "@"
+}
+
+// withEntryMetadata returns the options to save key with and a function
+// storing the metadata set with SaveMetadata. The function is nil if no
+// metadata is set.
+func (c *Cache) withEntryMetadata(ctx context.Context, key string, opts []SaveOpt) ([]SaveOpt, func()) {
+	var so saveOpt
+	for _, o := range opts {
+		o(&so)
+	}
+	if so.entryMetadata == nil {
+		return opts, nil
+	}
+	var saved bool
+	opts = append(opts[:len(opts):len(opts)], reportSaved(func(k string) {
+		if k == key {
+			saved = true
+		}
+	}))
+	return opts, func() {
+		if !saved {
+			return
+		}
+		md := *so.entryMetadata
+		if md.CreatedAt.IsZero() {
+			md.CreatedAt = c.clock().Now().UTC()
+		}
+		dt, err := json.Marshal(md)
+		if err != nil {
+			c.warn(ctx, "failed to save cache metadata", F("key", key), F("error", err))
+			return
+		}
+		// the timestamp makes the key unique, entries saved again after an
+		// eviction get new metadata
+		mk := entryMetadataKeyBase(key) + strconv.FormatInt(c.clock().Now().UnixNano(), 10)
+		sopts := []SaveOpt{SaveIgnoreAlreadyExists()}
+		if so.scope != "" {
+			sopts = append(sopts, SaveScope(so.scope))
+		}
+		if err := c.save(ctx, mk, bytes.NewReader(dt), int64(len(dt)), sopts); err != nil {
+			c.warn(ctx, "failed to save cache metadata", F("key", key), F("error", err))
+		}
+	}
+}
+
+// Metadata returns the metadata stored with SaveMetadata when ce was saved,
+// or nil if there is none. Metadata older than ce belongs to an evicted
+// entry and is ignored.
+func (ce *Entry) Metadata(ctx context.Context) (*EntryMetadata, error) {
+	if ce.c == nil {
+		return nil, nil
+	}
+	ctx = ce.withVersion(ctx)
+	k := entryMetadataKeyBase(ce.Key)
+	mc, err := ce.c.load(ctx, []string{k}, nil)
+	if err != nil || mc == nil || !strings.HasPrefix(mc.Key, k) {
+		return nil, err
+	}
+	if !ce.CreationTime.IsZero() && !mc.CreationTime.IsZero() && mc.CreationTime.Before(ce.CreationTime) {
+		return nil, nil
+	}
+	buf := &bytes.Buffer{}
+	if err := mc.download(ctx, buf); err != nil {
+		return nil, err
+	}
+	var md EntryMetadata
+	if err := json.Unmarshal(buf.Bytes(), &md); err != nil {
+		return nil, errors.Wrapf(err, "invalid metadata entry %s", mc.Key)
+	}
+	return &md, nil
+}
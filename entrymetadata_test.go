@@ -0,0 +1,63 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEntryMetadata(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	clock := newTestClock()
+	c.Clock = clock
+	ctx := context.TODO()
+
+	md := EntryMetadata{Producer: "build", GitSHA: "abc123", Labels: map[string]string{"os": "linux"}}
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader([]byte("foo")), 3, SaveMetadata(md)))
+	ce, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	got, err := ce.Metadata(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	require.Equal(t, "build", got.Producer)
+	require.Equal(t, "abc123", got.GitSHA)
+	require.Equal(t, map[string]string{"os": "linux"}, got.Labels)
+	require.True(t, got.CreatedAt.Equal(clock.Now()))
+
+	// skipped saves do not replace the metadata
+	clock.Advance(time.Minute)
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader([]byte("bar")), 3, SaveIgnoreAlreadyExists(), SaveMetadata(EntryMetadata{Producer: "other"})))
+	got, err = ce.Metadata(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "build", got.Producer)
+
+	require.NoError(t, c.Save(ctx, "bar", bytes.NewReader([]byte("bar")), 3))
+	ce, err = c.Load(ctx, "bar")
+	require.NoError(t, err)
+	got, err = ce.Metadata(ctx)
+	require.NoError(t, err)
+	require.Nil(t, got)
+}
+
+func TestEntryMetadataLongKey(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	ctx := context.TODO()
+
+	key := strings.Repeat("k", MaxKeyLength-10)
+	md := EntryMetadata{Producer: "build"}
+	require.NoError(t, c.Save(ctx, key, bytes.NewReader([]byte("foo")), 3, SaveMetadata(md)))
+	ce, err := c.Load(ctx, key)
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	got, err := ce.Metadata(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	require.Equal(t, "build", got.Producer)
+}
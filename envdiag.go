@@ -0,0 +1,109 @@
+package actionscache
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrEnvNotConfigured is matched by the errors of TryEnvWithDiagnostics when
+// the environment does not allow using the cache.
+var ErrEnvNotConfigured = errors.New("cache environment not configured")
+
+// EnvError reports why the environment does not allow using the cache, eg.
+// to explain in a build log why caching is disabled.
+type EnvError struct {
+	// Missing are the variables that are not set.
+	Missing []string
+	// Malformed are the variables that are set to an invalid value, with
+	// the reason.
+	Malformed map[string]string
+	// NoCacheScopes is set if the runtime token grants no cache scopes, eg.
+	// because the workflow or the job does not have access to the cache.
+	NoCacheScopes bool
+}
+
+func (e *EnvError) Error() string {
+	var out []string
+	if len(e.Missing) > 0 {
+		out = append(out, "missing "+strings.Join(e.Missing, ", "))
+	}
+	keys := make([]string, 0, len(e.Malformed))
+	for k := range e.Malformed {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		out = append(out, fmt.Sprintf("invalid %s: %s", k, e.Malformed[k]))
+	}
+	if e.NoCacheScopes {
+		out = append(out, "runtime token has no cache scopes")
+	}
+	return "cache environment not configured: " + strings.Join(out, "; ")
+}
+
+func (e *EnvError) Is(target error) bool {
+	return target == ErrEnvNotConfigured
+}
+
+// TryEnvWithDiagnostics is like TryEnv but returns an *EnvError saying which
+// variables are missing or malformed, or that the token has no cache
+// scopes, instead of a nil Cache.
+func TryEnvWithDiagnostics(opts ...Opt) (*Cache, error) {
+	if err := checkEnv(opts); err != nil {
+		return nil, err
+	}
+	return TryEnv(opts...)
+}
+
+// checkEnv returns an *EnvError for the environment TryEnv reads.
+func checkEnv(opts []Opt) error {
+	if _, ok := S3ConfigFromEnv(); ok {
+		return nil
+	}
+	var c Cache
+	for _, o := range opts {
+		o(&c)
+	}
+	e := &EnvError{Malformed: map[string]string{}}
+	if token, ok := os.LookupEnv("ACTIONS_RUNTIME_TOKEN"); !ok || token == "" {
+		e.Missing = append(e.Missing, "ACTIONS_RUNTIME_TOKEN")
+	} else if _, scopes, err := parseToken(token, c.RelaxedToken); err != nil {
+		e.Malformed["ACTIONS_RUNTIME_TOKEN"] = err.Error()
+	} else if len(scopes) == 0 && !c.RelaxedToken {
+		e.NoCacheScopes = true
+	}
+	var found bool
+	for _, k := range []string{"ACTIONS_CACHE_URL", "ACTIONS_RESULTS_URL"} {
+		v, ok := os.LookupEnv(k)
+		if !ok {
+			continue
+		}
+		found = true
+		if err := checkServiceURL(v); err != nil {
+			e.Malformed[k] = err.Error()
+		}
+	}
+	if !found {
+		e.Missing = append(e.Missing, "ACTIONS_CACHE_URL or ACTIONS_RESULTS_URL")
+	}
+	if len(e.Missing) == 0 && len(e.Malformed) == 0 && !e.NoCacheScopes {
+		return nil
+	}
+	return errors.WithStack(e)
+}
+
+func checkServiceURL(v string) error {
+	u, err := url.Parse(v)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.Errorf("%q is not an http(s) URL", v)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package actionscache
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func setTestEnv(t *testing.T, env map[string]string) {
+	for _, k := range []string{"ACTIONS_RUNTIME_TOKEN", "ACTIONS_CACHE_URL", "ACTIONS_RESULTS_URL", "ACTIONS_CACHE_SERVICE_V2", "ACTIONS_CACHE_S3_BUCKET"} {
+		old, ok := os.LookupEnv(k)
+		t.Cleanup(func() {
+			if ok {
+				os.Setenv(k, old)
+			} else {
+				os.Unsetenv(k)
+			}
+		})
+		if v, ok := env[k]; ok {
+			os.Setenv(k, v)
+		} else {
+			os.Unsetenv(k)
+		}
+	}
+}
+
+func TestTryEnvWithDiagnostics(t *testing.T) {
+	setTestEnv(t, nil)
+	_, err := TryEnvWithDiagnostics()
+	require.ErrorIs(t, err, ErrEnvNotConfigured)
+	var ee *EnvError
+	require.ErrorAs(t, err, &ee)
+	require.Equal(t, []string{"ACTIONS_RUNTIME_TOKEN", "ACTIONS_CACHE_URL or ACTIONS_RESULTS_URL"}, ee.Missing)
+
+	setTestEnv(t, map[string]string{
+		"ACTIONS_RUNTIME_TOKEN": "notajwt",
+		"ACTIONS_CACHE_URL":     "artifactcache/xxx",
+	})
+	_, err = TryEnvWithDiagnostics()
+	require.ErrorAs(t, err, &ee)
+	require.Empty(t, ee.Missing)
+	require.Contains(t, ee.Malformed, "ACTIONS_RUNTIME_TOKEN")
+	require.Contains(t, ee.Malformed, "ACTIONS_CACHE_URL")
+	require.Contains(t, err.Error(), "invalid ACTIONS_CACHE_URL")
+
+	_, err = TryEnvWithDiagnostics(WithRelaxedToken())
+	require.ErrorAs(t, err, &ee)
+	require.NotContains(t, ee.Malformed, "ACTIONS_RUNTIME_TOKEN")
+
+	setTestEnv(t, map[string]string{
+		"ACTIONS_RUNTIME_TOKEN": testToken(t),
+		"ACTIONS_RESULTS_URL":   "https://results-receiver.actions.githubusercontent.com/",
+	})
+	_, err = TryEnvWithDiagnostics()
+	require.ErrorAs(t, err, &ee)
+	require.True(t, ee.NoCacheScopes)
+	require.Empty(t, ee.Missing)
+	require.Empty(t, ee.Malformed)
+
+	setTestEnv(t, map[string]string{
+		"ACTIONS_RUNTIME_TOKEN": testToken(t, Scope{Scope: "refs/heads/main", Permission: PermissionRead}),
+		"ACTIONS_RESULTS_URL":   "https://results-receiver.actions.githubusercontent.com/",
+	})
+	c, err := TryEnvWithDiagnostics()
+	require.NoError(t, err)
+	require.NotNil(t, c)
+	require.Equal(t, "v2", c.Protocol())
+}
@@ -0,0 +1,71 @@
+package actionscache
+
+import (
+	"net/http"
+
+	"github.com/pkg/errors"
+	apierrors "github.com/tonistiigi/go-actions-cache/internal/errors"
+)
+
+var (
+	// ErrCacheNotFound is returned when the service does not know the
+	// requested cache or upload.
+	ErrCacheNotFound = apierrors.ErrCacheNotFound
+	// ErrCacheAlreadyExists is returned when an entry for the key and
+	// version has already been saved.
+	ErrCacheAlreadyExists = apierrors.ErrCacheAlreadyExists
+	// ErrReserveConflict is returned when the key can not be reserved, either
+	// because it exists or another job is saving it.
+	ErrReserveConflict = apierrors.ErrReserveConflict
+	// ErrTooManyRequests is returned when the service rate limits the client
+	// beyond the retry policy.
+	ErrTooManyRequests = apierrors.ErrTooManyRequests
+	// ErrCacheSizeExceeded is returned when the entry is larger than the
+	// service accepts.
+	ErrCacheSizeExceeded = apierrors.ErrCacheSizeExceeded
+	// ErrServiceUnavailable is returned for 502, 503 and 504 responses, eg.
+	// during incidents of the service.
+	ErrServiceUnavailable = apierrors.ErrServiceUnavailable
+	// ErrUnexpectedResponse is returned when the service responds with a
+	// body that is not JSON, like the HTML error page of a proxy. The
+	// *GithubAPIError carries a snippet of it.
+	ErrUnexpectedResponse = apierrors.ErrUnexpectedResponse
+	// ErrURLExpired is returned when downloading an entry whose signed URL
+	// has expired.
+	ErrURLExpired = errors.New("cache entry URL expired")
+	// ErrDownloadStalled is returned when a download receives no data for
+	// the DownloadIdleTimeout.
+	ErrDownloadStalled = errors.New("cache download stalled")
+	// ErrUploadStalled is returned when a chunk upload makes no progress
+	// for the UploadIdleTimeout.
+	ErrUploadStalled = errors.New("cache upload stalled")
+	// ErrQuotaExceeded is returned by saves of a Cache failing fast on its
+	// quota when the payload is larger than the remaining cache storage of
+	// the repository.
+	ErrQuotaExceeded = errors.New("cache quota exceeded")
+)
+
+// GithubAPIError is an error response of the cache service or the REST API.
+// It matches the Err* sentinel errors with errors.Is based on its type key
+// and status code.
+type GithubAPIError = apierrors.GithubAPIError
+
+// checkResponse returns a *GithubAPIError for a non-2xx response of the
+// cache service. The body is consumed in that case, up to the error body
+// limit.
+func (c *Cache) checkResponse(resp *http.Response) error {
+	err := apierrors.CheckResponseBody(resp, c.maxErrorBodySize(), c.verbose())
+	c.logErrorBody(resp, err)
+	return err
+}
+
+// unexpectedResponse returns the error of a response whose body dt is not
+// the JSON the service should have sent.
+func (c *Cache) unexpectedResponse(resp *http.Response, dt []byte) *GithubAPIError {
+	e := &GithubAPIError{StatusCode: resp.StatusCode, Body: apierrors.Snippet(dt, resp.Header.Get("Content-Type"))}
+	if c.verbose() {
+		e.RawBody = dt
+	}
+	c.logErrorBody(resp, e)
+	return e
+}
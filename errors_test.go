@@ -0,0 +1,136 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTypedErrors(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	c.RetryPolicy = &RetryPolicy{MaxAttempts: 1}
+
+	ctx := context.TODO()
+	dt := []byte("foobar")
+
+	ts.Config.Handler = conflictHandler(ts.Config.Handler)
+	err := c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt)))
+	require.ErrorIs(t, err, ErrCacheAlreadyExists)
+	require.ErrorIs(t, err, ErrReserveConflict)
+	require.NotErrorIs(t, err, ErrCacheNotFound)
+	var ae *GithubAPIError
+	require.True(t, errors.As(err, &ae))
+	require.Equal(t, http.StatusConflict, ae.StatusCode)
+	require.Equal(t, "ArtifactCacheItemAlreadyExistsException", ae.TypeKey)
+	require.Equal(t, "Cache already exists.", ae.Message)
+
+	ts.fail = func(r *http.Request) int {
+		if r.Method == "GET" {
+			return http.StatusTooManyRequests
+		}
+		return 0
+	}
+	_, err = c.Load(ctx, "foo")
+	require.ErrorIs(t, err, ErrTooManyRequests)
+}
+
+func TestTypedErrorsV2(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCacheV2(t)
+
+	ctx := context.TODO()
+	h := ts.Config.Handler
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/CreateCacheEntry") {
+			w.Write([]byte(`{"ok":false}`))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+	err := c.Save(ctx, "foo", bytes.NewReader(nil), 0)
+	require.ErrorIs(t, err, ErrReserveConflict)
+
+	require.ErrorIs(t, &twirpError{Code: "not_found"}, ErrCacheNotFound)
+}
+
+func conflictHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/caches") {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"$id":"1","innerException":null,"message":"Cache already exists.","typeName":"Microsoft.Azure.DevOps.ArtifactCache.WebApi.ArtifactCacheItemAlreadyExistsException, Microsoft.Azure.DevOps.ArtifactCache.WebApi","typeKey":"ArtifactCacheItemAlreadyExistsException","errorCode":0,"eventId":3000}`))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func TestSaveIgnoreAlreadyExists(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	ts.Config.Handler = conflictHandler(ts.Config.Handler)
+
+	ctx := context.TODO()
+	dt := []byte("foobar")
+	require.ErrorIs(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))), ErrCacheAlreadyExists)
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt)), SaveIgnoreAlreadyExists()))
+
+	w, err := c.SaveWriter(ctx, "foo", SaveIgnoreAlreadyExists())
+	require.NoError(t, err)
+	_, err = w.Write(dt)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.Equal(t, 0, ts.count("PATCH "))
+	require.Len(t, c.Stats().Orphaned, 0)
+}
+
+func htmlHandler(h http.Handler, path string, status int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, path) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
+		w.Write([]byte("<!DOCTYPE html>\n<html><head><title>Unicorn!</title><style>body { color: red }</style></head>\n<body><p>We&#39;re having a really bad day.</p>" + strings.Repeat("<p>padding</p>", 100) + "</body></html>"))
+	})
+}
+
+func TestUnexpectedResponse(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	c.RetryPolicy = &RetryPolicy{MaxAttempts: 1}
+	h := ts.Config.Handler
+
+	ctx := context.TODO()
+	ts.Config.Handler = htmlHandler(h, "/cache", http.StatusBadGateway)
+	_, err := c.Load(ctx, "foo")
+	require.ErrorIs(t, err, ErrServiceUnavailable)
+	require.ErrorIs(t, err, ErrUnexpectedResponse)
+	var ae *GithubAPIError
+	require.True(t, errors.As(err, &ae))
+	require.Equal(t, http.StatusBadGateway, ae.StatusCode)
+	require.True(t, strings.HasPrefix(ae.Body, "Unicorn! We're having a really bad day. padding"), ae.Body)
+	require.True(t, strings.HasSuffix(ae.Body, "..."))
+	require.NotContains(t, ae.Body, "<")
+	require.NotContains(t, ae.Body, "color")
+	require.Contains(t, err.Error(), "unexpected response 502 Bad Gateway: Unicorn!")
+
+	ts.Config.Handler = htmlHandler(h, "/cache", http.StatusOK)
+	_, err = c.Load(ctx, "foo")
+	require.ErrorIs(t, err, ErrUnexpectedResponse)
+	require.NotErrorIs(t, err, ErrServiceUnavailable)
+
+	c = ts.newCacheV2(t)
+	c.RetryPolicy = &RetryPolicy{MaxAttempts: 1}
+	ts.Config.Handler = htmlHandler(h, "/GetCacheEntryDownloadURL", http.StatusServiceUnavailable)
+	_, err = c.Load(ctx, "foo")
+	require.ErrorIs(t, err, ErrServiceUnavailable)
+	require.ErrorIs(t, err, ErrUnexpectedResponse)
+	require.Contains(t, err.Error(), "Unicorn!")
+}
@@ -0,0 +1,133 @@
+package actionscache
+
+import (
+	"context"
+	"time"
+)
+
+// Events receives the lifecycle events of a Cache, eg. to feed progress
+// bars or telemetry without parsing log messages. Methods are called
+// concurrently with the context of the operation and must not block.
+// Embed NopEvents to implement only some of them.
+type Events interface {
+	// OnReserve is called after a key is reserved for a save, or the
+	// reservation failed. Entries of the v2 service have no ID.
+	OnReserve(ctx context.Context, e ReserveEvent)
+	// OnChunkStart is called before a chunk or block is uploaded.
+	OnChunkStart(ctx context.Context, e ChunkEvent)
+	// OnChunkDone is called after a chunk or block is uploaded, including
+	// retries, or failed.
+	OnChunkDone(ctx context.Context, e ChunkEvent)
+	// OnCommit is called after a reservation is committed, or the commit
+	// failed.
+	OnCommit(ctx context.Context, e CommitEvent)
+	// OnLoadHit is called when a load finds an entry.
+	OnLoadHit(ctx context.Context, e LoadEvent)
+	// OnLoadMiss is called when a load finds no entry.
+	OnLoadMiss(ctx context.Context, e LoadEvent)
+	// OnRetry is called before a request or chunk is sent again.
+	OnRetry(ctx context.Context, e RetryEvent)
+	// OnError is called when a load, save or download fails.
+	OnError(ctx context.Context, e ErrorEvent)
+	// OnDeprecation is called when the Cache uses a deprecated API.
+	OnDeprecation(ctx context.Context, e DeprecationEvent)
+}
+
+// ReserveEvent is a reservation of Key.
+type ReserveEvent struct {
+	Key string
+	ID  int
+	Err error
+}
+
+// ChunkEvent is the upload of Size bytes at Offset of the reservation ID.
+// Duration and Err are only set when it is done.
+type ChunkEvent struct {
+	ID       int
+	Offset   int64
+	Size     int64
+	Duration time.Duration
+	Err      error
+}
+
+// CommitEvent is the commit of Size bytes of Key.
+type CommitEvent struct {
+	Key  string
+	ID   int
+	Size int64
+	Err  error
+}
+
+// LoadEvent is a lookup of Keys. Entry is nil on a miss.
+type LoadEvent struct {
+	Keys  []string
+	Entry *Entry
+}
+
+// RetryEvent is the retry of a request of Endpoint, eg. "lookup",
+// "reserve", "upload", "commit", "twirp", "blob" or "download". Attempt is
+// the attempt about to be made after Delay, Reason the error or status of
+// the previous one.
+type RetryEvent struct {
+	Endpoint string
+	Attempt  int
+	Delay    time.Duration
+	Reason   string
+}
+
+// ErrorEvent is the failure of the operation Op, "load", "save" or
+// "download", of Key.
+type ErrorEvent struct {
+	Op  string
+	Key string
+	Err error
+}
+
+// DeprecationEvent is the use of the deprecated Protocol of the service at
+// URL.
+type DeprecationEvent struct {
+	Protocol string
+	URL      string
+	Message  string
+}
+
+// NopEvents ignores all events. It is embedded by Events implementations
+// interested in only some of them.
+type NopEvents struct{}
+
+func (NopEvents) OnReserve(context.Context, ReserveEvent)         {}
+func (NopEvents) OnChunkStart(context.Context, ChunkEvent)        {}
+func (NopEvents) OnChunkDone(context.Context, ChunkEvent)         {}
+func (NopEvents) OnCommit(context.Context, CommitEvent)           {}
+func (NopEvents) OnLoadHit(context.Context, LoadEvent)            {}
+func (NopEvents) OnLoadMiss(context.Context, LoadEvent)           {}
+func (NopEvents) OnRetry(context.Context, RetryEvent)             {}
+func (NopEvents) OnError(context.Context, ErrorEvent)             {}
+func (NopEvents) OnDeprecation(context.Context, DeprecationEvent) {}
+
+// WithEvents sets the Events receiving the lifecycle events of the Cache.
+func WithEvents(e Events) Opt {
+	return func(c *Cache) {
+		c.Events = e
+	}
+}
+
+func (c *Cache) events() Events {
+	if c == nil || c.Events == nil {
+		return NopEvents{}
+	}
+	return c.Events
+}
+
+// chunkEvents reports the start of the upload of a chunk and returns the
+// function reporting it done.
+func (c *Cache) chunkEvents(ctx context.Context, id int, off, n int64) func(error) {
+	ev := c.events()
+	e := ChunkEvent{ID: id, Offset: off, Size: n}
+	ev.OnChunkStart(ctx, e)
+	start := c.clock().Now()
+	return func(err error) {
+		e.Duration, e.Err = c.clock().Now().Sub(start), err
+		ev.OnChunkDone(ctx, e)
+	}
+}
@@ -0,0 +1,113 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type recordingEvents struct {
+	NopEvents
+	mu     sync.Mutex
+	events []string
+	chunks []ChunkEvent
+	errs   []ErrorEvent
+}
+
+func (e *recordingEvents) add(s string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.events = append(e.events, s)
+}
+
+func (e *recordingEvents) OnReserve(_ context.Context, ev ReserveEvent) {
+	e.add(fmt.Sprintf("reserve %s %v", ev.Key, ev.Err != nil))
+}
+
+func (e *recordingEvents) OnChunkDone(_ context.Context, ev ChunkEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.chunks = append(e.chunks, ev)
+}
+
+func (e *recordingEvents) OnCommit(_ context.Context, ev CommitEvent) {
+	e.add(fmt.Sprintf("commit %s %d %v", ev.Key, ev.Size, ev.Err != nil))
+}
+
+func (e *recordingEvents) OnLoadHit(_ context.Context, ev LoadEvent) {
+	e.add("hit " + ev.Entry.Key)
+}
+
+func (e *recordingEvents) OnLoadMiss(_ context.Context, ev LoadEvent) {
+	e.add(fmt.Sprintf("miss %v", ev.Keys))
+}
+
+func (e *recordingEvents) OnRetry(_ context.Context, ev RetryEvent) {
+	e.add(fmt.Sprintf("retry %s %d %s", ev.Endpoint, ev.Attempt, ev.Delay))
+}
+
+func (e *recordingEvents) OnError(_ context.Context, ev ErrorEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.errs = append(e.errs, ev)
+}
+
+func TestEvents(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	ev := &recordingEvents{}
+	WithEvents(ev)(c)
+	WithUploadChunkSize(4)(c)
+	WithUploadConcurrency(1)(c)
+	c.Clock = newTestClock()
+	c.RetryPolicy = &RetryPolicy{MaxAttempts: 2, MinBackoff: time.Second, MaxBackoff: time.Second}
+
+	ctx := context.TODO()
+	dt := []byte("0123456789")
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+	require.Equal(t, []string{"reserve foo false", "commit foo 10 false"}, ev.events)
+	require.Len(t, ev.chunks, 3)
+	require.Equal(t, int64(8), ev.chunks[2].Offset)
+	require.Equal(t, int64(2), ev.chunks[2].Size)
+	require.NoError(t, ev.chunks[2].Err)
+
+	ev.events = nil
+	_, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	_, err = c.Load(ctx, "bar", "baz")
+	require.NoError(t, err)
+	require.Equal(t, []string{"hit foo", "miss [bar baz]"}, ev.events)
+
+	ev.events = nil
+	ts.fail = func(r *http.Request) int {
+		return http.StatusBadGateway
+	}
+	_, err = c.Load(ctx, "foo")
+	require.Error(t, err)
+	require.Equal(t, []string{"retry lookup 2 1s"}, ev.events)
+	require.Len(t, ev.errs, 1)
+	require.Equal(t, "load", ev.errs[0].Op)
+	require.Equal(t, "foo", ev.errs[0].Key)
+	require.Equal(t, err, ev.errs[0].Err)
+}
+
+func TestEventsV2(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCacheV2(t)
+	ev := &recordingEvents{}
+	WithEvents(ev)(c)
+
+	ctx := context.TODO()
+	dt := []byte("foobar")
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+	require.Error(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+	require.Equal(t, []string{"reserve foo false", "commit foo 6 false", "reserve foo true"}, ev.events)
+	require.Len(t, ev.errs, 1)
+	require.Equal(t, "save", ev.errs[0].Op)
+}
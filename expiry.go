@@ -0,0 +1,98 @@
+package actionscache
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ExpiresAt returns the expiry of the signed URL of the entry, parsed from
+// its "se" query parameter. It is the zero time if the URL has no expiry.
+func (ce *Entry) ExpiresAt() time.Time {
+	u, err := url.Parse(ce.URL)
+	if err != nil {
+		return time.Time{}
+	}
+	se := u.Query().Get("se")
+	if se == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, se)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// checkExpiry returns ErrURLExpired if the URL of ce has expired, unless
+// the Cache is set to refresh it with a new lookup.
+func (ce *Entry) checkExpiry(ctx context.Context) error {
+	exp := ce.ExpiresAt()
+	if exp.IsZero() || ce.c.clock().Now().Before(exp) {
+		return nil
+	}
+	if ce.c != nil && ce.c.RefreshExpiredURLs {
+		ce.c.info(ctx, "cache URL expired, refreshing", F("key", ce.Key), F("expiresAt", exp.Format(time.RFC3339)))
+		_, err := ce.refreshURL(ctx)
+		return err
+	}
+	return errors.Wrapf(ErrURLExpired, "cache %s URL expired at %s", ce.Key, exp.Format(time.RFC3339))
+}
+
+// freshURL looks up ce again and returns its new signed URL. It fails with
+// ErrURLExpired if the entry is gone.
+func (ce *Entry) freshURL(ctx context.Context) (string, error) {
+	ne, err := ce.c.load(ctx, []string{ce.Key}, nil)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to refresh URL of cache %s", ce.Key)
+	}
+	if ne == nil || ne.Key != ce.Key {
+		return "", errors.Wrapf(ErrURLExpired, "cache %s no longer found to refresh its URL", ce.Key)
+	}
+	return ne.URL, nil
+}
+
+// refreshURL replaces the URL of ce with a fresh one.
+func (ce *Entry) refreshURL(ctx context.Context) (string, error) {
+	u, err := ce.freshURL(ctx)
+	if err != nil {
+		return "", err
+	}
+	ce.URL = u
+	return u, nil
+}
+
+// urlRefresher returns a function getting a fresh URL of ce for downloads
+// whose URL is rejected, or nil if the Cache does not refresh URLs.
+func (ce *Entry) urlRefresher() func(context.Context) (string, error) {
+	if ce.c == nil || !ce.c.RefreshExpiredURLs || ce.c.Downloader != nil || ce.c.Backend != nil {
+		return nil
+	}
+	return ce.refreshURL
+}
+
+type urlRefresherKey struct{}
+
+// withURLRefresher makes the downloads of ctx call refresh for a new URL
+// when the signed URL is rejected.
+func withURLRefresher(ctx context.Context, refresh func(context.Context) (string, error)) context.Context {
+	if refresh == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, urlRefresherKey{}, refresh)
+}
+
+func urlRefresherFromContext(ctx context.Context) func(context.Context) (string, error) {
+	refresh, _ := ctx.Value(urlRefresherKey{}).(func(context.Context) (string, error))
+	return refresh
+}
+
+// isURLRejected reports if a download failed with err because the service
+// refused its signed URL, eg. after the signature expired.
+func isURLRejected(ctx context.Context, err error) bool {
+	var ae *GithubAPIError
+	return ctx.Err() == nil && errors.As(err, &ae) && ae.StatusCode == http.StatusForbidden
+}
@@ -0,0 +1,86 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestURLExpiry(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	clock := newTestClock()
+	c.Clock = clock
+
+	ctx := context.TODO()
+	dt := []byte("foobar")
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+
+	ce, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	require.True(t, ce.ExpiresAt().IsZero())
+
+	exp := clock.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	ce.URL += "&sv=2020-04-08&se=" + exp.Format(time.RFC3339) + "&sig=x"
+	require.Equal(t, exp, ce.ExpiresAt())
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, ce.Download(ctx, buf))
+	require.Equal(t, string(dt), buf.String())
+
+	clock.Advance(2 * time.Hour)
+	err = ce.Download(ctx, buf)
+	require.ErrorIs(t, err, ErrURLExpired)
+	require.Equal(t, 1, ts.count("GET /blob/foo"))
+
+	c.RefreshExpiredURLs = true
+	buf.Reset()
+	require.NoError(t, ce.Download(ctx, buf))
+	require.Equal(t, string(dt), buf.String())
+	require.True(t, ce.ExpiresAt().IsZero())
+	require.Equal(t, 2, ts.count(http.MethodGet+" /_apis/artifactcache/cache"))
+}
+
+func TestRefreshRejectedURL(t *testing.T) {
+	ts := newTestServer(t)
+	ts.fail = func(r *http.Request) int {
+		if r.URL.Query().Get("sig") == "stale" {
+			return http.StatusForbidden
+		}
+		return 0
+	}
+	c := ts.newCache(t)
+	c.DownloadChunkSize = 2
+
+	ctx := context.TODO()
+	dt := []byte("foobar")
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+
+	ce, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	ce.URL += "&sig=stale"
+	err = ce.Download(ctx, &bytes.Buffer{})
+	require.Error(t, err)
+	var ae *GithubAPIError
+	require.ErrorAs(t, err, &ae)
+	require.Equal(t, http.StatusForbidden, ae.StatusCode)
+
+	c.RefreshExpiredURLs = true
+	buf := &bytes.Buffer{}
+	require.NoError(t, ce.Download(ctx, buf))
+	require.Equal(t, string(dt), buf.String())
+	require.NotContains(t, ce.URL, "sig=stale")
+
+	ce.URL += "&sig=stale"
+	w := &bufferAt{}
+	require.NoError(t, ce.DownloadAt(ctx, w))
+	require.Equal(t, string(dt), string(w.buf))
+	require.NotContains(t, ce.URL, "sig=stale")
+	require.Equal(t, 3, ts.count(http.MethodGet+" /_apis/artifactcache/cache"))
+}
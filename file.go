@@ -0,0 +1,56 @@
+package actionscache
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// SaveFile saves the contents of the regular file at path under key.
+func (c *Cache) SaveFile(ctx context.Context, key, path string, opts ...SaveOpt) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if !fi.Mode().IsRegular() {
+		return errors.Errorf("%s is not a regular file", path)
+	}
+	return c.Save(ctx, key, f, fi.Size(), opts...)
+}
+
+// DownloadToFile downloads ce to the file at path. The data is written to a
+// temporary file next to it that is synced and renamed over path, so path
+// never holds a partial download. The file is created with mode 0644.
+func (ce *Entry) DownloadToFile(ctx context.Context, path string) (err error) {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	if err = ce.DownloadAt(ctx, f); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := f.Chmod(0644); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := f.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.Rename(f.Name(), path))
+}
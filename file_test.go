@@ -0,0 +1,50 @@
+package actionscache
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaveFile(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+
+	dir, err := ioutil.TempDir("", "actionscache-file")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	require.NoError(t, ioutil.WriteFile(src, []byte("foobar"), 0600))
+
+	ctx := context.TODO()
+	require.NoError(t, c.SaveFile(ctx, "foo", src))
+	require.Error(t, c.SaveFile(ctx, "bar", dir))
+
+	ce, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	dst := filepath.Join(dir, "dst")
+	require.NoError(t, ce.DownloadToFile(ctx, dst))
+	dt, err := ioutil.ReadFile(dst)
+	require.NoError(t, err)
+	require.Equal(t, "foobar", string(dt))
+	fi, err := os.Stat(dst)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0644), fi.Mode().Perm())
+
+	// a failed download leaves the previous file and no temporary files
+	ts.fail = func(r *http.Request) int {
+		return http.StatusForbidden
+	}
+	require.Error(t, ce.DownloadToFile(ctx, dst))
+	dt, err = ioutil.ReadFile(dst)
+	require.NoError(t, err)
+	require.Equal(t, "foobar", string(dt))
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+}
@@ -0,0 +1,215 @@
+package actionscache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// FSBackend is a Backend storing entries as files in a local directory, eg.
+// for runners without a cache service. Entries of a version are stored in a
+// directory named after it with the escaped key as file name. Keys too long
+// for a file name are stored under their digest, with the key in a file
+// next to it.
+type FSBackend struct {
+	dir string
+
+	mu      sync.Mutex
+	nextID  int
+	uploads map[int]*fsUpload
+}
+
+type fsUpload struct {
+	key     string
+	version string
+	f       *os.File
+}
+
+// NewFSBackend returns a FSBackend storing entries in dir, creating it if
+// needed.
+func NewFSBackend(dir string) (*FSBackend, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, ".uploads"), 0755); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &FSBackend{dir: dir, uploads: map[int]*fsUpload{}}, nil
+}
+
+// fsMaxName is the longest file name of a key, the NAME_MAX of common file
+// systems.
+const fsMaxName = 255
+
+// fsLongPrefix starts the file names of keys longer than fsMaxName. Escaped
+// keys never start with it as url.PathEscape encodes "%".
+const fsLongPrefix = "%long-"
+
+// fsKeySuffix is appended to the file name of a long key for the file
+// storing the key.
+const fsKeySuffix = ".key"
+
+func (b *FSBackend) path(key, version string) string {
+	return filepath.Join(b.dir, version, fsName(key))
+}
+
+// fsName returns the file name of key.
+func fsName(key string) string {
+	name := url.PathEscape(key)
+	if len(name) <= fsMaxName {
+		return name
+	}
+	sum := sha256.Sum256([]byte(key))
+	return fsLongPrefix + hex.EncodeToString(sum[:])
+}
+
+// fsKey returns the key of the file name in dir, false if it is not the
+// file of an entry.
+func fsKey(dir, name string) (string, bool) {
+	if !strings.HasPrefix(name, fsLongPrefix) {
+		key, err := url.PathUnescape(name)
+		return key, err == nil
+	}
+	if strings.HasSuffix(name, fsKeySuffix) {
+		return "", false
+	}
+	dt, err := ioutil.ReadFile(filepath.Join(dir, name+fsKeySuffix))
+	if err != nil {
+		return "", false
+	}
+	return string(dt), true
+}
+
+func (b *FSBackend) Reserve(ctx context.Context, key, version string) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, err := os.Stat(b.path(key, version)); err == nil {
+		return 0, errors.Wrapf(ErrReserveConflict, "cache %s already exists", key)
+	}
+	for _, u := range b.uploads {
+		if u.key == key && u.version == version {
+			return 0, errors.Wrapf(ErrReserveConflict, "cache %s is being uploaded", key)
+		}
+	}
+	f, err := ioutil.TempFile(filepath.Join(b.dir, ".uploads"), "upload-")
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	b.nextID++
+	b.uploads[b.nextID] = &fsUpload{key: key, version: version, f: f}
+	return b.nextID, nil
+}
+
+func (b *FSBackend) upload(id int) (*fsUpload, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	u, ok := b.uploads[id]
+	if !ok {
+		return nil, errors.Errorf("cache %d is not reserved", id)
+	}
+	return u, nil
+}
+
+func (b *FSBackend) UploadChunk(ctx context.Context, id int, ra io.ReaderAt, off, n int64) error {
+	u, err := b.upload(id)
+	if err != nil {
+		return err
+	}
+	copied, err := io.Copy(&offsetWriter{w: u.f, off: off}, io.NewSectionReader(ra, off, n))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if copied != n {
+		return errors.Errorf("short read for cache %d range %d-%d: %d bytes", id, off, off+n-1, copied)
+	}
+	return nil
+}
+
+func (b *FSBackend) Commit(ctx context.Context, id int, size int64) error {
+	u, err := b.upload(id)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	delete(b.uploads, id)
+	b.mu.Unlock()
+	defer os.Remove(u.f.Name())
+	if err := u.f.Truncate(size); err != nil {
+		u.f.Close()
+		return errors.WithStack(err)
+	}
+	if err := u.f.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	p := b.path(u.key, u.version)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return errors.WithStack(err)
+	}
+	if strings.HasPrefix(filepath.Base(p), fsLongPrefix) {
+		// the key is written first, so the entry is never found without it
+		if err := ioutil.WriteFile(p+fsKeySuffix, []byte(u.key), 0644); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return errors.WithStack(os.Rename(u.f.Name(), p))
+}
+
+func (b *FSBackend) Lookup(ctx context.Context, keys []string, version string) (*Entry, error) {
+	dir := filepath.Join(b.dir, version)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.WithStack(err)
+	}
+	for _, k := range keys {
+		var match os.FileInfo
+		var matchKey string
+		for _, fi := range files {
+			key, ok := fsKey(dir, fi.Name())
+			if !ok || !strings.HasPrefix(key, k) {
+				continue
+			}
+			if key == k {
+				match, matchKey = fi, key
+				break
+			}
+			if match == nil || fi.ModTime().After(match.ModTime()) {
+				match, matchKey = fi, key
+			}
+		}
+		if match != nil {
+			return &Entry{
+				Key:          matchKey,
+				URL:          "file://" + filepath.ToSlash(b.path(matchKey, version)),
+				CreationTime: match.ModTime(),
+			}, nil
+		}
+	}
+	return nil, nil
+}
+
+func (b *FSBackend) Download(ctx context.Context, u string, w io.Writer) error {
+	p := filepath.FromSlash(strings.TrimPrefix(u, "file://"))
+	if !strings.HasPrefix(p, b.dir+string(filepath.Separator)) {
+		return errors.Errorf("cache archive %s is not in %s", u, b.dir)
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return errors.WithStack(err)
+}
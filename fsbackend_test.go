@@ -0,0 +1,83 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFSBackend(t *testing.T) {
+	defer func(v int) { UploadChunkSize = v }(UploadChunkSize)
+	UploadChunkSize = 4
+
+	dir := t.TempDir()
+	b, err := NewFSBackend(dir)
+	require.NoError(t, err)
+	c := NewWithBackend(b)
+
+	ctx := context.TODO()
+	dt := []byte("0123456789abcdef")
+	require.NoError(t, c.Save(ctx, "foo/1", bytes.NewReader(dt), int64(len(dt))))
+	require.NoError(t, c.SaveValue(ctx, "foo/2", []byte("second")))
+
+	err = c.Save(ctx, "foo/1", bytes.NewReader(dt), int64(len(dt)))
+	require.ErrorIs(t, err, ErrReserveConflict)
+
+	// the newest prefix match is returned
+	old := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(b.path("foo/1", c.version("foo/1")), old, old))
+	ce, err := c.Load(ctx, "bar", "foo/")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	require.Equal(t, "foo/2", ce.Key)
+	require.Equal(t, "foo/", ce.MatchedKey)
+
+	ce, err = c.Load(ctx, "foo/1", "foo/")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	require.True(t, ce.Exact)
+	buf := &bytes.Buffer{}
+	require.NoError(t, ce.Download(ctx, buf))
+	require.Equal(t, string(dt), buf.String())
+
+	ce, err = c.Load(ctx, "baz")
+	require.NoError(t, err)
+	require.Nil(t, ce)
+
+	err = b.Download(ctx, "file://"+filepath.ToSlash(filepath.Join(filepath.Dir(dir), "other")), buf)
+	require.Error(t, err)
+}
+
+func TestFSBackendLongKey(t *testing.T) {
+	b, err := NewFSBackend(t.TempDir())
+	require.NoError(t, err)
+	c := NewWithBackend(b)
+
+	ctx := context.TODO()
+	key := "long/" + strings.Repeat("x", 400)
+	dt := []byte("long")
+	require.NoError(t, c.Save(ctx, key, bytes.NewReader(dt), int64(len(dt))))
+	require.NoError(t, c.Save(ctx, key+"/2", bytes.NewReader(dt), int64(len(dt))))
+	require.ErrorIs(t, c.Save(ctx, key, bytes.NewReader(dt), int64(len(dt))), ErrReserveConflict)
+	require.LessOrEqual(t, len(filepath.Base(b.path(key, c.version(key)))), fsMaxName)
+
+	ce, err := c.Load(ctx, key)
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	require.Equal(t, key, ce.Key)
+	require.True(t, ce.Exact)
+	buf := &bytes.Buffer{}
+	require.NoError(t, ce.Download(ctx, buf))
+	require.Equal(t, "long", buf.String())
+
+	ce, err = c.Load(ctx, "missing", key+"/")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	require.Equal(t, key+"/2", ce.Key)
+}
@@ -0,0 +1,110 @@
+package actionscache
+
+import (
+	"net/url"
+	"os"
+	"strings"
+)
+
+// DefaultAPIPathPrefix is the path of the v1 cache service API below the
+// cache URL.
+const DefaultAPIPathPrefix = "_apis/artifactcache/"
+
+// GHESAPIVersion is the api-version WithGHES pins requests to.
+var GHESAPIVersion = "5.1-preview.1"
+
+// WithAPIVersion pins the api-version of all v1 requests instead of
+// negotiating the newest one the server accepts.
+func WithAPIVersion(v string) Opt {
+	return func(c *Cache) {
+		c.APIVersion = v
+	}
+}
+
+// WithAPIVersions sets the api-versions of v1 requests, newest first. The
+// first version the server accepts is used, older ones are tried when it
+// rejects a version.
+func WithAPIVersions(versions ...string) Opt {
+	return func(c *Cache) {
+		c.APIVersions = append([]string(nil), versions...)
+	}
+}
+
+// WithAPIPathPrefix sets the path of the v1 API below the cache URL.
+func WithAPIPathPrefix(p string) Opt {
+	return func(c *Cache) {
+		c.APIPathPrefix = p
+	}
+}
+
+// WithURLJoiner sets how request URLs are built from the cache URL and the
+// path of an endpoint, eg. "_apis/artifactcache/caches" or
+// "twirp/github.actions.results.api.v1.CacheService/CreateCacheEntry", for
+// proxies that rewrite or route paths differently.
+func WithURLJoiner(fn func(base, p string) string) Opt {
+	return func(c *Cache) {
+		c.URLJoiner = fn
+	}
+}
+
+// WithSingleChunkUploads uploads each entry in one request for servers
+// that do not support chunked uploads. Streaming saves with SaveWriter are
+// not supported, SaveReader spools the data first.
+func WithSingleChunkUploads() Opt {
+	return func(c *Cache) {
+		c.SingleChunkUploads = true
+	}
+}
+
+// WithGHES configures c for the cache service of GitHub Enterprise Server.
+// TryEnv only detects GHES and sets Cache.GHES, pass WithGHES to pin the
+// api-version and upload in single chunks. Options passed after it override
+// the individual settings.
+func WithGHES() Opt {
+	return func(c *Cache) {
+		c.GHES = true
+		c.APIVersion = GHESAPIVersion
+		c.SingleChunkUploads = true
+	}
+}
+
+// IsGHES reports if serverURL, the value of GITHUB_SERVER_URL, is a GitHub
+// Enterprise Server instance.
+func IsGHES(serverURL string) bool {
+	if serverURL == "" {
+		return false
+	}
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	// GitHub Enterprise Cloud with data residency runs the github.com service
+	return host != "github.com" && !strings.HasSuffix(host, ".ghe.com")
+}
+
+func isGHESEnv() bool {
+	return IsGHES(os.Getenv("GITHUB_SERVER_URL"))
+}
+
+// joinURL appends p to base, adding the separating slash when base does not
+// end with one.
+func joinURL(base, p string) string {
+	return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(p, "/")
+}
+
+// endpointURL returns the URL of p below the cache URL.
+func (c *Cache) endpointURL(p string) string {
+	if c.URLJoiner != nil {
+		return c.URLJoiner(c.URL, p)
+	}
+	return joinURL(c.URL, p)
+}
+
+func (c *Cache) apiPathPrefix() string {
+	p := strings.Trim(c.APIPathPrefix, "/")
+	if p == "" {
+		return DefaultAPIPathPrefix
+	}
+	return p + "/"
+}
@@ -0,0 +1,131 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsGHES(t *testing.T) {
+	for u, exp := range map[string]bool{
+		"":                           false,
+		"https://github.com":         false,
+		"https://GitHub.com/":        false,
+		"https://octo.ghe.com":       false,
+		"https://github.example.com": true,
+		"http://10.0.0.1:8080":       true,
+	} {
+		require.Equal(t, exp, IsGHES(u), u)
+	}
+}
+
+func TestGHES(t *testing.T) {
+	ts := newTestServer(t)
+	h := ts.Config.Handler
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/blob/") {
+			if !strings.HasPrefix(r.URL.Path, "/ghes/cache/") {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			r.URL.Path = "/_apis/artifactcache/" + strings.TrimPrefix(r.URL.Path, "/ghes/cache/")
+		}
+		h.ServeHTTP(w, r)
+	})
+	var versions []string
+	ts.verify = func(r *http.Request, _ []byte) {
+		if v := r.Header.Get("Accept"); v != "" {
+			versions = append(versions, v)
+		}
+	}
+	c := ts.newCache(t)
+	WithGHES()(c)
+	WithAPIPathPrefix("/ghes/cache")(c)
+	c.UploadChunkSize = 4
+
+	ctx := context.TODO()
+	dt := []byte("0123456789abcdef")
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+	require.Equal(t, 1, ts.count("PATCH "))
+	require.NoError(t, c.SaveReader(ctx, "bar", bytes.NewReader(dt)))
+	require.Equal(t, 2, ts.count("PATCH "))
+	_, err := c.SaveWriter(ctx, "baz")
+	require.Error(t, err)
+
+	ce, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	buf := &bytes.Buffer{}
+	require.NoError(t, ce.Download(ctx, buf))
+	require.Equal(t, dt, buf.Bytes())
+
+	require.NotEmpty(t, versions)
+	for _, v := range versions {
+		require.Equal(t, "application/json;api-version="+GHESAPIVersion, v)
+	}
+}
+
+func TestTryEnvGHES(t *testing.T) {
+	env := map[string]string{
+		"ACTIONS_RUNTIME_TOKEN": testToken(t, Scope{Scope: "refs/heads/main", Permission: PermissionRead}),
+		"ACTIONS_CACHE_URL":     "https://ghes.example.com/",
+		"GITHUB_SERVER_URL":     "https://ghes.example.com",
+	}
+	for k, v := range env {
+		if old, ok := os.LookupEnv(k); ok {
+			defer os.Setenv(k, old)
+		} else {
+			defer os.Unsetenv(k)
+		}
+		os.Setenv(k, v)
+	}
+	c, err := TryEnv()
+	require.NoError(t, err)
+	require.True(t, c.GHES)
+	require.False(t, c.SingleChunkUploads)
+	require.Empty(t, c.APIVersion)
+
+	c, err = TryEnv(WithGHES(), WithAPIVersion("6.0-preview.1"))
+	require.NoError(t, err)
+	require.True(t, c.SingleChunkUploads)
+	require.Equal(t, "6.0-preview.1", c.APIVersion)
+
+	os.Setenv("GITHUB_SERVER_URL", "https://github.com")
+	c, err = TryEnv()
+	require.NoError(t, err)
+	require.False(t, c.GHES)
+}
+
+func TestURLJoin(t *testing.T) {
+	ts := newTestServer(t)
+	ctx := context.TODO()
+	for _, v2 := range []bool{false, true} {
+		c := ts.newCache(t)
+		if v2 {
+			c = ts.newCacheV2(t)
+		}
+		c.URL = strings.TrimSuffix(c.URL, "/")
+		require.NoError(t, c.Save(ctx, "nosep-"+c.Protocol(), bytes.NewReader([]byte("foo")), 3))
+		ce, err := c.Load(ctx, "nosep-"+c.Protocol())
+		require.NoError(t, err)
+		require.NotNil(t, ce)
+	}
+
+	var paths []string
+	c := ts.newCache(t)
+	c.URL = "http://proxy.invalid/cache"
+	WithURLJoiner(func(base, p string) string {
+		paths = append(paths, p)
+		require.Equal(t, "http://proxy.invalid/cache", base)
+		return ts.URL + "/" + p
+	})(c)
+	ce, err := c.Load(ctx, "nosep-v1")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	require.Equal(t, []string{DefaultAPIPathPrefix + "cache"}, paths)
+}
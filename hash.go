@@ -0,0 +1,97 @@
+package actionscache
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"hash"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultHash is the name of the hash used without Cache.Hash.
+const DefaultHash = "sha256"
+
+var (
+	hashesMu sync.RWMutex
+	hashes   = map[string]func() hash.Hash{}
+)
+
+func init() {
+	RegisterHash("sha256", sha256.New)
+	RegisterHash("sha512", sha512.New)
+}
+
+// RegisterHash makes a hash available by name to Cache.Hash, eg. a blake3
+// implementation, replacing a previous one of the same name.
+func RegisterHash(name string, fn func() hash.Hash) {
+	hashesMu.Lock()
+	defer hashesMu.Unlock()
+	hashes[name] = fn
+}
+
+func getHash(name string) (func() hash.Hash, error) {
+	hashesMu.RLock()
+	defer hashesMu.RUnlock()
+	fn, ok := hashes[name]
+	if !ok {
+		return nil, errors.Errorf("hash %q not registered", name)
+	}
+	return fn, nil
+}
+
+// WithHash sets the registered hash used for cache versions and content
+// digests. New fails if no hash is registered as name.
+func WithHash(name string) Opt {
+	return func(c *Cache) {
+		c.Hash = name
+	}
+}
+
+func (c *Cache) hashName() string {
+	if c == nil || c.Hash == "" {
+		return DefaultHash
+	}
+	return c.Hash
+}
+
+func (c *Cache) newHash() (hash.Hash, error) {
+	fn, err := getHash(c.hashName())
+	if err != nil {
+		return nil, err
+	}
+	return fn(), nil
+}
+
+// readerDigest returns the digest of the data read from r in the form
+// "<hash>:<hex>".
+func (c *Cache) readerDigest(r io.Reader) (string, error) {
+	h, err := c.newHash()
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(h, r); err != nil {
+		return "", errors.WithStack(err)
+	}
+	return hashDigest(c.hashName(), h), nil
+}
+
+// digestHash returns a new hash of the algorithm of digest.
+func digestHash(digest string) (string, hash.Hash, error) {
+	i := strings.IndexByte(digest, ':')
+	if i < 0 {
+		return "", nil, errors.Errorf("invalid digest %q", digest)
+	}
+	fn, err := getHash(digest[:i])
+	if err != nil {
+		return "", nil, err
+	}
+	return digest[:i], fn(), nil
+}
+
+func hashDigest(name string, h hash.Hash) string {
+	return name + ":" + hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,49 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"hash"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHash(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	WithHash("sha512")(c)
+	require.NotEqual(t, version("foo"), c.version("foo"))
+
+	ctx := context.TODO()
+	dt := []byte("hashed member")
+	m, err := c.SaveMembers(ctx, "hashed", []Member{{Key: "hashed-0", Data: bytes.NewReader(dt), Size: int64(len(dt))}})
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(m.Members[0].Digest, "sha512:"), m.Members[0].Digest)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, c.FetchMembers(ctx, m, func(ManifestMember) (io.Writer, error) {
+		return buf, nil
+	}))
+	require.Equal(t, dt, buf.Bytes())
+
+	RegisterHash("test-hash", func() hash.Hash { return sha256.New() })
+	WithHash("test-hash")(c)
+	d, err := c.readerDigest(bytes.NewReader(dt))
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(d, "test-hash:"), d)
+
+	WithHash("unknown")(c)
+	require.Error(t, c.Save(ctx, "unknown-hash", bytes.NewReader(dt), int64(len(dt)), SaveDedup()))
+}
+
+func TestUnregisteredHash(t *testing.T) {
+	token := testToken(t, Scope{Scope: "refs/heads/main", Permission: PermissionRead})
+	_, err := New(token, "http://localhost/", WithHash("blake3-unregistered"))
+	require.Error(t, err)
+	_, err = NewV2(token, "http://localhost/", WithHash("blake3-unregistered"))
+	require.Error(t, err)
+}
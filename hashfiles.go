@@ -0,0 +1,239 @@
+package actionscache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// HashFiles returns the same digest as the hashFiles() expression of
+// workflows for files below GITHUB_WORKSPACE, or the working directory
+// outside of workflows, so keys derived in Go match keys of workflow steps.
+func HashFiles(patterns ...string) (string, error) {
+	root := os.Getenv("GITHUB_WORKSPACE")
+	if root == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+		root = wd
+	}
+	return HashFilesIn(root, patterns...)
+}
+
+// HashFilesIn returns the hashFiles() digest of the files below root
+// matching patterns: the hex SHA-256 of the SHA-256 digests of the files in
+// sorted order, or an empty string if no file matched. Patterns support "*",
+// "?", character classes and "**" for any number of directories. A pattern
+// matching a directory matches all files below it, patterns starting with
+// "!" exclude files and lines starting with "#" are ignored. Like in
+// workflows the last matching pattern decides if a file is hashed, so a
+// later pattern can include files an earlier "!" pattern excluded, and
+// symbolic links are followed.
+func HashFilesIn(root string, patterns ...string) (string, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	var globs []hashGlob
+	for _, p := range patterns {
+		for _, line := range strings.Split(p, "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			neg := strings.HasPrefix(line, "!")
+			line = strings.TrimPrefix(line, "!")
+			if filepath.IsAbs(line) {
+				rel, err := filepath.Rel(root, line)
+				if err != nil || strings.HasPrefix(rel, "..") {
+					// files outside of root are never hashed
+					continue
+				}
+				line = rel
+			}
+			re, err := globRegexp(filepath.ToSlash(line))
+			if err != nil {
+				return "", err
+			}
+			globs = append(globs, hashGlob{re: re, exclude: neg})
+		}
+	}
+
+	var files []string
+	if err := walkFollow(root, "", map[string]struct{}{}, func(p, rel string) {
+		if hashGlobMatch(globs, rel) {
+			files = append(files, p)
+		}
+	}); err != nil {
+		return "", err
+	}
+	if len(files) == 0 {
+		return "", nil
+	}
+	sort.Strings(files)
+	h := sha256.New()
+	for _, p := range files {
+		fh := sha256.New()
+		if err := hashFile(fh, p); err != nil {
+			return "", err
+		}
+		h.Write(fh.Sum(nil))
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// walkFollow calls fn with the path and slash separated path relative to
+// the walk root of every regular file below dir, following symbolic links.
+// Broken links are skipped. active holds the resolved directories being
+// walked to stop at links pointing to one of their parents.
+func walkFollow(dir, rel string, active map[string]struct{}, fn func(p, rel string)) error {
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if _, ok := active[resolved]; ok {
+		return nil
+	}
+	active[resolved] = struct{}{}
+	defer delete(active, resolved)
+
+	names, err := readDirNames(dir)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		p := filepath.Join(dir, name)
+		r := path.Join(rel, name)
+		fi, err := os.Stat(p)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return errors.WithStack(err)
+		}
+		switch {
+		case fi.IsDir():
+			if err := walkFollow(p, r, active, fn); err != nil {
+				return err
+			}
+		case fi.Mode().IsRegular():
+			fn(p, r)
+		}
+	}
+	return nil
+}
+
+func readDirNames(dir string) ([]string, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer f.Close()
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func hashFile(w io.Writer, p string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return errors.WithStack(err)
+}
+
+type hashGlob struct {
+	re      *regexp.Regexp
+	exclude bool
+}
+
+// hashGlobMatch reports if the last of globs matching p or one of its
+// parent directories includes it.
+func hashGlobMatch(globs []hashGlob, p string) bool {
+	match := false
+	for _, g := range globs {
+		if globMatch(g.re, p) {
+			match = !g.exclude
+		}
+	}
+	return match
+}
+
+// globMatch reports if re matches p or one of its parent directories.
+func globMatch(re *regexp.Regexp, p string) bool {
+	for {
+		if re.MatchString(p) {
+			return true
+		}
+		i := strings.LastIndexByte(p, '/')
+		if i < 0 {
+			return false
+		}
+		p = p[:i]
+	}
+}
+
+// globRegexp converts a slash separated glob pattern to a regexp.
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	pattern = strings.TrimPrefix(pattern, "./")
+	pattern = strings.TrimSuffix(pattern, "/")
+	segs := strings.Split(pattern, "/")
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i, seg := range segs {
+		last := i == len(segs)-1
+		if seg == "**" {
+			if last {
+				sb.WriteString(".*")
+			} else {
+				sb.WriteString("(?:[^/]+/)*")
+			}
+			continue
+		}
+		for j := 0; j < len(seg); j++ {
+			switch ch := seg[j]; ch {
+			case '*':
+				sb.WriteString("[^/]*")
+			case '?':
+				sb.WriteString("[^/]")
+			case '[':
+				end := strings.IndexByte(seg[j+1:], ']')
+				if end < 0 {
+					sb.WriteString(`\[`)
+					continue
+				}
+				class := seg[j+1 : j+1+end]
+				if strings.HasPrefix(class, "!") {
+					class = "^" + class[1:]
+				}
+				sb.WriteString("[" + strings.Replace(class, `\`, `\\`, -1) + "]")
+				j += end + 1
+			default:
+				sb.WriteString(regexp.QuoteMeta(string(ch)))
+			}
+		}
+		if !last {
+			sb.WriteString("/")
+		}
+	}
+	sb.WriteString("$")
+	re, err := regexp.Compile(sb.String())
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid pattern %q", pattern)
+	}
+	return re, nil
+}
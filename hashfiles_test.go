@@ -0,0 +1,106 @@
+package actionscache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.sum":             "root",
+		"a/go.sum":           "a",
+		"a/b/go.sum":         "b",
+		"a/b/main.go":        "main",
+		"vendor/x/go.sum":    "vendor",
+		"docs/readme.md":     "docs",
+		"docs/ref/readme.md": "ref",
+	}
+	for p, dt := range files {
+		p = filepath.Join(dir, p)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, ioutil.WriteFile(p, []byte(dt), 0644))
+	}
+	expected := func(names ...string) string {
+		h := sha256.New()
+		for _, n := range names {
+			fh := sha256.Sum256([]byte(files[n]))
+			h.Write(fh[:])
+		}
+		return hex.EncodeToString(h.Sum(nil))
+	}
+
+	sum, err := HashFilesIn(dir, "**/go.sum")
+	require.NoError(t, err)
+	require.Equal(t, expected("a/b/go.sum", "a/go.sum", "go.sum", "vendor/x/go.sum"), sum)
+
+	sum, err = HashFilesIn(dir, "**/go.sum", "!vendor/**")
+	require.NoError(t, err)
+	require.Equal(t, expected("a/b/go.sum", "a/go.sum", "go.sum"), sum)
+
+	sum, err = HashFilesIn(dir, "docs")
+	require.NoError(t, err)
+	require.Equal(t, expected("docs/readme.md", "docs/ref/readme.md"), sum)
+
+	sum, err = HashFilesIn(dir, "a/*/*.go\n# comment", filepath.Join(dir, "go.su[m]"))
+	require.NoError(t, err)
+	require.Equal(t, expected("a/b/main.go", "go.sum"), sum)
+
+	// the last matching pattern wins
+	sum, err = HashFilesIn(dir, "**/go.sum", "!**/go.sum", "a/go.sum")
+	require.NoError(t, err)
+	require.Equal(t, expected("a/go.sum"), sum)
+
+	sum, err = HashFilesIn(dir, "*.lock")
+	require.NoError(t, err)
+	require.Equal(t, "", sum)
+
+	defer os.Setenv("GITHUB_WORKSPACE", os.Getenv("GITHUB_WORKSPACE"))
+	os.Setenv("GITHUB_WORKSPACE", dir)
+	sum, err = HashFiles("go.sum")
+	require.NoError(t, err)
+	require.Equal(t, expected("go.sum"), sum)
+}
+
+func TestHashFilesSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "real", "sub"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "real", "sub", "go.sum"), []byte("sum"), 0644))
+	require.NoError(t, os.Symlink(filepath.Join(dir, "real"), filepath.Join(dir, "link")))
+	require.NoError(t, os.Symlink(filepath.Join(dir, "real", "sub", "go.sum"), filepath.Join(dir, "go.sum")))
+	// links to a parent and broken links are skipped
+	require.NoError(t, os.Symlink(dir, filepath.Join(dir, "real", "loop")))
+	require.NoError(t, os.Symlink(filepath.Join(dir, "missing"), filepath.Join(dir, "broken")))
+
+	fh := sha256.Sum256([]byte("sum"))
+	h := sha256.New()
+	h.Write(fh[:])
+	h.Write(fh[:])
+	sum, err := HashFilesIn(dir, "link/**", "go.sum", "broken")
+	require.NoError(t, err)
+	require.Equal(t, hex.EncodeToString(h.Sum(nil)), sum)
+}
+
+// TestHashFilesDigest compares against the digest of hashFiles('go.mod',
+// 'go.sum') for the same files, computed with
+// sha256sum go.mod go.sum | cut -d' ' -f1 | xxd -r -p | sha256sum.
+func TestHashFilesDigest(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "src"), 0755))
+	for p, dt := range map[string]string{
+		"go.mod":      "module example.com/m\n\ngo 1.16\n",
+		"go.sum":      "example.com/x v1.0.0 h1:abc=\n",
+		"src/main.go": "package main\n",
+	} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, p), []byte(dt), 0644))
+	}
+	sum, err := HashFilesIn(dir, "go.mod\ngo.sum")
+	require.NoError(t, err)
+	require.Equal(t, "49e258e3b3b7f5c83396ba201c9c6e4f11c97c4984c640b5c57965694c76d417", sum)
+}
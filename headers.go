@@ -0,0 +1,57 @@
+package actionscache
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// ResponseHeaders are the response headers exposed on Entry.Headers and
+// through the SaveHeaders option.
+var ResponseHeaders = []string{
+	"Content-Length",
+	"Retry-After",
+	"X-GitHub-Request-Id",
+	"X-MS-Request-Id",
+	"X-MSEdge-Ref",
+	"X-RateLimit-Limit",
+	"X-RateLimit-Remaining",
+	"X-RateLimit-Reset",
+	"X-RateLimit-Resource",
+	"X-TFS-Session",
+	"X-VSS-E2EID",
+}
+
+// SaveHeaders fills h with the ResponseHeaders of the requests made by Save.
+// Values of later requests replace the ones of earlier requests.
+func SaveHeaders(h http.Header) SaveOpt {
+	return func(o *saveOpt) {
+		o.headers = h
+	}
+}
+
+type headerSink struct {
+	mu sync.Mutex
+	h  http.Header
+}
+
+type headerSinkKey struct{}
+
+func withHeaderSink(ctx context.Context, h http.Header) context.Context {
+	return context.WithValue(ctx, headerSinkKey{}, &headerSink{h: h})
+}
+
+// recordHeaders copies the ResponseHeaders of resp to the sink of ctx.
+func recordHeaders(ctx context.Context, resp *http.Response) {
+	s, ok := ctx.Value(headerSinkKey{}).(*headerSink)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range ResponseHeaders {
+		if v := resp.Header.Values(k); len(v) > 0 {
+			s.h[http.CanonicalHeaderKey(k)] = append([]string(nil), v...)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseHeaders(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+
+	ctx := context.TODO()
+	dt := []byte("foobar")
+	h := http.Header{}
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt)), SaveHeaders(h)))
+	// reserve, upload, commit
+	require.Equal(t, "3", h.Get("X-GitHub-Request-Id"))
+	require.Empty(t, h.Get("Content-Type"))
+
+	ce, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	require.Equal(t, "4", ce.Headers.Get("X-GitHub-Request-Id"))
+}
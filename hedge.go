@@ -0,0 +1,70 @@
+package actionscache
+
+import (
+	"context"
+	"time"
+)
+
+// WithHedgedLoads makes the lookups of Load send a second identical request
+// when the first has not returned after delay, using whichever result
+// arrives first. It cuts the latency of lookups hitting a slow node of the
+// service at the cost of extra requests. Zero disables hedging.
+func WithHedgedLoads(delay time.Duration) Opt {
+	return func(c *Cache) {
+		c.HedgeDelay = delay
+	}
+}
+
+type hedgeResult struct {
+	ce  *Entry
+	err error
+}
+
+// hedged calls lookup, and again after HedgeDelay if the first call has not
+// returned yet. The first successful result is returned and the other call
+// is canceled. If all calls fail the first error is returned.
+func (c *Cache) hedged(ctx context.Context, lookup func(context.Context) (*Entry, error)) (*Entry, error) {
+	if c.HedgeDelay <= 0 {
+		return lookup(ctx)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan hedgeResult, 2)
+	send := func() {
+		ce, err := lookup(ctx)
+		results <- hedgeResult{ce, err}
+	}
+	go send()
+	hedge := make(chan struct{})
+	stop := c.afterFunc(c.HedgeDelay, func() { close(hedge) })
+	defer stop()
+
+	pending := 1
+	var first error
+	for {
+		select {
+		case <-hedge:
+			hedge = nil
+			pending++
+			c.debug(ctx, "load cache: lookup slow, sending hedged request", F("delay", c.HedgeDelay))
+			go send()
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				// the canceled call must not record its headers later
+				cancel()
+				for ; pending > 0; pending-- {
+					<-results
+				}
+				return res.ce, nil
+			}
+			if first == nil {
+				first = res.err
+			}
+			// a lookup failing before the hedge is sent is not hedged
+			if pending == 0 {
+				return nil, first
+			}
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// stallingTransport never answers the first lookup, until it is canceled.
+type stallingTransport struct {
+	lookups  int32
+	canceled int32
+}
+
+func (t *stallingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.HasSuffix(req.URL.Path, "/cache") || strings.HasSuffix(req.URL.Path, "/GetCacheEntryDownloadURL") {
+		if atomic.AddInt32(&t.lookups, 1) == 1 {
+			<-req.Context().Done()
+			atomic.AddInt32(&t.canceled, 1)
+			return nil, req.Context().Err()
+		}
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestHedgedLoad(t *testing.T) {
+	ts := newTestServer(t)
+	ctx := context.TODO()
+	for _, v2 := range []bool{false, true} {
+		c := ts.newCache(t)
+		if v2 {
+			c = ts.newCacheV2(t)
+		}
+		key := "hedged-" + c.Protocol()
+		require.NoError(t, c.Save(ctx, key, bytes.NewReader([]byte("foo")), 3))
+
+		tr := &stallingTransport{}
+		c.HTTPClient = &http.Client{Transport: tr}
+		WithHedgedLoads(10 * time.Millisecond)(c)
+		ce, err := c.Load(ctx, key)
+		require.NoError(t, err)
+		require.NotNil(t, ce)
+		require.Equal(t, key, ce.Key)
+		require.Equal(t, int32(2), atomic.LoadInt32(&tr.lookups))
+		require.Equal(t, int32(1), atomic.LoadInt32(&tr.canceled))
+	}
+
+	// fast lookups are not hedged
+	c := ts.newCache(t)
+	tr := &countingTransport{}
+	c.HTTPClient = &http.Client{Transport: tr}
+	WithHedgedLoads(time.Minute)(c)
+	ce, err := c.Load(ctx, "hedged-missing")
+	require.NoError(t, err)
+	require.Nil(t, ce)
+	// one hedged lookup of the v1 cache reached the server before
+	require.Equal(t, 2, ts.count("GET /_apis/artifactcache/cache"))
+}
@@ -0,0 +1,22 @@
+//go:build go1.24
+// +build go1.24
+
+package actionscache
+
+import (
+	"net/http"
+	"time"
+)
+
+// configureHTTP2 makes tr ping HTTP/2 connections that received no frame
+// for keepAlive and close them if the ping is not answered within
+// keepAlive.
+func configureHTTP2(tr *http.Transport, keepAlive time.Duration) {
+	if keepAlive <= 0 {
+		return
+	}
+	tr.HTTP2 = &http.HTTP2Config{
+		SendPingTimeout: keepAlive,
+		PingTimeout:     keepAlive,
+	}
+}
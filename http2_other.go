@@ -0,0 +1,13 @@
+//go:build !go1.24
+// +build !go1.24
+
+package actionscache
+
+import (
+	"net/http"
+	"time"
+)
+
+// configureHTTP2 is a no-op, http.Transport can only send HTTP/2 pings
+// since Go 1.24.
+func configureHTTP2(tr *http.Transport, keepAlive time.Duration) {}
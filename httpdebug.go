@@ -0,0 +1,83 @@
+package actionscache
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// redactedHeaders are replaced by "REDACTED" in HTTP debug logs.
+var redactedHeaders = []string{"Authorization", "X-Ms-Copy-Source-Authorization"}
+
+// redactedParams are query parameters of signed URLs replaced by
+// "REDACTED" in HTTP debug logs.
+var redactedParams = []string{"sig", "token"}
+
+// WithHTTPDebug logs every request with its URL, headers, response status,
+// request IDs and duration at debug level. Credentials are redacted.
+func WithHTTPDebug() Opt {
+	return func(c *Cache) {
+		c.DebugHTTP = true
+	}
+}
+
+// logHTTP logs a request sent at start when HTTP debugging is enabled.
+func (c *Cache) logHTTP(req *http.Request, resp *http.Response, err error, start time.Time) {
+	if c == nil || !c.DebugHTTP {
+		return
+	}
+	fields := []Field{
+		F("method", req.Method),
+		F("url", redactQuery(req.URL)),
+		F("requestHeaders", redactHeaders(req.Header)),
+		F("duration", c.clock().Now().Sub(start)),
+	}
+	if err != nil {
+		fields = append(fields, F("error", err))
+	}
+	if resp != nil {
+		fields = append(fields, F("status", resp.StatusCode))
+		ids := map[string]string{}
+		for _, k := range ResponseHeaders {
+			if v := resp.Header.Get(k); v != "" {
+				ids[k] = v
+			}
+		}
+		fields = append(fields, F("responseHeaders", ids))
+	}
+	c.debug(req.Context(), "http request", fields...)
+}
+
+// redactQuery returns u with the values of signature parameters redacted.
+func redactQuery(u *url.URL) string {
+	q := u.Query()
+	changed := false
+	for k := range q {
+		for _, p := range redactedParams {
+			if strings.EqualFold(k, p) {
+				q.Set(k, "REDACTED")
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return u.String()
+	}
+	u2 := *u
+	u2.RawQuery = q.Encode()
+	return u2.String()
+}
+
+func redactHeaders(h http.Header) map[string]string {
+	out := make(map[string]string, len(h))
+	for k, v := range h {
+		out[k] = strings.Join(v, ", ")
+	}
+	for _, k := range redactedHeaders {
+		if _, ok := out[http.CanonicalHeaderKey(k)]; ok {
+			out[http.CanonicalHeaderKey(k)] = "REDACTED"
+		}
+	}
+	return out
+}
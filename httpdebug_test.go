@@ -0,0 +1,39 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPDebug(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	l := &recordingLogger{}
+	WithLogger(l)(c)
+
+	ctx := context.TODO()
+	dt := []byte("foobar")
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+	require.Nil(t, l.find("http request"))
+
+	WithHTTPDebug()(c)
+	_, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	e := l.find("http request")
+	require.NotNil(t, e)
+	require.Equal(t, "GET", e.fields["method"])
+	require.Contains(t, e.fields["url"], "keys=foo")
+	require.Equal(t, 200, e.fields["status"])
+	require.Equal(t, "REDACTED", e.fields["requestHeaders"].(map[string]string)["Authorization"])
+	require.NotEmpty(t, e.fields["responseHeaders"].(map[string]string)["X-GitHub-Request-Id"])
+}
+
+func TestRedactQuery(t *testing.T) {
+	u, err := url.Parse("https://blob.example.com/foo?sv=2020-04-08&sig=secret&se=2021")
+	require.NoError(t, err)
+	require.Equal(t, "https://blob.example.com/foo?se=2021&sig=REDACTED&sv=2020-04-08", redactQuery(u))
+}
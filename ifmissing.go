@@ -0,0 +1,30 @@
+package actionscache
+
+import "context"
+
+// SaveIfMissing skips the save without reserving the key or uploading when
+// an entry of the exact key already exists, eg. in matrix builds where all
+// jobs produce the same content. A save racing with another job still
+// fails to reserve unless SaveIgnoreAlreadyExists is set too.
+func SaveIfMissing() SaveOpt {
+	return func(o *saveOpt) {
+		o.ifMissing = true
+	}
+}
+
+func existenceChecked(o *saveOpt) {
+	o.ifMissing = false
+}
+
+// exists reports if an entry of the exact key exists.
+func (c *Cache) exists(ctx context.Context, key string) (bool, error) {
+	ce, err := c.load(ctx, []string{key}, nil)
+	if err != nil {
+		return false, err
+	}
+	if ce == nil || ce.Key != key {
+		return false, nil
+	}
+	c.info(ctx, "save cache: entry exists, skipping", F("key", key))
+	return true, nil
+}
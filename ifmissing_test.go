@@ -0,0 +1,32 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaveIfMissing(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+
+	ctx := context.TODO()
+	dt := []byte("foobar")
+	require.NoError(t, c.Save(ctx, "foo-1", bytes.NewReader(dt), int64(len(dt)), SaveIfMissing()))
+	require.Equal(t, 1, ts.count("PATCH "))
+
+	require.NoError(t, c.Save(ctx, "foo-1", bytes.NewReader(dt), int64(len(dt)), SaveIfMissing()))
+	w, err := c.SaveWriter(ctx, "foo-1", SaveIfMissing())
+	require.NoError(t, err)
+	_, err = w.Write(dt)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.Equal(t, 1, ts.count("PATCH "))
+
+	// a prefix match does not count as existing
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt)), SaveIfMissing()))
+	require.Equal(t, 2, ts.count("PATCH "))
+	require.Equal(t, 4, ts.count("GET /_apis/artifactcache/cache"))
+}
@@ -0,0 +1,153 @@
+// Package cachestore implements the entry bookkeeping of the in-memory cache
+// servers used in tests: reservations, commits and key lookups.
+package cachestore
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Entry is a reserved upload or, once committed, a saved cache entry.
+type Entry struct {
+	Key     string
+	Version string
+	Data    []byte
+	Created time.Time
+	Blocks  map[string][]byte
+}
+
+type entryKey struct {
+	key     string
+	version string
+}
+
+// Store keeps uploads and entries in memory. It is not safe for concurrent
+// use; servers serialize access with their own lock.
+type Store struct {
+	// Now returns the creation time of committed entries, time.Now if nil.
+	Now func() time.Time
+
+	nextID  int
+	uploads map[int]*Entry
+	entries map[entryKey]*Entry
+}
+
+// New returns an empty Store.
+func New() *Store {
+	return &Store{
+		uploads: map[int]*Entry{},
+		entries: map[entryKey]*Entry{},
+	}
+}
+
+// Find returns the entry of version matching the first key that matches,
+// preferring an exact match over the newest entry with the key as prefix.
+func (s *Store) Find(version string, keys ...string) *Entry {
+	for _, k := range keys {
+		if e, ok := s.entries[entryKey{k, version}]; ok {
+			return e
+		}
+		var found *Entry
+		for _, e := range s.entries {
+			if e.Version == version && strings.HasPrefix(e.Key, k) && (found == nil || e.Created.After(found.Created)) {
+				found = e
+			}
+		}
+		if found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// Get returns the entry of key and version.
+func (s *Store) Get(key, version string) *Entry {
+	return s.entries[entryKey{key, version}]
+}
+
+// Latest returns the newest entry of key in any version.
+func (s *Store) Latest(key string) *Entry {
+	var found *Entry
+	for _, e := range s.entries {
+		if e.Key == key && (found == nil || e.Created.After(found.Created)) {
+			found = e
+		}
+	}
+	return found
+}
+
+// Reserve starts an upload of key unless it is saved or being uploaded.
+func (s *Store) Reserve(key, version string) (int, bool) {
+	if _, ok := s.entries[entryKey{key, version}]; ok {
+		return 0, false
+	}
+	for _, u := range s.uploads {
+		if u.Key == key && u.Version == version {
+			return 0, false
+		}
+	}
+	s.nextID++
+	s.uploads[s.nextID] = &Entry{Key: key, Version: version}
+	return s.nextID, true
+}
+
+// Upload returns the pending upload of id.
+func (s *Store) Upload(id int) (*Entry, bool) {
+	u, ok := s.uploads[id]
+	return u, ok
+}
+
+// UploadOf returns the pending upload of key and version.
+func (s *Store) UploadOf(key, version string) (int, *Entry, bool) {
+	for id, u := range s.uploads {
+		if u.Key == key && u.Version == version {
+			return id, u, true
+		}
+	}
+	return 0, nil, false
+}
+
+// Expire drops the pending upload of id like an expired reservation.
+func (s *Store) Expire(id int) {
+	delete(s.uploads, id)
+}
+
+// Commit makes the upload of id a saved entry.
+func (s *Store) Commit(id int) {
+	u, ok := s.uploads[id]
+	if !ok {
+		return
+	}
+	if s.Now != nil {
+		u.Created = s.Now()
+	} else {
+		u.Created = time.Now()
+	}
+	u.Blocks = nil
+	s.entries[entryKey{u.Key, u.Version}] = u
+	delete(s.uploads, id)
+}
+
+// Delete removes the entries of key in every version.
+func (s *Store) Delete(key string) {
+	for k := range s.entries {
+		if k.key == key {
+			delete(s.entries, k)
+		}
+	}
+}
+
+// Keys returns the sorted keys of the saved entries.
+func (s *Store) Keys() []string {
+	seen := map[string]struct{}{}
+	keys := make([]string, 0, len(s.entries))
+	for k := range s.entries {
+		if _, ok := seen[k.key]; !ok {
+			seen[k.key] = struct{}{}
+			keys = append(keys, k.key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,142 @@
+// Package errors defines the errors shared by the cache client and the REST
+// API client. The root package re-exports them.
+package errors
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrCacheNotFound      = errors.New("cache not found")
+	ErrCacheAlreadyExists = errors.New("cache already exists")
+	ErrReserveConflict    = errors.New("cache reservation conflict")
+	ErrTooManyRequests    = errors.New("too many requests")
+	ErrCacheSizeExceeded  = errors.New("cache size exceeded")
+	ErrCrossTenant        = errors.New("cross-tenant cache access")
+	ErrServiceUnavailable = errors.New("cache service unavailable")
+	ErrUnexpectedResponse = errors.New("unexpected cache service response")
+)
+
+// maxSnippet limits the length of the body snippets of errors.
+const maxSnippet = 256
+
+// DefaultErrorBodyLimit is the number of bytes of error responses read by
+// CheckResponse.
+const DefaultErrorBodyLimit = 32 * 1024
+
+var (
+	htmlTagRe    = regexp.MustCompile(`(?s)<(script|style)[^>]*>.*?</(script|style)>|<[^>]*>`)
+	whitespaceRe = regexp.MustCompile(`\s+`)
+)
+
+// GithubAPIError is an error response of the cache service or the REST API.
+type GithubAPIError struct {
+	StatusCode int    `json:"-"`
+	Message    string `json:"message"`
+	TypeName   string `json:"typeName"`
+	TypeKey    string `json:"typeKey"`
+	ErrorCode  int    `json:"errorCode"`
+	// Body is a snippet of a response body that is not a JSON error, eg. an
+	// HTML error page served during an incident.
+	Body string `json:"-"`
+	// RawBody is the response body as it was read, only kept by clients in
+	// verbose mode for debugging.
+	RawBody []byte `json:"-"`
+}
+
+func (e *GithubAPIError) Error() string {
+	if e.Body != "" {
+		return fmt.Sprintf("unexpected response %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Body)
+	}
+	msg := e.Message
+	if msg == "" {
+		msg = http.StatusText(e.StatusCode)
+	}
+	if e.TypeKey != "" {
+		return fmt.Sprintf("%s: %s (%d)", e.TypeKey, msg, e.StatusCode)
+	}
+	return fmt.Sprintf("%s (%d)", msg, e.StatusCode)
+}
+
+func (e *GithubAPIError) Is(target error) bool {
+	switch target {
+	case ErrCacheNotFound:
+		return e.StatusCode == http.StatusNotFound || strings.Contains(e.TypeKey, "NotFound")
+	case ErrCacheAlreadyExists:
+		return strings.Contains(e.TypeKey, "AlreadyExists")
+	case ErrReserveConflict:
+		return e.StatusCode == http.StatusConflict || strings.Contains(e.TypeKey, "AlreadyExists")
+	case ErrTooManyRequests:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrCacheSizeExceeded:
+		return e.StatusCode == http.StatusRequestEntityTooLarge || strings.Contains(e.TypeKey, "SizeExceeded")
+	case ErrServiceUnavailable:
+		return e.StatusCode == http.StatusBadGateway || e.StatusCode == http.StatusServiceUnavailable || e.StatusCode == http.StatusGatewayTimeout
+	case ErrUnexpectedResponse:
+		return e.Body != ""
+	}
+	return false
+}
+
+// CheckResponse returns a *GithubAPIError for a non-2xx response. The body
+// is consumed in that case.
+func CheckResponse(resp *http.Response) error {
+	return CheckResponseBody(resp, DefaultErrorBodyLimit, false)
+}
+
+// CheckResponseBody is CheckResponse reading up to limit bytes of the body,
+// and keeping them as the RawBody of the error if raw is set.
+func CheckResponseBody(resp *http.Response, limit int64, raw bool) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	dt, _ := ioutil.ReadAll(io.LimitReader(resp.Body, limit))
+	e := &GithubAPIError{}
+	if err := json.Unmarshal(dt, e); err != nil || (e.Message == "" && e.TypeKey == "") {
+		e = &GithubAPIError{Body: Snippet(dt, resp.Header.Get("Content-Type"))}
+	}
+	e.StatusCode = resp.StatusCode
+	if raw {
+		e.RawBody = dt
+	}
+	return e
+}
+
+// Snippet returns the start of a response body for an error message. Tags
+// of HTML pages are removed and whitespace is collapsed.
+func Snippet(dt []byte, contentType string) string {
+	s := string(dt)
+	if strings.Contains(contentType, "html") || strings.HasPrefix(strings.TrimSpace(s), "<") {
+		s = htmlTagRe.ReplaceAllString(s, " ")
+		s = html.UnescapeString(s)
+	}
+	s = strings.TrimSpace(whitespaceRe.ReplaceAllString(s, " "))
+	if len(s) > maxSnippet {
+		s = strings.ToValidUTF8(s[:maxSnippet], "") + "..."
+	}
+	return s
+}
+
+// CrossTenantError is returned when the tenant attached to the context of
+// an operation is not the tenant of the client.
+type CrossTenantError struct {
+	Tenant    string
+	Requested string
+}
+
+func (e *CrossTenantError) Error() string {
+	return fmt.Sprintf("cache of tenant %q used for tenant %q", e.Tenant, e.Requested)
+}
+
+func (e *CrossTenantError) Is(target error) bool {
+	return target == ErrCrossTenant
+}
@@ -0,0 +1,94 @@
+// Package policy defines the retry and failure policies shared by the cache
+// client and the REST API client. The root package re-exports them.
+package policy
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy controls how requests failing with network errors, 429 or 5xx
+// responses are retried.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts including the first one.
+	MaxAttempts int
+	// MinBackoff is the delay before the first retry. It doubles for every
+	// following retry up to MaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Jitter randomizes each delay by up to this fraction of it.
+	Jitter float64
+	// AttemptTimeout cancels each attempt whose response does not arrive
+	// in time when positive, so a hanging attempt leaves time for the
+	// retries. Attempts never run past the deadline of the request context.
+	AttemptTimeout time.Duration
+}
+
+// Backoff returns the delay before retrying after the given attempt.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	d := p.MinBackoff
+	for i := 1; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(d))
+	}
+	return d
+}
+
+// Attempt returns the context of an attempt limited to the AttemptTimeout
+// of p, for requests whose response is read before the attempt ends.
+func (p RetryPolicy) Attempt(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p.AttemptTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, p.AttemptTimeout)
+}
+
+// Fits reports if a retry after a delay of d starts before the deadline of
+// ctx. Retries that would start after it are not made, the last failure is
+// returned instead of sleeping into the deadline.
+func Fits(ctx context.Context, d time.Duration) bool {
+	deadline, ok := ctx.Deadline()
+	return !ok || time.Until(deadline) > d
+}
+
+// OperationPolicy configures failure handling for one type of operation,
+// eg. aggressive retries for restores and giving up quickly on saves.
+type OperationPolicy struct {
+	// RetryPolicy overrides the retry policy of the client for the requests
+	// of the operation when set.
+	RetryPolicy *RetryPolicy
+	// Timeout cancels the operation when it takes longer. Zero means no
+	// limit besides the timeouts of the client.
+	Timeout time.Duration
+	// BestEffort reports failures as success, a cache miss for loads.
+	// Cross-tenant errors are always returned.
+	BestEffort bool
+}
+
+type retryPolicyKey struct{}
+
+// With applies the retry policy and timeout of p to ctx.
+func With(ctx context.Context, p *OperationPolicy) (context.Context, context.CancelFunc) {
+	if p == nil {
+		return ctx, func() {}
+	}
+	if p.RetryPolicy != nil {
+		ctx = context.WithValue(ctx, retryPolicyKey{}, p.RetryPolicy)
+	}
+	if p.Timeout > 0 {
+		return context.WithTimeout(ctx, p.Timeout)
+	}
+	return ctx, func() {}
+}
+
+// RetryFromContext returns the retry policy applied to ctx by With.
+func RetryFromContext(ctx context.Context) (*RetryPolicy, bool) {
+	p, ok := ctx.Value(retryPolicyKey{}).(*RetryPolicy)
+	return p, ok
+}
@@ -0,0 +1,32 @@
+// Package tenant attaches the tenant of an operation to its context.
+package tenant
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	apierrors "github.com/tonistiigi/go-actions-cache/internal/errors"
+)
+
+type key struct{}
+
+// With returns a context for operations on behalf of tenant.
+func With(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, key{}, tenant)
+}
+
+// FromContext returns the tenant attached to ctx with With.
+func FromContext(ctx context.Context) (string, bool) {
+	tenant, ok := ctx.Value(key{}).(string)
+	return tenant, ok
+}
+
+// Check returns a *CrossTenantError if ctx carries a tenant other than
+// owner.
+func Check(ctx context.Context, owner string) error {
+	tenant, ok := FromContext(ctx)
+	if !ok || tenant == owner {
+		return nil
+	}
+	return errors.WithStack(&apierrors.CrossTenantError{Tenant: owner, Requested: tenant})
+}
@@ -0,0 +1,46 @@
+package actionscache
+
+import (
+	"context"
+	"fmt"
+)
+
+// SaveInterruptedError is returned by Save and the SaveWriter when their
+// context is canceled after the cache was reserved. It reports the progress
+// made so callers can decide whether to retry or give up on the entry.
+type SaveInterruptedError struct {
+	Key     string
+	CacheID int
+	// Size is the size of the entry, or the bytes written so far for a
+	// SaveWriter.
+	Size int64
+	// Uploaded is the number of bytes acknowledged by the service.
+	Uploaded int64
+	// Chunks is the number of chunks acknowledged by the service.
+	Chunks int
+	// Err is the error of the context.
+	Err error
+}
+
+func (e *SaveInterruptedError) Error() string {
+	return fmt.Sprintf("save of cache %d for key %s interrupted after %d/%d bytes in %d chunks: %v", e.CacheID, e.Key, e.Uploaded, e.Size, e.Chunks, e.Err)
+}
+
+func (e *SaveInterruptedError) Unwrap() error {
+	return e.Err
+}
+
+// interrupted returns a *SaveInterruptedError for err if ctx was canceled.
+func interrupted(ctx context.Context, err error, key string, id int, size int64, acked *rangeSet) error {
+	if ctx.Err() == nil {
+		return err
+	}
+	return &SaveInterruptedError{
+		Key:      key,
+		CacheID:  id,
+		Size:     size,
+		Uploaded: acked.covered(size),
+		Chunks:   len(acked.ranges),
+		Err:      ctx.Err(),
+	}
+}
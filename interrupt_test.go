@@ -0,0 +1,48 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaveInterrupted(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	c.UploadConcurrency = 1
+	c.UploadChunkSize = 4
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	patches := 0
+	ts.fail = func(r *http.Request) int {
+		if r.Method == "PATCH" && strings.Contains(r.URL.Path, "/caches/") {
+			patches++
+			if patches == 2 {
+				cancel()
+				return http.StatusInternalServerError
+			}
+		}
+		return 0
+	}
+
+	dt := []byte("0123456789")
+	err := c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt)))
+	var ie *SaveInterruptedError
+	require.True(t, errors.As(err, &ie), "%+v", err)
+	require.Equal(t, "foo", ie.Key)
+	require.Equal(t, 1, ie.CacheID)
+	require.Equal(t, int64(10), ie.Size)
+	require.Equal(t, int64(4), ie.Uploaded)
+	require.Equal(t, 1, ie.Chunks)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Len(t, c.Stats().Orphaned, 1)
+
+	ts.fail = nil
+	require.NoError(t, c.Save(context.TODO(), "bar", bytes.NewReader(dt), int64(len(dt))))
+}
@@ -0,0 +1,120 @@
+package actionscache
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// MaxKeyLength is the longest key the cache service accepts.
+const MaxKeyLength = 512
+
+// MaxLookupKeys is the most keys sent in one lookup, the limit of the cache
+// service. Load looks up longer lists of restore keys, or lists too long
+// for a URL, in batches in order, so the first match still wins. Zero or
+// less only splits lists too long for a URL.
+var MaxLookupKeys = 10
+
+// ErrInvalidKey is matched by errors of keys the service would reject.
+var ErrInvalidKey = errors.New("invalid cache key")
+
+// InvalidKeyError is returned by Load and Save for keys the service would
+// reject.
+type InvalidKeyError struct {
+	Key    string
+	Reason string
+}
+
+func (e *InvalidKeyError) Error() string {
+	return fmt.Sprintf("invalid cache key %q: %s", e.Key, e.Reason)
+}
+
+func (e *InvalidKeyError) Is(target error) bool {
+	return target == ErrInvalidKey
+}
+
+// ValidateKey returns an *InvalidKeyError if the service would reject key.
+// Commas are allowed, they are escaped as "%2C" on the wire. Keys that
+// contain "%2C" themselves are not told apart from keys with commas.
+func ValidateKey(key string) error {
+	switch {
+	case key == "":
+		return errors.WithStack(&InvalidKeyError{Key: key, Reason: "key is empty"})
+	case len(escapeKey(key)) > MaxKeyLength:
+		return errors.WithStack(&InvalidKeyError{Key: key, Reason: fmt.Sprintf("key is longer than %d characters", MaxKeyLength)})
+	}
+	return nil
+}
+
+// validateKeys returns an *InvalidKeyError if keys is empty or the service
+// would reject one of them.
+func validateKeys(keys []string) error {
+	if len(keys) == 0 {
+		return errors.WithStack(&InvalidKeyError{Reason: "no keys"})
+	}
+	for _, k := range keys {
+		if err := ValidateKey(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// escapeKey encodes commas that would split the keys of a lookup. Keys
+// without commas are sent as they are so existing entries stay reachable,
+// and prefixes of a key are encoded like the key. Percent signs are not
+// encoded, so a key containing "%2C" is stored like the same key with
+// commas instead and is read back with commas.
+func escapeKey(key string) string {
+	return strings.ReplaceAll(key, ",", "%2C")
+}
+
+func unescapeKey(key string) string {
+	return strings.ReplaceAll(key, "%2C", ",")
+}
+
+// maxLookupQueryLength limits the encoded keys of one lookup, far below the
+// URL limits of common proxies. It always fits several keys of MaxKeyLength.
+const maxLookupQueryLength = 4096
+
+// WithMaxLookupKeys sets the most keys sent in one lookup, a negative n
+// sends all keys in one lookup.
+func WithMaxLookupKeys(n int) Opt {
+	return func(c *Cache) {
+		c.MaxLookupKeys = n
+	}
+}
+
+func (c *Cache) maxLookupKeys() int {
+	if c != nil && c.MaxLookupKeys != 0 {
+		return c.MaxLookupKeys
+	}
+	return MaxLookupKeys
+}
+
+// lookupBatches splits keys into the batches of a lookup, each of at most
+// max keys and maxLookupQueryLength encoded bytes. max is not checked when
+// zero or less.
+func lookupBatches(keys []string, max int) [][]string {
+	var out [][]string
+	start, n := 0, 0
+	for i, k := range keys {
+		l := len(url.QueryEscape(escapeKey(k))) + len("%2C")
+		if i > start && ((max > 0 && i-start >= max) || n+l > maxLookupQueryLength) {
+			out = append(out, keys[start:i:i])
+			start, n = i, 0
+		}
+		n += l
+	}
+	return append(out, keys[start:len(keys):len(keys)])
+}
+
+func escapeKeys(keys []string) []string {
+	out := make([]string, len(keys))
+	for i, k := range keys {
+		out[i] = escapeKey(k)
+	}
+	return out
+}
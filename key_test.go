@@ -0,0 +1,116 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyValidation(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	ctx := context.TODO()
+	dt := []byte("foobar")
+
+	long := strings.Repeat("a", MaxKeyLength+1)
+	require.ErrorIs(t, c.Save(ctx, long, bytes.NewReader(dt), int64(len(dt))), ErrInvalidKey)
+	_, err := c.SaveWriter(ctx, "")
+	require.ErrorIs(t, err, ErrInvalidKey)
+	_, err = c.Load(ctx, "foo", long)
+	require.ErrorIs(t, err, ErrInvalidKey)
+	_, err = c.Load(ctx)
+	require.ErrorIs(t, err, ErrInvalidKey)
+	require.Equal(t, 0, ts.count(""))
+
+	// commas count towards the length once escaped
+	require.Error(t, ValidateKey(strings.Repeat(",", MaxKeyLength/2)))
+	require.NoError(t, ValidateKey(strings.Repeat("a", MaxKeyLength)))
+}
+
+func TestKeyWithComma(t *testing.T) {
+	for _, v2 := range []bool{false, true} {
+		ts := newTestServer(t)
+		c := ts.newCache(t)
+		if v2 {
+			c = ts.newCacheV2(t)
+		}
+		ctx := context.TODO()
+		dt := []byte("foobar")
+		require.NoError(t, c.Save(ctx, "os=linux,arch=amd64", bytes.NewReader(dt), int64(len(dt))))
+
+		ce, err := c.Load(ctx, "os=linux,arch=arm64", "os=linux,")
+		require.NoError(t, err)
+		require.NotNil(t, ce)
+		require.Equal(t, "os=linux,arch=amd64", ce.Key)
+
+		ce, err = c.Load(ctx, "os=linux")
+		require.NoError(t, err)
+		require.NotNil(t, ce)
+		ce, err = c.Load(ctx, "arch=amd64")
+		require.NoError(t, err)
+		require.Nil(t, ce)
+	}
+}
+
+func TestKeyEscape(t *testing.T) {
+	// keys without commas are sent as they are
+	for _, k := range []string{"a", "100%", "a%25b", "%"} {
+		require.Equal(t, k, escapeKey(k))
+	}
+	for _, k := range []string{"a,b", "a%,b", ","} {
+		require.Equal(t, k, unescapeKey(escapeKey(k)))
+	}
+
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	ctx := context.TODO()
+	dt := []byte("foobar")
+	require.NoError(t, c.Save(ctx, "100%", bytes.NewReader(dt), int64(len(dt))))
+	require.NotNil(t, ts.entry("100%"))
+	require.NoError(t, c.Save(ctx, "a%b,c", bytes.NewReader(dt), int64(len(dt))))
+
+	// prefixes of keys with commas are encoded like the keys
+	ce, err := c.Load(ctx, "missing", "a%b")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	require.Equal(t, "a%b,c", ce.Key)
+}
+
+func TestLookupBatches(t *testing.T) {
+	ts := newTestServer(t)
+	ctx := context.TODO()
+	for _, v2 := range []bool{false, true} {
+		c := ts.newCache(t)
+		if v2 {
+			c = ts.newCacheV2(t)
+		}
+		p := "batch-" + c.Protocol() + "-"
+		require.NoError(t, c.Save(ctx, p+"22-x", bytes.NewReader([]byte("foo")), 3))
+		require.NoError(t, c.Save(ctx, p+"24", bytes.NewReader([]byte("bar")), 3))
+		keys := []string{p + "00"}
+		for i := 1; i < 25; i++ {
+			keys = append(keys, fmt.Sprintf("%s%02d", p, i))
+		}
+		ce, err := c.Load(ctx, keys...)
+		require.NoError(t, err)
+		require.NotNil(t, ce)
+		require.Equal(t, p+"22-x", ce.Key)
+		require.Equal(t, p+"22", ce.MatchedKey)
+	}
+	require.Equal(t, 3, ts.count("GET /_apis/artifactcache/cache"))
+	require.Equal(t, 3, ts.count("POST /"+twirpCacheService+"GetCacheEntryDownloadURL"))
+
+	long := strings.Repeat("ü", MaxKeyLength/2)
+	batches := lookupBatches([]string{long, long, long, long, long, "a"}, MaxLookupKeys)
+	require.Len(t, batches, 3)
+	require.Equal(t, []string{long, long}, batches[0])
+	require.Equal(t, []string{long, "a"}, batches[2])
+
+	defer func(v int) { MaxLookupKeys = v }(MaxLookupKeys)
+	MaxLookupKeys = 0
+	require.Len(t, lookupBatches(make([]string, 50), MaxLookupKeys), 1)
+}
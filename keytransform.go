@@ -0,0 +1,124 @@
+package actionscache
+
+import (
+	"context"
+	"strings"
+)
+
+// KeyTransform rewrites the keys of entries before they are sent to the
+// cache service or Backend, eg. so that tools embedding this package in
+// the same workflow do not share generic keys like "build-cache". Keys are
+// transformed for lookups, reservations and the entries of checksums,
+// metadata and deduplication alike. Entries report the keys of the caller.
+type KeyTransform interface {
+	// TransformKey returns the stored key of key.
+	TransformKey(key string) string
+	// RestoreKey returns the key of a stored key, false if it was not
+	// returned by TransformKey. Lookups may match keys by prefix, so it
+	// must restore keys extending a transformed key too.
+	RestoreKey(stored string) (string, bool)
+}
+
+// WithKeyTransform adds t to the KeyTransforms of the Cache. Transforms are
+// applied in the order they are added.
+func WithKeyTransform(t KeyTransform) Opt {
+	return func(c *Cache) {
+		c.KeyTransforms = append(c.KeyTransforms, t)
+	}
+}
+
+// WithKeyNamespace prefixes keys with the non-empty parts, see KeyPrefix.
+func WithKeyNamespace(parts ...string) Opt {
+	return WithKeyTransform(KeyPrefix(parts...))
+}
+
+// KeyPrefix returns a KeyTransform prefixing keys with the non-empty parts
+// joined by "-", eg. KeyPrefix("mytool", os.Getenv("RUNNER_OS"),
+// os.Getenv("RUNNER_ARCH")) stores "build-cache" as
+// "mytool-Linux-X64-build-cache".
+func KeyPrefix(parts ...string) KeyTransform {
+	var p []string
+	for _, s := range parts {
+		if s != "" {
+			p = append(p, s)
+		}
+	}
+	if len(p) == 0 {
+		return keyPrefix("")
+	}
+	return keyPrefix(strings.Join(p, "-") + "-")
+}
+
+type keyPrefix string
+
+func (p keyPrefix) TransformKey(key string) string {
+	return string(p) + key
+}
+
+func (p keyPrefix) RestoreKey(stored string) (string, bool) {
+	if !strings.HasPrefix(stored, string(p)) {
+		return "", false
+	}
+	return stored[len(p):], true
+}
+
+// transformKey returns the stored key of key.
+func (c *Cache) transformKey(key string) string {
+	for _, t := range c.KeyTransforms {
+		key = t.TransformKey(key)
+	}
+	return key
+}
+
+func (c *Cache) transformKeys(keys []string) []string {
+	if len(c.KeyTransforms) == 0 {
+		return keys
+	}
+	out := make([]string, len(keys))
+	for i, k := range keys {
+		out[i] = c.transformKey(k)
+	}
+	return out
+}
+
+// restoreKey returns the key of a stored key, undoing the transforms in
+// reverse order.
+func (c *Cache) restoreKey(stored string) (string, bool) {
+	for i := len(c.KeyTransforms) - 1; i >= 0; i-- {
+		k, ok := c.KeyTransforms[i].RestoreKey(stored)
+		if !ok {
+			return "", false
+		}
+		stored = k
+	}
+	return stored, true
+}
+
+// validateKeys returns an *InvalidKeyError if keys is empty or the service
+// would reject one of them, before or after they are transformed.
+func (c *Cache) validateKeys(keys ...string) error {
+	if err := validateKeys(keys); err != nil {
+		return err
+	}
+	if len(c.KeyTransforms) == 0 {
+		return nil
+	}
+	return validateKeys(c.transformKeys(keys))
+}
+
+// lookupTransformed looks up the stored keys of keys with lookup and
+// returns the entry with the key of the caller. Entries whose key can not
+// be restored belong to another namespace and are misses.
+func (c *Cache) lookupTransformed(ctx context.Context, keys []string, lookup func([]string) (*Entry, error)) (*Entry, error) {
+	ce, err := lookup(c.transformKeys(keys))
+	if err != nil || ce == nil || len(c.KeyTransforms) == 0 {
+		return ce, err
+	}
+	key, ok := c.restoreKey(ce.Key)
+	if !ok {
+		c.debug(ctx, "load cache: ignoring entry of other key namespace", F("key", ce.Key))
+		return nil, nil
+	}
+	ce.Key = key
+	return ce, nil
+}
@@ -0,0 +1,85 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyPrefix(t *testing.T) {
+	p := KeyPrefix("tool", "", "Linux", "X64")
+	require.Equal(t, "tool-Linux-X64-build-cache", p.TransformKey("build-cache"))
+	k, ok := p.RestoreKey("tool-Linux-X64-build-cache-2")
+	require.True(t, ok)
+	require.Equal(t, "build-cache-2", k)
+	_, ok = p.RestoreKey("other-build-cache")
+	require.False(t, ok)
+
+	p = KeyPrefix()
+	require.Equal(t, "build-cache", p.TransformKey("build-cache"))
+}
+
+func TestKeyNamespace(t *testing.T) {
+	ts := newTestServer(t)
+	ctx := context.TODO()
+
+	for _, v2 := range []bool{false, true} {
+		newCache := ts.newCache
+		if v2 {
+			newCache = ts.newCacheV2
+		}
+		a := newCache(t)
+		WithKeyNamespace("tool-a")(a)
+		b := newCache(t)
+		WithKeyNamespace("tool-b")(b)
+		plain := newCache(t)
+
+		key := "build-cache"
+		if v2 {
+			key = "build-cache-v2"
+		}
+		require.NoError(t, a.Save(ctx, key, bytes.NewReader([]byte("a")), 1))
+		require.NoError(t, b.Save(ctx, key, bytes.NewReader([]byte("b")), 1))
+		require.NotNil(t, ts.entry("tool-a-"+key))
+		require.NotNil(t, ts.entry("tool-b-"+key))
+		require.Nil(t, ts.entry(key))
+
+		for c, exp := range map[*Cache]string{a: "a", b: "b"} {
+			ce, err := c.Load(ctx, "missing", key[:5])
+			require.NoError(t, err)
+			require.NotNil(t, ce)
+			require.Equal(t, key, ce.Key)
+			require.Equal(t, key[:5], ce.MatchedKey)
+			buf := &bytes.Buffer{}
+			require.NoError(t, ce.Download(ctx, buf))
+			require.Equal(t, exp, buf.String())
+		}
+
+		ce, err := plain.Load(ctx, key)
+		require.NoError(t, err)
+		require.Nil(t, ce)
+		ce, err = plain.Load(ctx, "tool-a-"+key)
+		require.NoError(t, err)
+		require.NotNil(t, ce)
+	}
+}
+
+func TestKeyTransformsOrder(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	WithKeyNamespace("inner")(c)
+	WithKeyNamespace("outer")(c)
+	ctx := context.TODO()
+
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader([]byte("foo")), 3))
+	require.NotNil(t, ts.entry("outer-inner-foo"))
+	ce, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	require.Equal(t, "foo", ce.Key)
+	require.True(t, ce.Exact)
+
+	_, err = c.Load(ctx, "")
+	require.ErrorIs(t, err, ErrInvalidKey)
+}
@@ -0,0 +1,143 @@
+package actionscache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ReservationTTL is the default estimate of how long a reservation stays
+// valid without a commit. The cache service does not document it, the
+// estimate only sets the ExpiresAt of reservations and makes Save reserve
+// a key again if its commit is refused after it. Zero means reservations
+// do not expire.
+var ReservationTTL = time.Hour
+
+// WithReservationTTL sets the estimated validity of reservations.
+func WithReservationTTL(d time.Duration) Opt {
+	return func(c *Cache) {
+		c.ReservationTTL = d
+	}
+}
+
+func (c *Cache) reservationTTL() time.Duration {
+	if c.ReservationTTL != 0 {
+		return c.ReservationTTL
+	}
+	return ReservationTTL
+}
+
+// expiresAt returns the estimated expiry of a reservation made or renewed
+// at t, the zero time if reservations do not expire.
+func (c *Cache) expiresAt(t time.Time) time.Time {
+	if ttl := c.reservationTTL(); ttl > 0 {
+		return t.Add(ttl)
+	}
+	return time.Time{}
+}
+
+// ReservationRenewer is implemented by Backends whose reservations expire
+// and can be extended. The v1 cache service has no call to renew them.
+type ReservationRenewer interface {
+	// Renew extends the reservation id as if it was made now.
+	Renew(ctx context.Context, id int) error
+}
+
+// ErrRenewNotSupported is returned by Heartbeat when the reservation can not
+// be renewed by its Backend or the cache service.
+var ErrRenewNotSupported = errors.New("renewing cache reservations is not supported")
+
+// ErrReservationExpired is matched by errors of commits refused because
+// the reservation expired before it.
+var ErrReservationExpired = errors.New("cache reservation expired")
+
+// ReservationExpiredError is returned by Reservation.Commit when the
+// service no longer knows the reservation, most likely because the upload
+// took longer than the reservation was valid. The key can be reserved
+// again with Rereserve.
+type ReservationExpiredError struct {
+	Reservation ReservationInfo
+	Err         error
+}
+
+func (e *ReservationExpiredError) Error() string {
+	return fmt.Sprintf("cache reservation %d of key %s created %s has expired: %v", e.Reservation.ID, e.Reservation.Key, e.Reservation.Created.Format(time.RFC3339), e.Err)
+}
+
+func (e *ReservationExpiredError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ReservationExpiredError) Is(target error) bool {
+	return target == ErrReservationExpired
+}
+
+// ExpiresAt returns the estimated time the reservation expires at, the zero
+// time if it does not expire.
+func (rv *Reservation) ExpiresAt() time.Time {
+	rv.c.mu.Lock()
+	defer rv.c.mu.Unlock()
+	return rv.r.ExpiresAt
+}
+
+// Expired reports if the reservation is past its estimated expiry.
+func (rv *Reservation) Expired() bool {
+	return rv.c.pastExpiry(ReservationInfo{ExpiresAt: rv.ExpiresAt()})
+}
+
+// Heartbeat renews the reservation if its Backend implements
+// ReservationRenewer, and fails with ErrRenewNotSupported otherwise.
+// Callers of long uploads call it periodically before ExpiresAt.
+func (rv *Reservation) Heartbeat(ctx context.Context) error {
+	rn, ok := rv.c.Backend.(ReservationRenewer)
+	if !ok {
+		return errors.WithStack(ErrRenewNotSupported)
+	}
+	if err := rn.Renew(ctx, rv.ID); err != nil {
+		return errors.Wrapf(err, "failed to renew cache reservation %d", rv.ID)
+	}
+	rv.c.renewed(rv.r)
+	return nil
+}
+
+// Rereserve reserves the key again after the reservation expired, eg. when
+// Commit failed with ErrReservationExpired. The reservation gets a new ID
+// and all its chunks must be uploaded again. It must not be called
+// concurrently with UploadChunk or Commit.
+func (rv *Reservation) Rereserve(ctx context.Context) error {
+	id, err := rv.c.reserve(ctx, rv.Key)
+	if err != nil {
+		return err
+	}
+	rv.c.trackCommit(rv.r)
+	rv.r = rv.c.trackReserve(id, rv.Key)
+	rv.ReservationInfo = *rv.r
+	rv.mu.Lock()
+	rv.acked = rangeSet{}
+	rv.mu.Unlock()
+	return nil
+}
+
+// pastExpiry reports if r is past its estimated expiry.
+func (c *Cache) pastExpiry(r ReservationInfo) bool {
+	return !r.ExpiresAt.IsZero() && !c.clock().Now().Before(r.ExpiresAt)
+}
+
+// renewed moves the estimated expiry of r to the TTL from now.
+func (c *Cache) renewed(r *ReservationInfo) {
+	now := c.clock().Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r.ExpiresAt = c.expiresAt(now)
+}
+
+// reservationExpired returns a *ReservationExpiredError if the commit of r
+// failed with err because the service no longer knows the reservation.
+func reservationExpired(ctx context.Context, r ReservationInfo, err error) error {
+	if err == nil || !isStaleUpload(ctx, err) {
+		return err
+	}
+	return errors.WithStack(&ReservationExpiredError{Reservation: r, Err: err})
+}
@@ -0,0 +1,119 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type renewingBackend struct {
+	*FSBackend
+	renewed []int
+}
+
+func (b *renewingBackend) Renew(ctx context.Context, id int) error {
+	b.renewed = append(b.renewed, id)
+	return nil
+}
+
+func TestReservationLease(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	clock := newTestClock()
+	c.Clock = clock
+	WithReservationTTL(10 * time.Minute)(c)
+	ctx := context.TODO()
+
+	rv, err := c.Reserve(ctx, "lease")
+	require.NoError(t, err)
+	require.Equal(t, clock.Now().Add(10*time.Minute), rv.ExpiresAt())
+	require.Equal(t, rv.ExpiresAt(), c.Stats().Pending[0].ExpiresAt)
+	require.False(t, rv.Expired())
+	require.ErrorIs(t, rv.Heartbeat(ctx), ErrRenewNotSupported)
+
+	dt := []byte("leased")
+	require.NoError(t, rv.UploadChunk(ctx, 0, bytes.NewReader(dt), int64(len(dt))))
+	clock.Advance(time.Hour)
+	require.True(t, rv.Expired())
+	ts.mu.Lock()
+	ts.store.Expire(rv.ID)
+	ts.mu.Unlock()
+
+	err = rv.Commit(ctx, int64(len(dt)))
+	require.ErrorIs(t, err, ErrReservationExpired)
+	require.Empty(t, c.Stats().Pending)
+	require.Empty(t, c.Stats().Orphaned)
+
+	old := rv.ID
+	require.NoError(t, rv.Rereserve(ctx))
+	require.NotEqual(t, old, rv.ID)
+	require.False(t, rv.Expired())
+	require.Error(t, rv.Commit(ctx, int64(len(dt))), "chunks must be uploaded again")
+	require.NoError(t, rv.UploadChunk(ctx, 0, bytes.NewReader(dt), int64(len(dt))))
+	require.NoError(t, rv.Commit(ctx, int64(len(dt))))
+	require.Equal(t, dt, ts.entry("lease").Data)
+	require.NoError(t, c.Close())
+}
+
+func TestReservationHeartbeat(t *testing.T) {
+	fs, err := NewFSBackend(t.TempDir())
+	require.NoError(t, err)
+	b := &renewingBackend{FSBackend: fs}
+	clock := newTestClock()
+	c := NewWithBackend(b, WithClock(clock), WithReservationTTL(10*time.Minute))
+	ctx := context.TODO()
+
+	rv, err := c.Reserve(ctx, "heartbeat")
+	require.NoError(t, err)
+	clock.Advance(8 * time.Minute)
+	require.NoError(t, rv.Heartbeat(ctx))
+	require.Equal(t, []int{rv.ID}, b.renewed)
+	require.Equal(t, clock.Now().Add(10*time.Minute), rv.ExpiresAt())
+
+	c = NewWithBackend(b, WithClock(clock), WithReservationTTL(-1))
+	rv, err = c.Reserve(ctx, "forever")
+	require.NoError(t, err)
+	require.True(t, rv.ExpiresAt().IsZero())
+	clock.Advance(1000 * time.Hour)
+	require.False(t, rv.Expired())
+}
+
+func TestSaveReservationExpired(t *testing.T) {
+	ts := newTestServer(t)
+	clock := newTestClock()
+	h := ts.Config.Handler
+	expired := false
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !expired && r.Method == "POST" && strings.Contains(r.URL.Path, "/caches/") {
+			// the upload took longer than the reservation was valid
+			expired = true
+			id, err := strconv.Atoi(r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:])
+			require.NoError(t, err)
+			clock.Advance(2 * time.Hour)
+			ts.mu.Lock()
+			ts.store.Expire(id)
+			ts.mu.Unlock()
+		}
+		h.ServeHTTP(w, r)
+	})
+	c := ts.newCache(t)
+	c.Clock = clock
+	ctx := context.TODO()
+
+	dt := []byte("slow upload")
+	require.NoError(t, c.Save(ctx, "slow", bytes.NewReader(dt), int64(len(dt))))
+	require.True(t, expired)
+	require.Equal(t, dt, ts.entry("slow").Data)
+	require.NoError(t, c.Close())
+
+	// a commit refused before the reservation could have expired fails
+	expired = false
+	WithReservationTTL(3 * time.Hour)(c)
+	require.Error(t, c.Save(ctx, "fast", bytes.NewReader(dt), int64(len(dt))))
+}
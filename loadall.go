@@ -0,0 +1,96 @@
+package actionscache
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// LoadAllConcurrency is the number of lookups LoadAll runs at the same time.
+// Values below 1 run them one at a time.
+var LoadAllConcurrency = 8
+
+// WithLoadAllConcurrency sets the number of lookups LoadAll runs at the
+// same time.
+func WithLoadAllConcurrency(n int) Opt {
+	return func(c *Cache) {
+		c.LoadAllConcurrency = n
+	}
+}
+
+func (c *Cache) loadAllConcurrency() int {
+	if c != nil && c.LoadAllConcurrency > 0 {
+		return c.LoadAllConcurrency
+	}
+	return LoadAllConcurrency
+}
+
+// LoadAll looks up many independent sets of keys concurrently, eg. the
+// caches of all layers or modules at the start of a job. The result has an
+// entry for every name of sets, nil for misses. The first failing lookup
+// cancels the others and its error is returned. Sets without keys are
+// rejected before any lookup.
+func (c *Cache) LoadAll(ctx context.Context, sets map[string][]string, opts ...LoadOpt) (map[string]*Entry, error) {
+	for name, keys := range sets {
+		if err := c.validateKeys(keys...); err != nil {
+			return nil, errors.Wrapf(err, "failed to load %s", name)
+		}
+	}
+	var mu sync.Mutex
+	out := make(map[string]*Entry, len(sets))
+	eg, egCtx := errgroup.WithContext(ctx)
+	n := c.loadAllConcurrency()
+	if n < 1 {
+		n = 1
+	}
+	sem := make(chan struct{}, n)
+loop:
+	for name, keys := range sets {
+		name, keys := name, keys
+		select {
+		case sem <- struct{}{}:
+		case <-egCtx.Done():
+			break loop
+		}
+		eg.Go(func() error {
+			defer func() { <-sem }()
+			ce, err := c.LoadWithOpts(egCtx, keys, opts...)
+			if err != nil {
+				return errors.Wrapf(err, "failed to load %s", name)
+			}
+			mu.Lock()
+			out[name] = ce
+			mu.Unlock()
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Exists reports for each of keys if an entry of exactly that key exists.
+// The lookups run concurrently like those of LoadAll and nothing is
+// downloaded or stat'ed, entries matched only by a prefix of a key are
+// reported as missing.
+func (c *Cache) Exists(ctx context.Context, keys ...string) (map[string]bool, error) {
+	sets := make(map[string][]string, len(keys))
+	for _, k := range keys {
+		sets[k] = []string{k}
+	}
+	res, err := c.LoadAll(ctx, sets)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]bool, len(res))
+	for k, ce := range res {
+		out[k] = ce != nil && ce.Key == k
+	}
+	return out, nil
+}
@@ -0,0 +1,99 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadAll(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+
+	ctx := context.TODO()
+	dt := []byte("foobar")
+	require.NoError(t, c.Save(ctx, "go-mod-1", bytes.NewReader(dt), int64(len(dt))))
+	require.NoError(t, c.Save(ctx, "layer-a", bytes.NewReader(dt), int64(len(dt))))
+
+	res, err := c.LoadAll(ctx, map[string][]string{
+		"gomod":  {"go-mod-2", "go-mod-"},
+		"layerA": {"layer-a"},
+		"layerB": {"layer-b"},
+	})
+	require.NoError(t, err)
+	require.Len(t, res, 3)
+	require.Equal(t, "go-mod-1", res["gomod"].Key)
+	require.Equal(t, "layer-a", res["layerA"].Key)
+	require.Nil(t, res["layerB"])
+
+	ts.fail = func(r *http.Request) int {
+		if strings.Contains(r.URL.RawQuery, "layer-b") {
+			return http.StatusBadRequest
+		}
+		return 0
+	}
+	_, err = c.LoadAll(ctx, map[string][]string{
+		"layerA": {"layer-a"},
+		"layerB": {"layer-b"},
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "layerB")
+
+	n := ts.count("GET ")
+	_, err = c.LoadAll(ctx, map[string][]string{
+		"layerA": {"layer-a"},
+		"empty":  {},
+	})
+	require.ErrorIs(t, err, ErrInvalidKey)
+	require.Contains(t, err.Error(), "empty")
+	require.Equal(t, n, ts.count("GET "))
+}
+
+func TestLoadAllCanceled(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+
+	defer func(n int) { LoadAllConcurrency = n }(LoadAllConcurrency)
+	LoadAllConcurrency = 0
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	res, err := c.LoadAll(ctx, map[string][]string{"a": {"a"}, "b": {"b"}})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Nil(t, res)
+}
+
+func TestExists(t *testing.T) {
+	ts := newTestServer(t)
+	ctx := context.TODO()
+	dt := []byte("foobar")
+
+	for _, v2 := range []bool{false, true} {
+		c := ts.newCache(t)
+		prefix := "exists-v1-"
+		if v2 {
+			c = ts.newCacheV2(t)
+			prefix = "exists-v2-"
+		}
+		require.NoError(t, c.Save(ctx, prefix+"a", bytes.NewReader(dt), int64(len(dt))))
+		require.NoError(t, c.Save(ctx, prefix+"b-1", bytes.NewReader(dt), int64(len(dt))))
+
+		n := ts.count("GET ") + ts.count("POST ")
+		res, err := c.Exists(ctx, prefix+"a", prefix+"b-", prefix+"c", prefix+"a")
+		require.NoError(t, err)
+		require.Equal(t, map[string]bool{
+			prefix + "a":  true,
+			prefix + "b-": false,
+			prefix + "c":  false,
+		}, res)
+		require.Equal(t, n+3, ts.count("GET ")+ts.count("POST "), "one lookup per key and no downloads")
+	}
+
+	c := ts.newCache(t)
+	_, err := c.Exists(ctx, "ok", "")
+	require.ErrorIs(t, err, ErrInvalidKey)
+}
@@ -0,0 +1,58 @@
+package actionscache
+
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// LoadOrSave returns the entry saved under key. If there is none, the data
+// written by produce is saved under key and the new entry is returned.
+// Concurrent calls for the same key of a Cache share one lookup and one
+// producer, which runs with the context and options of the first caller.
+// Every caller gets its own copy of the entry. Entries matched only by a
+// prefix of key are not returned. The entry is nil in dry-run mode. With
+// SaveVersion the lookup uses the same version as the save.
+func (c *Cache) LoadOrSave(ctx context.Context, key string, produce func(io.Writer) error, opts ...SaveOpt) (*Entry, error) {
+	ctx = withSaveVersion(ctx, opts)
+	v, err, _ := c.loadOrSave.Do(c.keyVersion(ctx, key)+"|"+key, func() (interface{}, error) {
+		return c.loadOrSaveOnce(ctx, key, produce, opts)
+	})
+	ce, _ := v.(*Entry)
+	if err != nil || ce == nil {
+		return nil, err
+	}
+	ce2 := *ce
+	return &ce2, nil
+}
+
+func (c *Cache) loadOrSaveOnce(ctx context.Context, key string, produce func(io.Writer) error, opts []SaveOpt) (*Entry, error) {
+	ce, err := c.Load(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if ce != nil && ce.Exact {
+		return ce, nil
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(produce(pw))
+	}()
+	err = c.SaveReader(ctx, key, pr, opts...)
+	pr.CloseWithError(errors.New("save finished"))
+	if err != nil {
+		return nil, err
+	}
+	if c.DryRun {
+		return nil, nil
+	}
+	ce, err = c.Load(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if ce == nil || !ce.Exact {
+		return nil, errors.Errorf("cache %s not found after save", key)
+	}
+	return ce, nil
+}
@@ -0,0 +1,72 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestLoadOrSave(t *testing.T) {
+	ts := newTestServer(t)
+	for _, c := range []*Cache{ts.newCache(t), ts.newCacheV2(t)} {
+		c := c
+		t.Run(c.Protocol(), func(t *testing.T) {
+			ctx := context.TODO()
+			key := "los-" + c.Protocol()
+			require.NoError(t, c.Save(ctx, key+"-old", bytes.NewReader([]byte("old")), 3))
+
+			var produced int32
+			release := make(chan struct{})
+			var started sync.WaitGroup
+			started.Add(4)
+			eg, egctx := errgroup.WithContext(ctx)
+			entries := make([]*Entry, 4)
+			for i := range entries {
+				i := i
+				eg.Go(func() error {
+					started.Done()
+					ce, err := c.LoadOrSave(egctx, key, func(w io.Writer) error {
+						atomic.AddInt32(&produced, 1)
+						<-release
+						_, err := w.Write([]byte("produced"))
+						return err
+					})
+					entries[i] = ce
+					return err
+				})
+			}
+			started.Wait()
+			close(release)
+			require.NoError(t, eg.Wait())
+			require.Equal(t, int32(1), produced)
+			for _, ce := range entries {
+				require.NotNil(t, ce)
+				require.Equal(t, key, ce.Key)
+				buf := &bytes.Buffer{}
+				require.NoError(t, ce.Download(ctx, buf))
+				require.Equal(t, "produced", buf.String())
+			}
+			require.NotSame(t, entries[0], entries[1])
+
+			ce, err := c.LoadOrSave(ctx, key, func(w io.Writer) error {
+				t.Fatal("producer called for existing entry")
+				return nil
+			})
+			require.NoError(t, err)
+			require.Equal(t, key, ce.Key)
+
+			_, err = c.LoadOrSave(ctx, key+"-failed", func(w io.Writer) error {
+				return errors.New("produce failed")
+			})
+			require.Error(t, err)
+			require.Nil(t, ts.entry(key+"-failed"))
+		})
+	}
+}
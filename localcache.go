@@ -0,0 +1,146 @@
+package actionscache
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// WithLocalCache keeps a copy of the entries saved and downloaded by the
+// Cache in dir, so later downloads of the same key and version within a job,
+// or on a persistent self-hosted runner, are read from disk. Save, Download
+// and DownloadAt use the directory, it is never pruned.
+func WithLocalCache(dir string) Opt {
+	return func(c *Cache) {
+		c.LocalCacheDir = dir
+	}
+}
+
+// localPath returns the file of the local copy of key, or "" if the Cache
+// keeps no local copies.
+func (c *Cache) localPath(ctx context.Context, key string) string {
+	if c == nil || c.LocalCacheDir == "" {
+		return ""
+	}
+	return filepath.Join(c.LocalCacheDir, c.keyVersion(ctx, key), url.PathEscape(key))
+}
+
+// openLocal opens the local copy of ce, nil if there is none.
+func (ce *Entry) openLocal(ctx context.Context) *os.File {
+	p := ce.c.localPath(ctx, ce.Key)
+	if p == "" {
+		return nil
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			ce.c.warn(ctx, "failed to open local cache copy", F("key", ce.Key), F("error", err))
+		}
+		return nil
+	}
+	ce.c.debug(ctx, "download cache from local copy", F("key", ce.Key), F("path", p))
+	return f
+}
+
+// createLocal returns a temporary file for a new local copy of key, nil if
+// the Cache keeps no local copies or it can not be created.
+func (c *Cache) createLocal(ctx context.Context, key string) *os.File {
+	p := c.localPath(ctx, key)
+	if p == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		c.warn(ctx, "failed to create local cache directory", F("key", key), F("error", err))
+		return nil
+	}
+	f, err := ioutil.TempFile(filepath.Dir(p), ".tmp-")
+	if err != nil {
+		c.warn(ctx, "failed to create local cache copy", F("key", key), F("error", err))
+		return nil
+	}
+	return f
+}
+
+// finishLocal moves the temporary file f into place as the local copy of
+// key if err is nil and removes it otherwise.
+func (c *Cache) finishLocal(ctx context.Context, key string, f *os.File, err error) {
+	cerr := f.Close()
+	if err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), c.localPath(ctx, key))
+		if err != nil {
+			c.warn(ctx, "failed to store local cache copy", F("key", key), F("error", err))
+		}
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+}
+
+// storeLocal keeps a copy of size bytes of ra saved under key.
+func (c *Cache) storeLocal(ctx context.Context, key string, ra io.ReaderAt, size int64) {
+	if c.DryRun {
+		return
+	}
+	f := c.createLocal(ctx, key)
+	if f == nil {
+		return
+	}
+	_, err := io.Copy(f, io.NewSectionReader(ra, 0, size))
+	if err != nil {
+		c.warn(ctx, "failed to write local cache copy", F("key", key), F("error", err))
+	}
+	c.finishLocal(ctx, key, f, errors.WithStack(err))
+}
+
+// downloadLocal writes the local copy of ce to w, or downloads ce with
+// download and keeps a copy of it.
+func (ce *Entry) downloadLocal(ctx context.Context, w io.Writer, download func(context.Context, io.Writer) error) error {
+	if f := ce.openLocal(ctx); f != nil {
+		defer f.Close()
+		_, err := io.Copy(w, f)
+		return errors.WithStack(err)
+	}
+	f := ce.c.createLocal(ctx, ce.Key)
+	if f == nil {
+		return download(ctx, w)
+	}
+	err := download(ctx, io.MultiWriter(w, f))
+	ce.c.finishLocal(ctx, ce.Key, f, err)
+	return err
+}
+
+// downloadAtLocal is downloadLocal for io.WriterAt.
+func (ce *Entry) downloadAtLocal(ctx context.Context, w io.WriterAt, download func(context.Context, io.WriterAt) error) error {
+	if f := ce.openLocal(ctx); f != nil {
+		defer f.Close()
+		_, err := io.Copy(&offsetWriter{w: w}, f)
+		return errors.WithStack(err)
+	}
+	f := ce.c.createLocal(ctx, ce.Key)
+	if f == nil {
+		return download(ctx, w)
+	}
+	err := download(ctx, multiWriterAt{w, f})
+	ce.c.finishLocal(ctx, ce.Key, f, err)
+	return err
+}
+
+// multiWriterAt writes to all its writers.
+type multiWriterAt []io.WriterAt
+
+func (mw multiWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	for _, w := range mw {
+		if n, err := w.WriteAt(p, off); err != nil {
+			return n, err
+		}
+	}
+	return len(p), nil
+}
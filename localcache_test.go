@@ -0,0 +1,77 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalCache(t *testing.T) {
+	ts := newTestServer(t)
+	dir := t.TempDir()
+	c := ts.newCache(t)
+	WithLocalCache(dir)(c)
+
+	ctx := context.TODO()
+	dt := []byte("foobar")
+	require.NoError(t, c.Save(ctx, "foo/1", bytes.NewReader(dt), int64(len(dt))))
+	local, err := ioutil.ReadFile(c.localPath(ctx, "foo/1"))
+	require.NoError(t, err)
+	require.Equal(t, dt, local)
+
+	ce, err := c.Load(ctx, "foo/1")
+	require.NoError(t, err)
+	buf := &bytes.Buffer{}
+	require.NoError(t, ce.Download(ctx, buf))
+	require.Equal(t, string(dt), buf.String())
+	bufAt := &bufferAt{}
+	require.NoError(t, ce.DownloadAt(ctx, bufAt))
+	require.Equal(t, string(dt), string(bufAt.buf))
+	require.Equal(t, 0, ts.count("GET /blob/"))
+
+	// entries saved elsewhere are downloaded once
+	other := ts.newCache(t)
+	require.NoError(t, other.Save(ctx, "bar", bytes.NewReader(dt), int64(len(dt))))
+	ce, err = c.Load(ctx, "bar")
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		buf.Reset()
+		require.NoError(t, ce.Download(ctx, buf))
+		require.Equal(t, string(dt), buf.String())
+	}
+	require.Equal(t, 1, ts.count("GET /blob/"))
+
+	require.NoError(t, other.Save(ctx, "baz", bytes.NewReader(dt), int64(len(dt))))
+	ce, err = c.Load(ctx, "baz")
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		bufAt = &bufferAt{}
+		require.NoError(t, ce.DownloadAt(ctx, bufAt))
+		require.Equal(t, string(dt), string(bufAt.buf))
+	}
+	require.Equal(t, 2, ts.count("GET /blob/"))
+
+	// failed downloads leave no copy
+	require.NoError(t, other.Save(ctx, "failed", bytes.NewReader(dt), int64(len(dt))))
+	ce, err = c.Load(ctx, "failed")
+	require.NoError(t, err)
+	ts.fail = func(r *http.Request) int {
+		if strings.HasPrefix(r.URL.Path, "/blob/") {
+			return http.StatusNotFound
+		}
+		return 0
+	}
+	require.Error(t, ce.Download(ctx, &bytes.Buffer{}))
+	_, err = os.Stat(c.localPath(ctx, "failed"))
+	require.True(t, os.IsNotExist(err))
+	tmp, err := filepath.Glob(filepath.Join(filepath.Dir(c.localPath(ctx, "failed")), ".tmp-*"))
+	require.NoError(t, err)
+	require.Empty(t, tmp)
+}
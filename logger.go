@@ -0,0 +1,105 @@
+package actionscache
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Field is a structured attribute of a log message.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F returns a Field.
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Logger receives the log messages of a Cache. Fields carry the request
+// URLs, cache IDs, keys and byte counts the message refers to.
+type Logger interface {
+	Debug(msg string, fields ...Field)
+	Info(msg string, fields ...Field)
+	Warn(msg string, fields ...Field)
+}
+
+// LoggerFunc returns a Logger formatting messages with their level and
+// fields as key=value pairs, eg. for log.Printf.
+func LoggerFunc(fn func(string, ...interface{})) Logger {
+	return funcLogger(fn)
+}
+
+type funcLogger func(string, ...interface{})
+
+func (l funcLogger) Debug(msg string, fields ...Field) {
+	l.log("debug", msg, fields)
+}
+
+func (l funcLogger) Info(msg string, fields ...Field) {
+	l.log("info", msg, fields)
+}
+
+func (l funcLogger) Warn(msg string, fields ...Field) {
+	l.log("warn", msg, fields)
+}
+
+func (l funcLogger) log(level, msg string, fields []Field) {
+	var sb strings.Builder
+	sb.WriteString(level)
+	sb.WriteString(": ")
+	sb.WriteString(msg)
+	for _, f := range fields {
+		fmt.Fprintf(&sb, " %s=%v", f.Key, f.Value)
+	}
+	l("%s", sb.String())
+}
+
+func (c *Cache) logger() Logger {
+	if c != nil && c.Logger != nil {
+		return c.Logger
+	}
+	return funcLogger(Log)
+}
+
+// contextFields returns fields with the operation ID, correlation ID and
+// metadata of ctx appended.
+func contextFields(ctx context.Context, fields []Field) []Field {
+	id, ok := CorrelationIDFromContext(ctx)
+	opID, hasOp := operationID(ctx)
+	md := MetadataFromContext(ctx)
+	if len(md) == 0 && !ok && !hasOp {
+		return fields
+	}
+	keys := make([]string, 0, len(md))
+	for k := range md {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]Field, 0, len(fields)+len(keys)+2)
+	out = append(out, fields...)
+	if hasOp {
+		out = append(out, F("opID", opID))
+	}
+	if ok {
+		out = append(out, F("correlationID", id))
+	}
+	for _, k := range keys {
+		out = append(out, F(k, md[k]))
+	}
+	return out
+}
+
+func (c *Cache) debug(ctx context.Context, msg string, fields ...Field) {
+	c.logger().Debug(msg, contextFields(ctx, fields)...)
+}
+
+func (c *Cache) info(ctx context.Context, msg string, fields ...Field) {
+	c.logger().Info(msg, contextFields(ctx, fields)...)
+}
+
+func (c *Cache) warn(ctx context.Context, msg string, fields ...Field) {
+	c.logger().Warn(msg, contextFields(ctx, fields)...)
+}
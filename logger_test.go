@@ -0,0 +1,117 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+)
+
+type logEntry struct {
+	level, msg string
+	fields     map[string]interface{}
+}
+
+type recordingLogger struct {
+	mu      sync.Mutex
+	entries []logEntry
+}
+
+func (l *recordingLogger) record(level, msg string, fields []Field) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e := logEntry{level: level, msg: msg, fields: map[string]interface{}{}}
+	for _, f := range fields {
+		e.fields[f.Key] = f.Value
+	}
+	l.entries = append(l.entries, e)
+}
+
+func (l *recordingLogger) Debug(msg string, fields ...Field) { l.record("debug", msg, fields) }
+func (l *recordingLogger) Info(msg string, fields ...Field)  { l.record("info", msg, fields) }
+func (l *recordingLogger) Warn(msg string, fields ...Field)  { l.record("warn", msg, fields) }
+
+func (l *recordingLogger) find(msg string) *logEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i := range l.entries {
+		if l.entries[i].msg == msg {
+			return &l.entries[i]
+		}
+	}
+	return nil
+}
+
+func TestLogger(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	l := &recordingLogger{}
+	WithLogger(l)(c)
+
+	ctx := WithMetadata(context.TODO(), Metadata{"job": "build"})
+	dt := []byte("structured")
+	require.NoError(t, c.Save(ctx, "logged", bytes.NewReader(dt), int64(len(dt))))
+
+	e := l.find("commit cache")
+	require.NotNil(t, e)
+	require.Equal(t, "debug", e.level)
+	require.NotZero(t, e.fields["cacheID"])
+	require.Equal(t, int64(len(dt)), e.fields["size"])
+	require.Equal(t, "build", e.fields["job"])
+
+	e = l.find("upload cache chunk")
+	require.NotNil(t, e)
+	require.Equal(t, int64(0), e.fields["offset"])
+	require.Contains(t, e.fields["url"], "/_apis/artifactcache/caches/")
+
+	var out []string
+	LoggerFunc(func(format string, args ...interface{}) {
+		out = append(out, format)
+		out = append(out, args[0].(string))
+	}).Warn("failed", F("key", "foo"), F("size", 3))
+	require.Equal(t, []string{"%s", "warn: failed key=foo size=3"}, out)
+}
+
+func TestLogOperationID(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	c.UploadChunkSize = 2
+	l := &recordingLogger{}
+	WithLogger(l)(c)
+
+	// a shared correlation ID does not tell the saves apart
+	ctx := WithCorrelationID(context.TODO(), "build-1")
+	var eg errgroup.Group
+	for _, key := range []string{"op-a", "op-b"} {
+		key := key
+		eg.Go(func() error {
+			return c.Save(ctx, key, bytes.NewReader([]byte("foobar")), 6)
+		})
+	}
+	require.NoError(t, eg.Wait())
+
+	// the chunks and commit of each save share its operation ID
+	opIDs := map[interface{}]interface{}{}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, e := range l.entries {
+		if e.msg != "upload cache chunk" && e.msg != "commit cache" {
+			continue
+		}
+		require.Equal(t, "build-1", e.fields["correlationID"])
+		require.NotEmpty(t, e.fields["opID"])
+		if id, ok := opIDs[e.fields["cacheID"]]; ok {
+			require.Equal(t, id, e.fields["opID"])
+		}
+		opIDs[e.fields["cacheID"]] = e.fields["opID"]
+	}
+	require.Len(t, opIDs, 2)
+	var ids []interface{}
+	for _, id := range opIDs {
+		ids = append(ids, id)
+	}
+	require.NotEqual(t, ids[0], ids[1])
+}
@@ -0,0 +1,156 @@
+package actionscache
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// SaveManifest makes Save persist the cache ID and a digest of every
+// uploaded chunk to path. A Save of the same key and size with an existing
+// manifest resumes the upload: chunks whose data still matches the recorded
+// digest are skipped, the others are uploaded again. The manifest is
+// removed once the entry is committed.
+func SaveManifest(path string) SaveOpt {
+	return func(o *saveOpt) {
+		o.manifest = path
+	}
+}
+
+// resumeManifest records the progress of an upload for resuming it.
+type resumeManifest struct {
+	Key       string           `json:"key"`
+	CacheID   int              `json:"cacheId"`
+	Size      int64            `json:"size"`
+	ChunkSize int              `json:"chunkSize"`
+	Hash      string           `json:"hash,omitempty"`
+	Chunks    map[int64]string `json:"chunks"`
+
+	mu      sync.Mutex
+	path    string
+	newHash func() hash.Hash
+}
+
+// loadManifest returns the manifest at path if it belongs to an upload of
+// the same key, size, chunk size and hash, or a new one otherwise. It
+// returns nil if path is empty.
+func loadManifest(path, key string, size int64, chunkSize int, hashName string) (*resumeManifest, error) {
+	if path == "" {
+		return nil, nil
+	}
+	newHash, err := getHash(hashName)
+	if err != nil {
+		return nil, err
+	}
+	m := &resumeManifest{}
+	dt, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.WithStack(err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(dt, m); err != nil {
+			return nil, errors.Wrapf(err, "invalid manifest %s", path)
+		}
+	}
+	if m.Hash == "" {
+		m.Hash = DefaultHash
+	}
+	if m.Key != key || m.Size != size || m.ChunkSize != chunkSize || m.Hash != hashName || m.CacheID == 0 {
+		m = &resumeManifest{Key: key, Size: size, ChunkSize: chunkSize, Hash: hashName}
+	}
+	if m.Chunks == nil {
+		m.Chunks = map[int64]string{}
+	}
+	m.path = path
+	m.newHash = newHash
+	return m, nil
+}
+
+// start records the cache ID of a new upload.
+func (m *resumeManifest) start(id int) error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.CacheID = id
+	m.Chunks = map[int64]string{}
+	return m.write()
+}
+
+// uploaded reports if the chunk [start, end) was uploaded before and its
+// data is unchanged.
+func (m *resumeManifest) uploaded(ra io.ReaderAt, start, end int64) (bool, error) {
+	if m == nil {
+		return false, nil
+	}
+	m.mu.Lock()
+	d, ok := m.Chunks[start]
+	m.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+	d2, err := chunkDigest(m.newHash(), ra, start, end)
+	if err != nil {
+		return false, err
+	}
+	return d == d2, nil
+}
+
+// record adds the digest of the uploaded chunk [start, end).
+func (m *resumeManifest) record(ra io.ReaderAt, start, end int64) error {
+	if m == nil {
+		return nil
+	}
+	d, err := chunkDigest(m.newHash(), ra, start, end)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Chunks[start] = d
+	return m.write()
+}
+
+func (m *resumeManifest) remove() {
+	if m == nil {
+		return
+	}
+	os.Remove(m.path)
+}
+
+// write replaces the manifest file. Must be called with mu held.
+func (m *resumeManifest) write() error {
+	dt, err := json.Marshal(m)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	f, err := ioutil.TempFile(filepath.Dir(m.path), filepath.Base(m.path)+".tmp")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err := f.Write(dt); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return errors.WithStack(err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.Rename(f.Name(), m.path))
+}
+
+func chunkDigest(h hash.Hash, ra io.ReaderAt, start, end int64) (string, error) {
+	if _, err := io.Copy(h, io.NewSectionReader(ra, start, end-start)); err != nil {
+		return "", errors.WithStack(err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,79 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaveManifestResume(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	c.UploadConcurrency = 1
+	c.UploadChunkSize = 4
+	path := filepath.Join(t.TempDir(), "foo.manifest")
+
+	interrupt := func(cancel func()) {
+		patches := 0
+		ts.fail = func(r *http.Request) int {
+			if r.Method == "PATCH" && strings.Contains(r.URL.Path, "/caches/") {
+				patches++
+				if patches == 3 {
+					cancel()
+					return http.StatusInternalServerError
+				}
+			}
+			return 0
+		}
+	}
+
+	dt := []byte("0123456789ab")
+	ctx, cancel := context.WithCancel(context.TODO())
+	interrupt(cancel)
+	err := c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt)), SaveManifest(path))
+	require.ErrorIs(t, err, context.Canceled)
+	_, err = os.Stat(path)
+	require.NoError(t, err)
+
+	// the source changed in the first chunk
+	dt[0] = 'X'
+	ts.fail = nil
+	before := ts.count("PATCH ")
+	require.NoError(t, c.Save(context.TODO(), "foo", bytes.NewReader(dt), int64(len(dt)), SaveManifest(path)))
+	require.Equal(t, 2, ts.count("PATCH ")-before, "changed first and missing last chunk uploaded")
+	require.Equal(t, 1, ts.count("POST /_apis/artifactcache/caches")-ts.count("POST /_apis/artifactcache/caches/"), "no new reservation")
+	_, err = os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+
+	ce, err := c.Load(context.TODO(), "foo")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	buf := &bytes.Buffer{}
+	require.NoError(t, ce.Download(context.TODO(), buf))
+	require.Equal(t, string(dt), buf.String())
+}
+
+func TestSaveManifestStale(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	c.UploadChunkSize = 4
+	path := filepath.Join(t.TempDir(), "foo.manifest")
+
+	dt := []byte("0123456789ab")
+	m, err := loadManifest(path, "foo", int64(len(dt)), 4, c.hashName())
+	require.NoError(t, err)
+	require.NoError(t, m.start(999))
+
+	require.NoError(t, c.Save(context.TODO(), "foo", bytes.NewReader(dt), int64(len(dt)), SaveManifest(path)))
+	require.Equal(t, 1, ts.count("POST /_apis/artifactcache/caches")-ts.count("POST /_apis/artifactcache/caches/"), "reserved again")
+	require.Equal(t, dt, ts.entry("foo").Data)
+	_, err = os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+	require.Empty(t, c.Stats().Orphaned)
+}
@@ -0,0 +1,49 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// entryMarkerKeyPrefix prefixes the keys of the entries marking the payload
+// of another entry as written by this package, eg. an alias saved by
+// SaveDedup. Payloads are only resolved if they are marked, so entries
+// saved by others are downloaded as they are even if they look the same.
+const entryMarkerKeyPrefix = "actionscache-marker-"
+
+// markerKey returns the key of the entry marking dt as the payload of key.
+func markerKey(key string, dt []byte) string {
+	sum := sha256.Sum256(dt)
+	return auxKeyBase(entryMarkerKeyPrefix, key, 2*sha256.Size) + hex.EncodeToString(sum[:])
+}
+
+// saveMarker saves the entry marking dt as the payload of key. It must be
+// saved before the payload, so that the payload is never found unmarked.
+func (c *Cache) saveMarker(ctx context.Context, key string, dt []byte, opts []SaveOpt) error {
+	var so saveOpt
+	for _, o := range opts {
+		o(&so)
+	}
+	sopts := []SaveOpt{SaveIgnoreAlreadyExists()}
+	if so.scope != "" {
+		sopts = append(sopts, SaveScope(so.scope))
+	}
+	mctx, mopts := withoutSaveStats(ctx, sopts)
+	m := []byte("1")
+	return c.save(mctx, markerKey(key, dt), bytes.NewReader(m), int64(len(m)), mopts)
+}
+
+// marked reports if dt, the payload of ce, was marked by saveMarker.
+func (ce *Entry) marked(ctx context.Context, dt []byte) (bool, error) {
+	if ce.c == nil {
+		return false, nil
+	}
+	k := markerKey(ce.Key, dt)
+	me, err := ce.c.load(ce.withVersion(ctx), []string{k}, nil)
+	if err != nil {
+		return false, err
+	}
+	return me != nil && me.Key == k, nil
+}
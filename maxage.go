@@ -0,0 +1,42 @@
+package actionscache
+
+import (
+	"context"
+	"time"
+)
+
+// LoadMaxAge makes Load report entries created more than d ago as misses,
+// eg. to rebuild a cache every night without keeping track of when it was
+// saved. The age is taken from the creation time reported by the v1
+// service or Backend. Entries of the v2 service are probed for the last
+// modification time of their archive, entries whose age can not be told
+// are returned.
+func LoadMaxAge(d time.Duration) LoadOpt {
+	return func(o *loadOpt) {
+		o.maxAge = d
+	}
+}
+
+// expired reports if ce is older than the max age of lo.
+func (c *Cache) expired(ctx context.Context, ce *Entry, lo *loadOpt) (bool, error) {
+	if lo.maxAge <= 0 {
+		return false, nil
+	}
+	created := ce.CreationTime
+	if created.IsZero() && c.Backend == nil && c.Downloader == nil {
+		if err := ce.stat(ctx); err != nil {
+			return false, err
+		}
+		created = ce.LastModified
+	}
+	if created.IsZero() {
+		c.debug(ctx, "load cache: age of entry unknown", F("key", ce.Key))
+		return false, nil
+	}
+	age := c.clock().Now().Sub(created)
+	if age <= lo.maxAge {
+		return false, nil
+	}
+	c.info(ctx, "load cache: ignoring expired entry", F("key", ce.Key), F("age", age.Round(time.Second)), F("maxAge", lo.maxAge))
+	return true, nil
+}
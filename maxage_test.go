@@ -0,0 +1,64 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMaxAge(t *testing.T) {
+	ts := newTestServer(t)
+	ctx := context.TODO()
+	dt := []byte("nightly")
+
+	c := ts.newCache(t)
+	clock := &testClock{now: time.Date(2021, 1, 1, 0, 10, 0, 0, time.UTC)}
+	c.Clock = clock
+	require.NoError(t, c.Save(ctx, "nightly-v1", bytes.NewReader(dt), int64(len(dt))))
+
+	ce, err := c.LoadWithOpts(ctx, []string{"nightly-v1"}, LoadMaxAge(time.Hour))
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+
+	clock.Advance(2 * time.Hour)
+	ce, err = c.LoadWithOpts(ctx, []string{"nightly-v1"}, LoadMaxAge(time.Hour))
+	require.NoError(t, err)
+	require.Nil(t, ce)
+	ce, err = c.LoadWithOpts(ctx, []string{"nightly-v1"}, LoadMaxAge(3*time.Hour))
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	ce, err = c.Load(ctx, "nightly-v1")
+	require.NoError(t, err)
+	require.NotNil(t, ce, "expired entries are not remembered as misses")
+
+	// the v2 service reports no creation time, the archive is probed
+	modified := clock.Now().Add(-90 * time.Minute)
+	h := ts.Config.Handler
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" && strings.Contains(r.URL.Path, "/blob/") {
+			w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+		}
+		h.ServeHTTP(w, r)
+	})
+	c = ts.newCacheV2(t)
+	c.Clock = clock
+	require.NoError(t, c.Save(ctx, "nightly-v2", bytes.NewReader(dt), int64(len(dt))))
+	ce, err = c.LoadWithOpts(ctx, []string{"nightly-v2"}, LoadMaxAge(time.Hour))
+	require.NoError(t, err)
+	require.Nil(t, ce)
+	ce, err = c.LoadWithOpts(ctx, []string{"nightly-v2"}, LoadMaxAge(2*time.Hour))
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	require.True(t, modified.Truncate(time.Second).Equal(ce.LastModified))
+
+	// entries of unknown age are returned
+	ts.Config.Handler = h
+	ce, err = c.LoadWithOpts(ctx, []string{"nightly-v2"}, LoadMaxAge(time.Hour))
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+}
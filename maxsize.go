@@ -0,0 +1,36 @@
+package actionscache
+
+import (
+	"github.com/pkg/errors"
+)
+
+// MaxEntrySize is the default limit of the size of saved entries, the 10 GB
+// that GitHub accepts per entry. Zero disables the limit.
+var MaxEntrySize int64 = 10 << 30
+
+// SaveMaxSize makes the save fail with ErrCacheSizeExceeded before
+// reserving the key if the payload is larger than n bytes, instead of
+// MaxEntrySize. Streaming saves fail once they have written more. Zero or
+// less disables the limit.
+func SaveMaxSize(n int64) SaveOpt {
+	return func(o *saveOpt) {
+		o.maxSize = n
+		o.maxSizeSet = true
+	}
+}
+
+func (so *saveOpt) sizeLimit() int64 {
+	if so.maxSizeSet {
+		return so.maxSize
+	}
+	return MaxEntrySize
+}
+
+// checkSize returns ErrCacheSizeExceeded if size bytes of key are over the
+// size limit.
+func (so *saveOpt) checkSize(key string, size int64) error {
+	if limit := so.sizeLimit(); limit > 0 && size > limit {
+		return errors.Wrapf(ErrCacheSizeExceeded, "cache %s of %d bytes is larger than the limit of %d bytes", key, size, limit)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaveMaxSize(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	ctx := context.TODO()
+
+	err := c.Save(ctx, "big", bytes.NewReader([]byte("foobar")), 6, SaveMaxSize(5))
+	require.ErrorIs(t, err, ErrCacheSizeExceeded)
+	require.Equal(t, 0, ts.count("POST /_apis/artifactcache/caches"))
+	require.NoError(t, c.Save(ctx, "big", bytes.NewReader([]byte("foobar")), 6, SaveMaxSize(6)))
+
+	defer func(v int64) { MaxEntrySize = v }(MaxEntrySize)
+	MaxEntrySize = 3
+	err = c.Save(ctx, "default", bytes.NewReader([]byte("foobar")), 6)
+	require.ErrorIs(t, err, ErrCacheSizeExceeded)
+	require.NoError(t, c.Save(ctx, "default", bytes.NewReader([]byte("foobar")), 6, SaveMaxSize(0)))
+
+	c.UploadChunkSize = 2
+	w, err := c.SaveWriter(ctx, "stream")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("foo"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("bar"))
+	require.ErrorIs(t, err, ErrCacheSizeExceeded)
+	require.ErrorIs(t, w.Close(), ErrCacheSizeExceeded)
+	require.Nil(t, ts.entry("stream"))
+	require.Len(t, c.Stats().Orphaned, 1)
+}
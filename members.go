@@ -0,0 +1,121 @@
+package actionscache
+
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// Manifest lists the member entries of an artifact stored as multiple
+// cache entries, eg. a sharded archive or a chunk store.
+type Manifest struct {
+	Members []ManifestMember `json:"members"`
+}
+
+// ManifestMember is an entry referenced by a Manifest.
+type ManifestMember struct {
+	Key    string `json:"key"`
+	Size   int64  `json:"size"`
+	Digest string `json:"digest"`
+}
+
+// Member is the data of a member entry to save with SaveMembers.
+type Member struct {
+	Key  string
+	Data io.ReaderAt
+	Size int64
+}
+
+// SaveMembers saves members in parallel and then a Manifest of them under
+// key, so the manifest is only visible once all members are saved. Members
+// that already exist are not uploaded again.
+func (c *Cache) SaveMembers(ctx context.Context, key string, members []Member, opts ...SaveOpt) (*Manifest, error) {
+	m := &Manifest{Members: make([]ManifestMember, len(members))}
+	sem := make(chan struct{}, c.uploadConcurrency())
+	eg, egctx := errgroup.WithContext(ctx)
+	for i, mb := range members {
+		i, mb := i, mb
+		eg.Go(func() error {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			digest, err := c.readerDigest(io.NewSectionReader(mb.Data, 0, mb.Size))
+			if err != nil {
+				return err
+			}
+			m.Members[i] = ManifestMember{Key: mb.Key, Size: mb.Size, Digest: digest}
+			return c.Save(egctx, mb.Key, mb.Data, mb.Size, append(opts, SaveIgnoreAlreadyExists())...)
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	if err := c.SaveValue(ctx, key, m, opts...); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// LoadManifest loads the Manifest saved with SaveMembers for the first
+// matching key. It returns nil if none was found.
+func (c *Cache) LoadManifest(ctx context.Context, keys ...string) (*Manifest, error) {
+	var m Manifest
+	ce, err := c.LoadValue(ctx, &m, keys...)
+	if err != nil || ce == nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// FetchMembers downloads the members of m in parallel into the writers
+// returned by open and verifies their size and digest.
+func (c *Cache) FetchMembers(ctx context.Context, m *Manifest, open func(ManifestMember) (io.Writer, error)) error {
+	sem := make(chan struct{}, c.downloadConcurrency())
+	eg, ctx := errgroup.WithContext(ctx)
+	for _, mb := range m.Members {
+		mb := mb
+		eg.Go(func() error {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			ce, err := c.Load(ctx, mb.Key)
+			if err != nil {
+				return err
+			}
+			if ce == nil || ce.Key != mb.Key {
+				return errors.Wrapf(ErrCacheNotFound, "manifest member %s", mb.Key)
+			}
+			w, err := open(mb)
+			if err != nil {
+				return err
+			}
+			name, h, err := digestHash(mb.Digest)
+			if err != nil {
+				return errors.Wrapf(err, "manifest member %s", mb.Key)
+			}
+			cw := &countWriter{w: io.MultiWriter(w, h)}
+			if err := ce.Download(ctx, cw); err != nil {
+				return err
+			}
+			if cw.n != mb.Size {
+				return errors.Errorf("manifest member %s has size %d, expected %d", mb.Key, cw.n, mb.Size)
+			}
+			if d := hashDigest(name, h); d != mb.Digest {
+				return errors.Errorf("manifest member %s has digest %s, expected %s", mb.Key, d, mb.Digest)
+			}
+			return nil
+		})
+	}
+	return eg.Wait()
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
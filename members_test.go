@@ -0,0 +1,69 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMembers(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+
+	ctx := context.TODO()
+	shards := map[string]string{
+		"shard-0": "first shard",
+		"shard-1": "second shard",
+		"shard-2": "",
+	}
+	var members []Member
+	for _, k := range []string{"shard-0", "shard-1", "shard-2"} {
+		members = append(members, Member{Key: k, Data: bytes.NewReader([]byte(shards[k])), Size: int64(len(shards[k]))})
+	}
+	m, err := c.SaveMembers(ctx, "artifact", members)
+	require.NoError(t, err)
+	require.Len(t, m.Members, 3)
+	require.Equal(t, "shard-1", m.Members[1].Key)
+	require.Equal(t, int64(12), m.Members[1].Size)
+
+	// members are shared between manifests
+	m2, err := c.SaveMembers(ctx, "artifact-2", members[:1])
+	require.NoError(t, err)
+	require.Equal(t, m.Members[0], m2.Members[0])
+
+	m, err = c.LoadManifest(ctx, "artifact")
+	require.NoError(t, err)
+	require.NotNil(t, m)
+
+	var mu sync.Mutex
+	out := map[string]*bytes.Buffer{}
+	require.NoError(t, c.FetchMembers(ctx, m, func(mb ManifestMember) (io.Writer, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		out[mb.Key] = &bytes.Buffer{}
+		return out[mb.Key], nil
+	}))
+	for k, v := range shards {
+		require.Equal(t, v, out[k].String())
+	}
+
+	m.Members[0].Digest = "sha256:0000"
+	err = c.FetchMembers(ctx, m, func(ManifestMember) (io.Writer, error) {
+		return io.Discard, nil
+	})
+	require.Error(t, err)
+
+	m.Members = append(m.Members, ManifestMember{Key: "missing"})
+	err = c.FetchMembers(ctx, &Manifest{Members: m.Members[3:]}, func(ManifestMember) (io.Writer, error) {
+		return io.Discard, nil
+	})
+	require.ErrorIs(t, err, ErrCacheNotFound)
+
+	m, err = c.LoadManifest(ctx, "missing")
+	require.NoError(t, err)
+	require.Nil(t, m)
+}
@@ -0,0 +1,56 @@
+package actionscache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Metadata attributes cache operations to a caller, eg. a job, step or
+// component name. It is attached to a context with WithMetadata.
+type Metadata map[string]string
+
+type metadataKey struct{}
+
+// WithMetadata returns a context carrying md merged with any metadata
+// already attached to ctx. Values in md take precedence.
+func WithMetadata(ctx context.Context, md Metadata) context.Context {
+	merged := Metadata{}
+	for k, v := range MetadataFromContext(ctx) {
+		merged[k] = v
+	}
+	for k, v := range md {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, metadataKey{}, merged)
+}
+
+// MetadataFromContext returns the metadata attached to ctx or nil.
+func MetadataFromContext(ctx context.Context) Metadata {
+	md, _ := ctx.Value(metadataKey{}).(Metadata)
+	return md
+}
+
+func (md Metadata) String() string {
+	keys := make([]string, 0, len(md))
+	for k := range md {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]string, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, k+"="+md[k])
+	}
+	return strings.Join(out, " ")
+}
+
+func (c *Cache) setMetadataHeaders(req *http.Request) {
+	if c.MetadataHeaderPrefix == "" {
+		return
+	}
+	for k, v := range MetadataFromContext(req.Context()) {
+		req.Header.Set(fmt.Sprintf("%s%s", c.MetadataHeaderPrefix, k), v)
+	}
+}
@@ -0,0 +1,31 @@
+package actionscache
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetadata(t *testing.T) {
+	ctx := WithMetadata(context.TODO(), Metadata{"job": "build", "step": "restore"})
+	ctx = WithMetadata(ctx, Metadata{"step": "save", "component": "buildkit"})
+	md := MetadataFromContext(ctx)
+	require.Equal(t, "component=buildkit job=build step=save", md.String())
+
+	ts := newTestServer(t)
+	var headers []http.Header
+	ts.verify = func(r *http.Request, _ []byte) {
+		headers = append(headers, r.Header.Clone())
+	}
+	c := ts.newCache(t)
+	c.MetadataHeaderPrefix = "X-Cache-Meta-"
+
+	_, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	require.Len(t, headers, 1)
+	require.Equal(t, "build", headers[0].Get("X-Cache-Meta-Job"))
+	require.Equal(t, "save", headers[0].Get("X-Cache-Meta-Step"))
+	require.Equal(t, "buildkit", headers[0].Get("X-Cache-Meta-Component"))
+}
@@ -0,0 +1,78 @@
+package actionscache
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+const (
+	endpointBlob     endpoint = "blob"
+	endpointDownload endpoint = "download"
+)
+
+// Metrics receives measurements of a Cache, eg. to feed Prometheus
+// counters. Methods are called concurrently and must not block. The
+// Registry of the metrics package implements it.
+type Metrics interface {
+	// Request is called after every HTTP request with its endpoint, eg.
+	// "lookup", "reserve", "upload", "commit", "twirp", "blob" or
+	// "download", and the response status, 0 if none was received.
+	Request(endpoint string, status int, d time.Duration)
+	// Retry is called before a request of endpoint is sent again.
+	Retry(endpoint string)
+	// Transfer is called with the payload bytes of op, "save" or
+	// "download", as they are transferred.
+	Transfer(op string, n int64)
+	// Chunk is called after every uploaded chunk or block with its size
+	// and the duration including retries.
+	Chunk(size int64, d time.Duration, err error)
+}
+
+// WithMetrics sets the Metrics receiving measurements of the Cache.
+func WithMetrics(m Metrics) Opt {
+	return func(c *Cache) {
+		c.Metrics = m
+	}
+}
+
+type endpointKey struct{}
+
+// withEndpoint marks the requests sent with ctx as requests of ep.
+func withEndpoint(ctx context.Context, ep endpoint) context.Context {
+	return context.WithValue(ctx, endpointKey{}, ep)
+}
+
+func endpointOf(ctx context.Context) endpoint {
+	if ep, ok := ctx.Value(endpointKey{}).(endpoint); ok {
+		return ep
+	}
+	return endpointBlob
+}
+
+func (c *Cache) metrics() Metrics {
+	if c == nil {
+		return nil
+	}
+	return c.Metrics
+}
+
+// measureRequest reports a request sent at start to the Metrics of c.
+func (c *Cache) measureRequest(req *http.Request, resp *http.Response, start time.Time) {
+	m := c.metrics()
+	if m == nil {
+		return
+	}
+	status := 0
+	if resp != nil {
+		status = resp.StatusCode
+	}
+	m.Request(string(endpointOf(req.Context())), status, c.clock().Now().Sub(start))
+}
+
+// measureChunk reports a chunk upload that started at start.
+func (c *Cache) measureChunk(n int64, start time.Time, err error) {
+	if m := c.metrics(); m != nil {
+		m.Chunk(n, c.clock().Now().Sub(start), err)
+	}
+}
@@ -0,0 +1,335 @@
+// Package metrics provides a Registry that collects the measurements of
+// actionscache Caches for long running processes, eg. cache proxies. It
+// can be published with expvar, served in the Prometheus text format or
+// copied into prometheus/client_golang collectors from a Snapshot.
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	actionscache "github.com/tonistiigi/go-actions-cache"
+)
+
+// DefaultBuckets are the upper bounds in seconds of the duration
+// histograms of a Registry created by New.
+var DefaultBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+var _ actionscache.Metrics = &Registry{}
+
+// Registry implements actionscache.Metrics with counters and duration
+// histograms. It is safe for concurrent use by many Caches.
+type Registry struct {
+	buckets []float64
+
+	mu        sync.Mutex
+	requests  map[requestLabels]uint64
+	durations map[string]*histogram
+	retries   map[string]uint64
+	bytes     map[string]uint64
+	chunks    map[string]uint64
+	chunkSize uint64
+	chunkDur  *histogram
+}
+
+type requestLabels struct {
+	Endpoint string
+	Status   int
+}
+
+// New returns a Registry with duration histograms of buckets, the upper
+// bounds in seconds, or DefaultBuckets if none are given.
+func New(buckets ...float64) *Registry {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Registry{
+		buckets:   buckets,
+		requests:  map[requestLabels]uint64{},
+		durations: map[string]*histogram{},
+		retries:   map[string]uint64{},
+		bytes:     map[string]uint64{},
+		chunks:    map[string]uint64{},
+		chunkDur:  newHistogram(buckets),
+	}
+}
+
+// Request counts a request of endpoint with status and observes its
+// duration.
+func (r *Registry) Request(endpoint string, status int, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests[requestLabels{Endpoint: endpoint, Status: status}]++
+	h, ok := r.durations[endpoint]
+	if !ok {
+		h = newHistogram(r.buckets)
+		r.durations[endpoint] = h
+	}
+	h.observe(d.Seconds())
+}
+
+// Retry counts a retried request of endpoint.
+func (r *Registry) Retry(endpoint string) {
+	r.mu.Lock()
+	r.retries[endpoint]++
+	r.mu.Unlock()
+}
+
+// Transfer counts n payload bytes of op.
+func (r *Registry) Transfer(op string, n int64) {
+	if n <= 0 {
+		return
+	}
+	r.mu.Lock()
+	r.bytes[op] += uint64(n)
+	r.mu.Unlock()
+}
+
+// Chunk counts an uploaded chunk by result and observes its duration.
+func (r *Registry) Chunk(size int64, d time.Duration, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.chunks[result]++
+	if size > 0 {
+		r.chunkSize += uint64(size)
+	}
+	r.chunkDur.observe(d.Seconds())
+}
+
+// Snapshot is a copy of the values of a Registry.
+type Snapshot struct {
+	// Requests counts requests by endpoint and response status, 0 if no
+	// response was received.
+	Requests map[string]map[int]uint64 `json:"requests"`
+	// RequestDurations are the request durations by endpoint.
+	RequestDurations map[string]Histogram `json:"requestDurations"`
+	// Retries counts retried requests by endpoint.
+	Retries map[string]uint64 `json:"retries"`
+	// Bytes counts transferred payload bytes by operation, "save" or
+	// "download".
+	Bytes map[string]uint64 `json:"bytes"`
+	// Chunks counts uploaded chunks by result, "ok" or "error".
+	Chunks map[string]uint64 `json:"chunks"`
+	// ChunkBytes is the size of all uploaded chunks.
+	ChunkBytes uint64 `json:"chunkBytes"`
+	// ChunkDurations are the durations of the chunk uploads.
+	ChunkDurations Histogram `json:"chunkDurations"`
+}
+
+// Histogram counts observations in seconds by cumulative upper bounds.
+type Histogram struct {
+	// Buckets are the upper bounds of Counts.
+	Buckets []float64 `json:"buckets"`
+	// Counts are the numbers of observations at most the bucket bound.
+	Counts []uint64 `json:"counts"`
+	Count  uint64   `json:"count"`
+	Sum    float64  `json:"sum"`
+}
+
+// Snapshot returns a copy of the current values of r.
+func (r *Registry) Snapshot() Snapshot {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := Snapshot{
+		Requests:         map[string]map[int]uint64{},
+		RequestDurations: map[string]Histogram{},
+		Retries:          map[string]uint64{},
+		Bytes:            map[string]uint64{},
+		Chunks:           map[string]uint64{},
+		ChunkBytes:       r.chunkSize,
+		ChunkDurations:   r.chunkDur.snapshot(),
+	}
+	for l, n := range r.requests {
+		m, ok := s.Requests[l.Endpoint]
+		if !ok {
+			m = map[int]uint64{}
+			s.Requests[l.Endpoint] = m
+		}
+		m[l.Status] = n
+	}
+	for ep, h := range r.durations {
+		s.RequestDurations[ep] = h.snapshot()
+	}
+	for k, v := range r.retries {
+		s.Retries[k] = v
+	}
+	for k, v := range r.bytes {
+		s.Bytes[k] = v
+	}
+	for k, v := range r.chunks {
+		s.Chunks[k] = v
+	}
+	return s
+}
+
+// String returns the Snapshot of r as JSON, so r can be published with
+// expvar.Publish.
+func (r *Registry) String() string {
+	dt, err := json.Marshal(r.Snapshot())
+	if err != nil {
+		return "{}"
+	}
+	return string(dt)
+}
+
+// WritePrometheus writes the values of r in the Prometheus text exposition
+// format with metric names starting with "actionscache_".
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	s := r.Snapshot()
+	pw := &promWriter{w: w}
+
+	pw.header("actionscache_requests_total", "counter", "HTTP requests by endpoint and status.")
+	for _, ep := range sortedKeys(s.Requests) {
+		statuses := make([]int, 0, len(s.Requests[ep]))
+		for st := range s.Requests[ep] {
+			statuses = append(statuses, st)
+		}
+		sort.Ints(statuses)
+		for _, st := range statuses {
+			pw.sample("actionscache_requests_total", labels("endpoint", ep, "status", strconv.Itoa(st)), float64(s.Requests[ep][st]))
+		}
+	}
+	pw.header("actionscache_request_duration_seconds", "histogram", "HTTP request durations by endpoint.")
+	for _, ep := range sortedKeys(s.RequestDurations) {
+		pw.histogram("actionscache_request_duration_seconds", "endpoint", ep, s.RequestDurations[ep])
+	}
+	pw.header("actionscache_retries_total", "counter", "Retried HTTP requests by endpoint.")
+	for _, ep := range sortedKeys(s.Retries) {
+		pw.sample("actionscache_retries_total", labels("endpoint", ep), float64(s.Retries[ep]))
+	}
+	pw.header("actionscache_transferred_bytes_total", "counter", "Transferred payload bytes by operation.")
+	for _, op := range sortedKeys(s.Bytes) {
+		pw.sample("actionscache_transferred_bytes_total", labels("op", op), float64(s.Bytes[op]))
+	}
+	pw.header("actionscache_chunks_total", "counter", "Uploaded chunks by result.")
+	for _, res := range sortedKeys(s.Chunks) {
+		pw.sample("actionscache_chunks_total", labels("result", res), float64(s.Chunks[res]))
+	}
+	pw.header("actionscache_chunk_bytes_total", "counter", "Bytes of uploaded chunks.")
+	pw.sample("actionscache_chunk_bytes_total", "", float64(s.ChunkBytes))
+	pw.header("actionscache_chunk_duration_seconds", "histogram", "Chunk upload durations including retries.")
+	pw.histogram("actionscache_chunk_duration_seconds", "", "", s.ChunkDurations)
+	return pw.err
+}
+
+// ServeHTTP serves the values of r in the Prometheus text exposition
+// format, eg. as the /metrics endpoint of a daemon.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WritePrometheus(w)
+}
+
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *histogram) snapshot() Histogram {
+	return Histogram{
+		Buckets: append([]float64(nil), h.buckets...),
+		Counts:  append([]uint64(nil), h.counts...),
+		Count:   h.count,
+		Sum:     h.sum,
+	}
+}
+
+type promWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (pw *promWriter) printf(format string, args ...interface{}) {
+	if pw.err != nil {
+		return
+	}
+	_, pw.err = fmt.Fprintf(pw.w, format, args...)
+}
+
+func (pw *promWriter) header(name, typ, help string) {
+	pw.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (pw *promWriter) sample(name, labels string, v float64) {
+	pw.printf("%s%s %s\n", name, labels, strconv.FormatFloat(v, 'g', -1, 64))
+}
+
+func (pw *promWriter) histogram(name, label, value string, h Histogram) {
+	lv := func(kv ...string) string {
+		if label != "" {
+			kv = append([]string{label, value}, kv...)
+		}
+		return labels(kv...)
+	}
+	for i, b := range h.Buckets {
+		pw.sample(name+"_bucket", lv("le", strconv.FormatFloat(b, 'g', -1, 64)), float64(h.Counts[i]))
+	}
+	pw.sample(name+"_bucket", lv("le", "+Inf"), float64(h.Count))
+	pw.sample(name+"_sum", lv(), h.Sum)
+	pw.sample(name+"_count", lv(), float64(h.Count))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labels formats pairs of label names and values.
+func labels(kv ...string) string {
+	if len(kv) == 0 {
+		return ""
+	}
+	s := "{"
+	for i := 0; i+1 < len(kv); i += 2 {
+		if i > 0 {
+			s += ","
+		}
+		s += kv[i] + `="` + labelEscaper.Replace(kv[i+1]) + `"`
+	}
+	return s + "}"
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch m := m.(type) {
+	case map[string]map[int]uint64:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]Histogram:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]uint64:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
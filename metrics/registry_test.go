@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	actionscache "github.com/tonistiigi/go-actions-cache"
+	"github.com/tonistiigi/go-actions-cache/actionscachetest"
+)
+
+func TestRegistry(t *testing.T) {
+	s := actionscachetest.NewServer()
+	defer s.Close()
+	r := New()
+	c, err := s.Cache(actionscache.WithMetrics(r))
+	require.NoError(t, err)
+
+	ctx := context.TODO()
+	dt := []byte("foobar")
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+	ce, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	require.NoError(t, ce.Download(ctx, ioutil.Discard))
+	r.Retry("lookup")
+	r.Chunk(4, 20*time.Second, context.Canceled)
+
+	snap := r.Snapshot()
+	require.Equal(t, uint64(1), snap.Requests["lookup"][200])
+	require.Equal(t, uint64(1), snap.Requests["reserve"][200])
+	require.Equal(t, uint64(1), snap.RequestDurations["lookup"].Count)
+	require.Equal(t, uint64(1), snap.Retries["lookup"])
+	require.Equal(t, uint64(6), snap.Bytes["save"])
+	require.Equal(t, uint64(6), snap.Bytes["download"])
+	require.Equal(t, map[string]uint64{"ok": 1, "error": 1}, snap.Chunks)
+	require.Equal(t, uint64(10), snap.ChunkBytes)
+	require.Equal(t, uint64(2), snap.ChunkDurations.Count)
+	require.Equal(t, uint64(1), snap.ChunkDurations.Counts[len(DefaultBuckets)-3], "only the fast chunk is within 10s")
+
+	var decoded Snapshot
+	require.NoError(t, json.Unmarshal([]byte(r.String()), &decoded))
+	require.Equal(t, snap.Bytes, decoded.Bytes)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	require.True(t, strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain; version=0.0.4"))
+	out := rec.Body.String()
+	require.Contains(t, out, "# TYPE actionscache_requests_total counter\n")
+	require.Contains(t, out, `actionscache_requests_total{endpoint="lookup",status="200"} 1`+"\n")
+	require.Contains(t, out, `actionscache_retries_total{endpoint="lookup"} 1`+"\n")
+	require.Contains(t, out, `actionscache_transferred_bytes_total{op="save"} 6`+"\n")
+	require.Contains(t, out, `actionscache_chunk_duration_seconds_bucket{le="+Inf"} 2`+"\n")
+	require.Contains(t, out, `actionscache_chunk_duration_seconds_bucket{le="10"} 1`+"\n")
+	require.Contains(t, out, `actionscache_request_duration_seconds_count{endpoint="lookup"} 1`+"\n")
+}
+
+func TestLabels(t *testing.T) {
+	require.Equal(t, "", labels())
+	require.Equal(t, `{a="x\"y\\z\n"}`, labels("a", "x\"y\\z\n"))
+}
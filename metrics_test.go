@@ -0,0 +1,87 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type recordingMetrics struct {
+	mu        sync.Mutex
+	requests  map[string]int
+	statuses  map[int]int
+	retries   map[string]int
+	transfers map[string]int64
+	chunks    int
+}
+
+func newRecordingMetrics() *recordingMetrics {
+	return &recordingMetrics{
+		requests:  map[string]int{},
+		statuses:  map[int]int{},
+		retries:   map[string]int{},
+		transfers: map[string]int64{},
+	}
+}
+
+func (m *recordingMetrics) Request(ep string, status int, d time.Duration) {
+	m.mu.Lock()
+	m.requests[ep]++
+	m.statuses[status]++
+	m.mu.Unlock()
+}
+
+func (m *recordingMetrics) Retry(ep string) {
+	m.mu.Lock()
+	m.retries[ep]++
+	m.mu.Unlock()
+}
+
+func (m *recordingMetrics) Transfer(op string, n int64) {
+	m.mu.Lock()
+	m.transfers[op] += n
+	m.mu.Unlock()
+}
+
+func (m *recordingMetrics) Chunk(size int64, d time.Duration, err error) {
+	m.mu.Lock()
+	m.chunks++
+	m.mu.Unlock()
+}
+
+func TestMetrics(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	c.Clock = newTestClock()
+	c.UploadChunkSize = 4
+	m := newRecordingMetrics()
+	WithMetrics(m)(c)
+
+	failed := false
+	ts.fail = func(r *http.Request) int {
+		if r.Method == "POST" && !failed {
+			failed = true
+			return http.StatusServiceUnavailable
+		}
+		return 0
+	}
+
+	ctx := context.TODO()
+	dt := []byte("0123456789")
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+	ce, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	require.NoError(t, ce.Download(ctx, ioutil.Discard))
+
+	require.Equal(t, map[string]int{"reserve": 2, "upload": 3, "commit": 1, "lookup": 1, "download": 1}, m.requests)
+	require.Equal(t, 1, m.statuses[http.StatusServiceUnavailable])
+	require.Equal(t, map[string]int{"reserve": 1}, m.retries)
+	require.Equal(t, map[string]int64{"save": 10, "download": 10}, m.transfers)
+	require.Equal(t, 3, m.chunks)
+}
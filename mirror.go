@@ -0,0 +1,87 @@
+package actionscache
+
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// MirrorResult lists the keys of a Mirror by outcome.
+type MirrorResult struct {
+	// Copied are the keys saved in the destination.
+	Copied []string
+	// Existing are the keys the destination already had.
+	Existing []string
+	// Missing are the keys the source has no entry of.
+	Missing []string
+}
+
+// Mirror copies the entries of keys from src to dst, eg. to promote the
+// caches of a pull request to the default branch or to replicate them to a
+// self-hosted cache. Keys must match exactly, entries dst already has are
+// not copied again. Entries are streamed from the download of src to the
+// upload of dst without being written to disk. A dst that needs the size
+// before the upload, like the v2 service, reads plain entries with range
+// requests, other entries are spooled like by SaveReader. The first failing
+// copy stops the mirror.
+func Mirror(ctx context.Context, src, dst *Cache, keys ...string) (*MirrorResult, error) {
+	if err := src.validateKeys(keys...); err != nil {
+		return nil, err
+	}
+	res := &MirrorResult{}
+	for _, key := range keys {
+		ok, err := dst.exists(ctx, key)
+		if err != nil {
+			return res, errors.Wrapf(err, "failed to mirror cache %s", key)
+		}
+		if ok {
+			res.Existing = append(res.Existing, key)
+			continue
+		}
+		ce, err := src.Load(ctx, key)
+		if err != nil {
+			return res, errors.Wrapf(err, "failed to mirror cache %s", key)
+		}
+		if ce == nil || ce.Key != key {
+			res.Missing = append(res.Missing, key)
+			continue
+		}
+		if err := mirrorEntry(ctx, ce, dst); err != nil {
+			return res, errors.Wrapf(err, "failed to mirror cache %s", key)
+		}
+		src.debug(ctx, "mirrored cache", F("key", key), F("url", dst.URL))
+		res.Copied = append(res.Copied, key)
+	}
+	return res, nil
+}
+
+// mirrorEntry saves the payload of ce in dst under its key.
+func mirrorEntry(ctx context.Context, ce *Entry, dst *Cache) error {
+	if dst.v2 || dst.SingleChunkUploads {
+		if ce.enc.plain() {
+			ra := ce.ReaderAt(ctx)
+			// sharded entries can not be read at random offsets
+			if size, err := ra.Size(); err == nil {
+				return dst.Save(ctx, ce.Key, ra, size)
+			}
+		}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := ce.Download(ctx, pw)
+		pw.CloseWithError(err)
+		done <- err
+	}()
+	err := dst.SaveReader(ctx, ce.Key, pr)
+	pr.CloseWithError(errors.New("mirror aborted"))
+	if err != nil {
+		cancel()
+		<-done
+		return err
+	}
+	return <-done
+}
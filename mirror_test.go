@@ -0,0 +1,55 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMirror(t *testing.T) {
+	ts1 := newTestServer(t)
+	src := ts1.newCache(t)
+	WithUploadChunkSize(4)(src)
+	ctx := context.TODO()
+
+	payloads := map[string][]byte{
+		"a":       []byte("mirrored payload a"),
+		"b":       bytes.Repeat([]byte("b"), 100),
+		"sharded": bytes.Repeat([]byte("s"), 50),
+	}
+	require.NoError(t, src.Save(ctx, "a", bytes.NewReader(payloads["a"]), int64(len(payloads["a"]))))
+	require.NoError(t, src.Save(ctx, "b", bytes.NewReader(payloads["b"]), int64(len(payloads["b"]))))
+	require.NoError(t, src.Save(ctx, "sharded", bytes.NewReader(payloads["sharded"]), int64(len(payloads["sharded"])), SaveSharded(20)))
+	require.NoError(t, src.Save(ctx, "prefix-match", bytes.NewReader(payloads["a"]), int64(len(payloads["a"]))))
+
+	for _, v2 := range []bool{false, true} {
+		ts2 := newTestServer(t)
+		dst := ts2.newCache(t)
+		if v2 {
+			dst = ts2.newCacheV2(t)
+		}
+		WithUploadChunkSize(8)(dst)
+		require.NoError(t, dst.Save(ctx, "b", bytes.NewReader([]byte("old")), 3))
+
+		res, err := Mirror(ctx, src, dst, "a", "b", "sharded", "prefix", "missing")
+		require.NoError(t, err)
+		require.Equal(t, []string{"a", "sharded"}, res.Copied)
+		require.Equal(t, []string{"b"}, res.Existing)
+		require.Equal(t, []string{"prefix", "missing"}, res.Missing)
+
+		for _, key := range res.Copied {
+			ce, err := dst.Load(ctx, key)
+			require.NoError(t, err)
+			require.NotNil(t, ce)
+			buf := &bytes.Buffer{}
+			require.NoError(t, ce.Download(ctx, buf))
+			require.Equal(t, payloads[key], buf.Bytes(), key)
+		}
+		require.Equal(t, "old", string(ts2.entry("b").Data))
+	}
+
+	_, err := Mirror(ctx, src, ts1.newCache(t))
+	require.Error(t, err)
+}
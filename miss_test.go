@@ -0,0 +1,62 @@
+package actionscache
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// missHandler answers lookups with status and body.
+func missHandler(h http.Handler, status int, body string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (r.Method == "GET" && strings.HasSuffix(r.URL.Path, "/cache")) || strings.HasSuffix(r.URL.Path, "/GetCacheEntryDownloadURL") {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			w.Write([]byte(body))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func TestLoadMiss(t *testing.T) {
+	defer func(v time.Duration) { LoadMissTTL = v }(LoadMissTTL)
+	LoadMissTTL = time.Minute
+
+	for _, tc := range []struct {
+		name   string
+		v2     bool
+		status int
+		body   string
+	}{
+		{"v1 no content", false, http.StatusNoContent, ""},
+		{"v1 empty body", false, http.StatusOK, ""},
+		{"v1 null", false, http.StatusOK, "null"},
+		{"v1 empty key", false, http.StatusOK, `{"cacheKey":""}`},
+		{"v1 no location", false, http.StatusOK, `{"cacheKey":"foo","archiveLocation":""}`},
+		{"v1 not found", false, http.StatusNotFound, `{"message":"Cache not found.","typeKey":"ArtifactCacheNotFoundException"}`},
+		{"v2 not found", true, http.StatusNotFound, `{"code":"not_found","msg":"cache entry not found"}`},
+		{"v2 not ok", true, http.StatusOK, `{"ok":false}`},
+		{"v2 empty body", true, http.StatusOK, ""},
+		{"v2 no content", true, http.StatusNoContent, ""},
+		{"v2 no url", true, http.StatusOK, `{"ok":true,"signed_download_url":"","matched_key":"foo"}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			c := ts.newCache(t)
+			if tc.v2 {
+				c = ts.newCacheV2(t)
+			}
+			ts.Config.Handler = missHandler(ts.Config.Handler, tc.status, tc.body)
+			ce, found, err := c.Lookup(context.TODO(), []string{"foo"})
+			require.NoError(t, err)
+			require.False(t, found)
+			require.Nil(t, ce)
+			require.True(t, c.isMiss(c.version("foo")+"|foo"))
+		})
+	}
+}
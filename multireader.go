@@ -0,0 +1,75 @@
+package actionscache
+
+import (
+	"io"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// MultiReaderAt is the concatenation of several BlobSources, eg. files
+// opened with io.NewSectionReader, so a payload made of segments can be
+// saved as one entry without copying them into a temporary file first.
+// Chunks spanning a segment boundary are read from both segments. It is a
+// BlobSource itself and safe for concurrent reads if the segments are.
+type MultiReaderAt struct {
+	parts []BlobSource
+	// offsets are the offsets of parts in the payload
+	offsets []int64
+	size    int64
+}
+
+// NewMultiReaderAt returns the concatenation of parts. Empty parts are
+// skipped.
+func NewMultiReaderAt(parts ...BlobSource) *MultiReaderAt {
+	m := &MultiReaderAt{}
+	for _, p := range parts {
+		n := p.Size()
+		if n <= 0 {
+			continue
+		}
+		m.parts = append(m.parts, p)
+		m.offsets = append(m.offsets, m.size)
+		m.size += n
+	}
+	return m
+}
+
+// Size returns the total size of the parts.
+func (m *MultiReaderAt) Size() int64 {
+	return m.size
+}
+
+// ReadAt reads len(p) bytes at off from the parts covering them.
+func (m *MultiReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.Errorf("negative offset %d", off)
+	}
+	if off >= m.size {
+		return 0, io.EOF
+	}
+	// the last part starting at or before off
+	i := sort.Search(len(m.offsets), func(i int) bool { return m.offsets[i] > off }) - 1
+	n := 0
+	for n < len(p) && i < len(m.parts) {
+		part := m.parts[i]
+		pos := off + int64(n) - m.offsets[i]
+		want := p[n:]
+		if rem := part.Size() - pos; int64(len(want)) > rem {
+			want = want[:rem]
+		}
+		k, err := part.ReadAt(want, pos)
+		n += k
+		if k < len(want) {
+			if err == nil || errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return n, errors.Wrapf(err, "failed to read part %d at %d", i, pos)
+		}
+		i++
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,76 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultiReaderAt(t *testing.T) {
+	m := NewMultiReaderAt(
+		strings.NewReader("abc"),
+		bytes.NewReader(nil),
+		io.NewSectionReader(strings.NewReader("xxdefgxx"), 2, 4),
+		strings.NewReader("h"),
+	)
+	require.Equal(t, int64(8), m.Size())
+
+	for off := 0; off < 8; off++ {
+		for n := 1; off+n <= 8; n++ {
+			p := make([]byte, n)
+			k, err := m.ReadAt(p, int64(off))
+			require.NoError(t, err)
+			require.Equal(t, n, k)
+			require.Equal(t, "abcdefgh"[off:off+n], string(p))
+		}
+	}
+
+	p := make([]byte, 4)
+	n, err := m.ReadAt(p, 6)
+	require.Equal(t, io.EOF, err)
+	require.Equal(t, "gh", string(p[:n]))
+	_, err = m.ReadAt(p, 8)
+	require.Equal(t, io.EOF, err)
+	_, err = m.ReadAt(p, -1)
+	require.Error(t, err)
+
+	// a part shorter than it claims fails the read
+	short := NewMultiReaderAt(io.NewSectionReader(strings.NewReader("ab"), 0, 4), strings.NewReader("cd"))
+	_, err = short.ReadAt(make([]byte, 6), 0)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestSaveMultiReaderAt(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	c.UploadChunkSize = 5
+
+	dir := t.TempDir()
+	var parts []BlobSource
+	var want []byte
+	for i, s := range []string{"first file\n", "second\n", "3\n"} {
+		p := filepath.Join(dir, string(rune('a'+i)))
+		require.NoError(t, os.WriteFile(p, []byte(s), 0600))
+		f, err := os.Open(p)
+		require.NoError(t, err)
+		defer f.Close()
+		parts = append(parts, io.NewSectionReader(f, 0, int64(len(s))))
+		want = append(want, s...)
+	}
+	m := NewMultiReaderAt(parts...)
+
+	ctx := context.TODO()
+	require.NoError(t, c.Save(ctx, "segments", m, m.Size()))
+	ce, err := c.Load(ctx, "segments")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	var buf bytes.Buffer
+	require.NoError(t, ce.Download(ctx, &buf))
+	require.Equal(t, want, buf.Bytes())
+}
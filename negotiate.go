@@ -0,0 +1,148 @@
+package actionscache
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNoSupportedAPI is returned by Negotiate when neither the v2 nor the
+// v1 cache service answers.
+var ErrNoSupportedAPI = errors.New("no supported cache service API found")
+
+// negotiated remembers the protocol Negotiate picked for a pair of URLs for
+// the lifetime of the process.
+var negotiated = struct {
+	sync.Mutex
+	m map[string]string
+}{m: map[string]string{}}
+
+// Negotiate returns a Cache for the newest cache service API the
+// environment supports. It probes the v2 service at resultsURL, the value
+// of ACTIONS_RESULTS_URL, and then the deprecated v1 service at cacheURL,
+// the value of ACTIONS_CACHE_URL, with a lookup of a key that does not
+// exist. Either URL may be empty. The result is remembered for the URLs,
+// so later calls do not probe again. A Cache falling back to v1 logs a
+// warning and reports it to the OnDeprecation of its Events.
+func Negotiate(ctx context.Context, token, cacheURL, resultsURL string, opts ...Opt) (*Cache, error) {
+	c, err := newCache(token, cacheURL, opts)
+	if err != nil {
+		return nil, err
+	}
+	id := cacheURL + "\x00" + resultsURL
+	negotiated.Lock()
+	protocol := negotiated.m[id]
+	negotiated.Unlock()
+	if protocol == "" {
+		if protocol, err = c.negotiate(ctx, cacheURL, resultsURL); err != nil {
+			return nil, err
+		}
+		negotiated.Lock()
+		negotiated.m[id] = protocol
+		negotiated.Unlock()
+	}
+	c.useProtocol(protocol, cacheURL, resultsURL)
+	if protocol == "v1" && resultsURL != "" {
+		c.deprecated(ctx, "the v1 cache service is deprecated and the v2 service is not available")
+	}
+	c.startWarmUp()
+	return c, nil
+}
+
+// NegotiateEnv is Negotiate with the runtime token and cache URLs of the
+// environment. It returns nil without them, and the Cache of TryEnv for
+// the S3 backend.
+func NegotiateEnv(ctx context.Context, opts ...Opt) (*Cache, error) {
+	if _, ok := S3ConfigFromEnv(); ok {
+		return TryEnv(opts...)
+	}
+	token, ok := os.LookupEnv("ACTIONS_RUNTIME_TOKEN")
+	if !ok {
+		return nil, nil
+	}
+	cacheURL, resultsURL := os.Getenv("ACTIONS_CACHE_URL"), os.Getenv("ACTIONS_RESULTS_URL")
+	if cacheURL == "" && resultsURL == "" {
+		return nil, nil
+	}
+	c, err := Negotiate(ctx, token, cacheURL, resultsURL, opts...)
+	if err != nil {
+		return nil, err
+	}
+	c.GHES = c.GHES || (!c.v2 && isGHESEnv())
+	return c, nil
+}
+
+// negotiate returns the protocol of the first of the v2 and v1 services
+// that answers a probe.
+func (c *Cache) negotiate(ctx context.Context, cacheURL, resultsURL string) (string, error) {
+	var errs []error
+	for _, protocol := range []string{"v2", "v1"} {
+		if c.useProtocol(protocol, cacheURL, resultsURL) == "" {
+			continue
+		}
+		err := c.probe(ctx)
+		if err == nil {
+			c.debug(ctx, "negotiated cache service API", F("protocol", protocol), F("url", c.URL))
+			return protocol, nil
+		}
+		if ctx.Err() != nil {
+			return "", err
+		}
+		c.debug(ctx, "cache service API not available", F("protocol", protocol), F("url", c.URL), F("error", err))
+		errs = append(errs, errors.Wrap(err, protocol))
+	}
+	return "", errors.Wrapf(ErrNoSupportedAPI, "%v", errs)
+}
+
+// useProtocol points c at the service of protocol and returns its URL.
+func (c *Cache) useProtocol(protocol, cacheURL, resultsURL string) string {
+	c.v2 = protocol == "v2"
+	if c.v2 {
+		c.URL = resultsURL
+	} else {
+		c.URL = cacheURL
+	}
+	return c.URL
+}
+
+// probe looks up a key that does not exist and returns an error if the
+// service does not implement the lookup.
+func (c *Cache) probe(ctx context.Context) error {
+	req, ep, err := c.diagnoseRequest(ctx)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ep, req)
+	if err != nil {
+		return err
+	}
+	dt, _ := ioutil.ReadAll(io.LimitReader(resp.Body, c.maxErrorBodySize()))
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		// a twirp error other than bad_route is a miss of a supported service
+		var te twirpError
+		if ep == endpointTwirp && json.Unmarshal(dt, &te) == nil && te.Code != "" && te.Code != "bad_route" {
+			return nil
+		}
+		return errors.Errorf("lookup is not implemented: %s", resp.Status)
+	case http.StatusMethodNotAllowed, http.StatusGone, http.StatusNotImplemented:
+		return errors.Errorf("lookup is not implemented: %s", resp.Status)
+	}
+	if resp.StatusCode >= 500 {
+		return errors.Errorf("lookup failed: %s", resp.Status)
+	}
+	return nil
+}
+
+// deprecated reports the use of a deprecated API.
+func (c *Cache) deprecated(ctx context.Context, msg string) {
+	c.warn(ctx, msg, F("url", c.URL))
+	c.events().OnDeprecation(ctx, DeprecationEvent{Protocol: c.Protocol(), URL: c.URL, Message: msg})
+}
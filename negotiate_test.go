@@ -0,0 +1,118 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+type deprecationEvents struct {
+	NopEvents
+	events []DeprecationEvent
+}
+
+func (e *deprecationEvents) OnDeprecation(_ context.Context, ev DeprecationEvent) {
+	e.events = append(e.events, ev)
+}
+
+func TestNegotiate(t *testing.T) {
+	ts := newTestServer(t)
+	ctx := context.TODO()
+	token := testToken(t, Scope{Scope: "refs/heads/main", Permission: PermissionRead | PermissionWrite})
+
+	c, err := Negotiate(ctx, token, ts.URL+"/v1/", ts.URL+"/")
+	require.NoError(t, err)
+	require.Equal(t, "v2", c.Protocol())
+	require.Equal(t, ts.URL+"/", c.URL)
+	require.Equal(t, 1, ts.count("POST /twirp/"))
+	require.Equal(t, 0, ts.count("GET /v1/"))
+
+	dt := []byte("negotiated")
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+
+	// the result is remembered for the URLs
+	n := len(ts.requests)
+	c, err = Negotiate(ctx, token, ts.URL+"/v1/", ts.URL+"/")
+	require.NoError(t, err)
+	require.Equal(t, "v2", c.Protocol())
+	require.Len(t, ts.requests, n)
+	ce, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+}
+
+func TestNegotiateTwirpNotFound(t *testing.T) {
+	ts := newTestServer(t)
+	code := "not_found"
+	h := ts.Config.Handler
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/twirp/") {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"code":"` + code + `","msg":"not found"}`))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+	ctx := context.TODO()
+	token := testToken(t, Scope{Scope: "refs/heads/main", Permission: PermissionRead | PermissionWrite})
+
+	// a miss of the v2 service is not a missing service
+	c, err := Negotiate(ctx, token, ts.URL+"/", ts.URL+"/results/")
+	require.NoError(t, err)
+	require.Equal(t, "v2", c.Protocol())
+
+	code = "bad_route"
+	c, err = Negotiate(ctx, token, ts.URL+"/", ts.URL+"/other/")
+	require.NoError(t, err)
+	require.Equal(t, "v1", c.Protocol())
+}
+
+func TestNegotiateDeprecated(t *testing.T) {
+	ts := newTestServer(t)
+	ts.fail = func(r *http.Request) int {
+		if strings.HasPrefix(r.URL.Path, "/twirp/") {
+			return http.StatusNotFound
+		}
+		return 0
+	}
+	ctx := context.TODO()
+	token := testToken(t, Scope{Scope: "refs/heads/main", Permission: PermissionRead | PermissionWrite})
+
+	l := &recordingLogger{}
+	ev := &deprecationEvents{}
+	c, err := Negotiate(ctx, token, ts.URL+"/", ts.URL+"/results/", WithLogger(l), WithEvents(ev))
+	require.NoError(t, err)
+	require.Equal(t, "v1", c.Protocol())
+	require.Equal(t, ts.URL+"/", c.URL)
+	require.Len(t, ev.events, 1)
+	require.Equal(t, "v1", ev.events[0].Protocol)
+	require.Equal(t, ts.URL+"/", ev.events[0].URL)
+	e := l.find(ev.events[0].Message)
+	require.NotNil(t, e)
+	require.Equal(t, "warn", e.level)
+
+	dt := []byte("deprecated")
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+	ce, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+
+	// without a results URL v1 is not reported as deprecated
+	ev.events = nil
+	c, err = Negotiate(ctx, token, ts.URL+"/", "", WithEvents(ev))
+	require.NoError(t, err)
+	require.Equal(t, "v1", c.Protocol())
+	require.Empty(t, ev.events)
+
+	ts.fail = func(r *http.Request) int {
+		return http.StatusGone
+	}
+	_, err = Negotiate(ctx, token, ts.URL+"/", ts.URL+"/gone/")
+	require.True(t, errors.Is(err, ErrNoSupportedAPI))
+}
@@ -0,0 +1,158 @@
+package actionscache
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// OperationRecord is a line of the operation log written to
+// Cache.OperationLog. Its JSON encoding is stable.
+type OperationRecord struct {
+	Time    time.Time `json:"time"`
+	Op      string    `json:"op"`
+	Key     string    `json:"key"`
+	Version string    `json:"version"`
+	// Result is "hit" or "miss" for loads, "ok" or "error" otherwise.
+	Result     string `json:"result"`
+	Error      string `json:"error,omitempty"`
+	Bytes      int64  `json:"bytes"`
+	DurationMs int64  `json:"durationMs"`
+	// CorrelationID is the ID sent with the requests of the operation.
+	CorrelationID string `json:"correlationID,omitempty"`
+	// OperationID is the opID field of the log lines of the operation.
+	OperationID string `json:"operationID,omitempty"`
+}
+
+// WithOperationLog appends a JSON line OperationRecord for every load, save
+// and download to w, eg. a file uploaded as a workflow artifact.
+func WithOperationLog(w io.Writer) Opt {
+	return func(c *Cache) {
+		c.OperationLog = w
+	}
+}
+
+type opLogKey struct{}
+
+// recordsOp reports if an operation started with ctx is recorded.
+func (c *Cache) recordsOp(ctx context.Context) bool {
+	return c != nil && c.OperationLog != nil && ctx.Value(opLogKey{}) == nil
+}
+
+// startOp starts op with a correlation and operation ID and starts
+// recording it. The
+// returned function writes the record and returns err with the correlation
+// ID and the request that failed, operations started from the returned
+// context are not recorded again.
+func (c *Cache) startOp(ctx context.Context, op, key string) (context.Context, func(n int64, hit bool, err error) error) {
+	ctx = withRequestTracker(withOperationID(withCorrelationID(ctx)))
+	if !c.recordsOp(ctx) {
+		return ctx, func(_ int64, _ bool, err error) error {
+			return c.opFailed(ctx, op, key, correlate(ctx, withRequest(ctx, op, err)))
+		}
+	}
+	start := c.clock().Now()
+	return context.WithValue(ctx, opLogKey{}, struct{}{}), func(n int64, hit bool, err error) error {
+		id, _ := CorrelationIDFromContext(ctx)
+		opID, _ := operationID(ctx)
+		rec := OperationRecord{
+			Time:          start.UTC(),
+			Op:            op,
+			Key:           key,
+			Version:       c.keyVersion(ctx, key),
+			Result:        "ok",
+			Bytes:         n,
+			DurationMs:    c.clock().Now().Sub(start).Milliseconds(),
+			CorrelationID: id,
+			OperationID:   opID,
+		}
+		switch {
+		case err != nil:
+			rec.Result = "error"
+			rec.Error = err.Error()
+		case op == "load" && hit:
+			rec.Result = "hit"
+		case op == "load":
+			rec.Result = "miss"
+		}
+		c.writeOpRecord(rec)
+		return c.opFailed(ctx, op, key, correlate(ctx, withRequest(ctx, op, err)))
+	}
+}
+
+// opFailed reports the error of op to the Events of c and returns it.
+func (c *Cache) opFailed(ctx context.Context, op, key string, err error) error {
+	if err != nil {
+		c.events().OnError(ctx, ErrorEvent{Op: op, Key: key, Err: err})
+	}
+	return err
+}
+
+func (c *Cache) writeOpRecord(rec OperationRecord) {
+	dt, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	c.opLogMu.Lock()
+	defer c.opLogMu.Unlock()
+	if _, err := c.OperationLog.Write(append(dt, '\n')); err != nil {
+		c.warn(context.TODO(), "failed to write operation log", F("error", err))
+	}
+}
+
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+type countWriterAt struct {
+	w io.WriterAt
+	n int64
+}
+
+func (cw *countWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	n, err := cw.w.WriteAt(p, off)
+	atomic.AddInt64(&cw.n, int64(n))
+	return n, err
+}
+
+// opLogWriter records a SaveWriter operation when it is closed.
+type opLogWriter struct {
+	w      io.WriteCloser
+	n      int64
+	done   func(int64, bool, error) error
+	closed bool
+}
+
+func (ow *opLogWriter) Write(p []byte) (int, error) {
+	n, err := ow.w.Write(p)
+	ow.n += int64(n)
+	return n, err
+}
+
+func (ow *opLogWriter) Close() error {
+	err := ow.w.Close()
+	if !ow.closed {
+		ow.closed = true
+		err = ow.done(ow.n, false, err)
+	}
+	return err
+}
+
+func (ow *opLogWriter) abort(err error) {
+	if a, ok := ow.w.(interface{ abort(error) }); ok {
+		a.abort(err)
+	}
+	if !ow.closed {
+		ow.closed = true
+		ow.done(ow.n, false, err)
+	}
+}
@@ -0,0 +1,68 @@
+package actionscache
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOperationLog(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	buf := &bytes.Buffer{}
+	WithOperationLog(buf)(c)
+
+	ctx := context.TODO()
+	ce, err := c.Load(ctx, "oplog")
+	require.NoError(t, err)
+	require.Nil(t, ce)
+
+	dt := []byte("operation log")
+	require.NoError(t, c.Save(ctx, "oplog", bytes.NewReader(dt), int64(len(dt))))
+	require.NoError(t, c.SaveReader(ctx, "oplog-reader", bytes.NewReader(dt)))
+
+	ce, err = c.Load(ctx, "oplog")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	require.NoError(t, ce.Download(ctx, &bytes.Buffer{}))
+	require.NoError(t, ce.DownloadAt(ctx, &bufferAt{}))
+
+	ts.fail = func(r *http.Request) int { return http.StatusForbidden }
+	require.Error(t, c.Save(ctx, "oplog-fail", bytes.NewReader(dt), int64(len(dt))))
+
+	var recs []OperationRecord
+	s := bufio.NewScanner(buf)
+	for s.Scan() {
+		var rec OperationRecord
+		require.NoError(t, json.Unmarshal(s.Bytes(), &rec))
+		recs = append(recs, rec)
+	}
+	require.Len(t, recs, 7)
+
+	type result struct {
+		Op, Key, Result string
+		Bytes           int64
+	}
+	var results []result
+	for _, rec := range recs {
+		require.Equal(t, c.version(rec.Key), rec.Version)
+		results = append(results, result{rec.Op, rec.Key, rec.Result, rec.Bytes})
+	}
+	require.Equal(t, []result{
+		{"load", "oplog", "miss", 0},
+		{"save", "oplog", "ok", int64(len(dt))},
+		{"save", "oplog-reader", "ok", int64(len(dt))},
+		{"load", "oplog", "hit", 0},
+		{"download", "oplog", "ok", int64(len(dt))},
+		{"download", "oplog", "ok", int64(len(dt))},
+		{"save", "oplog-fail", "error", int64(len(dt))},
+	}, results)
+	require.NotEmpty(t, recs[6].Error)
+	require.NotEmpty(t, recs[0].OperationID)
+	require.NotEqual(t, recs[0].OperationID, recs[1].OperationID)
+}
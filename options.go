@@ -0,0 +1,290 @@
+package actionscache
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Opt configures a Cache created with New, NewV2 or TryEnv.
+type Opt func(*Cache)
+
+// WithUploadConcurrency sets the number of parallel chunk uploads.
+func WithUploadConcurrency(n int) Opt {
+	return func(c *Cache) {
+		c.UploadConcurrency = n
+	}
+}
+
+// WithUploadChunkSize sets the size of uploaded chunks.
+func WithUploadChunkSize(n int) Opt {
+	return func(c *Cache) {
+		c.UploadChunkSize = n
+	}
+}
+
+// WithDownloadConcurrency sets the number of parallel range requests of
+// Entry.DownloadAt.
+func WithDownloadConcurrency(n int) Opt {
+	return func(c *Cache) {
+		c.DownloadConcurrency = n
+	}
+}
+
+// WithDownloadChunkSize sets the size of the range requests of
+// Entry.DownloadAt.
+func WithDownloadChunkSize(n int) Opt {
+	return func(c *Cache) {
+		c.DownloadChunkSize = n
+	}
+}
+
+// WithDownloadResumeAttempts sets how many times an interrupted download
+// is resumed, a negative value disables resuming.
+func WithDownloadResumeAttempts(n int) Opt {
+	return func(c *Cache) {
+		c.DownloadResumeAttempts = n
+	}
+}
+
+// WithHTTPClient sets the client used for all requests, including blob
+// uploads and downloads.
+func WithHTTPClient(client *http.Client) Opt {
+	return func(c *Cache) {
+		c.HTTPClient = client
+	}
+}
+
+// WithTransport sets the RoundTripper used for all requests, eg. for
+// proxies, custom TLS configuration or tracing. It replaces a client set
+// with WithHTTPClient.
+func WithTransport(rt http.RoundTripper) Opt {
+	return func(c *Cache) {
+		c.HTTPClient = &http.Client{Transport: rt}
+	}
+}
+
+// MaxIdleConnsPerHost is the default number of idle connections per host
+// that a Cache without an HTTPClient keeps for reuse. The transport of
+// http.DefaultClient keeps two, fewer than the parallel chunk requests of a
+// single save or download.
+var MaxIdleConnsPerHost = 32
+
+// WithMaxIdleConnsPerHost sets the idle connections per host kept by the
+// transport of the Cache. It has no effect with WithHTTPClient or
+// WithTransport.
+func WithMaxIdleConnsPerHost(n int) Opt {
+	return func(c *Cache) {
+		c.MaxIdleConnsPerHost = n
+	}
+}
+
+func (c *Cache) maxIdleConnsPerHost() int {
+	if c != nil && c.MaxIdleConnsPerHost > 0 {
+		return c.MaxIdleConnsPerHost
+	}
+	return MaxIdleConnsPerHost
+}
+
+// KeepAlive is the default interval of the TCP keep-alive probes of the
+// transport of a Cache without an HTTPClient. Built with Go 1.24 or later,
+// HTTP/2 connections are also sent a ping when no frame arrived for that
+// long, and closed when it is not answered in time, so requests on a
+// half-dead connection fail instead of waiting for the TCP timeout of the
+// OS.
+var KeepAlive = 30 * time.Second
+
+// WithKeepAlive sets the interval of the keep-alive probes and HTTP/2 pings
+// of the transport of the Cache, a negative value disables them. It has no
+// effect with WithHTTPClient or WithTransport.
+func WithKeepAlive(d time.Duration) Opt {
+	return func(c *Cache) {
+		c.KeepAlive = d
+	}
+}
+
+func (c *Cache) keepAlive() time.Duration {
+	if c != nil && c.KeepAlive != 0 {
+		return c.KeepAlive
+	}
+	return KeepAlive
+}
+
+// newTransport returns a transport like http.DefaultTransport that keeps
+// maxIdle connections per host, attempts HTTP/2 and probes connections
+// every keepAlive.
+func newTransport(maxIdle int, keepAlive time.Duration) *http.Transport {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.MaxIdleConnsPerHost = maxIdle
+	if tr.MaxIdleConns > 0 && tr.MaxIdleConns < maxIdle {
+		tr.MaxIdleConns = maxIdle
+	}
+	tr.ForceAttemptHTTP2 = true
+	tr.DialContext = (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: keepAlive,
+	}).DialContext
+	configureHTTP2(tr, keepAlive)
+	return tr
+}
+
+// newTransport returns the transport of a Cache without an HTTPClient.
+func (c *Cache) newTransport() *http.Transport {
+	tr := newTransport(c.maxIdleConnsPerHost(), c.keepAlive())
+	switch {
+	case c.DialContext != nil:
+		tr.DialContext = c.DialContext
+	case c.Resolver != nil:
+		tr.DialContext = (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: c.keepAlive(),
+			Resolver:  c.Resolver,
+		}).DialContext
+	}
+	c.configureTLS(tr)
+	return tr
+}
+
+// httpClient returns the HTTPClient of c. Without one every Cache gets its
+// own client, so the connections of one Cache are not limited by the idle
+// pool of another.
+func (c *Cache) httpClient() *http.Client {
+	if c == nil {
+		return http.DefaultClient
+	}
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	c.clientOnce.Do(func() {
+		c.client = &http.Client{Transport: c.newTransport()}
+	})
+	return c.client
+}
+
+// WithDialContext sets the function used to open connections, eg. to pin
+// cache traffic to allowed addresses or to reach a local emulator on a unix
+// socket. It replaces a client set with WithHTTPClient or WithTransport.
+func WithDialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error)) Opt {
+	return func(c *Cache) {
+		c.DialContext = dial
+		c.HTTPClient = nil
+	}
+}
+
+// WithResolver sets the DNS resolver used to connect to the cache service
+// and blob storage. It replaces a client set with WithHTTPClient or
+// WithTransport and a function set with WithDialContext.
+func WithResolver(r *net.Resolver) Opt {
+	return func(c *Cache) {
+		c.Resolver = r
+		c.DialContext = nil
+		c.HTTPClient = nil
+	}
+}
+
+// WithLogger sets the logger of the Cache instead of the package Log.
+func WithLogger(log Logger) Opt {
+	return func(c *Cache) {
+		c.Logger = log
+	}
+}
+
+// WithDownloadIdleTimeout sets the time a download may receive no data before
+// it is aborted.
+func WithDownloadIdleTimeout(d time.Duration) Opt {
+	return func(c *Cache) {
+		c.DownloadIdleTimeout = d
+	}
+}
+
+// WithStepTimeouts sets the ReserveTimeout, CommitTimeout and ChunkTimeout
+// of the Cache so a single stuck request fails fast instead of hanging until
+// the timeout of the whole operation.
+func WithStepTimeouts(reserve, commit, chunk time.Duration) Opt {
+	return func(c *Cache) {
+		c.ReserveTimeout = reserve
+		c.CommitTimeout = commit
+		c.ChunkTimeout = chunk
+	}
+}
+
+// WithTimeouts sets the SoftTimeout and HardTimeout of the Cache.
+func WithTimeouts(soft, hard time.Duration) Opt {
+	return func(c *Cache) {
+		c.SoftTimeout = soft
+		c.HardTimeout = hard
+	}
+}
+
+// WithUserAgent sets the User-Agent header sent with every request.
+func WithUserAgent(ua string) Opt {
+	return func(c *Cache) {
+		c.UserAgent = ua
+	}
+}
+
+// The accessors below return the package defaults for unset fields. They
+// accept a nil Cache for entries that were not returned by Load.
+
+func (c *Cache) uploadConcurrency() int {
+	if c != nil && c.UploadConcurrency > 0 {
+		return c.UploadConcurrency
+	}
+	return UploadConcurrency
+}
+
+func (c *Cache) uploadChunkSize() int {
+	if c != nil && c.UploadChunkSize > 0 {
+		return c.UploadChunkSize
+	}
+	return UploadChunkSize
+}
+
+func (c *Cache) downloadConcurrency() int {
+	if c != nil && c.DownloadConcurrency > 0 {
+		return c.DownloadConcurrency
+	}
+	return DownloadConcurrency
+}
+
+func (c *Cache) downloadIdleTimeout() time.Duration {
+	if c != nil && c.DownloadIdleTimeout > 0 {
+		return c.DownloadIdleTimeout
+	}
+	return DownloadIdleTimeout
+}
+
+func (c *Cache) downloadResumeAttempts() int {
+	if c != nil && c.DownloadResumeAttempts != 0 {
+		return c.DownloadResumeAttempts
+	}
+	return DownloadResumeAttempts
+}
+
+func (c *Cache) downloadChunkSize() int {
+	if c != nil && c.DownloadChunkSize > 0 {
+		return c.DownloadChunkSize
+	}
+	return DownloadChunkSize
+}
+
+// doHTTP sends req once with the configured client and User-Agent, and the
+// correlation ID of its operation.
+func (c *Cache) doHTTP(req *http.Request) (*http.Response, error) {
+	if id, ok := CorrelationIDFromContext(req.Context()); ok {
+		req.Header.Set(CorrelationIDHeader, id)
+	}
+	client := c.httpClient()
+	if c != nil && c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+	start := c.clock().Now()
+	resp, err := c.throttle(req, func(req *http.Request) (*http.Response, error) {
+		return c.sendUpload(req, client.Do)
+	})
+	c.measureRequest(req, resp, start)
+	trackRequest(req, resp, err)
+	c.logHTTP(req, resp, err, start)
+	return resp, err
+}
@@ -0,0 +1,257 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+)
+
+type countingTransport struct {
+	mu sync.Mutex
+	n  int
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	t.n++
+	t.mu.Unlock()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestOptions(t *testing.T) {
+	ts := newTestServer(t)
+	var mu sync.Mutex
+	uas := map[string]int{}
+	ts.verify = func(r *http.Request, body []byte) {
+		mu.Lock()
+		uas[r.Header.Get("User-Agent")]++
+		mu.Unlock()
+	}
+
+	tr := &countingTransport{}
+	var logs []string
+	c, err := New(testToken(t, Scope{Scope: "refs/heads/main", Permission: PermissionRead | PermissionWrite}), ts.URL+"/",
+		WithUploadConcurrency(1),
+		WithUploadChunkSize(4),
+		WithHTTPClient(&http.Client{Transport: tr}),
+		WithLogger(LoggerFunc(func(format string, args ...interface{}) {
+			logs = append(logs, fmt.Sprintf(format, args...))
+		})),
+		WithTimeouts(time.Second, time.Minute),
+		WithUserAgent("test-agent/1.0"),
+	)
+	require.NoError(t, err)
+	require.Equal(t, time.Second, c.SoftTimeout)
+	require.Equal(t, time.Minute, c.HardTimeout)
+
+	ctx := context.TODO()
+	dt := []byte("0123456789")
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+	require.Equal(t, 3, ts.count("PATCH "))
+
+	ce, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	buf := &bytes.Buffer{}
+	require.NoError(t, ce.Download(ctx, buf))
+	require.Equal(t, string(dt), buf.String())
+
+	n := len(ts.requests)
+	require.Equal(t, map[string]int{"test-agent/1.0": n}, uas)
+	require.Equal(t, n, tr.n)
+	require.NotEmpty(t, logs)
+	require.Contains(t, logs[0], "parsed token")
+}
+
+func TestTransport(t *testing.T) {
+	ts := newTestServer(t)
+	tr := &countingTransport{}
+	c, err := NewV2(testToken(t, Scope{Scope: "refs/heads/main", Permission: PermissionRead | PermissionWrite}), ts.URL+"/", WithTransport(tr))
+	require.NoError(t, err)
+
+	ctx := context.TODO()
+	dt := []byte("foobar")
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+	ce, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	buf := &bytes.Buffer{}
+	require.NoError(t, ce.Download(ctx, buf))
+	require.Equal(t, string(dt), buf.String())
+	require.NoError(t, c.WarmUp(ctx))
+
+	require.Greater(t, ts.count("PUT /upload/"), 0)
+	require.Equal(t, len(ts.requests), tr.n)
+}
+
+func TestDialContext(t *testing.T) {
+	ts := newTestServer(t)
+	var mu sync.Mutex
+	var dialed []string
+	c, err := New(testToken(t, Scope{Scope: "refs/heads/main", Permission: PermissionRead | PermissionWrite}), "http://cache.invalid/",
+		WithDialContext(func(ctx context.Context, network, addr string) (net.Conn, error) {
+			mu.Lock()
+			dialed = append(dialed, addr)
+			mu.Unlock()
+			var d net.Dialer
+			return d.DialContext(ctx, network, ts.Listener.Addr().String())
+		}),
+	)
+	require.NoError(t, err)
+
+	ctx := context.TODO()
+	dt := []byte("foobar")
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+	require.NotEmpty(t, dialed)
+	require.Equal(t, "cache.invalid:80", dialed[0])
+}
+
+func TestDialContextOptionOrder(t *testing.T) {
+	ts := newTestServer(t)
+	var mu sync.Mutex
+	var dialed int
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		mu.Lock()
+		dialed++
+		mu.Unlock()
+		var d net.Dialer
+		return d.DialContext(ctx, network, ts.Listener.Addr().String())
+	}
+	// options applied after WithDialContext still configure the transport
+	l := &recordingLogger{}
+	c, err := New(testToken(t, Scope{Scope: "refs/heads/main", Permission: PermissionRead | PermissionWrite}), "http://cache.invalid/",
+		WithDialContext(dial),
+		WithMaxIdleConnsPerHost(7),
+		WithInsecureSkipVerify(),
+		WithLogger(l),
+	)
+	require.NoError(t, err)
+	tr, ok := c.httpClient().Transport.(*http.Transport)
+	require.True(t, ok)
+	require.Equal(t, 7, tr.MaxIdleConnsPerHost)
+	require.True(t, tr.TLSClientConfig.InsecureSkipVerify)
+	e := l.find("TLS certificate verification of cache requests is disabled, do not use this outside of tests")
+	require.NotNil(t, e)
+
+	ctx := context.TODO()
+	dt := []byte("foobar")
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+	mu.Lock()
+	defer mu.Unlock()
+	require.Greater(t, dialed, 0)
+}
+
+func TestPerInstanceUploadSettings(t *testing.T) {
+	ts1 := newTestServer(t)
+	ts2 := newTestServer(t)
+	c1 := ts1.newCache(t)
+	c2 := ts2.newCache(t)
+	WithUploadChunkSize(4)(c1)
+	WithUploadChunkSize(5)(c2)
+	WithUploadConcurrency(1)(c2)
+
+	ctx := context.TODO()
+	dt := []byte("0123456789abcdefghij")
+	var eg errgroup.Group
+	for _, c := range []*Cache{c1, c2} {
+		c := c
+		eg.Go(func() error {
+			return c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt)))
+		})
+	}
+	require.NoError(t, eg.Wait())
+	require.Equal(t, 5, ts1.count("PATCH "))
+	require.Equal(t, 4, ts2.count("PATCH "))
+}
+
+func TestPerInstanceLimits(t *testing.T) {
+	ts := newTestServer(t)
+	c1 := ts.newCache(t)
+	c2 := ts.newCache(t)
+	WithMaxLookupKeys(1)(c1)
+	WithReaderAtBlockSize(4)(c1)
+	WithSaveReaderMemoryLimit(-1)(c1)
+
+	ctx := context.TODO()
+	dt := []byte("0123456789")
+	require.NoError(t, c1.SaveReader(ctx, "foo", bytes.NewReader(dt)))
+	ce, err := c1.Load(ctx, "a", "b", "foo")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	require.Equal(t, 3, ts.count("GET /_apis/artifactcache/cache"))
+	require.Equal(t, int64(4), ce.ReaderAt(ctx).blockSize)
+
+	ce, err = c2.Load(ctx, "c", "d", "foo")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	require.Equal(t, 4, ts.count("GET /_apis/artifactcache/cache"))
+	require.Equal(t, int64(ReaderAtBlockSize), ce.ReaderAt(ctx).blockSize)
+}
+
+func TestDefaultTransport(t *testing.T) {
+	ts := newTestServer(t)
+	c1 := ts.newCache(t)
+	c2 := ts.newCache(t)
+	WithMaxIdleConnsPerHost(8)(c2)
+
+	tr1, ok := c1.httpClient().Transport.(*http.Transport)
+	require.True(t, ok)
+	require.Equal(t, MaxIdleConnsPerHost, tr1.MaxIdleConnsPerHost)
+	require.True(t, tr1.ForceAttemptHTTP2)
+	require.Same(t, c1.httpClient(), c1.httpClient())
+
+	tr2 := c2.httpClient().Transport.(*http.Transport)
+	require.NotSame(t, tr1, tr2)
+	require.Equal(t, 8, tr2.MaxIdleConnsPerHost)
+
+	tr := &countingTransport{}
+	WithTransport(tr)(c1)
+	require.Equal(t, tr, c1.httpClient().Transport)
+}
+
+func TestConcurrentSaveLoad(t *testing.T) {
+	ts := newTestServer(t)
+	for _, c := range []*Cache{ts.newCache(t), ts.newCacheV2(t)} {
+		c := c
+		WithUploadChunkSize(3)(c)
+		t.Run(c.Protocol(), func(t *testing.T) {
+			var eg errgroup.Group
+			for i := 0; i < 32; i++ {
+				key := fmt.Sprintf("concurrent-%s-%d", c.Protocol(), i)
+				dt := []byte("data of " + key)
+				eg.Go(func() error {
+					ctx := context.TODO()
+					if err := c.Save(ctx, key, bytes.NewReader(dt), int64(len(dt))); err != nil {
+						return err
+					}
+					ce, err := c.Load(ctx, key)
+					if err != nil {
+						return err
+					}
+					if ce == nil {
+						return fmt.Errorf("%s not found", key)
+					}
+					buf := &bufferAt{}
+					if err := ce.DownloadAt(ctx, buf); err != nil {
+						return err
+					}
+					if string(buf.buf) != string(dt) {
+						return fmt.Errorf("%s: got %q", key, buf.buf)
+					}
+					return nil
+				})
+			}
+			require.NoError(t, eg.Wait())
+			require.Empty(t, c.Stats().Pending)
+			require.Empty(t, c.Stats().Orphaned)
+		})
+	}
+}
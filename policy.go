@@ -0,0 +1,27 @@
+package actionscache
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/tonistiigi/go-actions-cache/internal/policy"
+)
+
+// OperationPolicy configures failure handling for one type of operation,
+// eg. aggressive retries for restores and giving up quickly on saves. With
+// BestEffort, failures of a Cache are logged before they are dropped.
+type OperationPolicy = policy.OperationPolicy
+
+// withPolicy applies the retry policy and timeout of p to ctx.
+func withPolicy(ctx context.Context, p *OperationPolicy) (context.Context, context.CancelFunc) {
+	return policy.With(ctx, p)
+}
+
+// bestEffort drops err if p is best-effort.
+func (c *Cache) bestEffort(ctx context.Context, p *OperationPolicy, op string, err error) error {
+	if err == nil || p == nil || !p.BestEffort || errors.Is(err, ErrCrossTenant) {
+		return err
+	}
+	c.warn(ctx, "operation failed, ignoring", F("op", op), F("error", err))
+	return nil
+}
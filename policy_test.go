@@ -0,0 +1,71 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOperationPolicy(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	clock := &sleepRecorder{testClock: newTestClock()}
+	c.Clock = clock
+	c.LoadPolicy = &OperationPolicy{
+		RetryPolicy: &RetryPolicy{MaxAttempts: 4, MinBackoff: time.Second},
+	}
+	c.SavePolicy = &OperationPolicy{
+		RetryPolicy: &RetryPolicy{MaxAttempts: 1},
+		BestEffort:  true,
+	}
+	ts.fail = func(r *http.Request) int {
+		return http.StatusBadGateway
+	}
+
+	ctx := context.TODO()
+	dt := []byte("foobar")
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+	require.NoError(t, c.SaveReader(ctx, "foo", bytes.NewReader(dt)))
+	require.Empty(t, clock.sleeps)
+
+	_, err := c.Load(ctx, "foo")
+	require.Error(t, err)
+	require.Len(t, clock.sleeps, 3)
+
+	c.LoadPolicy.BestEffort = true
+	ce, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	require.Nil(t, ce)
+
+	c.Tenant = "owner/a"
+	_, err = c.Load(ForTenant(ctx, "owner/b"), "foo")
+	require.ErrorIs(t, err, ErrCrossTenant)
+	err = c.Save(ForTenant(ctx, "owner/b"), "foo", bytes.NewReader(dt), int64(len(dt)))
+	require.ErrorIs(t, err, ErrCrossTenant)
+}
+
+func TestDeletePolicy(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	c.Clock = newTestClock()
+	c.RetryPolicy = &RetryPolicy{MaxAttempts: 1}
+	ts.fail = func(r *http.Request) int {
+		return http.StatusBadGateway
+	}
+
+	ctx := context.TODO()
+	r := ReservationInfo{ID: 1, Key: "foo"}
+	require.Error(t, c.Abandon(ctx, r))
+	n := ts.count("POST ")
+
+	c.DeletePolicy = &OperationPolicy{
+		RetryPolicy: &RetryPolicy{MaxAttempts: 3},
+		BestEffort:  true,
+	}
+	require.NoError(t, c.Abandon(ctx, r))
+	require.Equal(t, n+3, ts.count("POST "))
+}
@@ -0,0 +1,186 @@
+package actionscache
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// ProgressEvent reports the progress of a save or download after every
+// completed chunk.
+type ProgressEvent struct {
+	// Op is "save" or "download".
+	Op  string
+	Key string
+	// Bytes is the size of the chunk that completed.
+	Bytes int64
+	// Transferred is the number of bytes transferred so far.
+	Transferred int64
+	// Size is the size of the entry, or -1 if it is not known yet.
+	Size int64
+	// Throughput is the average rate since the start in bytes per second.
+	Throughput float64
+	// Speed is the rate over the last seconds in bytes per second. It drops
+	// towards zero while a transfer stalls.
+	Speed float64
+	// ETA is the time left at Speed, or -1 if the size is not known or no
+	// data arrived recently.
+	ETA time.Duration
+}
+
+// progressWindow is the time over which the Speed of ProgressEvents is
+// sampled.
+const progressWindow = 5 * time.Second
+
+// ProgressFunc receives the ProgressEvents of a Cache. It is called from
+// the upload and download goroutines and must not block.
+type ProgressFunc func(ProgressEvent)
+
+// WithProgress sets the callback receiving progress of saves and downloads.
+func WithProgress(fn ProgressFunc) Opt {
+	return func(c *Cache) {
+		c.Progress = fn
+	}
+}
+
+// WithProgressInterval makes downloads also report progress every d, with
+// zero Bytes when no data arrived, so callers can show a stalled download
+// instead of the last rate.
+func WithProgressInterval(d time.Duration) Opt {
+	return func(c *Cache) {
+		c.ProgressInterval = d
+	}
+}
+
+type progressSample struct {
+	t time.Time
+	n int64
+}
+
+type progressTracker struct {
+	fn      ProgressFunc
+	metrics Metrics
+	clock   Clock
+	start   time.Time
+	op      string
+	key     string
+
+	mu          sync.Mutex
+	size        int64
+	transferred int64
+	// samples are the transferred bytes over the last progressWindow,
+	// oldest first
+	samples []progressSample
+}
+
+type progressKey struct{}
+
+// withProgress attaches a tracker for a transfer to ctx if c reports
+// progress or metrics.
+func (c *Cache) withProgress(ctx context.Context, op, key string, size int64) context.Context {
+	if c == nil || (c.Progress == nil && c.Metrics == nil) {
+		return ctx
+	}
+	start := c.clock().Now()
+	return context.WithValue(ctx, progressKey{}, &progressTracker{
+		fn:      c.Progress,
+		metrics: c.Metrics,
+		clock:   c.clock(),
+		start:   start,
+		op:      op,
+		key:     key,
+		size:    size,
+		samples: []progressSample{{t: start}},
+	})
+}
+
+// reportProgress reports n transferred bytes to the tracker of ctx.
+func reportProgress(ctx context.Context, n int64) {
+	p, ok := ctx.Value(progressKey{}).(*progressTracker)
+	if !ok || n == 0 {
+		return
+	}
+	if p.metrics != nil {
+		p.metrics.Transfer(p.op, n)
+	}
+	if p.fn != nil {
+		p.report(n)
+	}
+}
+
+// report calls the callback of p after n more bytes were transferred.
+func (p *progressTracker) report(n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.clock.Now()
+	p.transferred += n
+	ev := ProgressEvent{
+		Op:          p.op,
+		Key:         p.key,
+		Bytes:       n,
+		Transferred: p.transferred,
+		Size:        p.size,
+		ETA:         -1,
+	}
+	if d := now.Sub(p.start); d > 0 {
+		ev.Throughput = float64(p.transferred) / d.Seconds()
+	}
+	p.samples = append(p.samples, progressSample{t: now, n: p.transferred})
+	for len(p.samples) > 2 && now.Sub(p.samples[1].t) >= progressWindow {
+		p.samples = p.samples[1:]
+	}
+	if first := p.samples[0]; now.Sub(first.t) > 0 {
+		ev.Speed = float64(p.transferred-first.n) / now.Sub(first.t).Seconds()
+	}
+	switch {
+	case p.size >= 0 && p.transferred >= p.size:
+		ev.ETA = 0
+	case p.size >= 0 && ev.Speed > 0:
+		ev.ETA = time.Duration(float64(p.size-p.transferred) / ev.Speed * float64(time.Second))
+	}
+	p.fn(ev)
+}
+
+// tickProgress reports the progress of the transfer tracked in ctx every
+// ProgressInterval until the returned function is called.
+func (c *Cache) tickProgress(ctx context.Context) (stop func()) {
+	p, ok := ctx.Value(progressKey{}).(*progressTracker)
+	if !ok || p.fn == nil || c.ProgressInterval <= 0 {
+		return func() {}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for c.clock().Sleep(ctx, c.ProgressInterval) == nil {
+			p.report(0)
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// setProgressSize sets the size of the transfer tracked in ctx once it is
+// known.
+func setProgressSize(ctx context.Context, size int64) {
+	if p, ok := ctx.Value(progressKey{}).(*progressTracker); ok {
+		p.mu.Lock()
+		p.size = size
+		p.mu.Unlock()
+	}
+}
+
+// progressWriter reports the bytes written to w to the tracker of ctx.
+type progressWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	reportProgress(pw.ctx, int64(n))
+	return n, err
+}
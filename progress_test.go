@@ -0,0 +1,147 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgress(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	c.UploadChunkSize = 4
+	c.DownloadChunkSize = 4
+
+	var mu sync.Mutex
+	var events []ProgressEvent
+	c.Progress = func(ev ProgressEvent) {
+		mu.Lock()
+		events = append(events, ev)
+		mu.Unlock()
+	}
+
+	ctx := context.TODO()
+	dt := []byte("0123456789")
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+	require.Len(t, events, 3)
+	var sum int64
+	for _, ev := range events {
+		require.Equal(t, "save", ev.Op)
+		require.Equal(t, "foo", ev.Key)
+		require.Equal(t, int64(10), ev.Size)
+		sum += ev.Bytes
+	}
+	require.Equal(t, int64(10), sum)
+	require.Equal(t, int64(10), events[2].Transferred)
+
+	ce, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+
+	events = nil
+	require.NoError(t, ce.DownloadAt(ctx, &bufferAt{}))
+	require.Len(t, events, 3)
+	require.Equal(t, "download", events[0].Op)
+	require.Equal(t, int64(10), events[0].Size)
+	require.Equal(t, int64(10), events[2].Transferred)
+
+	events = nil
+	require.NoError(t, ce.Download(ctx, &bytes.Buffer{}))
+	require.NotEmpty(t, events)
+	last := events[len(events)-1]
+	require.Equal(t, int64(-1), last.Size)
+	require.Equal(t, int64(10), last.Transferred)
+}
+
+func TestProgressSpeed(t *testing.T) {
+	clock := newTestClock()
+	c := &Cache{Clock: clock}
+	var events []ProgressEvent
+	c.Progress = func(ev ProgressEvent) {
+		events = append(events, ev)
+	}
+	ctx := c.withProgress(context.TODO(), "download", "foo", 1000)
+	for i := 0; i < 4; i++ {
+		clock.Advance(time.Second)
+		reportProgress(ctx, 100)
+	}
+	ev := events[len(events)-1]
+	require.Equal(t, float64(100), ev.Speed)
+	require.Equal(t, 6*time.Second, ev.ETA)
+
+	// the rate of the last seconds counts, not the average
+	for i := 0; i < 6; i++ {
+		clock.Advance(time.Second)
+		reportProgress(ctx, 50)
+	}
+	ev = events[len(events)-1]
+	require.Equal(t, float64(50), ev.Speed)
+	require.Equal(t, float64(70), ev.Throughput)
+	require.Equal(t, 6*time.Second, ev.ETA)
+
+	ctx = c.withProgress(context.TODO(), "download", "foo", -1)
+	clock.Advance(time.Second)
+	reportProgress(ctx, 100)
+	require.Equal(t, time.Duration(-1), events[len(events)-1].ETA)
+}
+
+func TestProgressInterval(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	WithProgressInterval(10 * time.Millisecond)(c)
+
+	var mu sync.Mutex
+	var events []ProgressEvent
+	c.Progress = func(ev ProgressEvent) {
+		mu.Lock()
+		events = append(events, ev)
+		mu.Unlock()
+	}
+
+	ctx := context.TODO()
+	dt := []byte("0123456789")
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+	ce, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+
+	h := ts.Config.Handler
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/blob/") {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(dt)))
+		w.Write(dt[:4])
+		w.(http.Flusher).Flush()
+		time.Sleep(100 * time.Millisecond)
+		w.Write(dt[4:])
+	})
+
+	mu.Lock()
+	events = nil
+	mu.Unlock()
+	buf := &bytes.Buffer{}
+	require.NoError(t, ce.Download(ctx, buf))
+	require.Equal(t, string(dt), buf.String())
+
+	mu.Lock()
+	defer mu.Unlock()
+	var ticks int
+	for _, ev := range events {
+		// ticks while the server stalls after the first bytes
+		if ev.Bytes == 0 && ev.Transferred == 4 {
+			ticks++
+		}
+	}
+	require.Greater(t, ticks, 0)
+	n := len(events)
+	time.Sleep(50 * time.Millisecond)
+	require.Len(t, events, n)
+}
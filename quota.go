@@ -0,0 +1,112 @@
+package actionscache
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// QuotaSource returns the cache policy and usage of the repository of a
+// Cache. RestAPI implements it.
+type QuotaSource interface {
+	Quota(ctx context.Context) (*CacheQuota, error)
+}
+
+// QuotaTTL is how long a Cache uses the quota returned by its QuotaSource
+// before querying it again. Saves of the Cache are added to the usage in
+// the meantime.
+var QuotaTTL = 5 * time.Minute
+
+// WithQuotaTTL sets how long the quota returned by the QuotaSource is used,
+// a negative d queries it before every save.
+func WithQuotaTTL(d time.Duration) Opt {
+	return func(c *Cache) {
+		c.QuotaTTL = d
+	}
+}
+
+func (c *Cache) quotaTTL() time.Duration {
+	if c != nil && c.QuotaTTL != 0 {
+		return c.QuotaTTL
+	}
+	return QuotaTTL
+}
+
+// WithQuota makes saves check the payload against the remaining cache
+// storage returned by src before reserving the key. Larger payloads evict
+// older entries of the repository, or are evicted right away if they are
+// larger than the storage limit. Saves log a warning for them, or fail with
+// ErrQuotaExceeded if failFast is set. Saves are not failed if the quota
+// can not be queried.
+func WithQuota(src QuotaSource, failFast bool) Opt {
+	return func(c *Cache) {
+		c.QuotaSource = src
+		c.QuotaFailFast = failFast
+	}
+}
+
+// Quota returns the cache policy and usage of the repository from the
+// QuotaSource of the Cache, with the bytes saved since it was queried added
+// to the usage. It returns nil without a QuotaSource.
+func (c *Cache) Quota(ctx context.Context) (*CacheQuota, error) {
+	if c.QuotaSource == nil {
+		return nil, nil
+	}
+	now := c.clock().Now()
+	c.mu.Lock()
+	q, saved := c.quota, c.quotaSaved
+	if q != nil && now.Sub(c.quotaAt) >= c.quotaTTL() {
+		q = nil
+	}
+	c.mu.Unlock()
+	if q == nil {
+		var err error
+		if q, err = c.QuotaSource.Quota(ctx); err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		c.quota, c.quotaAt, c.quotaSaved = q, now, 0
+		c.mu.Unlock()
+		saved = 0
+	}
+	out := *q
+	out.Usage.ActiveCachesSizeInBytes += saved
+	return &out, nil
+}
+
+// checkQuota warns about or fails a save of size bytes of key that is
+// larger than the remaining cache storage.
+func (c *Cache) checkQuota(ctx context.Context, key string, size int64) error {
+	if c.QuotaSource == nil {
+		return nil
+	}
+	q, err := c.Quota(ctx)
+	if err != nil {
+		c.debug(ctx, "save cache: failed to get cache quota", F("key", key), F("error", err))
+		return nil
+	}
+	remaining := q.Remaining()
+	if size <= remaining {
+		return nil
+	}
+	msg := "save cache: payload is larger than the remaining cache storage, older entries will be evicted"
+	if size > q.StorageLimit {
+		msg = "save cache: payload is larger than the cache storage limit and will be evicted"
+	}
+	if c.QuotaFailFast {
+		return errors.Wrapf(ErrQuotaExceeded, "cache %s of %d bytes, %d of %d bytes remaining", key, size, remaining, q.StorageLimit)
+	}
+	c.warn(ctx, msg, F("key", key), F("size", size), F("remaining", remaining), F("limit", q.StorageLimit))
+	return nil
+}
+
+// addQuotaUsage adds n saved bytes to the usage of the quota.
+func (c *Cache) addQuotaUsage(n int64) {
+	if c.QuotaSource == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.quotaSaved += n
+}
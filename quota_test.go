@@ -0,0 +1,69 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+type staticQuota struct {
+	q     CacheQuota
+	calls int
+	err   error
+}
+
+func (s *staticQuota) Quota(ctx context.Context) (*CacheQuota, error) {
+	s.calls++
+	if s.err != nil {
+		return nil, s.err
+	}
+	q := s.q
+	return &q, nil
+}
+
+func TestQuota(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	clock := newTestClock()
+	c.Clock = clock
+	l := &recordingLogger{}
+	WithLogger(l)(c)
+	src := &staticQuota{q: CacheQuota{CachePolicy: CachePolicy{StorageLimit: 20, RetentionDays: 7}}}
+	src.q.Usage.ActiveCachesSizeInBytes = 4
+	WithQuota(src, true)(c)
+	ctx := context.TODO()
+
+	dt := []byte("0123456789")
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+	q, err := c.Quota(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(14), q.Usage.ActiveCachesSizeInBytes)
+	require.Equal(t, int64(6), q.Remaining())
+
+	err = c.Save(ctx, "bar", bytes.NewReader(dt), int64(len(dt)))
+	require.True(t, errors.Is(err, ErrQuotaExceeded))
+	require.Nil(t, ts.entry("bar"))
+	require.Equal(t, 1, src.calls)
+
+	// the quota is queried again after QuotaTTL
+	clock.Advance(QuotaTTL + time.Second)
+	require.NoError(t, c.Save(ctx, "bar", bytes.NewReader(dt), int64(len(dt))))
+	require.Equal(t, 2, src.calls)
+
+	c.QuotaFailFast = false
+	big := bytes.Repeat([]byte("x"), 30)
+	require.NoError(t, c.Save(ctx, "big", bytes.NewReader(big), int64(len(big))))
+	e := l.find("save cache: payload is larger than the cache storage limit and will be evicted")
+	require.NotNil(t, e)
+	require.Equal(t, "warn", e.level)
+
+	// saves are not failed when the quota is not available
+	c.QuotaFailFast = true
+	clock.Advance(QuotaTTL + time.Second)
+	src.err = errors.New("forbidden")
+	require.NoError(t, c.Save(ctx, "other", bytes.NewReader(big), int64(len(big))))
+}
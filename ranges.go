@@ -0,0 +1,99 @@
+package actionscache
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// byteRange is a half-open interval [Start, End) of a payload.
+type byteRange struct {
+	Start, End int64
+}
+
+func (r byteRange) String() string {
+	return fmt.Sprintf("%d-%d", r.Start, r.End-1)
+}
+
+// rangeSet tracks the parts of a payload that the service has acknowledged.
+type rangeSet struct {
+	ranges []byteRange
+}
+
+func (rs *rangeSet) add(start, end int64) {
+	rs.ranges = append(rs.ranges, byteRange{Start: start, End: end})
+}
+
+// covered returns the number of bytes of [0, size) in the set.
+func (rs *rangeSet) covered(size int64) int64 {
+	n := size
+	for _, r := range rs.missing(size) {
+		n -= r.End - r.Start
+	}
+	return n
+}
+
+// missing returns the parts of [0, size) not covered by the set.
+func (rs *rangeSet) missing(size int64) []byteRange {
+	return rs.missingIn(0, size)
+}
+
+// missingIn returns the parts of [start, end) not covered by the set.
+func (rs *rangeSet) missingIn(start, end int64) []byteRange {
+	ranges := append([]byteRange(nil), rs.ranges...)
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].Start < ranges[j].Start
+	})
+	var out []byteRange
+	pos := start
+	for _, r := range ranges {
+		if r.End <= pos {
+			continue
+		}
+		if r.Start >= end {
+			break
+		}
+		if r.Start > pos {
+			out = append(out, byteRange{Start: pos, End: r.Start})
+		}
+		pos = r.End
+	}
+	if pos < end {
+		out = append(out, byteRange{Start: pos, End: end})
+	}
+	return out
+}
+
+// parseContentRanges parses the inclusive byte ranges of a Content-Range
+// value, eg. "bytes 0-99/*" or "bytes 0-49,60-99/100".
+func parseContentRanges(s string) ([]byteRange, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimPrefix(s, "bytes")
+	s = strings.TrimLeft(s, " =")
+	if i := strings.IndexByte(s, '/'); i >= 0 {
+		s = s[:i]
+	}
+	var out []byteRange
+	for _, part := range strings.Split(s, ",") {
+		se := strings.SplitN(strings.TrimSpace(part), "-", 2)
+		if len(se) != 2 {
+			return nil, errors.Errorf("invalid range %q", part)
+		}
+		start, err := strconv.ParseInt(se[0], 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid range %q", part)
+		}
+		end, err := strconv.ParseInt(se[1], 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid range %q", part)
+		}
+		if end < start {
+			return nil, errors.Errorf("invalid range %q", part)
+		}
+		out = append(out, byteRange{Start: start, End: end + 1})
+	}
+	return out, nil
+}
@@ -0,0 +1,46 @@
+package actionscache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRangeSetMissing(t *testing.T) {
+	var rs rangeSet
+	require.Equal(t, []byteRange{{0, 10}}, rs.missing(10))
+	require.Empty(t, rs.missing(0))
+
+	rs.add(4, 6)
+	rs.add(0, 2)
+	rs.add(5, 8)
+	require.Equal(t, []byteRange{{2, 4}, {8, 10}}, rs.missing(10))
+
+	rs.add(2, 4)
+	rs.add(8, 10)
+	require.Empty(t, rs.missing(10))
+}
+
+func TestRangeSetMissingIn(t *testing.T) {
+	rs := rangeSet{ranges: []byteRange{{0, 5}, {8, 12}}}
+	require.Equal(t, []byteRange{{5, 8}}, rs.missingIn(3, 10))
+	require.Equal(t, []byteRange{{12, 20}}, rs.missingIn(10, 20))
+	require.Empty(t, rs.missingIn(8, 12))
+}
+
+func TestParseContentRanges(t *testing.T) {
+	for s, exp := range map[string][]byteRange{
+		"bytes 0-99/*":         {{0, 100}},
+		"bytes 0-49,60-99/100": {{0, 50}, {60, 100}},
+		"bytes=10-19":          {{10, 20}},
+		"5-5":                  {{5, 6}},
+	} {
+		r, err := parseContentRanges(s)
+		require.NoError(t, err, s)
+		require.Equal(t, exp, r, s)
+	}
+	for _, s := range []string{"bytes */100", "bytes 10-5", "bytes a-b"} {
+		_, err := parseContentRanges(s)
+		require.Error(t, err, s)
+	}
+}
@@ -0,0 +1,80 @@
+package actionscache
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// WithRequestRateLimit limits the requests per second sent to the cache
+// service by all operations of the Cache together. Zero disables the limit.
+func WithRequestRateLimit(perSec float64) Opt {
+	return func(c *Cache) {
+		c.RequestRateLimit = perSec
+	}
+}
+
+// requestLimiter returns the shared request limiter of c or nil without a
+// limit.
+func (c *Cache) requestLimiter() *rateLimiter {
+	if c.RequestRateLimit <= 0 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.reqLimiter == nil || c.reqLimiter.rate != c.RequestRateLimit {
+		c.reqLimiter = &rateLimiter{clock: c.clock(), rate: c.RequestRateLimit, tokens: c.RequestRateLimit, last: c.clock().Now()}
+	}
+	return c.reqLimiter
+}
+
+// waitRateLimit delays a request to the cache service until the service
+// no longer rate limits the Cache and the request limit allows it.
+func (c *Cache) waitRateLimit(ctx context.Context) error {
+	c.mu.Lock()
+	until := c.rateLimitedUntil
+	c.mu.Unlock()
+	if d := until.Sub(c.clock().Now()); d > 0 {
+		c.debug(ctx, "waiting for rate limit", F("delay", d))
+		if err := c.clock().Sleep(ctx, d); err != nil {
+			return err
+		}
+	}
+	if l := c.requestLimiter(); l != nil {
+		return l.wait(ctx, 1)
+	}
+	return nil
+}
+
+// observeRateLimit pauses all requests to the cache service when resp
+// reports that the rate limit is exhausted, with a 429 status and
+// Retry-After or with x-ratelimit-remaining of 0 until x-ratelimit-reset.
+func (c *Cache) observeRateLimit(ctx context.Context, resp *http.Response) {
+	now := c.clock().Now()
+	var until time.Time
+	if resp.StatusCode == http.StatusTooManyRequests {
+		if d := retryAfter(resp, now); d > 0 {
+			until = now.Add(d)
+		}
+	}
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			if t := time.Unix(reset, 0); t.After(until) {
+				until = t
+			}
+		}
+	}
+	if !until.After(now) {
+		return
+	}
+	c.mu.Lock()
+	paused := until.After(c.rateLimitedUntil)
+	if paused {
+		c.rateLimitedUntil = until
+	}
+	c.mu.Unlock()
+	if paused {
+		c.warn(ctx, "cache service rate limit exceeded, pausing requests", F("until", until))
+	}
+}
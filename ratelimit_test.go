@@ -0,0 +1,84 @@
+package actionscache
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestRateLimit(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	clock := &sleepRecorder{testClock: newTestClock()}
+	c.Clock = clock
+	WithRequestRateLimit(2)(c)
+
+	ctx := context.TODO()
+	for i := 0; i < 4; i++ {
+		_, err := c.Load(ctx, "foo")
+		require.NoError(t, err)
+	}
+	require.Equal(t, []time.Duration{500 * time.Millisecond, 500 * time.Millisecond}, clock.sleeps)
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	clock := &sleepRecorder{testClock: newTestClock()}
+	c.Clock = clock
+
+	var limited int32 = 1
+	h := ts.Config.Handler
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.CompareAndSwapInt32(&limited, 1, 0) {
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(clock.Now().Add(30*time.Second).Unix(), 10))
+		}
+		h.ServeHTTP(w, r)
+	})
+
+	ctx := context.TODO()
+	_, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	require.Empty(t, clock.sleeps)
+
+	// the next request of any operation waits for the reset
+	_, err = c.Load(ctx, "bar")
+	require.NoError(t, err)
+	require.Equal(t, []time.Duration{30 * time.Second}, clock.sleeps)
+
+	clock.sleeps = nil
+	_, err = c.Load(ctx, "baz")
+	require.NoError(t, err)
+	require.Empty(t, clock.sleeps)
+}
+
+func TestRateLimitRetryAfter(t *testing.T) {
+	ts := newTestServer(t)
+	var failed int32
+	ts.fail = func(r *http.Request) int {
+		if atomic.CompareAndSwapInt32(&failed, 0, 1) {
+			return http.StatusTooManyRequests
+		}
+		return 0
+	}
+	ts.Config.Handler = retryAfterHandler(ts.Config.Handler)
+	c := ts.newCache(t)
+	clock := &sleepRecorder{testClock: newTestClock()}
+	c.Clock = clock
+	c.RetryPolicy = &RetryPolicy{MaxAttempts: 1}
+
+	ctx := context.TODO()
+	_, err := c.Load(ctx, "foo")
+	require.Error(t, err)
+	require.Empty(t, clock.sleeps)
+
+	_, err = c.Load(ctx, "foo")
+	require.NoError(t, err)
+	require.Equal(t, []time.Duration{7 * time.Second}, clock.sleeps)
+}
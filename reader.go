@@ -0,0 +1,82 @@
+package actionscache
+
+import (
+	"context"
+	"hash"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// SaveReaderMemoryLimit is how much of the payload SaveReader buffers in
+// memory for the v2 service before spooling it to a temporary file.
+var SaveReaderMemoryLimit = 32 * 1024 * 1024
+
+// WithSaveReaderMemoryLimit sets how much of the payload SaveReader buffers
+// in memory, a negative n spools every payload to a temporary file.
+func WithSaveReaderMemoryLimit(n int) Opt {
+	return func(c *Cache) {
+		c.SaveReaderMemoryLimit = n
+	}
+}
+
+func (c *Cache) saveReaderMemoryLimit() int64 {
+	switch {
+	case c == nil || c.SaveReaderMemoryLimit == 0:
+		return int64(SaveReaderMemoryLimit)
+	case c.SaveReaderMemoryLimit < 0:
+		return 0
+	}
+	return int64(c.SaveReaderMemoryLimit)
+}
+
+// SaveReader saves the data read from r until EOF under key. The v1 service
+// receives it in chunks as it is read, for the v2 service, single chunk
+// uploads and sharded saves the data is spooled first as the size must be
+// known before the upload.
+func (c *Cache) SaveReader(ctx context.Context, key string, r io.Reader, opts ...SaveOpt) error {
+	ctx = withSaveVersion(ctx, opts)
+	ctx, done := c.startOp(ctx, "save", key)
+	ctx, cancel := withPolicy(ctx, c.SavePolicy)
+	defer cancel()
+	cr := &countReader{r: r}
+	opts, finish := c.checksummed(ctx, key, opts)
+	var h hash.Hash
+	if finish != nil {
+		var err error
+		if h, err = c.newHash(); err != nil {
+			return done(0, false, err)
+		}
+		r = io.TeeReader(cr, h)
+	} else {
+		r = cr
+	}
+	err := c.saveReader(ctx, key, r, opts)
+	if err == nil && finish != nil {
+		err = finish(hashDigest(c.hashName(), h))
+	}
+	err = done(cr.n, false, err)
+	return c.bestEffort(ctx, c.SavePolicy, "save cache "+key, err)
+}
+
+func (c *Cache) saveReader(ctx context.Context, key string, r io.Reader, opts []SaveOpt) error {
+	if c.v2 || c.SingleChunkUploads || isSharded(opts) {
+		s, err := newReaderAtFrom(r, -1, c.saveReaderMemoryLimit())
+		if err != nil {
+			return err
+		}
+		defer s.Close()
+		return c.save(ctx, key, s, s.Size(), opts)
+	}
+	w, err := c.saveWriter(ctx, key, opts)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		if a, ok := w.(interface{ abort(error) }); ok {
+			a.abort(err)
+		}
+		return errors.Wrapf(err, "failed to save cache %s", key)
+	}
+	return w.Close()
+}
@@ -0,0 +1,40 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaveReader(t *testing.T) {
+	defer func(v int) { SaveReaderMemoryLimit = v }(SaveReaderMemoryLimit)
+	SaveReaderMemoryLimit = 4
+
+	ts := newTestServer(t)
+	ctx := context.TODO()
+	dt := []byte("0123456789")
+
+	for _, c := range []*Cache{ts.newCache(t), ts.newCacheV2(t)} {
+		c.UploadChunkSize = 4
+		key := "foo-" + c.Protocol()
+		require.NoError(t, c.SaveReader(ctx, key, iotest.OneByteReader(bytes.NewReader(dt))))
+
+		ce, err := c.Load(ctx, key)
+		require.NoError(t, err)
+		require.NotNil(t, ce)
+		buf := &bytes.Buffer{}
+		require.NoError(t, ce.Download(ctx, buf))
+		require.Equal(t, string(dt), buf.String())
+
+		r := io.MultiReader(bytes.NewReader(dt), iotest.ErrReader(iotest.ErrTimeout))
+		err = c.SaveReader(ctx, "bar-"+c.Protocol(), r)
+		require.ErrorIs(t, err, iotest.ErrTimeout)
+		ce, err = c.Load(ctx, "bar-"+c.Protocol())
+		require.NoError(t, err)
+		require.Nil(t, ce)
+	}
+}
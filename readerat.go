@@ -0,0 +1,258 @@
+package actionscache
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ReaderAtBlockSize is the size of the range requests of Entry.ReaderAt.
+var ReaderAtBlockSize = 1024 * 1024
+
+// WithReaderAtBlockSize sets the size of the range requests of
+// Entry.ReaderAt.
+func WithReaderAtBlockSize(n int) Opt {
+	return func(c *Cache) {
+		c.ReaderAtBlockSize = n
+	}
+}
+
+func (c *Cache) readerAtBlockSize() int {
+	if c != nil && c.ReaderAtBlockSize > 0 {
+		return c.ReaderAtBlockSize
+	}
+	return ReaderAtBlockSize
+}
+
+// ReaderAtCacheBlocks is the number of most recently used blocks kept in
+// memory by every Entry.ReaderAt.
+var ReaderAtCacheBlocks = 32
+
+// EntryReaderAt reads a cache archive with HTTP range requests without
+// downloading it first.
+type EntryReaderAt struct {
+	ctx       context.Context
+	ce        *Entry
+	blockSize int64
+
+	mu       sync.Mutex
+	resolved bool
+	size     int64
+	blocks   map[int64][]byte
+	lru      []int64
+}
+
+// ReaderAt returns a random access reader for the archive of ce. Reads
+// fetch blocks of ReaderAtBlockSize that are cached in memory. ctx is used
+// for all requests of the reader. A custom Downloader is not used.
+func (ce *Entry) ReaderAt(ctx context.Context) *EntryReaderAt {
+	return &EntryReaderAt{
+		ctx:       ctx,
+		ce:        ce,
+		blockSize: int64(ce.c.readerAtBlockSize()),
+		size:      -1,
+		blocks:    map[int64][]byte{},
+	}
+}
+
+// Size returns the size of the archive.
+func (r *EntryReaderAt) Size() (int64, error) {
+	if err := r.resolve(); err != nil {
+		return 0, err
+	}
+	if size := r.knownSize(); size >= 0 {
+		return size, nil
+	}
+	if _, err := r.block(0); err != nil {
+		return 0, err
+	}
+	return r.knownSize(), nil
+}
+
+func (r *EntryReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if !r.ce.enc.plain() {
+		return 0, errors.Errorf("compressed or encrypted cache %s does not support random access", r.ce.Key)
+	}
+	if off < 0 {
+		return 0, errors.Errorf("invalid offset %d", off)
+	}
+	if err := r.resolve(); err != nil {
+		return 0, err
+	}
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		if size := r.knownSize(); size >= 0 && pos >= size {
+			return n, io.EOF
+		}
+		b, err := r.block(pos / r.blockSize)
+		if err != nil {
+			return n, err
+		}
+		bo := pos % r.blockSize
+		if bo >= int64(len(b)) {
+			return n, io.EOF
+		}
+		n += copy(p[n:], b[bo:])
+	}
+	return n, nil
+}
+
+// resolve makes r read the entry that the entry of r is an alias of, if it
+// is one. Only payloads small enough to be an alias are checked.
+func (r *EntryReaderAt) resolve() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.resolved {
+		return nil
+	}
+	if err := r.resolveAlias(); err != nil {
+		return err
+	}
+	if r.ce.c != nil && r.ce.c.VerifyChecksums {
+		if err := r.verifiable(); err != nil {
+			return err
+		}
+	}
+	r.resolved = true
+	return nil
+}
+
+// resolveAlias replaces the entry of r with the entry it is an alias of.
+// Must be called with mu held.
+func (r *EntryReaderAt) resolveAlias() error {
+	resp, err := r.ce.getRange(r.ctx, 0, maxAliasSize)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
+		return nil
+	}
+	dt, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxAliasSize+1))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if resp.StatusCode == http.StatusPartialContent {
+		if size, err := contentRangeSize(resp.Header.Get("Content-Range")); err != nil || size > maxAliasSize {
+			return nil
+		}
+	}
+	_, isShards := parseShards(dt)
+	target, isAlias := aliasTarget(dt)
+	if !isAlias && !isShards {
+		return nil
+	}
+	if marked, err := r.ce.marked(r.ctx, dt); err != nil || !marked {
+		return err
+	}
+	if isShards {
+		return errors.Errorf("cache %s is sharded and can not be read at random offsets", r.ce.Key)
+	}
+	te, err := r.ce.loadAlias(r.ctx, target)
+	if err != nil {
+		return err
+	}
+	r.ce = te
+	return nil
+}
+
+// verifiable returns an error for entries with a stored digest, as the data
+// read at random offsets can not be verified.
+func (r *EntryReaderAt) verifiable() error {
+	expected, err := r.ce.expectedDigest(r.ctx)
+	if err != nil {
+		return err
+	}
+	if expected != "" {
+		return errors.Errorf("cache %s has a checksum that random access can not verify", r.ce.Key)
+	}
+	return nil
+}
+
+func (r *EntryReaderAt) knownSize() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.size
+}
+
+func (r *EntryReaderAt) block(i int64) ([]byte, error) {
+	r.mu.Lock()
+	if b, ok := r.blocks[i]; ok {
+		r.touch(i)
+		r.mu.Unlock()
+		return b, nil
+	}
+	r.mu.Unlock()
+
+	b, size, err := r.fetch(i)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if size >= 0 {
+		r.size = size
+	}
+	if _, ok := r.blocks[i]; !ok && ReaderAtCacheBlocks > 0 {
+		if len(r.lru) >= ReaderAtCacheBlocks {
+			delete(r.blocks, r.lru[0])
+			r.lru = r.lru[1:]
+		}
+		r.blocks[i] = b
+		r.lru = append(r.lru, i)
+	}
+	return b, nil
+}
+
+// touch marks block i as most recently used. Must be called with mu held.
+func (r *EntryReaderAt) touch(i int64) {
+	for j, v := range r.lru {
+		if v == i {
+			r.lru = append(append(r.lru[:j:j], r.lru[j+1:]...), i)
+			return
+		}
+	}
+}
+
+// fetch returns block i and the size of the archive, or -1 if the server
+// did not report it.
+func (r *EntryReaderAt) fetch(i int64) ([]byte, int64, error) {
+	off := i * r.blockSize
+	resp, err := r.ce.getRange(r.ctx, off, r.blockSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	size := int64(-1)
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		if size, err = contentRangeSize(resp.Header.Get("Content-Range")); err != nil {
+			return nil, 0, err
+		}
+	case http.StatusOK:
+		// ranges not supported, skip to the block
+		size = resp.ContentLength
+		if _, err := io.CopyN(ioutil.Discard, resp.Body, off); err != nil && err != io.EOF {
+			return nil, 0, errors.WithStack(err)
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		if i == 0 {
+			// empty archive
+			return nil, 0, nil
+		}
+		return nil, -1, nil
+	default:
+		return nil, 0, errors.Errorf("failed to read cache range %d-%d: %s", off, off+r.blockSize-1, resp.Status)
+	}
+	dt, err := ioutil.ReadAll(io.LimitReader(resp.Body, r.blockSize))
+	if err != nil {
+		return nil, 0, errors.WithStack(err)
+	}
+	return dt, size, nil
+}
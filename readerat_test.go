@@ -0,0 +1,60 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReaderAt(t *testing.T) {
+	defer func(v, n int) { ReaderAtBlockSize, ReaderAtCacheBlocks = v, n }(ReaderAtBlockSize, ReaderAtCacheBlocks)
+	ReaderAtBlockSize = 8
+	ReaderAtCacheBlocks = 2
+
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+
+	ctx := context.TODO()
+	dt := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+
+	ce, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+
+	r := ce.ReaderAt(ctx)
+	size, err := r.Size()
+	require.NoError(t, err)
+	require.Equal(t, int64(len(dt)), size)
+
+	p := make([]byte, 10)
+	n, err := r.ReadAt(p, 5)
+	require.NoError(t, err)
+	require.Equal(t, "56789abcde", string(p[:n]))
+
+	// blocks 0 and 1 are cached
+	before := ts.count("GET /blob/foo")
+	n, err = r.ReadAt(p[:4], 2)
+	require.NoError(t, err)
+	require.Equal(t, "2345", string(p[:n]))
+	require.Equal(t, before, ts.count("GET /blob/foo"))
+
+	n, err = r.ReadAt(p, 30)
+	require.Equal(t, io.EOF, err)
+	require.Equal(t, "uvwxyz", string(p[:n]))
+
+	ts.noRanges = true
+	r = ce.ReaderAt(ctx)
+	n, err = r.ReadAt(p, 20)
+	require.NoError(t, err)
+	require.Equal(t, "klmnopqrst", string(p[:n]))
+
+	sr := io.NewSectionReader(r, 0, size)
+	out := &bytes.Buffer{}
+	_, err = io.Copy(out, sr)
+	require.NoError(t, err)
+	require.Equal(t, string(dt), out.String())
+}
@@ -0,0 +1,180 @@
+package actionscache
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+type endpoint string
+
+const (
+	endpointLookup  endpoint = "lookup"
+	endpointReserve endpoint = "reserve"
+	endpointUpload  endpoint = "upload"
+	endpointCommit  endpoint = "commit"
+)
+
+// apiVersions are the api-versions tried for each endpoint, newest first.
+// The first version a server accepts is remembered per Cache.
+var apiVersions = map[endpoint][]string{
+	endpointLookup:  {"6.0-preview.1", "5.1-preview.1"},
+	endpointReserve: {"6.0-preview.1", "5.1-preview.1"},
+	endpointUpload:  {"6.0-preview.1", "5.1-preview.1"},
+	endpointCommit:  {"6.0-preview.1", "5.1-preview.1"},
+}
+
+// endpointAPIVersions returns the api-versions to try for ep.
+func (c *Cache) endpointAPIVersions(ep endpoint) []string {
+	versions := apiVersions[ep]
+	if len(versions) > 0 && len(c.APIVersions) > 0 {
+		return c.APIVersions
+	}
+	return versions
+}
+
+func (c *Cache) apiVersion(ep endpoint) (string, int) {
+	c.mu.Lock()
+	i := c.apiVersions[ep]
+	c.mu.Unlock()
+	versions := c.endpointAPIVersions(ep)
+	if len(versions) == 0 {
+		return "", 0
+	}
+	if c.APIVersion != "" {
+		// a pinned version is never downgraded
+		return c.APIVersion, len(versions)
+	}
+	if i >= len(versions) {
+		i = len(versions) - 1
+	}
+	return versions[i], i
+}
+
+// downgradeAPIVersion switches ep to the next older api-version after the
+// server rejected index i. It returns false if there is nothing to try.
+func (c *Cache) downgradeAPIVersion(ep endpoint, i int) bool {
+	if i+1 >= len(c.endpointAPIVersions(ep)) {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.apiVersions == nil {
+		c.apiVersions = map[endpoint]int{}
+	}
+	if c.apiVersions[ep] <= i {
+		c.apiVersions[ep] = i + 1
+	}
+	return true
+}
+
+// do sends a request to the cache service, retrying transient failures and
+// with an older api-version if the server rejects the one used.
+func (c *Cache) do(ep endpoint, req *http.Request) (*http.Response, error) {
+	req = req.WithContext(withEndpoint(req.Context(), ep))
+	if err := c.breakerAllow(); err != nil {
+		return nil, err
+	}
+	resp, err := c.doRetry(req, func(req *http.Request) (*http.Response, error) {
+		return c.send(ep, req)
+	})
+	c.breakerRecord(req.Context(), resp, err)
+	return resp, err
+}
+
+func (c *Cache) send(ep endpoint, req *http.Request) (*http.Response, error) {
+	refreshed := false
+	for {
+		if err := c.authorize(req); err != nil {
+			return nil, err
+		}
+		v, i := c.apiVersion(ep)
+		if v != "" {
+			req.Header.Set("Accept", "application/json;api-version="+v)
+		}
+		c.setMetadataHeaders(req)
+		sreq, gz, err := c.compressRequest(req)
+		if err != nil {
+			return nil, err
+		}
+		if c.Signer != nil {
+			if err := c.Signer.Sign(sreq); err != nil {
+				return nil, errors.Wrap(err, "failed to sign request")
+			}
+		}
+		if err := c.waitRateLimit(req.Context()); err != nil {
+			return nil, err
+		}
+		resp, err := c.doHTTP(sreq)
+		if err != nil {
+			return nil, err
+		}
+		c.observeRateLimit(req.Context(), resp)
+		recordHeaders(req.Context(), resp)
+		switch {
+		case gz && resp.StatusCode == http.StatusUnsupportedMediaType:
+			c.info(req.Context(), "request compression not supported, retrying uncompressed", F("endpoint", ep))
+			c.disableCompression()
+		case resp.StatusCode == http.StatusUnauthorized && c.TokenSource != nil && !refreshed && canRewind(req) && req.Header.Get("Authorization") != "":
+			c.info(req.Context(), "runtime token rejected, refreshing", F("endpoint", ep))
+			refreshed = true
+			if err := c.refreshToken(req.Context(), true); err != nil {
+				resp.Body.Close()
+				return nil, err
+			}
+		case resp.StatusCode == http.StatusUnauthorized && c.TokenSource == nil && c.tokenExpired(tokenExpirySkew):
+			resp.Body.Close()
+			return nil, errors.WithStack(&TokenExpiredError{Expiry: c.TokenExpiry()})
+		case c.isAPIVersionError(resp) && canRewind(req) && c.downgradeAPIVersion(ep, i):
+			c.info(req.Context(), "api-version rejected, retrying with older version", F("endpoint", ep), F("apiVersion", v))
+		default:
+			return resp, nil
+		}
+		resp.Body.Close()
+		if req, err = rewind(req); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// canRewind reports if req can be sent again.
+func canRewind(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// rewind returns a copy of req with a fresh body for sending it again.
+func rewind(req *http.Request) (*http.Request, error) {
+	req = req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		req.Body = body
+	}
+	return req, nil
+}
+
+// isAPIVersionError reports if resp rejects the requested api-version. The
+// response body is restored for the caller otherwise.
+func (c *Cache) isAPIVersionError(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusBadRequest {
+		return false
+	}
+	dt, _ := ioutil.ReadAll(io.LimitReader(resp.Body, c.maxErrorBodySize()))
+	if bytes.Contains(dt, []byte("VssVersionOutOfRangeException")) || bytes.Contains(dt, []byte("VssInvalidPreviewVersionException")) {
+		resp.Body.Close()
+		return true
+	}
+	resp.Body = &replayBody{Reader: io.MultiReader(bytes.NewReader(dt), resp.Body), Closer: resp.Body}
+	return false
+}
+
+// replayBody is a response body whose start was already read.
+type replayBody struct {
+	io.Reader
+	io.Closer
+}
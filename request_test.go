@@ -0,0 +1,86 @@
+package actionscache
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIVersionFallback(t *testing.T) {
+	var accepts []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accepts = append(accepts, r.Method+" "+r.Header.Get("Accept"))
+		if r.Method == "POST" && r.Header.Get("Accept") == "application/json;api-version=6.0-preview.1" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"typeKey":"VssVersionOutOfRangeException"}`))
+			return
+		}
+		dt, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		if r.Method == "POST" {
+			require.Contains(t, string(dt), `"key":"foo"`)
+			json.NewEncoder(w).Encode(ReserveCacheResp{CacheID: 1})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	c, err := New(testToken(t, Scope{Scope: "refs/heads/main", Permission: PermissionRead | PermissionWrite}), ts.URL+"/")
+	require.NoError(t, err)
+
+	ctx := context.TODO()
+	for i := 0; i < 2; i++ {
+		id, err := c.reserve(ctx, "foo")
+		require.NoError(t, err)
+		require.Equal(t, 1, id)
+	}
+	_, err = c.Load(ctx, "foo")
+	require.NoError(t, err)
+
+	require.Equal(t, []string{
+		"POST application/json;api-version=6.0-preview.1",
+		"POST application/json;api-version=5.1-preview.1",
+		"POST application/json;api-version=5.1-preview.1",
+		"GET application/json;api-version=6.0-preview.1",
+	}, accepts)
+}
+
+func TestAPIVersions(t *testing.T) {
+	var accepts []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accepts = append(accepts, r.Header.Get("Accept"))
+		if r.Header.Get("Accept") != "application/json;api-version=4.0" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"typeKey":"VssInvalidPreviewVersionException"}`))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	c, err := New(testToken(t, Scope{Scope: "refs/heads/main", Permission: PermissionRead}), ts.URL+"/", WithAPIVersions("7.0", "4.0"))
+	require.NoError(t, err)
+	ctx := context.TODO()
+	for i := 0; i < 2; i++ {
+		_, err = c.Load(ctx, "foo")
+		require.NoError(t, err)
+	}
+	require.Equal(t, []string{
+		"application/json;api-version=7.0",
+		"application/json;api-version=4.0",
+		"application/json;api-version=4.0",
+	}, accepts)
+
+	// a pinned version is not negotiated
+	accepts = nil
+	WithAPIVersion("7.0")(c)
+	_, err = c.Load(ctx, "foo")
+	require.Error(t, err)
+	require.Equal(t, []string{"application/json;api-version=7.0"}, accepts)
+}
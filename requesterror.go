@@ -0,0 +1,157 @@
+package actionscache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// RequestIDHeaders are the response headers whose value is reported as the
+// RequestID of a RequestError, in order of preference.
+var RequestIDHeaders = []string{
+	"X-GitHub-Request-Id",
+	"X-MS-Request-Id",
+}
+
+// RequestError is returned by failed Load, Save and Download operations
+// with the request that failed, or the last one sent if none did. The URL
+// has no query, so it carries no signature or token.
+type RequestError struct {
+	// Op is the operation, "load", "save" or "download".
+	Op     string
+	Method string
+	URL    string
+	// StatusCode is 0 if no response was received.
+	StatusCode int
+	// RequestID is the first of the RequestIDHeaders of the response, that
+	// GitHub support can look up.
+	RequestID string
+	// Attempt is the number of the attempt, starting at 1, with the retries
+	// of the RetryPolicy.
+	Attempt int
+	Err     error
+}
+
+func (e *RequestError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %s %s", e.Op, e.Method, e.URL)
+	var details []string
+	if e.StatusCode != 0 {
+		details = append(details, fmt.Sprintf("status %d", e.StatusCode))
+	}
+	if e.RequestID != "" {
+		details = append(details, "request ID "+e.RequestID)
+	}
+	details = append(details, fmt.Sprintf("attempt %d", e.Attempt))
+	fmt.Fprintf(&b, " (%s): %v", strings.Join(details, ", "), e.Err)
+	return b.String()
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+func (e *RequestError) Cause() error {
+	return e.Err
+}
+
+type attemptKey struct{}
+
+// withAttempt returns req marked as the n-th attempt of a request.
+func withAttempt(req *http.Request, n int) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), attemptKey{}, n))
+}
+
+func attemptOf(ctx context.Context) int {
+	if n, ok := ctx.Value(attemptKey{}).(int); ok {
+		return n
+	}
+	return 1
+}
+
+// requestTracker keeps the request reported by the RequestError of an
+// operation.
+type requestTracker struct {
+	mu  sync.Mutex
+	req *RequestError
+}
+
+type requestTrackerKey struct{}
+
+// withRequestTracker returns ctx with a tracker for the requests of an
+// operation unless it belongs to an operation already.
+func withRequestTracker(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(requestTrackerKey{}).(*requestTracker); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, requestTrackerKey{}, &requestTracker{})
+}
+
+// trackRequest records a request sent with the tracker of its context. A
+// failed request is kept until a request for the same URL succeeds, so the
+// successful requests of concurrent chunks do not hide it.
+func trackRequest(req *http.Request, resp *http.Response, err error) {
+	t, ok := req.Context().Value(requestTrackerKey{}).(*requestTracker)
+	if !ok {
+		return
+	}
+	re := &RequestError{
+		Method:  req.Method,
+		URL:     redactURL(req.URL),
+		Attempt: attemptOf(req.Context()),
+	}
+	failed := err != nil
+	if resp != nil {
+		re.StatusCode = resp.StatusCode
+		re.RequestID = requestID(resp)
+		failed = failed || resp.StatusCode >= 400
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !failed && t.req != nil && isFailure(t.req) && (t.req.Method != re.Method || t.req.URL != re.URL) {
+		return
+	}
+	t.req = re
+}
+
+func isFailure(re *RequestError) bool {
+	return re.StatusCode == 0 || re.StatusCode >= 400
+}
+
+func requestID(resp *http.Response) string {
+	for _, k := range RequestIDHeaders {
+		if v := resp.Header.Get(k); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// withRequest adds the request tracked for op in ctx to err.
+func withRequest(ctx context.Context, op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var re *RequestError
+	if errors.As(err, &re) {
+		return err
+	}
+	t, ok := ctx.Value(requestTrackerKey{}).(*requestTracker)
+	if !ok {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.req == nil {
+		return err
+	}
+	re = &RequestError{}
+	*re = *t.req
+	re.Op = op
+	re.Err = err
+	return re
+}
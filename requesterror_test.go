@@ -0,0 +1,68 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestError(t *testing.T) {
+	ts := newTestServer(t)
+	h := ts.Config.Handler
+	failPut := false
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failPut && r.Method == "PUT" && strings.HasPrefix(r.URL.Path, "/upload/") {
+			w.Header().Set("X-MS-Request-Id", "blob-1")
+			http.Error(w, "blob storage down", http.StatusInternalServerError)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+	policy := &RetryPolicy{MaxAttempts: 2, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+	c := ts.newCache(t)
+	c.RetryPolicy = policy
+	ctx := context.TODO()
+	ts.fail = func(r *http.Request) int {
+		return http.StatusBadGateway
+	}
+	_, err := c.Load(ctx, "foo")
+	var re *RequestError
+	require.ErrorAs(t, err, &re)
+	require.Equal(t, "load", re.Op)
+	require.Equal(t, "GET", re.Method)
+	require.Equal(t, ts.URL+"/_apis/artifactcache/cache", re.URL)
+	require.Equal(t, http.StatusBadGateway, re.StatusCode)
+	// the test server numbers its requests
+	require.Equal(t, "2", re.RequestID)
+	require.Equal(t, 2, re.Attempt)
+	require.ErrorIs(t, err, ErrServiceUnavailable)
+	require.Contains(t, err.Error(), "load: GET "+re.URL+" (status 502, request ID 2, attempt 2)")
+	var ce *CorrelationError
+	require.ErrorAs(t, err, &ce)
+
+	// a failed chunk is reported, not the successful requests after it
+	ts.fail = nil
+	failPut = true
+	c = ts.newCacheV2(t)
+	c.RetryPolicy = policy
+	dt := []byte("foobar")
+	err = c.Save(ctx, "request-error-v2", bytes.NewReader(dt), int64(len(dt)))
+	require.ErrorAs(t, err, &re)
+	require.Equal(t, "save", re.Op)
+	require.Equal(t, "PUT", re.Method)
+	require.True(t, strings.HasPrefix(re.URL, ts.URL+"/upload/"))
+	require.NotContains(t, re.URL, "sig=")
+	require.Equal(t, http.StatusInternalServerError, re.StatusCode)
+	require.Equal(t, "blob-1", re.RequestID)
+	require.Equal(t, 2, re.Attempt)
+
+	// retried requests that succeed are not reported
+	failPut = false
+	require.NoError(t, c.Save(ctx, "request-error-v2-ok", bytes.NewReader(dt), int64(len(dt))))
+}
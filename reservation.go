@@ -0,0 +1,88 @@
+package actionscache
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Reservation is a cache ID reserved with Reserve for callers that schedule
+// the chunk uploads of a save themselves. Chunks may be uploaded
+// concurrently and in any order. The reservation is tracked like the ones
+// of Save and reported by Stats until it is committed. Reservations expire
+// if they are not committed in time, see ExpiresAt and Heartbeat.
+type Reservation struct {
+	ReservationInfo
+
+	c     *Cache
+	r     *ReservationInfo
+	mu    sync.Mutex
+	acked rangeSet
+}
+
+// Reserve reserves key for a save whose data is uploaded with
+// Reservation.UploadChunk. The data is stored as is, so Reserve fails for
+// caches that compress or encrypt payloads. It is not supported by the v2
+// service, which uploads entries to blob storage in one pass.
+func (c *Cache) Reserve(ctx context.Context, key string) (*Reservation, error) {
+	if err := c.validateKeys(key); err != nil {
+		return nil, err
+	}
+	if _, err := c.saveOpts(ctx, nil); err != nil {
+		return nil, err
+	}
+	if c.v2 {
+		return nil, errors.New("reserving cache entries is not supported by the v2 cache service")
+	}
+	if !c.encoding().plain() {
+		return nil, errors.New("reserving cache entries is not supported with compression or encryption")
+	}
+	id, err := c.reserve(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	r := c.trackReserve(id, key)
+	return &Reservation{ReservationInfo: *r, c: c, r: r}, nil
+}
+
+// UploadChunk uploads the n bytes of r, starting at offset 0 of r, to off
+// of the entry. Failed uploads are retried like the chunks of Save.
+func (rv *Reservation) UploadChunk(ctx context.Context, off int64, r io.ReaderAt, n int64) error {
+	if off < 0 || n <= 0 {
+		return errors.Errorf("invalid chunk %d+%d of cache %d", off, n, rv.ID)
+	}
+	if err := rv.c.uploadChunk(ctx, rv.ID, &offsetReaderAt{ra: r, base: off}, off, n); err != nil {
+		return err
+	}
+	rv.mu.Lock()
+	rv.acked.add(off, off+n)
+	rv.mu.Unlock()
+	return nil
+}
+
+// Commit finalizes the entry with size bytes. It fails without contacting
+// the service if a part of the data was not uploaded. A reservation whose
+// commit failed is reported by Stats as orphaned.
+func (rv *Reservation) Commit(ctx context.Context, size int64) error {
+	rv.mu.Lock()
+	err := verifyUploaded(rv.ID, &rv.acked, size)
+	rv.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := rv.c.commitEntry(ctx, rv.Key, rv.ID, size); err != nil {
+		if err := reservationExpired(ctx, rv.ReservationInfo, err); errors.Is(err, ErrReservationExpired) {
+			// the service released the key with the reservation
+			rv.c.trackCommit(rv.r)
+			return err
+		}
+		rv.c.trackOrphan(ctx, rv.r, err)
+		return err
+	}
+	recordCacheID(ctx, int64(rv.ID))
+	rv.c.trackCommit(rv.r)
+	rv.c.clearMisses(rv.Key)
+	return nil
+}
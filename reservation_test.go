@@ -0,0 +1,48 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReserve(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	ctx := context.TODO()
+
+	rv, err := c.Reserve(ctx, "foo")
+	require.NoError(t, err)
+	require.NotZero(t, rv.ID)
+	require.Equal(t, "foo", rv.Key)
+	require.Len(t, c.Stats().Pending, 1)
+
+	// chunks are uploaded out of order
+	require.NoError(t, rv.UploadChunk(ctx, 5, bytes.NewReader([]byte("56789")), 5))
+	err = rv.Commit(ctx, 10)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "ranges not uploaded")
+	require.NoError(t, rv.UploadChunk(ctx, 0, bytes.NewReader([]byte("01234")), 5))
+	require.NoError(t, rv.Commit(ctx, 10))
+	require.Empty(t, c.Stats().Pending)
+
+	ce, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	var buf bytes.Buffer
+	require.NoError(t, ce.Download(ctx, &buf))
+	require.Equal(t, "0123456789", buf.String())
+
+	_, err = c.Reserve(ctx, "foo")
+	require.ErrorIs(t, err, ErrReserveConflict)
+
+	c.Compression = "gzip"
+	_, err = c.Reserve(ctx, "bar")
+	require.Error(t, err)
+
+	c2 := ts.newCacheV2(t)
+	_, err = c2.Reserve(ctx, "bar")
+	require.Error(t, err)
+}
@@ -0,0 +1,202 @@
+package actionscache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// MaxOrphanedReservations limits how many reservations of a Cache may be
+// left uncommitted after failed saves before new saves are refused. Zero
+// means no limit.
+var MaxOrphanedReservations = 0
+
+// ErrKeyLocked is matched by errors of saves of a key that this Cache
+// reserved earlier without committing it. The service keeps such keys locked
+// until the reservation expires or is released with Abandon.
+var ErrKeyLocked = errors.New("cache key locked by uncommitted reservation")
+
+// KeyLockedError is returned when a key can not be reserved because of an
+// orphaned reservation of the same Cache.
+type KeyLockedError struct {
+	Reservation ReservationInfo
+	Err         error
+}
+
+func (e *KeyLockedError) Error() string {
+	return fmt.Sprintf("cache key %s locked by uncommitted reservation %d since %s: %v", e.Reservation.Key, e.Reservation.ID, e.Reservation.Created.Format(time.RFC3339), e.Err)
+}
+
+func (e *KeyLockedError) Unwrap() error {
+	return e.Err
+}
+
+func (e *KeyLockedError) Is(target error) bool {
+	return target == ErrKeyLocked
+}
+
+// ReservationInfo describes a cache ID reserved by this process. ID is zero
+// for the v2 service that only assigns it on commit.
+type ReservationInfo struct {
+	ID      int
+	Key     string
+	Created time.Time
+	// ExpiresAt is the estimated expiry of the reservation, see
+	// ReservationTTL. It is zero if reservations do not expire.
+	ExpiresAt time.Time
+}
+
+// Stats is a snapshot of the client state.
+type Stats struct {
+	// Pending are reservations with an upload in progress.
+	Pending []ReservationInfo
+	// Orphaned are reservations whose save failed before commit. They keep
+	// the key locked and consume quota until the service expires them.
+	Orphaned []ReservationInfo
+}
+
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var st Stats
+	for r := range c.pending {
+		st.Pending = append(st.Pending, *r)
+	}
+	st.Orphaned = append(st.Orphaned, c.orphaned...)
+	return st
+}
+
+// Close reports reservations that were never committed. The Cache can
+// still be used after Close.
+func (c *Cache) Close() error {
+	st := c.Stats()
+	if n := len(st.Pending) + len(st.Orphaned); n > 0 {
+		return errors.Errorf("%d cache reservations not committed (%d pending, %d orphaned)", n, len(st.Pending), len(st.Orphaned))
+	}
+	return nil
+}
+
+// WithMaxOrphanedReservations limits how many reservations of the Cache
+// may be orphaned before reserving fails, a negative n disables the limit.
+func WithMaxOrphanedReservations(n int) Opt {
+	return func(c *Cache) {
+		c.MaxOrphanedReservations = n
+	}
+}
+
+func (c *Cache) maxOrphanedReservations() int {
+	if c.MaxOrphanedReservations != 0 {
+		return c.MaxOrphanedReservations
+	}
+	return MaxOrphanedReservations
+}
+
+func (c *Cache) checkReserve() error {
+	max := c.maxOrphanedReservations()
+	if max <= 0 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.orphaned) >= max {
+		return errors.Errorf("refusing to reserve cache, %d reservations already orphaned", len(c.orphaned))
+	}
+	return nil
+}
+
+func (c *Cache) trackReserve(id int, key string) *ReservationInfo {
+	now := c.clock().Now()
+	r := &ReservationInfo{ID: id, Key: key, Created: now, ExpiresAt: c.expiresAt(now)}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pending == nil {
+		c.pending = map[*ReservationInfo]struct{}{}
+	}
+	c.pending[r] = struct{}{}
+	return r
+}
+
+func (c *Cache) trackCommit(r *ReservationInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, r)
+}
+
+func (c *Cache) trackOrphan(ctx context.Context, r *ReservationInfo, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.pending[r]; !ok {
+		return
+	}
+	delete(c.pending, r)
+	c.orphaned = append(c.orphaned, *r)
+	c.warn(ctx, "cache reserved but not committed", F("cacheID", r.ID), F("key", r.Key), F("error", err))
+}
+
+// keyLocked returns a *KeyLockedError for a reservation conflict err of a
+// key with an orphaned reservation, err otherwise.
+func (c *Cache) keyLocked(key string, err error) error {
+	if !errors.Is(err, ErrReserveConflict) {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := len(c.orphaned) - 1; i >= 0; i-- {
+		if c.orphaned[i].Key == key {
+			return &KeyLockedError{Reservation: c.orphaned[i], Err: err}
+		}
+	}
+	return err
+}
+
+// Abandon releases an orphaned reservation reported by Stats. The v1 service
+// has no call to cancel a reservation, so the cache ID is committed empty on
+// a best-effort basis, leaving an empty entry under the key. The v2 service
+// expires reservations itself. The reservation is no longer reported by
+// Stats afterwards, even if the commit failed. DeletePolicy applies to it.
+func (c *Cache) Abandon(ctx context.Context, r ReservationInfo) error {
+	ctx, cancel := withPolicy(ctx, c.DeletePolicy)
+	defer cancel()
+	return c.bestEffort(ctx, c.DeletePolicy, fmt.Sprintf("abandon cache %d", r.ID), c.abandon(ctx, r))
+}
+
+func (c *Cache) abandon(ctx context.Context, r ReservationInfo) error {
+	if err := c.checkTenant(ctx); err != nil {
+		return err
+	}
+	if c.ReadOnly {
+		return errors.WithStack(&ReadOnlyError{Op: "abandon"})
+	}
+	c.mu.Lock()
+	for i, o := range c.orphaned {
+		if o == r {
+			c.orphaned = append(c.orphaned[:i:i], c.orphaned[i+1:]...)
+			break
+		}
+	}
+	c.mu.Unlock()
+	if r.ID == 0 {
+		return nil
+	}
+	c.info(ctx, "abandon cache reservation", F("cacheID", r.ID), F("key", r.Key))
+	if err := c.commit(ctx, r.ID, 0); err != nil {
+		return errors.Wrapf(err, "failed to abandon cache %d for key %s", r.ID, r.Key)
+	}
+	return nil
+}
+
+// abandonFailed abandons the reservation of a failed save if
+// AbandonFailedSaves is set and the save can not be resumed with m.
+func (c *Cache) abandonFailed(ctx context.Context, r ReservationInfo, m *resumeManifest) {
+	if !c.AbandonFailedSaves || m != nil || r.ID == 0 {
+		return
+	}
+	// the context of the save may be canceled already
+	actx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := c.Abandon(detachLogContext(actx, ctx), r); err != nil {
+		c.warn(ctx, "failed to abandon cache reservation", F("cacheID", r.ID), F("key", r.Key), F("error", err))
+	}
+}
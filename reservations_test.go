@@ -0,0 +1,97 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrphanedReservations(t *testing.T) {
+	ts := newTestServer(t)
+	ts.fail = func(r *http.Request) int {
+		if r.Method == "PATCH" {
+			return http.StatusInternalServerError
+		}
+		return 0
+	}
+	c := ts.newCache(t)
+	c.Clock = newTestClock()
+
+	old := MaxOrphanedReservations
+	MaxOrphanedReservations = 2
+	defer func() { MaxOrphanedReservations = old }()
+
+	ctx := context.TODO()
+	dt := []byte("foobar")
+	for _, k := range []string{"foo", "bar"} {
+		err := c.Save(ctx, k, bytes.NewReader(dt), int64(len(dt)))
+		require.Error(t, err)
+	}
+	st := c.Stats()
+	require.Len(t, st.Orphaned, 2)
+	require.Empty(t, st.Pending)
+	require.Equal(t, "foo", st.Orphaned[0].Key)
+	require.Error(t, c.Close())
+
+	err := c.Save(ctx, "baz", bytes.NewReader(dt), int64(len(dt)))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "2 reservations already orphaned")
+	require.Equal(t, 2, ts.count("POST /_apis/artifactcache/caches"))
+
+	ts.fail = nil
+	c = ts.newCache(t)
+	require.NoError(t, c.Save(ctx, "baz", bytes.NewReader(dt), int64(len(dt))))
+	w, err := c.SaveWriter(ctx, "qux")
+	require.NoError(t, err)
+	require.Len(t, c.Stats().Pending, 1)
+	require.Error(t, c.Close())
+	require.NoError(t, w.Close())
+	require.NoError(t, c.Close())
+}
+
+func TestAbandonReservation(t *testing.T) {
+	ts := newTestServer(t)
+	failPatch := func(r *http.Request) int {
+		if r.Method == "PATCH" {
+			return http.StatusBadRequest
+		}
+		return 0
+	}
+	ts.fail = failPatch
+	c := ts.newCache(t)
+
+	ctx := context.TODO()
+	dt := []byte("foobar")
+	require.Error(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+	require.Len(t, c.Stats().Orphaned, 1)
+
+	// the service refuses to reserve the key again
+	h := ts.Config.Handler
+	ts.Config.Handler = conflictHandler(h)
+	err := c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt)), SaveIgnoreAlreadyExists())
+	require.ErrorIs(t, err, ErrKeyLocked)
+	require.ErrorIs(t, err, ErrReserveConflict)
+	var le *KeyLockedError
+	require.True(t, errors.As(err, &le))
+	require.Equal(t, c.Stats().Orphaned[0], le.Reservation)
+
+	err = c.Save(ctx, "bar", bytes.NewReader(dt), int64(len(dt)))
+	require.ErrorIs(t, err, ErrReserveConflict)
+	require.NotErrorIs(t, err, ErrKeyLocked)
+	ts.Config.Handler = h
+
+	require.NoError(t, c.Abandon(ctx, c.Stats().Orphaned[0]))
+	require.Empty(t, c.Stats().Orphaned)
+	require.NoError(t, c.Close())
+	require.Empty(t, ts.entry("foo").Data)
+
+	c = ts.newCache(t)
+	c.AbandonFailedSaves = true
+	require.Error(t, c.Save(ctx, "baz", bytes.NewReader(dt), int64(len(dt))))
+	require.Empty(t, c.Stats().Orphaned)
+	require.NotNil(t, ts.entry("baz"))
+}
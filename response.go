@@ -0,0 +1,150 @@
+package actionscache
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// MaxResponseSize is the default limit of the JSON responses of the cache
+// service. Larger responses fail with ErrResponseTooLarge.
+var MaxResponseSize int64 = 1 << 20
+
+// ErrResponseTooLarge is returned when a response of the cache service is
+// larger than the response size limit.
+var ErrResponseTooLarge = errors.New("response too large")
+
+func (c *Cache) maxResponseSize() int64 {
+	if c != nil && c.MaxResponseSize > 0 {
+		return c.MaxResponseSize
+	}
+	return MaxResponseSize
+}
+
+// MaxErrorBodySize is the default number of bytes of error responses read
+// for the error, eg. of HTML error pages. Errors only quote a short
+// snippet, verbose Caches keep all of them as the RawBody of the
+// *GithubAPIError and log them.
+var MaxErrorBodySize int64 = 32 * 1024
+
+// WithResponseLimit sets the size limit of the responses of endpoint, one
+// of "lookup", "reserve", "commit", "twirp" or "s3", instead of
+// MaxResponseSize, eg. for lookups returning large entry lists.
+func WithResponseLimit(endpoint string, n int64) Opt {
+	return func(c *Cache) {
+		if c.ResponseLimits == nil {
+			c.ResponseLimits = map[string]int64{}
+		}
+		c.ResponseLimits[endpoint] = n
+	}
+}
+
+// WithMaxErrorBodySize sets the number of bytes of error responses read.
+func WithMaxErrorBodySize(n int64) Opt {
+	return func(c *Cache) {
+		c.MaxErrorBodySize = n
+	}
+}
+
+// responseLimit returns the size limit of resp.
+func (c *Cache) responseLimit(resp *http.Response) int64 {
+	if c != nil && resp.Request != nil {
+		if n := c.ResponseLimits[string(endpointOf(resp.Request.Context()))]; n > 0 {
+			return n
+		}
+	}
+	return c.maxResponseSize()
+}
+
+func (c *Cache) maxErrorBodySize() int64 {
+	if c != nil && c.MaxErrorBodySize > 0 {
+		return c.MaxErrorBodySize
+	}
+	return MaxErrorBodySize
+}
+
+// verbose reports if c keeps and logs the bodies of error responses.
+func (c *Cache) verbose() bool {
+	return c != nil && c.DebugHTTP
+}
+
+// logErrorBody logs the body of the error response resp in verbose mode.
+func (c *Cache) logErrorBody(resp *http.Response, err error) {
+	if err == nil || !c.verbose() {
+		return
+	}
+	var ae *GithubAPIError
+	if !errors.As(err, &ae) || ae.RawBody == nil || resp.Request == nil {
+		return
+	}
+	c.debug(resp.Request.Context(), "error response body", F("method", resp.Request.Method), F("url", redactURL(resp.Request.URL)), F("status", resp.StatusCode), F("body", string(ae.RawBody)))
+}
+
+// decodeResponse decodes the JSON body of resp into v as it is read. An
+// empty body fails with io.EOF, a body that is not JSON with
+// ErrUnexpectedResponse.
+func (c *Cache) decodeResponse(resp *http.Response, v interface{}) error {
+	limit := c.responseLimit(resp)
+	head := &headBuffer{n: int(c.maxErrorBodySize())}
+	dec := json.NewDecoder(io.TeeReader(&boundedReader{r: resp.Body, n: limit}, head))
+	if err := dec.Decode(v); err != nil {
+		if errors.Is(err, ErrResponseTooLarge) {
+			return errors.Wrapf(err, "response of %s %s is larger than %d bytes", resp.Request.Method, redactURL(resp.Request.URL), limit)
+		}
+		var se *json.SyntaxError
+		if errors.As(err, &se) {
+			return errors.Wrapf(c.unexpectedResponse(resp, head.Bytes()), "invalid response of %s %s", resp.Request.Method, redactURL(resp.Request.URL))
+		}
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// headBuffer keeps the first n bytes written to it.
+type headBuffer struct {
+	bytes.Buffer
+	n int
+}
+
+func (b *headBuffer) Write(p []byte) (int, error) {
+	if l := b.n - b.Len(); l > 0 {
+		if len(p) < l {
+			l = len(p)
+		}
+		b.Buffer.Write(p[:l])
+	}
+	return len(p), nil
+}
+
+// closeResponse discards what is left of the body of resp, up to the
+// response size limit, and closes it so the connection can be reused.
+func (c *Cache) closeResponse(resp *http.Response) {
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, c.responseLimit(resp)))
+	resp.Body.Close()
+}
+
+// boundedReader fails with ErrResponseTooLarge if r has more than n bytes.
+type boundedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (br *boundedReader) Read(p []byte) (int, error) {
+	if br.n <= 0 {
+		var b [1]byte
+		if n, err := br.r.Read(b[:]); n == 0 && err != nil {
+			return 0, err
+		}
+		return 0, errors.WithStack(ErrResponseTooLarge)
+	}
+	if int64(len(p)) > br.n {
+		p = p[:br.n]
+	}
+	n, err := br.r.Read(p)
+	br.n -= int64(n)
+	return n, err
+}
@@ -0,0 +1,133 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaxResponseSize(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	ctx := context.TODO()
+	dt := []byte("foobar")
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+
+	c.MaxResponseSize = 16
+	_, err := c.Load(ctx, "foo")
+	require.ErrorIs(t, err, ErrResponseTooLarge)
+
+	c.MaxResponseSize = 0
+	ce, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	require.Equal(t, "foo", ce.Key)
+}
+
+func TestResponseLimit(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	ctx := context.TODO()
+	dt := []byte("foobar")
+
+	WithResponseLimit("lookup", 16)(c)
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+	_, err := c.Load(ctx, "foo")
+	require.ErrorIs(t, err, ErrResponseTooLarge)
+
+	c.MaxResponseSize = 16
+	WithResponseLimit("lookup", 1<<10)(c)
+	ce, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	require.Equal(t, "foo", ce.Key)
+}
+
+func TestErrorBody(t *testing.T) {
+	ts := newTestServer(t)
+	page := "<html><body><h1>Bad gateway</h1>" + strings.Repeat("<p>upstream details</p>", 4000) + "</body></html>"
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(page))
+	})
+	ctx := context.TODO()
+
+	c := ts.newCache(t)
+	_, err := c.Load(ctx, "foo")
+	var ae *GithubAPIError
+	require.ErrorAs(t, err, &ae)
+	require.True(t, strings.HasPrefix(ae.Body, "Bad gateway upstream details"))
+	require.LessOrEqual(t, len(ae.Body), 300)
+	require.Nil(t, ae.RawBody)
+
+	l := &recordingLogger{}
+	c = ts.newCache(t)
+	WithHTTPDebug()(c)
+	WithLogger(l)(c)
+	WithMaxErrorBodySize(1 << 20)(c)
+	_, err = c.Load(ctx, "foo")
+	require.ErrorAs(t, err, &ae)
+	require.LessOrEqual(t, len(ae.Body), 300)
+	require.Equal(t, page, string(ae.RawBody))
+	e := l.find("error response body")
+	require.NotNil(t, e)
+	require.Equal(t, page, e.fields["body"])
+
+	WithMaxErrorBodySize(1024)(c)
+	_, err = c.Load(ctx, "foo")
+	require.ErrorAs(t, err, &ae)
+	require.Len(t, ae.RawBody, 1024)
+}
+
+func TestAPIVersionErrorLimit(t *testing.T) {
+	body := strings.Repeat(" ", 64) + "VssVersionOutOfRangeException"
+	resp := func() *http.Response {
+		return &http.Response{StatusCode: http.StatusBadRequest, Body: ioutil.NopCloser(strings.NewReader(body))}
+	}
+
+	// only MaxErrorBodySize bytes are read, the body is restored
+	c := &Cache{MaxErrorBodySize: 16}
+	r := resp()
+	require.False(t, c.isAPIVersionError(r))
+	dt, err := ioutil.ReadAll(r.Body)
+	require.NoError(t, err)
+	require.Equal(t, body, string(dt))
+
+	c.MaxErrorBodySize = 0
+	require.True(t, c.isAPIVersionError(resp()))
+}
+
+func TestBoundedReader(t *testing.T) {
+	dt, err := ioutil.ReadAll(&boundedReader{r: strings.NewReader("foo"), n: 3})
+	require.NoError(t, err)
+	require.Equal(t, "foo", string(dt))
+
+	_, err = ioutil.ReadAll(&boundedReader{r: strings.NewReader("foobar"), n: 3})
+	require.ErrorIs(t, err, ErrResponseTooLarge)
+}
+
+func TestCloseResponseDrains(t *testing.T) {
+	body := &drainRecorder{r: strings.NewReader("leftover")}
+	c := &Cache{}
+	c.closeResponse(&http.Response{Body: body})
+	require.True(t, body.closed)
+	require.Equal(t, 0, body.r.Len())
+}
+
+type drainRecorder struct {
+	r      *strings.Reader
+	closed bool
+}
+
+func (d *drainRecorder) Read(p []byte) (int, error) {
+	return d.r.Read(p)
+}
+
+func (d *drainRecorder) Close() error {
+	d.closed = true
+	return nil
+}
@@ -0,0 +1,44 @@
+package actionscache
+
+import "github.com/tonistiigi/go-actions-cache/restapi"
+
+// DefaultRestAPIURL is the GitHub REST API used when GITHUB_API_URL is not
+// set.
+const DefaultRestAPIURL = restapi.DefaultURL
+
+// RestAPI is a client for the cache endpoints of the GitHub REST API, see
+// package restapi.
+type RestAPI = restapi.Client
+
+// CacheEntry is a cache entry of the REST API.
+type CacheEntry = restapi.CacheEntry
+
+// CacheUsage is the cache usage of a repository.
+type CacheUsage = restapi.CacheUsage
+
+// OrgCacheUsage is the total cache usage of the repositories of an
+// organization.
+type OrgCacheUsage = restapi.OrgCacheUsage
+
+// CachePolicy is the cache storage limit and retention of a repository.
+type CachePolicy = restapi.CachePolicy
+
+// CacheQuota is the cache policy and usage of a repository.
+type CacheQuota = restapi.CacheQuota
+
+// ListOptions filters and orders the entries listed by RestAPI.ListEach.
+type ListOptions = restapi.ListOptions
+
+// GCPolicy selects the cache entries deleted by RestAPI.GC.
+type GCPolicy = restapi.GCPolicy
+
+// NewRestAPI returns a REST API client for repo, in "owner/repo" form.
+func NewRestAPI(repo, token string) (*RestAPI, error) {
+	return restapi.New(repo, token)
+}
+
+// RestAPIFromEnv returns a REST API client configured from GITHUB_TOKEN,
+// GITHUB_REPOSITORY and GITHUB_API_URL, or nil if they are not set.
+func RestAPIFromEnv() (*RestAPI, error) {
+	return restapi.FromEnv()
+}
@@ -0,0 +1,69 @@
+package restapi
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	apierrors "github.com/tonistiigi/go-actions-cache/internal/errors"
+	"github.com/tonistiigi/go-actions-cache/internal/policy"
+)
+
+// GCPolicy selects the cache entries deleted by Client.GC.
+type GCPolicy struct {
+	// MaxSize deletes the least recently accessed entries until the entries
+	// in scope of the policy take at most this many bytes. Zero means no
+	// limit.
+	MaxSize int64
+	// MaxAge deletes entries not accessed for longer. Zero means no limit.
+	MaxAge time.Duration
+	// Key and Ref limit the policy to entries with the key prefix and ref
+	// when set.
+	Key string
+	Ref string
+	// DryRun returns the entries that would be deleted without deleting.
+	DryRun bool
+}
+
+// GC deletes cache entries of the repository according to p and returns
+// the deleted entries. Entries deleted concurrently by others are ignored.
+// The DeletePolicy of r applies to the whole collection.
+func (r *Client) GC(ctx context.Context, p GCPolicy) ([]CacheEntry, error) {
+	ctx, cancel := policy.With(ctx, r.DeletePolicy)
+	defer cancel()
+	deleted, err := r.gc(ctx, p)
+	return deleted, r.bestEffort(err)
+}
+
+func (r *Client) gc(ctx context.Context, p GCPolicy) ([]CacheEntry, error) {
+	entries, err := r.List(ctx, p.Key, p.Ref)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].LastAccessedAt.Before(entries[j].LastAccessedAt)
+	})
+	var total int64
+	for _, e := range entries {
+		total += e.SizeInBytes
+	}
+
+	now := time.Now()
+	var deleted []CacheEntry
+	for _, e := range entries {
+		expired := p.MaxAge > 0 && now.Sub(e.LastAccessedAt) > p.MaxAge
+		overBudget := p.MaxSize > 0 && total > p.MaxSize
+		if !expired && !overBudget {
+			continue
+		}
+		if !p.DryRun {
+			if err := r.delete(ctx, e.ID); err != nil && !errors.Is(err, apierrors.ErrCacheNotFound) {
+				return deleted, err
+			}
+		}
+		total -= e.SizeInBytes
+		deleted = append(deleted, e)
+	}
+	return deleted, nil
+}
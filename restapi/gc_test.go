@@ -0,0 +1,66 @@
+package restapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGC(t *testing.T) {
+	now := time.Now()
+	entries := map[int64]CacheEntry{}
+	for i := 1; i <= 5; i++ {
+		entries[int64(i)] = CacheEntry{ID: int64(i), Key: fmt.Sprintf("key-%d", i), SizeInBytes: 100, LastAccessedAt: now.Add(-time.Duration(i) * time.Hour)}
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			var list []CacheEntry
+			for _, e := range entries {
+				list = append(list, e)
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"total_count": len(list), "actions_caches": list})
+		case "DELETE":
+			var id int64
+			fmt.Sscanf(r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:], "%d", &id)
+			delete(entries, id)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+
+	r, err := New("owner/repo", "ghs_test")
+	require.NoError(t, err)
+	r.URL = srv.URL
+	ctx := context.TODO()
+
+	ids := func(entries []CacheEntry) []int64 {
+		var out []int64
+		for _, e := range entries {
+			out = append(out, e.ID)
+		}
+		return out
+	}
+
+	deleted, err := r.GC(ctx, GCPolicy{MaxSize: 250, DryRun: true})
+	require.NoError(t, err)
+	require.Equal(t, []int64{5, 4, 3}, ids(deleted))
+	require.Len(t, entries, 5)
+
+	deleted, err = r.GC(ctx, GCPolicy{MaxAge: 150 * time.Minute})
+	require.NoError(t, err)
+	require.Equal(t, []int64{5, 4, 3}, ids(deleted))
+	require.Len(t, entries, 2)
+
+	deleted, err = r.GC(ctx, GCPolicy{MaxSize: 100})
+	require.NoError(t, err)
+	require.Equal(t, []int64{2}, ids(deleted))
+	require.Len(t, entries, 1)
+}
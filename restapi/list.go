@@ -0,0 +1,107 @@
+package restapi
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// ErrStopListing is returned by the function passed to ListEach to stop
+// listing without an error.
+var ErrStopListing = errors.New("stop listing")
+
+// Sort orders of ListOptions.
+const (
+	SortLastAccessedAt = "last_accessed_at"
+	SortCreatedAt      = "created_at"
+	SortSizeInBytes    = "size_in_bytes"
+)
+
+// ListOptions filters and orders the entries listed by ListEach.
+type ListOptions struct {
+	// Key filters by key prefix and Ref by git reference when set.
+	Key string
+	Ref string
+	// Sort is one of the Sort orders, the API sorts by last access when
+	// empty.
+	Sort string
+	// Ascending lists the oldest or smallest entries first instead of the
+	// newest or largest.
+	Ascending bool
+}
+
+func (o ListOptions) query() url.Values {
+	q := url.Values{}
+	if o.Key != "" {
+		q.Set("key", o.Key)
+	}
+	if o.Ref != "" {
+		q.Set("ref", o.Ref)
+	}
+	if o.Sort != "" {
+		q.Set("sort", o.Sort)
+	}
+	if o.Ascending {
+		q.Set("direction", "asc")
+	}
+	return q
+}
+
+// ListEach calls fn with every page of the cache entries matching o, until
+// all pages were listed or fn returns an error. fn may return
+// ErrStopListing to stop without an error.
+func (r *Client) ListEach(ctx context.Context, o ListOptions, fn func(page []CacheEntry) error) error {
+	listed := 0
+	for page := 1; ; page++ {
+		q := o.query()
+		q.Set("per_page", strconv.Itoa(restPageSize))
+		q.Set("page", strconv.Itoa(page))
+		var resp struct {
+			TotalCount int          `json:"total_count"`
+			Caches     []CacheEntry `json:"actions_caches"`
+		}
+		if err := r.do(ctx, "GET", "actions/caches", q, &resp); err != nil {
+			return errors.Wrap(err, "failed to list caches")
+		}
+		listed += len(resp.Caches)
+		if len(resp.Caches) > 0 {
+			if err := fn(resp.Caches); err != nil {
+				if errors.Is(err, ErrStopListing) {
+					return nil
+				}
+				return err
+			}
+		}
+		if len(resp.Caches) < restPageSize || listed >= resp.TotalCount {
+			return nil
+		}
+	}
+}
+
+// ListByPrefix returns the cache entries of all refs whose key starts with
+// prefix, the most recently accessed first, eg. to find the latest entry
+// matching a key or to clean up the entries of a feature.
+func (r *Client) ListByPrefix(ctx context.Context, prefix string) ([]CacheEntry, error) {
+	var out []CacheEntry
+	seen := map[int64]bool{}
+	err := r.ListEach(ctx, ListOptions{Key: prefix, Sort: SortLastAccessedAt}, func(page []CacheEntry) error {
+		for _, e := range page {
+			// entries accessed while listing move between pages
+			if !seen[e.ID] {
+				seen[e.ID] = true
+				out = append(out, e)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].LastAccessedAt.After(out[j].LastAccessedAt)
+	})
+	return out, nil
+}
@@ -0,0 +1,105 @@
+package restapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestListByPrefix(t *testing.T) {
+	base := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	var entries []CacheEntry
+	for i := 1; i <= 250; i++ {
+		entries = append(entries, CacheEntry{
+			ID:             int64(i),
+			Key:            fmt.Sprintf("key-%d", i%3),
+			LastAccessedAt: base.Add(time.Duration(i*7%250) * time.Minute),
+			SizeInBytes:    int64(i),
+		})
+	}
+	var queries []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		queries = append(queries, q.Get("key")+" "+q.Get("sort")+" "+q.Get("direction")+" "+q.Get("page"))
+		var match []CacheEntry
+		for _, e := range entries {
+			if strings.HasPrefix(e.Key, q.Get("key")) {
+				match = append(match, e)
+			}
+		}
+		switch q.Get("sort") {
+		case SortLastAccessedAt:
+			sort.SliceStable(match, func(i, j int) bool { return match[i].LastAccessedAt.After(match[j].LastAccessedAt) })
+		case SortSizeInBytes:
+			sort.SliceStable(match, func(i, j int) bool { return match[i].SizeInBytes > match[j].SizeInBytes })
+		}
+		if q.Get("direction") == "asc" {
+			for i, j := 0, len(match)-1; i < j; i, j = i+1, j-1 {
+				match[i], match[j] = match[j], match[i]
+			}
+		}
+		page, _ := strconv.Atoi(q.Get("page"))
+		per, _ := strconv.Atoi(q.Get("per_page"))
+		start, end := (page-1)*per, page*per
+		if start > len(match) {
+			start = len(match)
+		}
+		if end > len(match) {
+			end = len(match)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"total_count": len(match), "actions_caches": match[start:end]})
+	}))
+	defer srv.Close()
+
+	r, err := New("owner/repo", "ghs_test")
+	require.NoError(t, err)
+	r.URL = srv.URL
+	ctx := context.TODO()
+
+	list, err := r.ListByPrefix(ctx, "key-1")
+	require.NoError(t, err)
+	require.Len(t, list, 84)
+	for i, e := range list {
+		require.Equal(t, "key-1", e.Key)
+		if i > 0 {
+			require.False(t, e.LastAccessedAt.After(list[i-1].LastAccessedAt))
+		}
+	}
+	require.Equal(t, []string{"key-1 last_accessed_at  1"}, queries)
+
+	queries = nil
+	var pages int
+	var smallest []int64
+	err = r.ListEach(ctx, ListOptions{Sort: SortSizeInBytes, Ascending: true}, func(page []CacheEntry) error {
+		pages++
+		for _, e := range page[:3] {
+			smallest = append(smallest, e.SizeInBytes)
+		}
+		return ErrStopListing
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, pages)
+	require.Equal(t, []int64{1, 2, 3}, smallest)
+
+	pages = 0
+	require.NoError(t, r.ListEach(ctx, ListOptions{}, func(page []CacheEntry) error {
+		pages++
+		return nil
+	}))
+	require.Equal(t, 3, pages)
+	require.Equal(t, " size_in_bytes asc 1", queries[0])
+
+	errFail := fmt.Errorf("fail")
+	require.Equal(t, errFail, r.ListEach(ctx, ListOptions{}, func(page []CacheEntry) error {
+		return errFail
+	}))
+}
@@ -0,0 +1,92 @@
+package restapi
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/pkg/errors"
+	apierrors "github.com/tonistiigi/go-actions-cache/internal/errors"
+)
+
+// DefaultStorageLimit and DefaultRetentionDays are the cache limits of
+// repositories on github.com that did not change them.
+const (
+	DefaultStorageLimit  int64 = 10 << 30
+	DefaultRetentionDays       = 7
+)
+
+// CachePolicy is the cache storage limit and retention of a repository.
+type CachePolicy struct {
+	// StorageLimit is the size in bytes above which the least recently
+	// used entries are evicted.
+	StorageLimit int64
+	// RetentionDays is the number of days entries are kept without being
+	// accessed.
+	RetentionDays int
+	// Default is set when the API does not expose a limit, eg. on GHES, and
+	// the defaults of github.com are assumed instead.
+	Default bool
+}
+
+// CacheQuota is the cache policy and usage of a repository.
+type CacheQuota struct {
+	CachePolicy
+	Usage CacheUsage
+}
+
+// Remaining returns the bytes that can be saved before entries are evicted.
+func (q *CacheQuota) Remaining() int64 {
+	if n := q.StorageLimit - q.Usage.ActiveCachesSizeInBytes; n > 0 {
+		return n
+	}
+	return 0
+}
+
+// Policy returns the cache storage limit and retention of the repository.
+// Limits the API does not expose are the defaults of github.com.
+func (r *Client) Policy(ctx context.Context) (*CachePolicy, error) {
+	p := &CachePolicy{StorageLimit: DefaultStorageLimit, RetentionDays: DefaultRetentionDays}
+	var sl struct {
+		MaxCacheSizeGB int64 `json:"max_cache_size_gb"`
+	}
+	switch err := r.do(ctx, "GET", "actions/cache/storage-limit", nil, &sl); {
+	case err == nil && sl.MaxCacheSizeGB > 0:
+		p.StorageLimit = sl.MaxCacheSizeGB << 30
+	case err == nil || notExposed(err):
+		p.Default = true
+	default:
+		return nil, errors.Wrap(err, "failed to get cache storage limit")
+	}
+	var rl struct {
+		MaxCacheRetentionDays int `json:"max_cache_retention_days"`
+	}
+	switch err := r.do(ctx, "GET", "actions/cache/retention-limit", nil, &rl); {
+	case err == nil && rl.MaxCacheRetentionDays > 0:
+		p.RetentionDays = rl.MaxCacheRetentionDays
+	case err == nil || notExposed(err):
+		p.Default = true
+	default:
+		return nil, errors.Wrap(err, "failed to get cache retention limit")
+	}
+	return p, nil
+}
+
+// Quota returns the cache policy and usage of the repository.
+func (r *Client) Quota(ctx context.Context) (*CacheQuota, error) {
+	p, err := r.Policy(ctx)
+	if err != nil {
+		return nil, err
+	}
+	u, err := r.Usage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &CacheQuota{CachePolicy: *p, Usage: *u}, nil
+}
+
+// notExposed reports if a request for a limit failed with err because the
+// API or the token does not give access to it.
+func notExposed(err error) bool {
+	var ae *apierrors.GithubAPIError
+	return errors.As(err, &ae) && (ae.StatusCode == http.StatusForbidden || ae.StatusCode == http.StatusNotFound)
+}
@@ -0,0 +1,60 @@
+package restapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuota(t *testing.T) {
+	exposed := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/repos/owner/repo/actions/cache/usage":
+			json.NewEncoder(w).Encode(CacheUsage{FullName: "owner/repo", ActiveCachesSizeInBytes: 3 << 30, ActiveCachesCount: 3})
+		case exposed && r.URL.Path == "/repos/owner/repo/actions/cache/storage-limit":
+			w.Write([]byte(`{"max_cache_size_gb":20}`))
+		case exposed && r.URL.Path == "/repos/owner/repo/actions/cache/retention-limit":
+			w.Write([]byte(`{"max_cache_retention_days":30}`))
+		case r.URL.Path == "/repos/owner/repo/actions/cache/retention-limit":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"Not Found"}`))
+		}
+	}))
+	defer srv.Close()
+	r, err := New("owner/repo", "ghs_test")
+	require.NoError(t, err)
+	r.URL = srv.URL
+	ctx := context.TODO()
+
+	q, err := r.Quota(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(20<<30), q.StorageLimit)
+	require.Equal(t, 30, q.RetentionDays)
+	require.False(t, q.Default)
+	require.Equal(t, int64(17<<30), q.Remaining())
+
+	exposed = false
+	_, err = r.Policy(ctx)
+	require.Error(t, err)
+
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/repos/owner/repo/actions/cache/usage" {
+			json.NewEncoder(w).Encode(CacheUsage{ActiveCachesSizeInBytes: 12 << 30})
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	})
+	q, err = r.Quota(ctx)
+	require.NoError(t, err)
+	require.True(t, q.Default)
+	require.Equal(t, DefaultStorageLimit, q.StorageLimit)
+	require.Equal(t, DefaultRetentionDays, q.RetentionDays)
+	require.Equal(t, int64(0), q.Remaining())
+}
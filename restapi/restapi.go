@@ -0,0 +1,290 @@
+// Package restapi is a client for the cache endpoints of the GitHub REST
+// API. The actionscache package re-exports it as RestAPI.
+package restapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	apierrors "github.com/tonistiigi/go-actions-cache/internal/errors"
+	"github.com/tonistiigi/go-actions-cache/internal/policy"
+	"github.com/tonistiigi/go-actions-cache/internal/tenant"
+)
+
+// DefaultURL is the GitHub REST API used when GITHUB_API_URL is not set.
+const DefaultURL = "https://api.github.com/"
+
+// Client is a client for the cache endpoints of the GitHub REST API. Unlike
+// the runtime cache service it can list and delete entries. It is
+// authenticated with a GITHUB_TOKEN with actions permissions.
+type Client struct {
+	// Repo is the "owner/repo" the caches belong to.
+	Repo  string
+	Token string
+	// URL is the API root, eg. DefaultURL.
+	URL string
+	// HTTPClient defaults to http.DefaultClient when nil.
+	HTTPClient *http.Client
+	// DeletePolicy configures failure handling of Delete, DeleteKey and GC
+	// when set. Requests are only retried with its RetryPolicy.
+	DeletePolicy *OperationPolicy
+}
+
+// OperationPolicy configures failure handling for one type of operation.
+// With BestEffort, failures are dropped without being reported.
+type OperationPolicy = policy.OperationPolicy
+
+// RetryPolicy controls how requests failing with network errors, 429 or 5xx
+// responses are retried.
+type RetryPolicy = policy.RetryPolicy
+
+// CacheEntry is a cache entry of the REST API.
+type CacheEntry struct {
+	ID             int64     `json:"id"`
+	Ref            string    `json:"ref"`
+	Key            string    `json:"key"`
+	Version        string    `json:"version"`
+	LastAccessedAt time.Time `json:"last_accessed_at"`
+	CreatedAt      time.Time `json:"created_at"`
+	SizeInBytes    int64     `json:"size_in_bytes"`
+}
+
+// CacheUsage is the cache usage of a repository.
+type CacheUsage struct {
+	FullName                string `json:"full_name"`
+	ActiveCachesSizeInBytes int64  `json:"active_caches_size_in_bytes"`
+	ActiveCachesCount       int    `json:"active_caches_count"`
+}
+
+// OrgCacheUsage is the total cache usage of the repositories of an
+// organization.
+type OrgCacheUsage struct {
+	TotalActiveCachesSizeInBytes int64 `json:"total_active_caches_size_in_bytes"`
+	TotalActiveCachesCount       int   `json:"total_active_caches_count"`
+}
+
+// New returns a REST API client for repo, in "owner/repo" form.
+func New(repo, token string) (*Client, error) {
+	if parts := strings.Split(repo, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, errors.Errorf("invalid repository %q, expected owner/repo", repo)
+	}
+	return &Client{
+		Repo:  repo,
+		Token: token,
+		URL:   DefaultURL,
+	}, nil
+}
+
+// FromEnv returns a REST API client configured from GITHUB_TOKEN,
+// GITHUB_REPOSITORY and GITHUB_API_URL, or nil if they are not set.
+func FromEnv() (*Client, error) {
+	token, ok := os.LookupEnv("GITHUB_TOKEN")
+	if !ok {
+		return nil, nil
+	}
+	repo, ok := os.LookupEnv("GITHUB_REPOSITORY")
+	if !ok {
+		return nil, nil
+	}
+	r, err := New(repo, token)
+	if err != nil {
+		return nil, err
+	}
+	if u, ok := os.LookupEnv("GITHUB_API_URL"); ok {
+		r.URL = u
+	}
+	return r, nil
+}
+
+const restPageSize = 100
+
+// List returns the cache entries of the repository. key filters by key
+// prefix and ref by git reference when not empty.
+func (r *Client) List(ctx context.Context, key, ref string) ([]CacheEntry, error) {
+	var out []CacheEntry
+	err := r.ListEach(ctx, ListOptions{Key: key, Ref: ref}, func(page []CacheEntry) error {
+		out = append(out, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Delete deletes the cache entry with id.
+func (r *Client) Delete(ctx context.Context, id int64) error {
+	ctx, cancel := policy.With(ctx, r.DeletePolicy)
+	defer cancel()
+	return r.bestEffort(r.delete(ctx, id))
+}
+
+func (r *Client) delete(ctx context.Context, id int64) error {
+	return errors.Wrapf(r.do(ctx, "DELETE", fmt.Sprintf("actions/caches/%d", id), nil, nil), "failed to delete cache %d", id)
+}
+
+// DeleteKey deletes the cache entries with key. ref restricts the deletion
+// to one git reference when not empty.
+func (r *Client) DeleteKey(ctx context.Context, key, ref string) error {
+	ctx, cancel := policy.With(ctx, r.DeletePolicy)
+	defer cancel()
+	q := url.Values{}
+	q.Set("key", key)
+	if ref != "" {
+		q.Set("ref", ref)
+	}
+	return r.bestEffort(errors.Wrapf(r.do(ctx, "DELETE", "actions/caches", q, nil), "failed to delete cache %s", key))
+}
+
+// bestEffort drops err if the DeletePolicy is best-effort.
+func (r *Client) bestEffort(err error) error {
+	if err == nil || r.DeletePolicy == nil || !r.DeletePolicy.BestEffort || errors.Is(err, apierrors.ErrCrossTenant) {
+		return err
+	}
+	return nil
+}
+
+// Usage returns the cache usage of the repository.
+func (r *Client) Usage(ctx context.Context) (*CacheUsage, error) {
+	var u CacheUsage
+	if err := r.do(ctx, "GET", "actions/cache/usage", nil, &u); err != nil {
+		return nil, errors.Wrap(err, "failed to get cache usage")
+	}
+	return &u, nil
+}
+
+// OrgUsage returns the cache usage of all repositories of org, the owner of
+// the repository when empty. The token needs read access to the
+// organization administration.
+func (r *Client) OrgUsage(ctx context.Context, org string) (*OrgCacheUsage, error) {
+	org = r.org(org)
+	var u OrgCacheUsage
+	if err := r.doPath(ctx, "GET", "orgs/"+org+"/actions/cache/usage", nil, &u); err != nil {
+		return nil, errors.Wrapf(err, "failed to get cache usage of %s", org)
+	}
+	return &u, nil
+}
+
+// OrgUsageByRepository returns the cache usage of every repository of org
+// with active caches, the owner of the repository when empty.
+func (r *Client) OrgUsageByRepository(ctx context.Context, org string) ([]CacheUsage, error) {
+	org = r.org(org)
+	var out []CacheUsage
+	for page := 1; ; page++ {
+		q := url.Values{}
+		q.Set("per_page", strconv.Itoa(restPageSize))
+		q.Set("page", strconv.Itoa(page))
+		var resp struct {
+			TotalCount int          `json:"total_count"`
+			Usages     []CacheUsage `json:"repository_cache_usages"`
+		}
+		if err := r.doPath(ctx, "GET", "orgs/"+org+"/actions/cache/usage-by-repository", q, &resp); err != nil {
+			return nil, errors.Wrapf(err, "failed to list cache usage of %s", org)
+		}
+		out = append(out, resp.Usages...)
+		if len(resp.Usages) < restPageSize || len(out) >= resp.TotalCount {
+			return out, nil
+		}
+	}
+}
+
+func (r *Client) org(org string) string {
+	if org != "" {
+		return org
+	}
+	return strings.SplitN(r.Repo, "/", 2)[0]
+}
+
+// do sends a request to p below the repository endpoints.
+func (r *Client) do(ctx context.Context, method, p string, q url.Values, out interface{}) error {
+	return r.doPath(ctx, method, "repos/"+r.Repo+"/"+p, q, out)
+}
+
+// doPath sends a request to p below the API root, retrying it with the
+// retry policy of ctx if it has one.
+func (r *Client) doPath(ctx context.Context, method, p string, q url.Values, out interface{}) error {
+	if err := tenant.Check(ctx, r.Repo); err != nil {
+		return err
+	}
+	u := strings.TrimSuffix(r.URL, "/") + "/" + p
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	rp, ok := policy.RetryFromContext(ctx)
+	for attempt := 1; ; attempt++ {
+		err := r.sendAttempt(ctx, rp, method, u, out)
+		if !ok || attempt >= rp.MaxAttempts || !isRetryable(ctx, err) {
+			return err
+		}
+		d := rp.Backoff(attempt)
+		if !policy.Fits(ctx, d) {
+			return err
+		}
+		t := time.NewTimer(d)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		}
+	}
+}
+
+// sendAttempt sends a request limited to the AttemptTimeout of rp, if the
+// context has a retry policy.
+func (r *Client) sendAttempt(ctx context.Context, rp *policy.RetryPolicy, method, u string, out interface{}) error {
+	if rp == nil {
+		return r.send(ctx, method, u, out)
+	}
+	actx, cancel := rp.Attempt(ctx)
+	defer cancel()
+	return r.send(actx, method, u, out)
+}
+
+// isRetryable reports if a request that failed with err can be sent again.
+func isRetryable(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	var ae *apierrors.GithubAPIError
+	if errors.As(err, &ae) {
+		return ae.StatusCode == http.StatusTooManyRequests || ae.StatusCode >= 500
+	}
+	var ne net.Error
+	return errors.As(err, &ne)
+}
+
+func (r *Client) send(ctx context.Context, method, u string, out interface{}) error {
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+r.Token)
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	client := r.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+	if err := apierrors.CheckResponse(resp); err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	return errors.WithStack(json.NewDecoder(resp.Body).Decode(out))
+}
@@ -0,0 +1,157 @@
+package restapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	apierrors "github.com/tonistiigi/go-actions-cache/internal/errors"
+)
+
+func TestClient(t *testing.T) {
+	var entries []CacheEntry
+	for i := 1; i <= 150; i++ {
+		entries = append(entries, CacheEntry{ID: int64(i), Key: fmt.Sprintf("key-%d", i), Ref: "refs/heads/main", SizeInBytes: 10})
+	}
+	var deleted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "Bearer ghs_test", r.Header.Get("Authorization"))
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/repos/owner/repo/actions/caches":
+			page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+			per, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+			var match []CacheEntry
+			for _, e := range entries {
+				if strings.HasPrefix(e.Key, r.URL.Query().Get("key")) {
+					match = append(match, e)
+				}
+			}
+			start, end := (page-1)*per, page*per
+			if end > len(match) {
+				end = len(match)
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"total_count": len(match), "actions_caches": match[start:end]})
+		case r.Method == "GET" && r.URL.Path == "/repos/owner/repo/actions/cache/usage":
+			json.NewEncoder(w).Encode(CacheUsage{FullName: "owner/repo", ActiveCachesSizeInBytes: 1500, ActiveCachesCount: 150})
+		case r.Method == "GET" && r.URL.Path == "/orgs/owner/actions/cache/usage":
+			json.NewEncoder(w).Encode(OrgCacheUsage{TotalActiveCachesSizeInBytes: 2500, TotalActiveCachesCount: 250})
+		case r.Method == "GET" && r.URL.Path == "/orgs/owner/actions/cache/usage-by-repository":
+			json.NewEncoder(w).Encode(map[string]interface{}{"total_count": 2, "repository_cache_usages": []CacheUsage{
+				{FullName: "owner/repo", ActiveCachesSizeInBytes: 1500, ActiveCachesCount: 150},
+				{FullName: "owner/other", ActiveCachesSizeInBytes: 1000, ActiveCachesCount: 100},
+			}})
+		case r.Method == "DELETE" && r.URL.Path == "/repos/owner/repo/actions/caches/404":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"Not Found"}`))
+		case r.Method == "DELETE":
+			deleted = append(deleted, r.URL.Path+"?"+r.URL.RawQuery)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	_, err := New("invalid", "ghs_test")
+	require.Error(t, err)
+	r, err := New("owner/repo", "ghs_test")
+	require.NoError(t, err)
+	r.URL = srv.URL
+
+	ctx := context.TODO()
+	list, err := r.List(ctx, "", "")
+	require.NoError(t, err)
+	require.Len(t, list, 150)
+	require.Equal(t, int64(150), list[149].ID)
+
+	list, err = r.List(ctx, "key-14", "")
+	require.NoError(t, err)
+	require.Len(t, list, 11)
+
+	u, err := r.Usage(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 150, u.ActiveCachesCount)
+
+	ou, err := r.OrgUsage(ctx, "")
+	require.NoError(t, err)
+	require.Equal(t, int64(2500), ou.TotalActiveCachesSizeInBytes)
+	require.Equal(t, 250, ou.TotalActiveCachesCount)
+	repos, err := r.OrgUsageByRepository(ctx, "owner")
+	require.NoError(t, err)
+	require.Len(t, repos, 2)
+	require.Equal(t, "owner/other", repos[1].FullName)
+	_, err = r.OrgUsage(ctx, "other")
+	require.ErrorIs(t, err, apierrors.ErrCacheNotFound)
+
+	require.NoError(t, r.Delete(ctx, 7))
+	require.NoError(t, r.DeleteKey(ctx, "key-8", "refs/heads/main"))
+	require.Equal(t, []string{
+		"/repos/owner/repo/actions/caches/7?",
+		"/repos/owner/repo/actions/caches?key=key-8&ref=refs%2Fheads%2Fmain",
+	}, deleted)
+
+	err = r.Delete(ctx, 404)
+	require.ErrorIs(t, err, apierrors.ErrCacheNotFound)
+}
+
+func TestDeletePolicy(t *testing.T) {
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	r, err := New("owner/repo", "ghs_test")
+	require.NoError(t, err)
+	r.URL = srv.URL
+
+	ctx := context.TODO()
+	require.Error(t, r.Delete(ctx, 1))
+	require.Equal(t, 1, attempts)
+
+	r.DeletePolicy = &OperationPolicy{RetryPolicy: &RetryPolicy{MaxAttempts: 3}}
+	attempts = 0
+	require.Error(t, r.DeleteKey(ctx, "foo", ""))
+	require.Equal(t, 3, attempts)
+
+	r.DeletePolicy.BestEffort = true
+	require.NoError(t, r.Delete(ctx, 1))
+	_, err = r.GC(ctx, GCPolicy{MaxSize: 1})
+	require.NoError(t, err)
+
+	// lists are not retried outside of deletes
+	attempts = 0
+	_, err = r.List(ctx, "", "")
+	require.Error(t, err)
+	require.Equal(t, 1, attempts)
+}
+
+func TestDeletePolicyDeadline(t *testing.T) {
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	r, err := New("owner/repo", "ghs_test")
+	require.NoError(t, err)
+	r.URL = srv.URL
+	r.DeletePolicy = &OperationPolicy{RetryPolicy: &RetryPolicy{MaxAttempts: 3, MinBackoff: time.Minute}}
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancel()
+	start := time.Now()
+	err = r.Delete(ctx, 1)
+	require.ErrorIs(t, err, apierrors.ErrServiceUnavailable)
+	require.Equal(t, 1, attempts)
+	require.Less(t, int64(time.Since(start)), int64(5*time.Second))
+}
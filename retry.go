@@ -0,0 +1,168 @@
+package actionscache
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tonistiigi/go-actions-cache/internal/policy"
+)
+
+// RetryPolicy controls how requests failing with network errors, 429 or 5xx
+// responses are retried.
+type RetryPolicy = policy.RetryPolicy
+
+// DefaultRetryPolicy is used by a Cache without a RetryPolicy.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	MinBackoff:  500 * time.Millisecond,
+	MaxBackoff:  30 * time.Second,
+	Jitter:      0.2,
+}
+
+func (c *Cache) retryPolicy(ctx context.Context) RetryPolicy {
+	if p, ok := policy.RetryFromContext(ctx); ok {
+		return *p
+	}
+	if c.RetryPolicy != nil {
+		return *c.RetryPolicy
+	}
+	return DefaultRetryPolicy
+}
+
+// doRetry sends req with send and retries transient failures according to
+// the retry policy. Requests with a body are only retried if it can be
+// recreated with GetBody. Retries that would start after the deadline of
+// the request context are not made.
+func (c *Cache) doRetry(req *http.Request, send func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	ctx := req.Context()
+	p := c.retryPolicy(ctx)
+	for attempt := 1; ; attempt++ {
+		resp, err := c.sendAttempt(req, attempt, p, send)
+		if attempt >= p.MaxAttempts || !isRetryable(ctx, resp, err) || !canRewind(req) {
+			return resp, err
+		}
+		d := p.Backoff(attempt)
+		if err == nil {
+			if ra := retryAfter(resp, c.clock().Now()); ra > d {
+				d = ra
+			}
+		}
+		if !retryFits(ctx, d) {
+			c.debug(ctx, "not retrying request past the deadline", F("method", req.Method), F("url", redactURL(req.URL)), F("delay", d), F("attempt", attempt))
+			return resp, err
+		}
+		reason := ""
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = resp.Status
+			io.Copy(ioutil.Discard, io.LimitReader(resp.Body, c.maxErrorBodySize()))
+			resp.Body.Close()
+		}
+		if m := c.metrics(); m != nil {
+			m.Retry(string(endpointOf(ctx)))
+		}
+		recordRetry(ctx)
+		c.events().OnRetry(ctx, RetryEvent{Endpoint: string(endpointOf(ctx)), Attempt: attempt + 1, Delay: d, Reason: reason})
+		c.info(ctx, "retrying request", F("method", req.Method), F("url", redactURL(req.URL)), F("delay", d), F("attempt", attempt+1), F("maxAttempts", p.MaxAttempts), F("reason", reason))
+		if err := c.clock().Sleep(ctx, d); err != nil {
+			return nil, err
+		}
+		if req, err = rewind(req); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// retryFits reports if a retry after d starts before the deadline of ctx.
+func retryFits(ctx context.Context, d time.Duration) bool {
+	return policy.Fits(ctx, d)
+}
+
+// sendAttempt sends req as the given attempt with send. It is canceled if
+// no response arrived within the AttemptTimeout of p, reading the body of
+// the response is not limited as downloads may take long.
+func (c *Cache) sendAttempt(req *http.Request, attempt int, p RetryPolicy, send func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	if p.AttemptTimeout <= 0 {
+		return send(withAttempt(req, attempt))
+	}
+	ctx, cancel := context.WithCancel(req.Context())
+	fired := make(chan struct{})
+	stop := c.afterFunc(p.AttemptTimeout, func() {
+		close(fired)
+		cancel()
+	})
+	resp, err := send(withAttempt(req.WithContext(ctx), attempt))
+	stop()
+	if err != nil && req.Context().Err() == nil {
+		select {
+		case <-fired:
+			err = errors.WithStack(&attemptTimeoutError{timeout: p.AttemptTimeout})
+		default:
+		}
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// attemptTimeoutError is returned for an attempt canceled by the
+// AttemptTimeout. It is a net.Error so the request is retried.
+type attemptTimeoutError struct {
+	timeout time.Duration
+}
+
+func (e *attemptTimeoutError) Error() string {
+	return fmt.Sprintf("no response within attempt timeout of %v", e.timeout)
+}
+
+func (e *attemptTimeoutError) Timeout() bool   { return true }
+func (e *attemptTimeoutError) Temporary() bool { return true }
+
+// cancelBody cancels the request of a response when it is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel func()
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+func isRetryable(ctx context.Context, resp *http.Response, err error) bool {
+	if err != nil {
+		if ctx.Err() != nil {
+			return false
+		}
+		var ne net.Error
+		return errors.As(err, &ne) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// retryAfter returns the delay requested by the Retry-After header of resp.
+func retryAfter(resp *http.Response, now time.Time) time.Duration {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if s, err := strconv.Atoi(v); err == nil {
+		return time.Duration(s) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return t.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,238 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+type sleepRecorder struct {
+	*testClock
+	sleeps []time.Duration
+}
+
+func (c *sleepRecorder) Sleep(ctx context.Context, d time.Duration) error {
+	c.sleeps = append(c.sleeps, d)
+	return c.testClock.Sleep(ctx, d)
+}
+
+func TestRetry(t *testing.T) {
+	ts := newTestServer(t)
+	failures := map[string]int{}
+	ts.fail = func(r *http.Request) int {
+		k := r.Method + " " + r.URL.Path
+		failures[k]++
+		switch {
+		case r.Method == "PATCH" && failures[k] <= 2:
+			return http.StatusServiceUnavailable
+		case r.Method == "GET" && failures[k] == 1:
+			return http.StatusTooManyRequests
+		case r.Method == "POST" && r.URL.Path == "/_apis/artifactcache/caches" && failures[k] == 1:
+			return http.StatusBadRequest
+		}
+		return 0
+	}
+	ts.Config.Handler = retryAfterHandler(ts.Config.Handler)
+
+	c := ts.newCache(t)
+	clock := &sleepRecorder{testClock: newTestClock()}
+	c.Clock = clock
+	c.RetryPolicy = &RetryPolicy{MaxAttempts: 3, MinBackoff: time.Second, MaxBackoff: time.Minute}
+
+	ctx := context.TODO()
+	dt := []byte("foobar")
+	err := c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt)))
+	require.Error(t, err, "400 is not retried")
+	require.Empty(t, clock.sleeps)
+
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+	require.Equal(t, []time.Duration{time.Second, 2 * time.Second}, clock.sleeps)
+
+	clock.sleeps = nil
+	ce, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	require.Equal(t, []time.Duration{7 * time.Second}, clock.sleeps)
+
+	clock.sleeps = nil
+	ts.fail = func(r *http.Request) int {
+		return http.StatusBadGateway
+	}
+	_, err = c.Load(ctx, "bar")
+	var ae *GithubAPIError
+	require.True(t, errors.As(err, &ae))
+	require.Equal(t, http.StatusBadGateway, ae.StatusCode)
+	require.Len(t, clock.sleeps, 2)
+
+	clock.sleeps = nil
+	err = c.Save(ctx, "bar", bytes.NewReader(dt), int64(len(dt)))
+	require.Error(t, err)
+	require.Len(t, clock.sleeps, 2)
+}
+
+func retryAfterHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			w.Header().Set("Retry-After", "7")
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{MinBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	require.Equal(t, time.Second, p.Backoff(1))
+	require.Equal(t, 2*time.Second, p.Backoff(2))
+	require.Equal(t, 4*time.Second, p.Backoff(3))
+	require.Equal(t, 5*time.Second, p.Backoff(4))
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := p.Backoff(2)
+		require.True(t, d >= time.Second && d <= 3*time.Second, d)
+	}
+}
+
+func TestChunkRetry(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	c.UploadConcurrency = 1
+	c.UploadChunkSize = 4
+	clock := &sleepRecorder{testClock: newTestClock()}
+	c.Clock = clock
+	c.RetryPolicy = &RetryPolicy{MaxAttempts: 1, MinBackoff: time.Second, MaxBackoff: time.Minute}
+
+	failures := 0
+	ts.fail = func(r *http.Request) int {
+		if r.Method == "PATCH" && r.Header.Get("Content-Range") == "bytes 4-7/*" && failures < MaxChunkRetries {
+			failures++
+			return http.StatusBadGateway
+		}
+		return 0
+	}
+	ctx := context.TODO()
+	dt := []byte("0123456789")
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+	// only the failed chunk is uploaded again
+	require.Equal(t, 3+MaxChunkRetries, ts.count("PATCH "))
+	require.Equal(t, []time.Duration{time.Second, 2 * time.Second}, clock.sleeps)
+
+	// the save fails once the chunk retries are exhausted
+	failures = -100
+	err := c.Save(ctx, "bar", bytes.NewReader(dt), int64(len(dt)))
+	var ae *GithubAPIError
+	require.True(t, errors.As(err, &ae), "%+v", err)
+	require.Equal(t, http.StatusBadGateway, ae.StatusCode)
+}
+
+func TestRetryDeadline(t *testing.T) {
+	ts := newTestServer(t)
+	requests := 0
+	ts.fail = func(r *http.Request) int {
+		requests++
+		return http.StatusServiceUnavailable
+	}
+	ts.Config.Handler = retryAfterHandler(ts.Config.Handler)
+
+	c := ts.newCache(t)
+	clock := &sleepRecorder{testClock: newTestClock()}
+	c.Clock = clock
+	c.RetryPolicy = &RetryPolicy{MaxAttempts: 5, MinBackoff: time.Second, MaxBackoff: time.Minute}
+
+	// the Retry-After of 7s does not fit in the deadline
+	ctx, cancel := context.WithTimeout(context.TODO(), 5*time.Second)
+	defer cancel()
+	_, err := c.Load(ctx, "foo")
+	require.Error(t, err)
+	require.False(t, errors.Is(err, context.DeadlineExceeded))
+	require.True(t, errors.Is(err, ErrServiceUnavailable))
+	require.Equal(t, 1, requests)
+	require.Empty(t, clock.sleeps)
+
+	requests = 0
+	ctx, cancel = context.WithTimeout(context.TODO(), time.Minute)
+	defer cancel()
+	_, err = c.Load(ctx, "foo")
+	require.Error(t, err)
+	require.Equal(t, 5, requests)
+}
+
+func TestRetryAttemptTimeout(t *testing.T) {
+	ts := newTestServer(t)
+	h := ts.Config.Handler
+	hung := 0
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" && hung == 0 {
+			hung++
+			<-r.Context().Done()
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+
+	c := ts.newCache(t)
+	c.RetryPolicy = &RetryPolicy{MaxAttempts: 2, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond, AttemptTimeout: 100 * time.Millisecond}
+	ctx := context.TODO()
+	dt := []byte("foobar")
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+
+	start := time.Now()
+	ce, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	require.Equal(t, 1, hung)
+	require.Less(t, int64(time.Since(start)), int64(5*time.Second))
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, ce.Download(ctx, buf))
+	require.Equal(t, dt, buf.Bytes())
+}
+
+// gateClock returns from a sleep of an hour or more once for every value
+// sent to release, shorter sleeps return right away.
+type gateClock struct {
+	*testClock
+	release chan struct{}
+}
+
+func (c *gateClock) Sleep(ctx context.Context, d time.Duration) error {
+	if d < time.Hour {
+		return c.testClock.Sleep(ctx, d)
+	}
+	select {
+	case <-c.release:
+		return c.testClock.Sleep(ctx, d)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestRetryAttemptTimeoutClock(t *testing.T) {
+	ts := newTestServer(t)
+	h := ts.Config.Handler
+	clock := &gateClock{testClock: newTestClock(), release: make(chan struct{}, 1)}
+	hung := 0
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" && hung == 0 {
+			hung++
+			// the attempt times out once the clock passes the timeout
+			clock.release <- struct{}{}
+			<-r.Context().Done()
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+
+	c := ts.newCache(t)
+	c.Clock = clock
+	c.RetryPolicy = &RetryPolicy{MaxAttempts: 2, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond, AttemptTimeout: time.Hour}
+	ce, err := c.Load(context.TODO(), "foo")
+	require.NoError(t, err)
+	require.Nil(t, ce)
+	require.Equal(t, 1, hung)
+}
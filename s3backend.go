@@ -0,0 +1,542 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// S3Config configures an S3Backend.
+type S3Config struct {
+	// Endpoint is the URL of the S3 API, eg. a MinIO server. Defaults to
+	// the AWS endpoint of Region.
+	Endpoint string
+	// Region defaults to us-east-1.
+	Region string
+	Bucket string
+	// Prefix is prepended to the names of the objects.
+	Prefix          string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// VirtualHosted addresses the bucket as a subdomain of the endpoint
+	// instead of as the first segment of the path.
+	VirtualHosted bool
+	// PartSize is the size of the parts of multipart uploads. The chunks of
+	// saves must start at multiples of it, so it must be the upload chunk
+	// size of the Cache. Defaults to the upload chunk size of the Cache
+	// saving the entry. S3 needs parts of at least 5 MiB.
+	PartSize int64
+	// HTTPClient defaults to the client of the Cache using the backend,
+	// so its TLS and connection settings apply. Requests are retried with
+	// the RetryPolicy of the Cache either way.
+	HTTPClient *http.Client
+	// Clock is the time requests are signed with, the system clock when
+	// nil.
+	Clock Clock
+}
+
+// S3ConfigFromEnv returns the S3Config of the environment and if it is
+// configured, ie. ACTIONS_CACHE_S3_BUCKET is set. ACTIONS_CACHE_S3_ENDPOINT,
+// ACTIONS_CACHE_S3_REGION and ACTIONS_CACHE_S3_PREFIX set the other fields,
+// the credentials are read from the standard AWS variables. Buckets of a
+// custom endpoint are addressed by path.
+func S3ConfigFromEnv() (S3Config, bool) {
+	cfg := S3Config{
+		Endpoint:        os.Getenv("ACTIONS_CACHE_S3_ENDPOINT"),
+		Region:          os.Getenv("ACTIONS_CACHE_S3_REGION"),
+		Bucket:          os.Getenv("ACTIONS_CACHE_S3_BUCKET"),
+		Prefix:          os.Getenv("ACTIONS_CACHE_S3_PREFIX"),
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	for _, k := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if cfg.Region == "" {
+			cfg.Region = os.Getenv(k)
+		}
+	}
+	cfg.VirtualHosted = cfg.Endpoint == ""
+	return cfg, cfg.Bucket != ""
+}
+
+// S3Error is an error response of S3.
+type S3Error struct {
+	StatusCode int    `xml:"-"`
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *S3Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3 request failed: %s", http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("s3 request failed: %s: %s (%d)", e.Code, e.Message, e.StatusCode)
+}
+
+func (e *S3Error) Is(target error) bool {
+	return target == ErrCacheNotFound && (e.StatusCode == http.StatusNotFound || e.Code == "NoSuchKey" || e.Code == "NoSuchUpload")
+}
+
+// S3Backend is a Backend storing entries in an S3 bucket, or a compatible
+// store like MinIO. Entries are saved with multipart uploads and stored as
+// objects named after the version and the key.
+type S3Backend struct {
+	cfg S3Config
+
+	mu      sync.Mutex
+	nextID  int
+	uploads map[int]*s3Upload
+}
+
+type s3Upload struct {
+	object   string
+	uploadID string
+	partSize int64
+	parts    map[int]s3Part
+}
+
+type s3Part struct {
+	etag string
+	size int64
+}
+
+// NewS3Backend returns an S3Backend for cfg.
+func NewS3Backend(cfg S3Config) (*S3Backend, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.Errorf("s3 bucket not set")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid s3 endpoint %s", cfg.Endpoint)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.Errorf("invalid s3 endpoint %s", cfg.Endpoint)
+	}
+	if cfg.PartSize > 0 && cfg.PartSize < s3MinPartSize {
+		return nil, errors.Errorf("s3 part size %d is smaller than the minimum of %d", cfg.PartSize, s3MinPartSize)
+	}
+	return &S3Backend{cfg: cfg, uploads: map[int]*s3Upload{}}, nil
+}
+
+// s3MinPartSize is the minimum size of the parts of a multipart upload but
+// the last one.
+var s3MinPartSize int64 = 5 << 20
+
+const endpointS3 endpoint = "s3"
+
+// partSize returns the part size of the uploads reserved with ctx.
+func (b *S3Backend) partSize(ctx context.Context) int64 {
+	if b.cfg.PartSize > 0 {
+		return b.cfg.PartSize
+	}
+	return int64(cacheOf(ctx).uploadChunkSize())
+}
+
+func (b *S3Backend) object(key, version string) string {
+	return b.cfg.Prefix + version + "/" + key
+}
+
+// bucketURL returns the URL of the bucket, ending in a slash.
+func (b *S3Backend) bucketURL() string {
+	u, _ := url.Parse(b.cfg.Endpoint)
+	if b.cfg.VirtualHosted {
+		u.Host = b.cfg.Bucket + "." + u.Host
+		return strings.TrimSuffix(u.String(), "/") + "/"
+	}
+	return strings.TrimSuffix(u.String(), "/") + "/" + s3Escape(b.cfg.Bucket, false) + "/"
+}
+
+func (b *S3Backend) objectURL(object string) string {
+	return b.bucketURL() + s3Escape(object, true)
+}
+
+func (b *S3Backend) Reserve(ctx context.Context, key, version string) (int, error) {
+	object := b.object(key, version)
+	b.mu.Lock()
+	for _, u := range b.uploads {
+		if u.object == object {
+			b.mu.Unlock()
+			return 0, errors.Wrapf(ErrReserveConflict, "cache %s is being uploaded", key)
+		}
+	}
+	b.mu.Unlock()
+	resp, err := b.do(ctx, "HEAD", b.objectURL(object), nil)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return 0, errors.Wrapf(ErrReserveConflict, "cache %s already exists", key)
+	case http.StatusNotFound:
+	default:
+		return 0, errors.WithStack(&S3Error{StatusCode: resp.StatusCode})
+	}
+	var res struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := b.call(ctx, "POST", b.objectURL(object)+"?uploads", nil, &res); err != nil {
+		return 0, errors.Wrapf(err, "failed to start upload of cache %s", key)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nextID++
+	b.uploads[b.nextID] = &s3Upload{object: object, uploadID: res.UploadID, partSize: b.partSize(ctx), parts: map[int]s3Part{}}
+	return b.nextID, nil
+}
+
+func (b *S3Backend) upload(id int) (*s3Upload, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	u, ok := b.uploads[id]
+	if !ok {
+		return nil, errors.Errorf("cache %d is not reserved", id)
+	}
+	return u, nil
+}
+
+func (b *S3Backend) UploadChunk(ctx context.Context, id int, ra io.ReaderAt, off, n int64) error {
+	u, err := b.upload(id)
+	if err != nil {
+		return err
+	}
+	if off%u.partSize != 0 {
+		return errors.Errorf("cache chunk at %d is not aligned to the s3 part size %d", off, u.partSize)
+	}
+	if off > 0 && u.partSize < s3MinPartSize {
+		return errors.Errorf("s3 part size %d of cache %d is smaller than the minimum of %d", u.partSize, id, s3MinPartSize)
+	}
+	part := int(off/u.partSize) + 1
+	q := url.Values{"partNumber": {strconv.Itoa(part)}, "uploadId": {u.uploadID}}
+	resp, err := b.do(ctx, "PUT", b.objectURL(u.object)+"?"+q.Encode(), io.NewSectionReader(ra, off, n))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkS3Response(ctx, resp); err != nil {
+		return errors.Wrapf(err, "failed to upload part %d of cache %d", part, id)
+	}
+	b.mu.Lock()
+	u.parts[part] = s3Part{etag: resp.Header.Get("ETag"), size: n}
+	b.mu.Unlock()
+	return nil
+}
+
+type s3CompletePart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+func (b *S3Backend) Commit(ctx context.Context, id int, size int64) error {
+	u, err := b.upload(id)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	delete(b.uploads, id)
+	var parts []s3CompletePart
+	var uploaded int64
+	for n, p := range u.parts {
+		parts = append(parts, s3CompletePart{PartNumber: n, ETag: p.etag})
+		uploaded += p.size
+	}
+	b.mu.Unlock()
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].PartNumber < parts[j].PartNumber
+	})
+	if uploaded != size {
+		b.abort(ctx, u)
+		return errors.Errorf("refusing to commit cache %d of %d bytes, %d bytes uploaded", id, size, uploaded)
+	}
+	if len(parts) == 0 {
+		// multipart uploads need at least one part
+		b.abort(ctx, u)
+		resp, err := b.do(ctx, "PUT", b.objectURL(u.object), bytes.NewReader(nil))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		return errors.Wrapf(checkS3Response(ctx, resp), "failed to commit cache %d", id)
+	}
+	dt, err := xml.Marshal(struct {
+		XMLName xml.Name         `xml:"CompleteMultipartUpload"`
+		Parts   []s3CompletePart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	q := url.Values{"uploadId": {u.uploadID}}
+	var res struct {
+		XMLName xml.Name
+		S3Error
+	}
+	if err := b.call(ctx, "POST", b.objectURL(u.object)+"?"+q.Encode(), bytes.NewReader(dt), &res); err != nil {
+		return errors.Wrapf(err, "failed to commit cache %d", id)
+	}
+	// S3 reports failures of completing an upload in a 200 response
+	if res.XMLName.Local == "Error" {
+		res.S3Error.StatusCode = http.StatusOK
+		return errors.Wrapf(&res.S3Error, "failed to commit cache %d", id)
+	}
+	return nil
+}
+
+// abort releases the parts of an upload on a best-effort basis.
+func (b *S3Backend) abort(ctx context.Context, u *s3Upload) {
+	q := url.Values{"uploadId": {u.uploadID}}
+	if resp, err := b.do(ctx, "DELETE", b.objectURL(u.object)+"?"+q.Encode(), nil); err == nil {
+		resp.Body.Close()
+	}
+}
+
+type s3ListResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+		Size         int64     `xml:"Size"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (b *S3Backend) Lookup(ctx context.Context, keys []string, version string) (*Entry, error) {
+	for _, k := range keys {
+		prefix := b.object(k, version)
+		var match *Entry
+		token := ""
+		for {
+			q := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+			if token != "" {
+				q.Set("continuation-token", token)
+			}
+			var res s3ListResult
+			if err := b.call(ctx, "GET", b.bucketURL()+"?"+q.Encode(), nil, &res); err != nil {
+				return nil, errors.Wrapf(err, "failed to list cache %s", k)
+			}
+			for _, o := range res.Contents {
+				e := &Entry{
+					Key:          strings.TrimPrefix(o.Key, b.object("", version)),
+					URL:          b.objectURL(o.Key),
+					CreationTime: o.LastModified,
+					Size:         o.Size,
+				}
+				if o.Key == prefix {
+					return e, nil
+				}
+				if match == nil || e.CreationTime.After(match.CreationTime) {
+					match = e
+				}
+			}
+			if !res.IsTruncated || res.NextContinuationToken == "" {
+				break
+			}
+			token = res.NextContinuationToken
+		}
+		if match != nil {
+			return match, nil
+		}
+	}
+	return nil, nil
+}
+
+func (b *S3Backend) Download(ctx context.Context, u string, w io.Writer) error {
+	if !strings.HasPrefix(u, b.bucketURL()) {
+		return errors.Errorf("cache archive %s is not in bucket %s", u, b.cfg.Bucket)
+	}
+	resp, err := b.do(ctx, "GET", u, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkS3Response(ctx, resp); err != nil {
+		return errors.Wrap(err, "failed to download cache")
+	}
+	_, err = io.Copy(w, resp.Body)
+	return errors.WithStack(err)
+}
+
+// call sends a request and decodes its XML response into out.
+func (b *S3Backend) call(ctx context.Context, method, u string, body io.ReadSeeker, out interface{}) error {
+	resp, err := b.do(ctx, method, u, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkS3Response(ctx, resp); err != nil {
+		return err
+	}
+	return errors.WithStack(xml.NewDecoder(io.LimitReader(resp.Body, cacheOf(ctx).responseLimit(resp))).Decode(out))
+}
+
+// do sends a signed request with body, which is read again if the request
+// is retried. Requests of a Cache use its client and retry policy.
+func (b *S3Backend) do(ctx context.Context, method, u string, body io.ReadSeeker) (*http.Response, error) {
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if body != nil {
+		n, err := body.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		req.ContentLength = n
+		req.GetBody = func() (io.ReadCloser, error) {
+			if _, err := body.Seek(0, io.SeekStart); err != nil {
+				return nil, err
+			}
+			return ioutil.NopCloser(body), nil
+		}
+		req.Body, _ = req.GetBody()
+		if n == 0 {
+			req.Body = http.NoBody
+		}
+	}
+	clk := b.cfg.Clock
+	if clk == nil {
+		clk = systemClock{}
+	}
+	c := cacheOf(ctx)
+	client := b.cfg.HTTPClient
+	if client == nil {
+		client = c.httpClient()
+	}
+	send := func(req *http.Request) (*http.Response, error) {
+		b.sign(req, clk.Now())
+		resp, err := client.Do(req)
+		return resp, errors.WithStack(err)
+	}
+	req = req.WithContext(withEndpoint(ctx, endpointS3))
+	if c == nil {
+		return send(req)
+	}
+	return c.doRetry(req, send)
+}
+
+// checkS3Response returns the *S3Error of an error response, reading at
+// most the MaxErrorBodySize of the Cache of ctx.
+func checkS3Response(ctx context.Context, resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	e := &S3Error{}
+	dt, _ := ioutil.ReadAll(io.LimitReader(resp.Body, cacheOf(ctx).maxErrorBodySize()))
+	if err := xml.Unmarshal(dt, e); err != nil {
+		e = &S3Error{}
+	}
+	e.StatusCode = resp.StatusCode
+	return errors.WithStack(e)
+}
+
+// s3UnsignedPayload is the payload hash of requests whose body is not
+// signed, so uploads are not read twice.
+const s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+
+// sign adds an AWS Signature Version 4 to req.
+func (b *S3Backend) sign(req *http.Request, now time.Time) {
+	if req.Header.Get("x-amz-content-sha256") == "" {
+		req.Header.Set("x-amz-content-sha256", s3UnsignedPayload)
+	}
+	amzDate := now.UTC().Format("20060102T150405Z")
+	req.Header.Set("x-amz-date", amzDate)
+	if b.cfg.SessionToken != "" {
+		req.Header.Set("x-amz-security-token", b.cfg.SessionToken)
+	}
+	names := []string{"host"}
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		k = strings.ToLower(k)
+		if strings.HasPrefix(k, "x-amz-") || k == "range" || k == "content-type" || k == "content-md5" {
+			names = append(names, k)
+			headers[k] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	q := req.URL.Query()
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var query []string
+	for _, k := range keys {
+		vs := append([]string(nil), q[k]...)
+		sort.Strings(vs)
+		for _, v := range vs {
+			query = append(query, s3Escape(k, false)+"="+s3Escape(v, false))
+		}
+	}
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		strings.Join(query, "&"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("x-amz-content-sha256"),
+	}, "\n")
+
+	date := amzDate[:8]
+	scope := date + "/" + b.cfg.Region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+	key := []byte("AWS4" + b.cfg.SecretAccessKey)
+	for _, s := range []string{date, b.cfg.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, s)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", b.cfg.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(s))
+	return h.Sum(nil)
+}
+
+// s3Escape escapes s the way AWS signatures expect, keeping slashes if
+// path is set.
+func s3Escape(s string, path bool) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' || (path && c == '/') {
+			sb.WriteByte(c)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,56 @@
+package actionscache
+
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// BlobSource is the payload of an entry saved with SaveAll, eg. a
+// *bytes.Reader or *io.SectionReader.
+type BlobSource interface {
+	io.ReaderAt
+	Size() int64
+}
+
+// SaveAll saves many entries concurrently. Unlike calling Save from many
+// goroutines, the chunks of all entries are uploaded by one pool of
+// UploadConcurrency workers so the load on the service does not grow with
+// the number of entries. The first failing save cancels the others and its
+// error is returned.
+func (c *Cache) SaveAll(ctx context.Context, blobs map[string]BlobSource, opts ...SaveOpt) error {
+	ctx = withUploadSlots(ctx, c.uploadConcurrency())
+	eg, ctx := errgroup.WithContext(ctx)
+	for key, src := range blobs {
+		key, src := key, src
+		eg.Go(func() error {
+			return errors.Wrapf(c.Save(ctx, key, src, src.Size(), opts...), "failed to save %s", key)
+		})
+	}
+	return eg.Wait()
+}
+
+type uploadSlotsKey struct{}
+
+// withUploadSlots limits the chunk uploads of all saves with ctx to n at a
+// time.
+func withUploadSlots(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, uploadSlotsKey{}, make(chan struct{}, n))
+}
+
+// acquireUploadSlot waits for a free upload slot of ctx if it limits the
+// uploads of several saves. The returned function releases the slot.
+func acquireUploadSlot(ctx context.Context) (func(), error) {
+	slots, ok := ctx.Value(uploadSlotsKey{}).(chan struct{})
+	if !ok {
+		return func() {}, nil
+	}
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,59 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaveAll(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	c.UploadChunkSize = 3
+	c.UploadConcurrency = 2
+
+	ctx := context.TODO()
+	blobs := map[string]BlobSource{
+		"foo": bytes.NewReader([]byte("0123456789")),
+		"bar": bytes.NewReader([]byte("abcdefgh")),
+		"baz": bytes.NewReader([]byte("xyz")),
+	}
+	require.NoError(t, c.SaveAll(ctx, blobs))
+	for key, src := range blobs {
+		ce, err := c.Load(ctx, key)
+		require.NoError(t, err)
+		require.NotNil(t, ce)
+		var buf bytes.Buffer
+		require.NoError(t, ce.Download(ctx, &buf))
+		dt := make([]byte, src.Size())
+		_, err = src.ReadAt(dt, 0)
+		require.NoError(t, err)
+		require.Equal(t, dt, buf.Bytes())
+	}
+
+	err := c.SaveAll(ctx, map[string]BlobSource{"": bytes.NewReader([]byte("foo"))})
+	require.ErrorIs(t, err, ErrInvalidKey)
+}
+
+func TestUploadSlots(t *testing.T) {
+	ctx := withUploadSlots(context.TODO(), 1)
+	release, err := acquireUploadSlot(ctx)
+	require.NoError(t, err)
+
+	ctx2, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = acquireUploadSlot(ctx2)
+	require.ErrorIs(t, err, context.Canceled)
+
+	release()
+	release, err = acquireUploadSlot(ctx)
+	require.NoError(t, err)
+	release()
+
+	// contexts without slots are not limited
+	release, err = acquireUploadSlot(context.TODO())
+	require.NoError(t, err)
+	release()
+}
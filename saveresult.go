@@ -0,0 +1,98 @@
+package actionscache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// SaveResult describes a finished save.
+type SaveResult struct {
+	// CacheID is the ID of the saved entry, zero if the service did not
+	// return one or the save was skipped.
+	CacheID int64
+	// Size is the number of bytes uploaded, excluding retried requests.
+	Size int64
+	// Chunks is the number of uploaded chunks or blocks.
+	Chunks int
+	// Retries is the number of retried requests and chunk uploads.
+	Retries int
+	// Elapsed is the duration of the save.
+	Elapsed time.Duration
+}
+
+// SaveStats fills res with the result of the save once it returns. The
+// fields are also set for failed saves, as far as the save got.
+func SaveStats(res *SaveResult) SaveOpt {
+	return func(o *saveOpt) {
+		o.result = res
+	}
+}
+
+type saveStats struct {
+	mu  sync.Mutex
+	res *SaveResult
+}
+
+type saveStatsKey struct{}
+
+// withSaveStats makes the uploads of ctx count into res. The clock of c
+// measures the time until the returned function is called.
+func (c *Cache) withSaveStats(ctx context.Context, res *SaveResult) (context.Context, func()) {
+	if res == nil {
+		return ctx, func() {}
+	}
+	// nested saves of encoded payloads count into the outer save
+	if s, ok := ctx.Value(saveStatsKey{}).(*saveStats); ok && s != nil && s.res == res {
+		return ctx, func() {}
+	}
+	*res = SaveResult{}
+	s := &saveStats{res: res}
+	start := c.clock().Now()
+	return context.WithValue(ctx, saveStatsKey{}, s), func() {
+		s.update(func(r *SaveResult) {
+			r.Elapsed = c.clock().Now().Sub(start)
+		})
+	}
+}
+
+// withoutSaveStats returns ctx and opts for a save that must not count into
+// the result of the save it is part of, like the dedup index entry.
+func withoutSaveStats(ctx context.Context, opts []SaveOpt) (context.Context, []SaveOpt) {
+	return context.WithValue(ctx, saveStatsKey{}, (*saveStats)(nil)), append(opts, SaveStats(nil))
+}
+
+func (s *saveStats) update(fn func(*SaveResult)) {
+	s.mu.Lock()
+	fn(s.res)
+	s.mu.Unlock()
+}
+
+func updateSaveStats(ctx context.Context, fn func(*SaveResult)) {
+	if s, ok := ctx.Value(saveStatsKey{}).(*saveStats); ok && s != nil {
+		s.update(fn)
+	}
+}
+
+// recordUpload counts an uploaded chunk of n bytes.
+func recordUpload(ctx context.Context, n int64) {
+	updateSaveStats(ctx, func(r *SaveResult) {
+		r.Size += n
+		r.Chunks++
+	})
+}
+
+// recordRetry counts a retried request.
+func recordRetry(ctx context.Context) {
+	countRetry(ctx)
+	updateSaveStats(ctx, func(r *SaveResult) {
+		r.Retries++
+	})
+}
+
+// recordCacheID sets the ID of the saved entry.
+func recordCacheID(ctx context.Context, id int64) {
+	updateSaveStats(ctx, func(r *SaveResult) {
+		r.CacheID = id
+	})
+}
@@ -0,0 +1,72 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaveStats(t *testing.T) {
+	for _, v2 := range []bool{false, true} {
+		ts := newTestServer(t)
+		failed := false
+		ts.fail = func(r *http.Request) int {
+			if !failed && (r.Method == "PATCH" || r.URL.Query().Get("comp") == "block") {
+				failed = true
+				return http.StatusInternalServerError
+			}
+			return 0
+		}
+		c := ts.newCache(t)
+		if v2 {
+			c = ts.newCacheV2(t)
+		}
+		c.Clock = newTestClock()
+		c.UploadChunkSize = 4
+
+		ctx := context.TODO()
+		dt := []byte("0123456789")
+		var res SaveResult
+		require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt)), SaveStats(&res)))
+		require.Equal(t, int64(1), res.CacheID)
+		require.Equal(t, int64(10), res.Size)
+		require.Equal(t, 3, res.Chunks)
+		require.Equal(t, 1, res.Retries)
+		require.Greater(t, int64(res.Elapsed), int64(0))
+
+		if v2 {
+			continue
+		}
+		w, err := c.SaveWriter(ctx, "bar", SaveStats(&res))
+		require.NoError(t, err)
+		_, err = w.Write(dt)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		require.Equal(t, int64(2), res.CacheID)
+		require.Equal(t, int64(10), res.Size)
+		require.Equal(t, 3, res.Chunks)
+		require.Equal(t, 0, res.Retries)
+	}
+}
+
+func TestSaveStatsDedup(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	c.UploadChunkSize = 4
+
+	ctx := context.TODO()
+	dt := []byte("01234")
+	var res SaveResult
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt)), SaveDedup(), SaveStats(&res)))
+	// the dedup index entry saved after foo is not counted
+	require.Equal(t, int64(1), res.CacheID)
+	require.Equal(t, int64(5), res.Size)
+	require.Equal(t, 2, res.Chunks)
+	sum := sha256.Sum256(dt)
+	require.NotNil(t, ts.entry(dedupKeyPrefix+hex.EncodeToString(sum[:])))
+}
@@ -0,0 +1,199 @@
+package actionscache
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// CanRead reports if the token may read entries of scope. Short branch
+// names like "main" match the refs/heads/ scope of the branch.
+func (c *Cache) CanRead(scope string) bool {
+	p, _ := c.permission(scope)
+	return p&PermissionRead != 0
+}
+
+// CanWrite reports if a Save with SaveScope(scope) is accepted. The service
+// only writes to the first writable scope of the token, other writable
+// scopes are reported as not writable.
+func (c *Cache) CanWrite(scope string) bool {
+	return c.checkWriteScope(scope) == nil
+}
+
+// checkWriteScope returns an error if a save to scope would be rejected.
+func (c *Cache) checkWriteScope(scope string) error {
+	if err := c.checkScope(scope, PermissionWrite); err != nil {
+		return err
+	}
+	// the service always writes to the first writable scope of the token
+	if ws := c.writeScope(); c.Scopes() != nil && !scopeMatches(ws, scope) {
+		return errors.Errorf("cannot save to scope %s, service writes to %s", scope, ws)
+	}
+	return nil
+}
+
+// ownScope returns the ref the job runs for: the first ref of the scope
+// the service writes to, or of the first scope of read-only tokens. It is
+// "" for tokens without scopes.
+func (c *Cache) ownScope() string {
+	scope := c.writeScope()
+	if scope == "" {
+		if scopes := c.Scopes(); len(scopes) > 0 {
+			scope = scopes[0].Scope
+		}
+	}
+	if refs := ParseScope(scope); len(refs) > 0 {
+		return refs[0].Raw
+	}
+	return ""
+}
+
+// ScopedKey returns key prefixed with the ref the job runs for, eg.
+// "refs/pull/12/merge:go-mod-1", so keys of different refs never match
+// each other, not even by prefix when the service falls back to the
+// entries of the default branch. Git refs can not contain ":". Keys are
+// returned unchanged for tokens without scopes.
+func (c *Cache) ScopedKey(key string) string {
+	ref := c.ownScope()
+	if ref == "" {
+		return key
+	}
+	return ref + ":" + key
+}
+
+// LoadNoFallback restricts Load to entries of the ref the job runs for,
+// reporting entries the service falls back to from other refs, eg. the
+// default branch of a pull request, as misses. Loads fail for tokens
+// without scopes and with the v2 service, which does not report scopes.
+func LoadNoFallback() LoadOpt {
+	return func(o *loadOpt) {
+		o.noFallback = true
+	}
+}
+
+// NewWithoutScopes is like New but also accepts runtime tokens without
+// access controls, as issued by some runners and proxies. The scopes of
+// such tokens are unknown so permissions are left to the server to check.
+func NewWithoutScopes(token, url string, opts ...Opt) (*Cache, error) {
+	return New(token, url, append([]Opt{WithRelaxedToken()}, opts...)...)
+}
+
+// permission returns the permissions of the token for scope and if any
+// scope of the token matched. Tokens without scopes are assumed to have
+// all permissions.
+func (c *Cache) permission(scope string) (Permission, bool) {
+	if c.Scopes() == nil {
+		return PermissionRead | PermissionWrite, true
+	}
+	var p Permission
+	found := false
+	for _, s := range c.Scopes() {
+		if scopeMatches(s.Scope, scope) {
+			p |= s.Permission
+			found = true
+		}
+	}
+	return p, found
+}
+
+// normalizeScope expands short branch names to their refs/heads/ scope.
+func normalizeScope(scope string) string {
+	if scope == "" || strings.HasPrefix(scope, "refs/") {
+		return scope
+	}
+	return "refs/heads/" + scope
+}
+
+// scopeMatches reports if the granted scope of a token covers scope. A
+// granted scope ending in "*" covers all scopes with that prefix.
+func scopeMatches(granted, scope string) bool {
+	scope = normalizeScope(scope)
+	if strings.HasSuffix(granted, "*") {
+		return strings.HasPrefix(scope, strings.TrimSuffix(granted, "*"))
+	}
+	return granted == scope
+}
+
+// RefType is the kind of git ref a scope is for.
+type RefType string
+
+const (
+	RefBranch      RefType = "branch"
+	RefTag         RefType = "tag"
+	RefPullRequest RefType = "pull"
+	// RefOther are refs of other kinds and patterns like "refs/tags/*".
+	RefOther RefType = "other"
+)
+
+// Ref is a git ref parsed from a scope.
+type Ref struct {
+	Type RefType
+	// Name is the branch or tag name, or the rest of a pull request ref
+	// like "123/merge".
+	Name string
+	// PullRequest is the number of a pull request ref.
+	PullRequest int
+	// Raw is the ref as it appears in the scope.
+	Raw string
+}
+
+func (r Ref) String() string {
+	return r.Raw
+}
+
+func (r Ref) IsBranch() bool {
+	return r.Type == RefBranch
+}
+
+func (r Ref) IsTag() bool {
+	return r.Type == RefTag
+}
+
+func (r Ref) IsPullRequest() bool {
+	return r.Type == RefPullRequest
+}
+
+// Refs returns the refs of the scope. A scope may list several refs
+// separated by ";", eg. "refs/heads/main;refs/pull/123/merge".
+func (s Scope) Refs() []Ref {
+	return ParseScope(s.Scope)
+}
+
+// ParseScope returns the refs of a scope string.
+func ParseScope(scope string) []Ref {
+	var refs []Ref
+	for _, r := range strings.Split(scope, ";") {
+		if r = strings.TrimSpace(r); r != "" {
+			refs = append(refs, ParseRef(r))
+		}
+	}
+	return refs
+}
+
+// ParseRef parses a git ref like "refs/heads/main". Short branch names are
+// not expanded.
+func ParseRef(ref string) Ref {
+	r := Ref{Type: RefOther, Raw: ref}
+	if strings.HasSuffix(ref, "*") {
+		return r
+	}
+	switch {
+	case strings.HasPrefix(ref, "refs/heads/"):
+		r.Type, r.Name = RefBranch, strings.TrimPrefix(ref, "refs/heads/")
+	case strings.HasPrefix(ref, "refs/tags/"):
+		r.Type, r.Name = RefTag, strings.TrimPrefix(ref, "refs/tags/")
+	case strings.HasPrefix(ref, "refs/pull/"):
+		name := strings.TrimPrefix(ref, "refs/pull/")
+		num := name
+		if i := strings.IndexByte(name, '/'); i >= 0 {
+			num = name[:i]
+		}
+		n, err := strconv.Atoi(num)
+		if err != nil || n <= 0 {
+			return r
+		}
+		r.Type, r.Name, r.PullRequest = RefPullRequest, name, n
+	}
+	return r
+}
@@ -0,0 +1,139 @@
+package actionscache
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScopePermissions(t *testing.T) {
+	c, err := New(testToken(t,
+		Scope{Scope: "refs/heads/feature", Permission: PermissionRead | PermissionWrite},
+		Scope{Scope: "refs/heads/main", Permission: PermissionRead},
+		Scope{Scope: "refs/tags/*", Permission: PermissionRead},
+	), "")
+	require.NoError(t, err)
+
+	require.True(t, c.CanWrite("refs/heads/feature"))
+	require.True(t, c.CanWrite("feature"))
+	require.True(t, c.CanRead("main"))
+	require.False(t, c.CanWrite("main"))
+	require.True(t, c.CanRead("refs/tags/v1.0.0"))
+	require.False(t, c.CanWrite("refs/tags/v1.0.0"))
+	require.False(t, c.CanRead("refs/heads/other"))
+
+	require.NoError(t, c.checkScope("main", PermissionRead))
+	require.Error(t, c.checkScope("main", PermissionWrite))
+	require.Error(t, c.checkScope("other", PermissionRead))
+
+	for _, scope := range []string{"feature", "main", "refs/tags/v1.0.0", "other"} {
+		_, err := c.saveOpts(context.TODO(), []SaveOpt{SaveScope(scope)})
+		require.Equal(t, c.CanWrite(scope), err == nil, scope)
+	}
+
+	// only the first writable scope can be saved to
+	c, err = New(testToken(t,
+		Scope{Scope: "refs/heads/feature", Permission: PermissionRead | PermissionWrite},
+		Scope{Scope: "refs/heads/other", Permission: PermissionRead | PermissionWrite},
+	), "")
+	require.NoError(t, err)
+	require.True(t, c.CanWrite("feature"))
+	require.False(t, c.CanWrite("other"))
+}
+
+func TestNewWithoutScopes(t *testing.T) {
+	tk, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"iss": "proxy"}).SignedString([]byte("secret"))
+	require.NoError(t, err)
+
+	_, err = New(tk, "")
+	require.Error(t, err)
+
+	c, err := NewWithoutScopes(tk, "")
+	require.NoError(t, err)
+	require.Nil(t, c.Scopes())
+	require.True(t, c.CanWrite("refs/heads/other"))
+	_, err = c.saveOpts(context.TODO(), []SaveOpt{SaveScope("refs/heads/other")})
+	require.NoError(t, err)
+
+	// tokens with access controls are still checked
+	c, err = NewWithoutScopes(testToken(t, Scope{Scope: "refs/heads/main", Permission: PermissionRead}), "")
+	require.NoError(t, err)
+	require.False(t, c.CanWrite("main"))
+}
+
+func TestParseScope(t *testing.T) {
+	refs := Scope{Scope: "refs/heads/main;refs/pull/123/merge"}.Refs()
+	require.Equal(t, []Ref{
+		{Type: RefBranch, Name: "main", Raw: "refs/heads/main"},
+		{Type: RefPullRequest, Name: "123/merge", PullRequest: 123, Raw: "refs/pull/123/merge"},
+	}, refs)
+	require.True(t, refs[0].IsBranch())
+	require.True(t, refs[1].IsPullRequest())
+	require.Equal(t, "refs/pull/123/merge", refs[1].String())
+
+	require.Equal(t, Ref{Type: RefTag, Name: "v1.0.0", Raw: "refs/tags/v1.0.0"}, ParseRef("refs/tags/v1.0.0"))
+	require.True(t, ParseRef("refs/tags/v1.0.0").IsTag())
+	require.Equal(t, Ref{Type: RefBranch, Name: "feature/x", Raw: "refs/heads/feature/x"}, ParseRef("refs/heads/feature/x"))
+	require.Equal(t, RefOther, ParseRef("refs/tags/*").Type)
+	require.Equal(t, RefOther, ParseRef("refs/pull/abc/merge").Type)
+	require.Equal(t, RefOther, ParseRef("main").Type)
+	require.Empty(t, ParseScope(""))
+}
+
+func TestScopedKey(t *testing.T) {
+	c, err := New(testToken(t,
+		Scope{Scope: "refs/pull/12/merge", Permission: PermissionRead | PermissionWrite},
+		Scope{Scope: "refs/heads/main", Permission: PermissionRead},
+	), "http://localhost/")
+	require.NoError(t, err)
+	require.Equal(t, "refs/pull/12/merge:go-mod-1", c.ScopedKey("go-mod-1"))
+	require.NoError(t, ValidateKey(c.ScopedKey("go-mod-1")))
+
+	c, err = New(testToken(t,
+		Scope{Scope: "refs/pull/12/merge", Permission: PermissionRead},
+		Scope{Scope: "refs/heads/main", Permission: PermissionRead},
+	), "http://localhost/")
+	require.NoError(t, err)
+	require.Equal(t, "refs/pull/12/merge:go-mod-1", c.ScopedKey("go-mod-1"), "read-only tokens use their first scope")
+
+	c, err = NewWithoutScopes("opaque", "http://localhost/")
+	require.NoError(t, err)
+	require.Equal(t, "go-mod-1", c.ScopedKey("go-mod-1"))
+}
+
+func TestLoadNoFallback(t *testing.T) {
+	scope := "refs/heads/main"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(Entry{Key: "foo", Scope: scope, URL: "http://example.invalid"})
+	}))
+	defer ts.Close()
+
+	c, err := New(testToken(t,
+		Scope{Scope: "refs/heads/feature", Permission: PermissionRead | PermissionWrite},
+		Scope{Scope: "refs/heads/main", Permission: PermissionRead},
+	), ts.URL+"/")
+	require.NoError(t, err)
+
+	ctx := context.TODO()
+	ce, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	ce, err = c.LoadWithOpts(ctx, []string{"foo"}, LoadNoFallback())
+	require.NoError(t, err)
+	require.Nil(t, ce, "entries of the default branch are misses")
+
+	scope = "refs/heads/feature"
+	ce, err = c.LoadWithOpts(ctx, []string{"foo"}, LoadNoFallback())
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+
+	c, err = NewWithoutScopes("opaque", ts.URL+"/")
+	require.NoError(t, err)
+	_, err = c.LoadWithOpts(ctx, []string{"foo"}, LoadNoFallback())
+	require.Error(t, err)
+}
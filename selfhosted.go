@@ -0,0 +1,36 @@
+package actionscache
+
+// WithAuthScheme sets the scheme of the Authorization header, for cache
+// servers that do not expect "Bearer".
+func WithAuthScheme(scheme string) Opt {
+	return func(c *Cache) {
+		c.AuthScheme = scheme
+	}
+}
+
+// WithRelaxedToken accepts runtime tokens that are not JWTs or have no
+// access controls claim, like the tokens of self-hosted cache servers.
+func WithRelaxedToken() Opt {
+	return func(c *Cache) {
+		c.RelaxedToken = true
+	}
+}
+
+// WithSelfHosted configures c for a self-hosted reimplementation of the
+// cache service serving the API below prefix, eg. the cache servers of act
+// or Gitea runners. Options passed after it override the individual
+// settings.
+func WithSelfHosted(prefix string) Opt {
+	return func(c *Cache) {
+		c.APIPathPrefix = prefix
+		c.RelaxedToken = true
+	}
+}
+
+func (c *Cache) authorization() string {
+	scheme := c.AuthScheme
+	if scheme == "" {
+		scheme = "Bearer"
+	}
+	return scheme + " " + c.token().Raw
+}
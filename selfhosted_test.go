@@ -0,0 +1,64 @@
+package actionscache
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelfHosted(t *testing.T) {
+	var path, auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, auth = r.URL.Path, r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	_, err := New("opaque", srv.URL+"/")
+	require.Error(t, err)
+
+	c, err := New("opaque", srv.URL+"/", WithSelfHosted("api/cache/"), WithAuthScheme("token"))
+	require.NoError(t, err)
+	require.Empty(t, c.Scopes())
+
+	ce, err := c.Load(context.TODO(), "foo")
+	require.NoError(t, err)
+	require.Nil(t, ce)
+	require.Equal(t, "/api/cache/cache", path)
+	require.Equal(t, "token opaque", auth)
+}
+
+func TestRelaxedToken(t *testing.T) {
+	tk, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"iss": "runner"}).SignedString([]byte("secret"))
+	require.NoError(t, err)
+
+	_, err = New(tk, "")
+	require.Error(t, err)
+
+	c, err := New(tk, "", WithRelaxedToken())
+	require.NoError(t, err)
+	require.Empty(t, c.Scopes())
+	require.Equal(t, "runner", c.claims().Issuer)
+
+	_, err = New(base64.RawURLEncoding.EncodeToString([]byte("not a jwt")), "", WithRelaxedToken())
+	require.NoError(t, err)
+}
+
+func TestAPIPathPrefix(t *testing.T) {
+	for p, exp := range map[string]string{
+		"":           DefaultAPIPathPrefix,
+		"/":          DefaultAPIPathPrefix,
+		"/_cache":    "_cache/",
+		"_cache/":    "_cache/",
+		"/api/cache": "api/cache/",
+	} {
+		c := &Cache{}
+		WithSelfHosted(p)(c)
+		require.Equal(t, exp, c.apiPathPrefix(), p)
+	}
+}
@@ -0,0 +1,361 @@
+package actionscache
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tonistiigi/go-actions-cache/internal/cachestore"
+)
+
+// testServer is a minimal in-memory implementation of the cache service.
+type testServer struct {
+	*httptest.Server
+	t *testing.T
+
+	// verify is called with every request and its body when set
+	verify func(r *http.Request, body []byte)
+	// plainUploads makes v2 upload URLs not look like Azure SAS URLs
+	plainUploads bool
+	// fail makes the server respond with the returned status when non-zero
+	fail func(r *http.Request) int
+	// noRanges makes blob downloads ignore Range headers
+	noRanges bool
+	// sasDownloads makes download URLs look like Azure SAS URLs, served
+	// with x-ms-range and Content-MD5 like Azure does
+	sasDownloads bool
+
+	mu       sync.Mutex
+	commits  int
+	store    *cachestore.Store
+	requests []string
+}
+
+func newTestServer(t *testing.T) *testServer {
+	ts := &testServer{t: t, store: cachestore.New()}
+	// creation times increase with every commit so newest-first lookups
+	// don't depend on the resolution of the wall clock
+	ts.store.Now = func() time.Time {
+		ts.commits++
+		return time.Date(2021, 1, 1, 0, 0, ts.commits, 0, time.UTC)
+	}
+	ts.Server = httptest.NewServer(http.HandlerFunc(ts.serveHTTP))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func (ts *testServer) newCache(t *testing.T) *Cache {
+	c, err := New(testToken(t, Scope{Scope: "refs/heads/main", Permission: PermissionRead | PermissionWrite}), ts.URL+"/")
+	require.NoError(t, err)
+	return c
+}
+
+func (ts *testServer) newCacheV2(t *testing.T) *Cache {
+	c, err := NewV2(testToken(t, Scope{Scope: "refs/heads/main", Permission: PermissionRead | PermissionWrite}), ts.URL+"/")
+	require.NoError(t, err)
+	return c
+}
+
+// entry returns the newest saved entry of key.
+func (ts *testServer) entry(key string) *cachestore.Entry {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return ts.store.Latest(key)
+}
+
+// evict removes the entries of key.
+func (ts *testServer) evict(key string) {
+	ts.mu.Lock()
+	ts.store.Delete(key)
+	ts.mu.Unlock()
+}
+
+func (ts *testServer) blobURL(e *cachestore.Entry) string {
+	u := ts.URL + "/blob/" + url.PathEscape(e.Key) + "?version=" + url.QueryEscape(e.Version)
+	if ts.sasDownloads {
+		u += "&sv=2020-04-08&sig=test"
+	}
+	return u
+}
+
+// serveSAS serves dt like Azure serves a blob to a SAS URL.
+func (ts *testServer) serveSAS(w http.ResponseWriter, r *http.Request, dt []byte) {
+	setMD5 := func(dt []byte) {
+		sum := md5.Sum(dt)
+		w.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+	}
+	xr := r.Header.Get("x-ms-range")
+	if xr == "" {
+		setMD5(dt)
+		w.Write(dt)
+		return
+	}
+	start, end := int64(0), int64(len(dt)-1)
+	if strings.HasSuffix(xr, "-") {
+		_, err := fmt.Sscanf(xr, "bytes=%d-", &start)
+		require.NoError(ts.t, err)
+	} else {
+		_, err := fmt.Sscanf(xr, "bytes=%d-%d", &start, &end)
+		require.NoError(ts.t, err)
+	}
+	if start >= int64(len(dt)) {
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	if end >= int64(len(dt)) {
+		end = int64(len(dt)) - 1
+	}
+	if r.Header.Get("x-ms-range-get-content-md5") == "true" {
+		require.LessOrEqual(ts.t, end-start+1, int64(blobRangeMD5Limit))
+		setMD5(dt[start : end+1])
+	}
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(dt)))
+	w.WriteHeader(http.StatusPartialContent)
+	w.Write(dt[start : end+1])
+}
+
+func (ts *testServer) count(prefix string) int {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	n := 0
+	for _, r := range ts.requests {
+		if strings.HasPrefix(r, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+func (ts *testServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.requests = append(ts.requests, r.Method+" "+r.URL.Path)
+	w.Header().Set("X-GitHub-Request-Id", strconv.Itoa(len(ts.requests)))
+	body, err := ioutil.ReadAll(r.Body)
+	require.NoError(ts.t, err)
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if ts.verify != nil {
+		ts.verify(r, body)
+	}
+	if ts.fail != nil {
+		if code := ts.fail(r); code != 0 {
+			w.WriteHeader(code)
+			return
+		}
+	}
+	if v := r.Header.Get("Content-MD5"); v != "" {
+		sum := md5.Sum(body)
+		if v != base64.StdEncoding.EncodeToString(sum[:]) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	p := strings.TrimPrefix(r.URL.Path, "/_apis/artifactcache/")
+	switch {
+	case r.Method == "GET" && p == "cache":
+		if e := ts.store.Find(r.URL.Query().Get("version"), strings.Split(r.URL.Query().Get("keys"), ",")...); e != nil {
+			json.NewEncoder(w).Encode(Entry{Key: e.Key, Scope: "refs/heads/main", URL: ts.blobURL(e), CreationTime: e.Created})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case strings.HasPrefix(r.URL.Path, "/"+twirpCacheService):
+		ts.serveTwirp(w, strings.TrimPrefix(r.URL.Path, "/"+twirpCacheService), body)
+	case strings.HasPrefix(r.URL.Path, "/"+twirpArtifactService):
+		ts.serveArtifacts(w, strings.TrimPrefix(r.URL.Path, "/"+twirpArtifactService), body)
+	case r.Method == "PUT" && strings.HasPrefix(r.URL.Path, "/upload/"):
+		id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/upload/"))
+		require.NoError(ts.t, err)
+		u, ok := ts.store.Upload(id)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch r.URL.Query().Get("comp") {
+		case "block":
+			if u.Blocks == nil {
+				u.Blocks = map[string][]byte{}
+			}
+			u.Blocks[r.URL.Query().Get("blockid")] = body
+		case "blocklist":
+			var bl blockList
+			require.NoError(ts.t, xml.Unmarshal(body, &bl))
+			u.Data = nil
+			for _, id := range bl.Latest {
+				dt, ok := u.Blocks[id]
+				require.True(ts.t, ok, "block %s not staged", id)
+				u.Data = append(u.Data, dt...)
+			}
+		default:
+			require.Equal(ts.t, "BlockBlob", r.Header.Get("x-ms-blob-type"))
+			u.Data = body
+		}
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == "POST" && p == "caches":
+		var req ReserveCacheReq
+		require.NoError(ts.t, json.NewDecoder(r.Body).Decode(&req))
+		id, ok := ts.store.Reserve(req.Key, req.Version)
+		if !ok {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"message":"Cache already exists.","typeKey":"ArtifactCacheItemAlreadyExistsException"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(ReserveCacheResp{CacheID: id})
+	case strings.HasPrefix(p, "caches/"):
+		id, err := strconv.Atoi(strings.TrimPrefix(p, "caches/"))
+		require.NoError(ts.t, err)
+		u, ok := ts.store.Upload(id)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch r.Method {
+		case "PATCH":
+			var start, end int64
+			_, err := fmt.Sscanf(r.Header.Get("Content-Range"), "bytes %d-%d/*", &start, &end)
+			require.NoError(ts.t, err)
+			dt, err := ioutil.ReadAll(r.Body)
+			require.NoError(ts.t, err)
+			require.Equal(ts.t, int(end-start+1), len(dt))
+			if int64(len(u.Data)) < end+1 {
+				u.Data = append(u.Data, make([]byte, end+1-int64(len(u.Data)))...)
+			}
+			copy(u.Data[start:], dt)
+		case "POST":
+			var req CommitCacheReq
+			require.NoError(ts.t, json.NewDecoder(r.Body).Decode(&req))
+			u.Data = u.Data[:req.Size]
+			ts.store.Commit(id)
+		}
+	case r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/blob/"):
+		key, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/blob/"))
+		require.NoError(ts.t, err)
+		e := ts.store.Get(key, r.URL.Query().Get("version"))
+		if e == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if ts.noRanges {
+			w.Write(e.Data)
+			return
+		}
+		if ts.sasDownloads {
+			require.Empty(ts.t, r.Header.Get("Range"))
+			ts.serveSAS(w, r, e.Data)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(e.Data))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (ts *testServer) serveTwirp(w http.ResponseWriter, method string, body []byte) {
+	switch method {
+	case "CreateCacheEntry":
+		var req createCacheEntryRequest
+		require.NoError(ts.t, json.Unmarshal(body, &req))
+		id, ok := ts.store.Reserve(req.Key, req.Version)
+		if !ok {
+			json.NewEncoder(w).Encode(createCacheEntryResponse{OK: false})
+			return
+		}
+		u := fmt.Sprintf("%s/upload/%d?sv=2020-04-08&sig=x", ts.URL, id)
+		if ts.plainUploads {
+			u = fmt.Sprintf("%s/upload/%d", ts.URL, id)
+		}
+		json.NewEncoder(w).Encode(createCacheEntryResponse{OK: true, SignedUploadURL: u})
+	case "FinalizeCacheEntryUpload":
+		var req finalizeCacheEntryUploadRequest
+		require.NoError(ts.t, json.Unmarshal(body, &req))
+		id, u, ok := ts.store.UploadOf(req.Key, req.Version)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"code":"not_found","msg":"upload not found"}`))
+			return
+		}
+		require.Equal(ts.t, req.SizeBytes, int64(len(u.Data)))
+		ts.store.Commit(id)
+		fmt.Fprintf(w, `{"ok":true,"entry_id":"%d"}`, id)
+	case "GetCacheEntryDownloadURL":
+		var req getCacheEntryDownloadURLRequest
+		require.NoError(ts.t, json.Unmarshal(body, &req))
+		e := ts.store.Find(req.Version, append([]string{req.Key}, req.RestoreKeys...)...)
+		if e == nil {
+			json.NewEncoder(w).Encode(getCacheEntryDownloadURLResponse{OK: false})
+			return
+		}
+		json.NewEncoder(w).Encode(getCacheEntryDownloadURLResponse{OK: true, MatchedKey: e.Key, SignedDownloadURL: ts.blobURL(e)})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"code":"bad_route","msg":"no handler"}`))
+	}
+}
+
+// artifactVersion is the version of the entries of artifacts in the store.
+const artifactVersion = "artifact"
+
+func (ts *testServer) serveArtifacts(w http.ResponseWriter, method string, body []byte) {
+	var req artifactRequest
+	require.NoError(ts.t, json.Unmarshal(body, &req))
+	require.Equal(ts.t, "run-1", req.WorkflowRunBackendID)
+	require.Equal(ts.t, "job-1", req.WorkflowJobRunBackendID)
+	switch method {
+	case "CreateArtifact":
+		var req createArtifactRequest
+		require.NoError(ts.t, json.Unmarshal(body, &req))
+		require.Equal(ts.t, 4, req.Version)
+		id, ok := ts.store.Reserve(req.Name, artifactVersion)
+		if !ok {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"code":"already_exists","msg":"artifact exists"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(createArtifactResponse{OK: true, SignedUploadURL: fmt.Sprintf("%s/upload/%d?sv=2020-04-08&sig=x", ts.URL, id)})
+	case "FinalizeArtifact":
+		var req finalizeArtifactRequest
+		require.NoError(ts.t, json.Unmarshal(body, &req))
+		id, u, ok := ts.store.UploadOf(req.Name, artifactVersion)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"code":"not_found","msg":"upload not found"}`))
+			return
+		}
+		require.Equal(ts.t, req.Size, int64(len(u.Data)))
+		ts.store.Commit(id)
+		fmt.Fprintf(w, `{"ok":true,"artifact_id":"%d"}`, id)
+	case "ListArtifacts":
+		var req listArtifactsRequest
+		require.NoError(ts.t, json.Unmarshal(body, &req))
+		e := ts.store.Get(req.NameFilter, artifactVersion)
+		if e == nil {
+			w.Write([]byte(`{}`))
+			return
+		}
+		fmt.Fprintf(w, `{"artifacts":[{"name":%q,"database_id":"1","size":"%d","created_at":%q}]}`, e.Key, len(e.Data), e.Created.Format(time.RFC3339))
+	case "GetSignedArtifactURL":
+		e := ts.store.Get(req.Name, artifactVersion)
+		if e == nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"code":"not_found","msg":"artifact not found"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(getSignedArtifactURLResponse{SignedURL: ts.blobURL(e)})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"code":"bad_route","msg":"no handler"}`))
+	}
+}
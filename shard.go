@@ -0,0 +1,161 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// shardsMagic starts the payload of an entry whose data is split over the
+// entries of its parts.
+const shardsMagic = "actionscache-shards:v1\n"
+
+// shards is the payload of a sharded entry after shardsMagic. The parts
+// are stored under the keys returned by shardKey in order.
+type shards struct {
+	Size  int64       `json:"size"`
+	Parts []shardPart `json:"parts"`
+}
+
+type shardPart struct {
+	Size   int64  `json:"size"`
+	Digest string `json:"digest"`
+}
+
+// SaveSharded makes Save split payloads larger than shardSize bytes over
+// multiple entries, so payloads larger than the size limit of an entry
+// can be saved. The parts are saved under the keys "<key>.part0" to
+// "<key>.partN" and then a small entry listing them under key, along with
+// an entry marking it as sharded. Download of that entry transparently
+// downloads and verifies the parts in order.
+// shardSize defaults to MaxEntrySize when zero or less. Parts are saved
+// before the entry listing them, so it is the newest entry matched by a
+// restore key that matches the parts too. SaveWriter does not shard, as
+// the size must be known before the first part is saved.
+func SaveSharded(shardSize int64) SaveOpt {
+	return func(o *saveOpt) {
+		o.sharded = true
+		o.shardSize = shardSize
+	}
+}
+
+func noShards(o *saveOpt) {
+	o.sharded = false
+}
+
+// shardLimit returns the size of the parts of a sharded save, 0 if the
+// save is not sharded.
+func (so *saveOpt) shardLimit() int64 {
+	if !so.sharded {
+		return 0
+	}
+	if so.shardSize > 0 {
+		return so.shardSize
+	}
+	if MaxEntrySize > 0 {
+		return MaxEntrySize
+	}
+	return 0
+}
+
+func isSharded(opts []SaveOpt) bool {
+	var so saveOpt
+	for _, o := range opts {
+		o(&so)
+	}
+	return so.sharded
+}
+
+// shardKey returns the key of the part i of the sharded entry key.
+func shardKey(key string, i int) string {
+	return fmt.Sprintf("%s.part%d", key, i)
+}
+
+// saveShards saves size bytes of ra as parts of shardSize bytes and then
+// the entry listing them under key.
+func (c *Cache) saveShards(ctx context.Context, key string, ra io.ReaderAt, size, shardSize int64, opts []SaveOpt) error {
+	opts = append(opts, noShards)
+	sh := shards{Size: size}
+	for off := int64(0); off < size; off += shardSize {
+		n := minInt64(shardSize, size-off)
+		digest, err := c.readerDigest(io.NewSectionReader(ra, off, n))
+		if err != nil {
+			return err
+		}
+		sh.Parts = append(sh.Parts, shardPart{Size: n, Digest: digest})
+	}
+	dt, err := json.Marshal(sh)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	dt = append([]byte(shardsMagic), dt...)
+	if len(dt) > maxAliasSize {
+		return errors.Errorf("cache %s of %d bytes needs too many parts of %d bytes", key, size, shardSize)
+	}
+	if err := c.validateKeys(shardKey(key, len(sh.Parts)-1)); err != nil {
+		return err
+	}
+	c.info(ctx, "save cache: saving sharded", F("key", key), F("size", size), F("parts", len(sh.Parts)))
+	for i, p := range sh.Parts {
+		// parts left by a failed save of the same data are not uploaded again
+		off := int64(i) * shardSize
+		if err := c.save(ctx, shardKey(key, i), io.NewSectionReader(ra, off, p.Size), p.Size, append(opts, SaveIgnoreAlreadyExists())); err != nil {
+			return errors.Wrapf(err, "failed to save part %d of cache %s", i, key)
+		}
+	}
+	if err := c.saveMarker(ctx, key, dt, opts); err != nil {
+		return errors.Wrapf(err, "failed to mark sharded cache %s", key)
+	}
+	return c.save(ctx, key, bytes.NewReader(dt), int64(len(dt)), opts)
+}
+
+// parseShards returns the shards listed by the payload dt of a sharded
+// entry.
+func parseShards(dt []byte) (*shards, bool) {
+	if len(dt) <= len(shardsMagic) || len(dt) > maxAliasSize || !strings.HasPrefix(string(dt), shardsMagic) {
+		return nil, false
+	}
+	var sh shards
+	if err := json.Unmarshal(dt[len(shardsMagic):], &sh); err != nil {
+		return nil, false
+	}
+	return &sh, true
+}
+
+// downloadShards writes the parts of the sharded entry ce to w in order
+// and verifies their size and digest.
+func (ce *Entry) downloadShards(ctx context.Context, sh *shards, w io.Writer) error {
+	if ce.c == nil {
+		return errors.Errorf("cache %s is sharded and can not be loaded without a Cache", ce.Key)
+	}
+	for i, p := range sh.Parts {
+		k := shardKey(ce.Key, i)
+		pe, err := ce.c.load(ctx, []string{k}, nil)
+		if err != nil {
+			return err
+		}
+		if pe == nil || pe.Key != k {
+			return errors.Wrapf(ErrCacheNotFound, "part %d of sharded cache %s", i, ce.Key)
+		}
+		name, h, err := digestHash(p.Digest)
+		if err != nil {
+			return errors.Wrapf(err, "part %d of sharded cache %s", i, ce.Key)
+		}
+		cw := &countWriter{w: io.MultiWriter(w, h)}
+		if err := pe.download(ctx, cw); err != nil {
+			return err
+		}
+		if cw.n != p.Size {
+			return errors.Errorf("part %d of sharded cache %s has size %d, expected %d", i, ce.Key, cw.n, p.Size)
+		}
+		if d := hashDigest(name, h); d != p.Digest {
+			return errors.Errorf("part %d of sharded cache %s has digest %s, expected %s", i, ce.Key, d, p.Digest)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaveSharded(t *testing.T) {
+	ts := newTestServer(t)
+	ctx := context.TODO()
+	dt := bytes.Repeat([]byte("0123456789"), 2500)
+
+	for _, v2 := range []bool{false, true} {
+		c := ts.newCache(t)
+		key := "sharded"
+		if v2 {
+			c = ts.newCacheV2(t)
+			key = "sharded-v2"
+		}
+		require.NoError(t, c.Save(ctx, key, bytes.NewReader(dt), int64(len(dt)), SaveSharded(10000)))
+		require.Len(t, ts.entry(key+".part0").Data, 10000)
+		require.Len(t, ts.entry(key+".part1").Data, 10000)
+		require.Len(t, ts.entry(key+".part2").Data, 5000)
+		require.Nil(t, ts.entry(key+".part3"))
+		require.Less(t, len(ts.entry(key).Data), 1000)
+
+		ce, err := c.Load(ctx, key[:5])
+		require.NoError(t, err)
+		require.Equal(t, key, ce.Key)
+		buf := &bytes.Buffer{}
+		require.NoError(t, ce.Download(ctx, buf))
+		require.Equal(t, dt, buf.Bytes())
+
+		f, err := ioutil.TempFile(t.TempDir(), "")
+		require.NoError(t, err)
+		require.NoError(t, ce.DownloadAt(ctx, f))
+		require.NoError(t, f.Close())
+		got, err := ioutil.ReadFile(f.Name())
+		require.NoError(t, err)
+		require.Equal(t, dt, got)
+
+		// payloads up to the shard size are saved as one entry
+		require.NoError(t, c.Save(ctx, key+"-small", bytes.NewReader(dt[:100]), 100, SaveSharded(10000)))
+		require.Equal(t, dt[:100], ts.entry(key+"-small").Data)
+		require.Nil(t, ts.entry(key+"-small.part0"))
+	}
+}
+
+func TestSaveShardedReader(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	WithCompression("gzip")(c)
+	ctx := context.TODO()
+	dt := bytes.Repeat([]byte("0123456789"), 2500)
+
+	require.NoError(t, c.SaveReader(ctx, "sharded", bytes.NewReader(dt), SaveSharded(10000)))
+	require.NotNil(t, ts.entry("sharded.part2"))
+	ce, err := c.Load(ctx, "sharded")
+	require.NoError(t, err)
+	buf := &bytes.Buffer{}
+	require.NoError(t, ce.Download(ctx, buf))
+	require.Equal(t, dt, buf.Bytes())
+
+	// a part replaced with other data fails the download
+	ts.evict("sharded.part1")
+	require.NoError(t, c.Save(ctx, "sharded.part1", bytes.NewReader(dt[1:10001]), 10000))
+	ce, err = c.Load(ctx, "sharded")
+	require.NoError(t, err)
+	err = ce.Download(ctx, &bytes.Buffer{})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "part 1 of sharded cache sharded has digest")
+
+	ts.evict("sharded.part1")
+	err = ce.Download(ctx, &bytes.Buffer{})
+	require.ErrorIs(t, err, ErrCacheNotFound)
+}
+
+func TestUnmarkedShards(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	ctx := context.TODO()
+
+	// payloads not saved by SaveSharded are data
+	dt := []byte(shardsMagic + `{"size":1,"parts":[{"size":1,"digest":"sha256:00"}]}`)
+	require.NoError(t, c.Save(ctx, "look-alike", bytes.NewReader(dt), int64(len(dt))))
+
+	ce, err := c.Load(ctx, "look-alike")
+	require.NoError(t, err)
+	buf := &bytes.Buffer{}
+	require.NoError(t, ce.Download(ctx, buf))
+	require.Equal(t, dt, buf.Bytes())
+	ba := &bufferAt{}
+	require.NoError(t, ce.DownloadAt(ctx, ba))
+	require.Equal(t, dt, ba.buf)
+	p := make([]byte, len(dt))
+	_, err = ce.ReaderAt(ctx).ReadAt(p, 0)
+	require.NoError(t, err)
+	require.Equal(t, dt, p)
+}
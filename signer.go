@@ -0,0 +1,48 @@
+package actionscache
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// Signer adds extra authentication headers to every request sent to the
+// cache service, eg. for a gateway in front of it. Signers that need the
+// request body must read it through r.GetBody so the body itself is not
+// consumed. Requests to the archive storage are not signed.
+type Signer interface {
+	Sign(r *http.Request) error
+}
+
+// SignerFunc adapts a function to the Signer interface.
+type SignerFunc func(r *http.Request) error
+
+func (f SignerFunc) Sign(r *http.Request) error {
+	return f(r)
+}
+
+// HMACSigner returns a Signer that sets header to the hex encoded
+// HMAC-SHA256 of the request method, path and body, separated by newlines.
+func HMACSigner(header string, key []byte) Signer {
+	return SignerFunc(func(r *http.Request) error {
+		h := hmac.New(sha256.New, key)
+		io.WriteString(h, r.Method+"\n"+r.URL.Path+"\n")
+		if r.GetBody != nil {
+			rc, err := r.GetBody()
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			_, err = io.Copy(h, rc)
+			rc.Close()
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		r.Header.Set(header, hex.EncodeToString(h.Sum(nil)))
+		return nil
+	})
+}
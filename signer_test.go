@@ -0,0 +1,42 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHMACSigner(t *testing.T) {
+	key := []byte("secret")
+	ts := newTestServer(t)
+	var signed int
+	ts.verify = func(r *http.Request, body []byte) {
+		if !strings.HasPrefix(r.URL.Path, "/_apis/") {
+			require.Empty(t, r.Header.Get("X-Signature"))
+			return
+		}
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(r.Method + "\n" + r.URL.Path + "\n"))
+		h.Write(body)
+		require.Equal(t, hex.EncodeToString(h.Sum(nil)), r.Header.Get("X-Signature"))
+		signed++
+	}
+	c := ts.newCache(t)
+	c.Signer = HMACSigner("X-Signature", key)
+
+	ctx := context.TODO()
+	dt := []byte("foobar")
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+	ce, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	require.NoError(t, ce.Download(ctx, &bytes.Buffer{}))
+	require.Equal(t, 4, signed)
+}
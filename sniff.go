@@ -0,0 +1,137 @@
+package actionscache
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+// compressionMagic are the leading bytes of the compression formats found in
+// cache archives, eg. gzip tarballs of older actions/cache versions and zstd
+// ones of newer versions.
+var compressionMagic = []struct {
+	name  string
+	magic []byte
+}{
+	{"gzip", []byte{0x1f, 0x8b}},
+	{"zstd", []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{"xz", []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	{"bzip2", []byte("BZh")},
+}
+
+// sniffLen is the number of bytes SniffCompression needs.
+const sniffLen = 6
+
+// SniffCompression returns the compression of data starting with header,
+// "gzip", "zstd", "xz" or "bzip2", or "" if it starts with none of their
+// magic bytes.
+func SniffCompression(header []byte) string {
+	for _, m := range compressionMagic {
+		if bytes.HasPrefix(header, m.magic) {
+			return m.name
+		}
+	}
+	return ""
+}
+
+var errSniffed = errors.New("compression detected")
+
+// sniffWriter keeps the first sniffLen bytes written to it and then fails
+// the download.
+type sniffWriter struct {
+	buf []byte
+}
+
+func (sw *sniffWriter) Write(p []byte) (int, error) {
+	n := sniffLen - len(sw.buf)
+	if n > len(p) {
+		n = len(p)
+	}
+	sw.buf = append(sw.buf, p[:n]...)
+	if len(sw.buf) == sniffLen {
+		return n, errSniffed
+	}
+	return n, nil
+}
+
+// DetectCompression returns the compression of the archive of ce, as
+// reported by SniffCompression, from its first bytes. The download stops
+// once they are read. Payloads of a Cache with a Compression are decoded
+// first, so this detects the format of the archive saved by the caller.
+func (ce *Entry) DetectCompression(ctx context.Context) (string, error) {
+	ctx = ce.withVersion(ctx)
+	sw := &sniffWriter{}
+	if err := ce.download(ctx, sw); err != nil && !errors.Is(err, errSniffed) {
+		return "", err
+	}
+	return SniffCompression(sw.buf), nil
+}
+
+// DownloadDecompressed downloads the archive of ce into w like Download,
+// decompressing it if it starts with the magic bytes of a registered
+// Compression. It returns the name of the detected compression, "" if the
+// archive was written as it is. Formats without a registered Compression,
+// like "zstd" unless one is registered, fail.
+func (ce *Entry) DownloadDecompressed(ctx context.Context, w io.Writer) (string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := ce.Download(ctx, pw)
+		pw.CloseWithError(err)
+		done <- err
+	}()
+	name, err := decompressTo(w, pr)
+	pr.CloseWithError(errors.New("download aborted"))
+	if err != nil {
+		cancel()
+		<-done
+		return name, err
+	}
+	return name, <-done
+}
+
+// decompressTo copies r to w, decompressing it with the Compression its
+// first bytes name.
+func decompressTo(w io.Writer, r io.Reader) (string, error) {
+	name, zr, err := decompressReader(r)
+	if err != nil {
+		return name, err
+	}
+	defer zr.Close()
+	if _, err := io.Copy(w, zr); err != nil {
+		if name == "" {
+			return "", errors.WithStack(err)
+		}
+		return name, errors.Wrapf(err, "failed to decompress with %s", name)
+	}
+	return name, nil
+}
+
+// decompressReader returns a reader of r decompressed with the Compression
+// its first bytes name, and the name. r is read as it is if they name none.
+func decompressReader(r io.Reader) (string, io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(sniffLen)
+	if err != nil && err != io.EOF {
+		return "", nil, err
+	}
+	name := SniffCompression(head)
+	if name == "" {
+		return "", ioutil.NopCloser(br), nil
+	}
+	comp, err := getCompression(name)
+	if err != nil {
+		return name, nil, errors.Wrap(err, "failed to decompress cache archive")
+	}
+	zr, err := comp.NewReader(br)
+	if err != nil {
+		return name, nil, errors.Wrapf(err, "failed to decompress with %s", name)
+	}
+	return name, zr, nil
+}
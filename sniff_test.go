@@ -0,0 +1,73 @@
+package actionscache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSniffCompression(t *testing.T) {
+	require.Equal(t, "gzip", SniffCompression([]byte{0x1f, 0x8b, 0x08}))
+	require.Equal(t, "zstd", SniffCompression([]byte{0x28, 0xb5, 0x2f, 0xfd, 0x00}))
+	require.Equal(t, "xz", SniffCompression([]byte("\xfd7zXZ\x00")))
+	require.Equal(t, "bzip2", SniffCompression([]byte("BZh91AY")))
+	require.Equal(t, "", SniffCompression([]byte("foo")))
+	require.Equal(t, "", SniffCompression(nil))
+}
+
+func TestDownloadDecompressed(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	ctx := context.TODO()
+
+	tarball := bytes.Repeat([]byte("tarball"), 1000)
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	_, err := zw.Write(tarball)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	zstd := append([]byte{0x28, 0xb5, 0x2f, 0xfd}, tarball...)
+
+	for key, dt := range map[string][]byte{"old": gz.Bytes(), "plain": tarball, "zstd": zstd, "short": []byte("x")} {
+		require.NoError(t, c.Save(ctx, key, bytes.NewReader(dt), int64(len(dt))))
+	}
+
+	for key, exp := range map[string]string{"old": "gzip", "plain": "", "zstd": "zstd", "short": ""} {
+		ce, err := c.Load(ctx, key)
+		require.NoError(t, err)
+		name, err := ce.DetectCompression(ctx)
+		require.NoError(t, err)
+		require.Equal(t, exp, name, key)
+	}
+
+	for _, key := range []string{"old", "plain"} {
+		ce, err := c.Load(ctx, key)
+		require.NoError(t, err)
+		buf := &bytes.Buffer{}
+		name, err := ce.DownloadDecompressed(ctx, buf)
+		require.NoError(t, err)
+		require.Equal(t, tarball, buf.Bytes(), key)
+		if key == "old" {
+			require.Equal(t, "gzip", name)
+		}
+	}
+
+	ce, err := c.Load(ctx, "zstd")
+	require.NoError(t, err)
+	name, err := ce.DownloadDecompressed(ctx, &bytes.Buffer{})
+	require.Error(t, err)
+	require.Equal(t, "zstd", name)
+
+	// payloads compressed by the Cache are decoded before sniffing
+	c = ts.newCache(t)
+	WithCompression("gzip")(c)
+	require.NoError(t, c.Save(ctx, "encoded", bytes.NewReader(tarball), int64(len(tarball))))
+	ce, err = c.Load(ctx, "encoded")
+	require.NoError(t, err)
+	name, err = ce.DetectCompression(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "", name)
+}
@@ -0,0 +1,79 @@
+package actionscache
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// SpooledReaderAt is the payload of a reader buffered by NewReaderAtFrom, in
+// memory or in a temporary file. Save closes it when it returns, which
+// removes the file.
+type SpooledReaderAt struct {
+	ra   io.ReaderAt
+	size int64
+	f    *os.File
+	once sync.Once
+}
+
+// NewReaderAtFrom reads r until EOF and returns the io.ReaderAt and size
+// Save needs. Payloads up to SaveReaderMemoryLimit are kept in memory,
+// larger ones are spilled to a temporary file. A sizeHint beyond the limit
+// writes to the file right away, a negative sizeHint means unknown.
+func NewReaderAtFrom(r io.Reader, sizeHint int64) (*SpooledReaderAt, error) {
+	return newReaderAtFrom(r, sizeHint, int64(SaveReaderMemoryLimit))
+}
+
+// newReaderAtFrom is NewReaderAtFrom keeping payloads up to limit bytes in
+// memory.
+func newReaderAtFrom(r io.Reader, sizeHint, limit int64) (*SpooledReaderAt, error) {
+	buf := &bytes.Buffer{}
+	if sizeHint <= limit {
+		if sizeHint > 0 {
+			buf.Grow(int(sizeHint))
+		}
+		n, err := io.CopyN(buf, r, limit+1)
+		if errors.Is(err, io.EOF) {
+			return &SpooledReaderAt{ra: bytes.NewReader(buf.Bytes()), size: n}, nil
+		}
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	f, err := ioutil.TempFile("", "actionscache-")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	s := &SpooledReaderAt{ra: f, f: f}
+	if s.size, err = io.Copy(f, io.MultiReader(buf, r)); err != nil {
+		s.Close()
+		return nil, errors.WithStack(err)
+	}
+	return s, nil
+}
+
+func (s *SpooledReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	return s.ra.ReadAt(p, off)
+}
+
+// Size returns the number of bytes read from the reader.
+func (s *SpooledReaderAt) Size() int64 {
+	return s.size
+}
+
+// Close removes the temporary file. It is safe to call more than once.
+func (s *SpooledReaderAt) Close() error {
+	var err error
+	s.once.Do(func() {
+		if s.f == nil {
+			return
+		}
+		s.f.Close()
+		err = errors.WithStack(os.Remove(s.f.Name()))
+	})
+	return err
+}
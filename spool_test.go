@@ -0,0 +1,52 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewReaderAtFrom(t *testing.T) {
+	defer func(v int) { SaveReaderMemoryLimit = v }(SaveReaderMemoryLimit)
+	SaveReaderMemoryLimit = 8
+
+	s, err := NewReaderAtFrom(bytes.NewReader([]byte("small")), -1)
+	require.NoError(t, err)
+	require.Nil(t, s.f)
+	require.Equal(t, int64(5), s.Size())
+	require.NoError(t, s.Close())
+
+	dt := []byte("larger than the limit")
+	for _, hint := range []int64{-1, int64(len(dt))} {
+		s, err = NewReaderAtFrom(bytes.NewReader(dt), hint)
+		require.NoError(t, err)
+		require.NotNil(t, s.f)
+		require.Equal(t, int64(len(dt)), s.Size())
+		buf := make([]byte, 6)
+		_, err = s.ReadAt(buf, 2)
+		require.NoError(t, err)
+		require.Equal(t, "rger t", string(buf))
+		require.NoError(t, s.Close())
+		_, err = os.Stat(s.f.Name())
+		require.True(t, os.IsNotExist(err))
+		require.NoError(t, s.Close())
+	}
+
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	ctx := context.TODO()
+	s, err = NewReaderAtFrom(bytes.NewReader(dt), -1)
+	require.NoError(t, err)
+	require.NoError(t, c.Save(ctx, "foo", s, s.Size()))
+	_, err = os.Stat(s.f.Name())
+	require.True(t, os.IsNotExist(err))
+
+	ce, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	buf := &bytes.Buffer{}
+	require.NoError(t, ce.Download(ctx, buf))
+	require.Equal(t, dt, buf.Bytes())
+}
@@ -0,0 +1,59 @@
+package actionscache
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// LoadStat makes Load probe the archive of the returned entry to fill in
+// its Size, ContentType and LastModified, see Entry.Stat.
+func LoadStat() LoadOpt {
+	return func(o *loadOpt) {
+		o.stat = true
+	}
+}
+
+// Stat probes the archive of ce with a single byte range request and sets
+// Size, ContentType and LastModified. The size of compressed or encrypted
+// entries is the size of the stored payload.
+func (ce *Entry) Stat(ctx context.Context) error {
+	return ce.stat(ctx)
+}
+
+func (ce *Entry) stat(ctx context.Context) error {
+	resp, err := ce.getRange(ctx, 0, 1)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	size := int64(-1)
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		if size, err = contentRangeSize(resp.Header.Get("Content-Range")); err != nil {
+			return err
+		}
+	case http.StatusOK:
+		// ranges not supported, the length of the full response is the size
+		size = resp.ContentLength
+	case http.StatusRequestedRangeNotSatisfiable:
+		// empty archive
+		size = 0
+	default:
+		return errors.Errorf("failed to stat cache %s: %s", ce.Key, resp.Status)
+	}
+	io.CopyN(ioutil.Discard, resp.Body, 1)
+	ce.Size = size
+	ce.ContentType = resp.Header.Get("Content-Type")
+	ce.LastModified = time.Time{}
+	if v := resp.Header.Get("Last-Modified"); v != "" {
+		if t, err := http.ParseTime(v); err == nil {
+			ce.LastModified = t
+		}
+	}
+	return nil
+}
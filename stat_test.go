@@ -0,0 +1,63 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStat(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+
+	ctx := context.TODO()
+	dt := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+	require.NoError(t, c.Save(ctx, "empty", bytes.NewReader(nil), 0))
+
+	ce, err := c.LoadWithOpts(ctx, []string{"foo"}, LoadStat())
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	require.Equal(t, int64(len(dt)), ce.Size)
+	require.Equal(t, "text/plain; charset=utf-8", ce.ContentType)
+
+	ce, err = c.Load(ctx, "foo")
+	require.NoError(t, err)
+	require.Equal(t, int64(0), ce.Size)
+	ts.noRanges = true
+	require.NoError(t, ce.Stat(ctx))
+	require.Equal(t, int64(len(dt)), ce.Size)
+	ts.noRanges = false
+
+	ce, err = c.LoadWithOpts(ctx, []string{"empty"}, LoadStat())
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	require.Equal(t, int64(0), ce.Size)
+}
+
+func TestStatHeaders(t *testing.T) {
+	modified := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/zstd")
+		http.ServeContent(w, r, "", modified, bytes.NewReader([]byte("payload")))
+	}))
+	defer srv.Close()
+
+	ce := &Entry{Key: "foo", URL: srv.URL + "/blob"}
+	require.NoError(t, ce.Stat(context.TODO()))
+	require.Equal(t, int64(7), ce.Size)
+	require.Equal(t, "application/zstd", ce.ContentType)
+	require.True(t, modified.Equal(ce.LastModified))
+
+	ce = &Entry{Key: "foo", URL: srv.URL + "/missing"}
+	require.Error(t, ce.Stat(context.TODO()))
+}
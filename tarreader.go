@@ -0,0 +1,75 @@
+package actionscache
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// TarReader iterates the files of the archive of an entry while it is
+// downloaded, so restores can extract them selectively without spooling
+// the archive to disk first.
+type TarReader struct {
+	*tar.Reader
+	// Compression is the name of the compression the archive was
+	// decompressed with, "" if it is not compressed.
+	Compression string
+
+	cancel func()
+	pr     *io.PipeReader
+	zr     io.ReadCloser
+	done   chan error
+	closed bool
+}
+
+// TarReader returns a reader of the files of the tar archive of ce,
+// decompressing it like DownloadDecompressed. The archive is downloaded as
+// the files are read, errors of the download are returned by the reads.
+// Close must be called to stop the download, eg. after extracting only
+// some of the files.
+func (ce *Entry) TarReader(ctx context.Context) (*TarReader, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := ce.Download(ctx, pw)
+		pw.CloseWithError(err)
+		done <- err
+	}()
+	name, zr, err := decompressReader(pr)
+	if err != nil {
+		pr.CloseWithError(errors.New("download aborted"))
+		cancel()
+		if derr := <-done; derr != nil {
+			return nil, derr
+		}
+		return nil, err
+	}
+	return &TarReader{
+		Reader:      tar.NewReader(zr),
+		Compression: name,
+		cancel:      cancel,
+		pr:          pr,
+		zr:          zr,
+		done:        done,
+	}, nil
+}
+
+// Close stops the download. It returns the error of the download if it
+// failed before the reader was closed.
+func (tr *TarReader) Close() error {
+	if tr.closed {
+		return nil
+	}
+	tr.closed = true
+	tr.pr.Close()
+	tr.zr.Close()
+	tr.cancel()
+	err := <-tr.done
+	if errors.Is(err, context.Canceled) || errors.Is(err, io.ErrClosedPipe) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,87 @@
+package actionscache
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTarReader(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	ctx := context.TODO()
+
+	var tarball bytes.Buffer
+	tw := tar.NewWriter(&tarball)
+	files := map[string][]byte{
+		"a": bytes.Repeat([]byte("a"), 100),
+		"b": bytes.Repeat([]byte("b"), 5000),
+		"c": bytes.Repeat([]byte("c"), 200000),
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name]))}))
+		_, err := tw.Write(files[name])
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	_, err := zw.Write(tarball.Bytes())
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	for key, dt := range map[string][]byte{"gzip": gz.Bytes(), "plain": tarball.Bytes()} {
+		require.NoError(t, c.Save(ctx, key, bytes.NewReader(dt), int64(len(dt))))
+	}
+
+	for _, key := range []string{"gzip", "plain"} {
+		ce, err := c.Load(ctx, key)
+		require.NoError(t, err)
+		tr, err := ce.TarReader(ctx)
+		require.NoError(t, err)
+		if key == "gzip" {
+			require.Equal(t, "gzip", tr.Compression)
+		} else {
+			require.Equal(t, "", tr.Compression)
+		}
+		var names []string
+		for {
+			h, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			names = append(names, h.Name)
+			if h.Name == "b" {
+				dt, err := ioutil.ReadAll(tr)
+				require.NoError(t, err)
+				require.Equal(t, files["b"], dt)
+			}
+		}
+		require.Equal(t, []string{"a", "b", "c"}, names, key)
+		require.NoError(t, tr.Close())
+	}
+
+	// closing before the end stops the download
+	ce, err := c.Load(ctx, "gzip")
+	require.NoError(t, err)
+	tr, err := ce.TarReader(ctx)
+	require.NoError(t, err)
+	h, err := tr.Next()
+	require.NoError(t, err)
+	require.Equal(t, "a", h.Name)
+	require.NoError(t, tr.Close())
+	require.NoError(t, tr.Close())
+
+	ce, err = c.Load(ctx, "gzip")
+	require.NoError(t, err)
+	ce.URL = ts.URL + "/missing"
+	_, err = ce.TarReader(ctx)
+	require.Error(t, err)
+}
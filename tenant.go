@@ -0,0 +1,41 @@
+package actionscache
+
+import (
+	"context"
+
+	apierrors "github.com/tonistiigi/go-actions-cache/internal/errors"
+	"github.com/tonistiigi/go-actions-cache/internal/tenant"
+)
+
+// ErrCrossTenant is matched by errors of operations run for a tenant that
+// the Cache does not belong to.
+var ErrCrossTenant = apierrors.ErrCrossTenant
+
+// CrossTenantError is returned when the tenant attached to the context of
+// an operation is not the Tenant of the Cache.
+type CrossTenantError = apierrors.CrossTenantError
+
+// WithTenant sets the tenant, eg. "owner/repo", that the token of the Cache
+// belongs to.
+func WithTenant(tenant string) Opt {
+	return func(c *Cache) {
+		c.Tenant = tenant
+	}
+}
+
+// ForTenant returns a context for operations on behalf of tenant. Proxies
+// serving multiple repositories attach the tenant of every request so that
+// operations fail with a *CrossTenantError if the Cache selected for it
+// belongs to another tenant, or the RestAPI to another repository.
+func ForTenant(ctx context.Context, t string) context.Context {
+	return tenant.With(ctx, t)
+}
+
+// TenantFromContext returns the tenant attached to ctx with ForTenant.
+func TenantFromContext(ctx context.Context) (string, bool) {
+	return tenant.FromContext(ctx)
+}
+
+func (c *Cache) checkTenant(ctx context.Context) error {
+	return tenant.Check(ctx, c.Tenant)
+}
@@ -0,0 +1,57 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTenant(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	WithTenant("owner/a")(c)
+
+	ctx := context.TODO()
+	dt := []byte("foobar")
+	require.NoError(t, c.Save(ForTenant(ctx, "owner/a"), "foo", bytes.NewReader(dt), int64(len(dt))))
+	require.NoError(t, c.Save(ctx, "bar", bytes.NewReader(dt), int64(len(dt))))
+
+	err := c.Save(ForTenant(ctx, "owner/b"), "foo", bytes.NewReader(dt), int64(len(dt)))
+	require.ErrorIs(t, err, ErrCrossTenant)
+	var cte *CrossTenantError
+	require.True(t, errors.As(err, &cte))
+	require.Equal(t, "owner/a", cte.Tenant)
+	require.Equal(t, "owner/b", cte.Requested)
+
+	_, err = c.SaveWriter(ForTenant(ctx, "owner/b"), "baz")
+	require.ErrorIs(t, err, ErrCrossTenant)
+
+	_, err = c.Load(ForTenant(ctx, "owner/b"), "foo")
+	require.ErrorIs(t, err, ErrCrossTenant)
+
+	ce, err := c.Load(ForTenant(ctx, "owner/a"), "foo")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+
+	err = c.Abandon(ForTenant(ctx, "owner/b"), ReservationInfo{ID: 1, Key: "foo"})
+	require.ErrorIs(t, err, ErrCrossTenant)
+}
+
+func TestTenantRestAPI(t *testing.T) {
+	r, err := NewRestAPI("owner/a", "token")
+	require.NoError(t, err)
+	r.URL = "http://127.0.0.1:0/"
+
+	ctx := ForTenant(context.TODO(), "owner/b")
+	_, err = r.List(ctx, "", "")
+	require.ErrorIs(t, err, ErrCrossTenant)
+	require.ErrorIs(t, r.Delete(ctx, 1), ErrCrossTenant)
+	require.ErrorIs(t, r.DeleteKey(ctx, "foo", ""), ErrCrossTenant)
+	_, err = r.Usage(ctx)
+	require.ErrorIs(t, err, ErrCrossTenant)
+	_, err = r.GC(ctx, GCPolicy{MaxSize: 1})
+	require.ErrorIs(t, err, ErrCrossTenant)
+}
@@ -0,0 +1,111 @@
+package actionscache
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// throttleBlock is the most a throttled body reads before waiting, so the
+// limit is shared evenly between concurrent transfers.
+const throttleBlock = 32 * 1024
+
+// WithBandwidthLimit limits the bytes per second sent and received by all
+// requests of the Cache together, eg. so saves do not saturate the network
+// of a self-hosted runner. Zero disables the limit.
+func WithBandwidthLimit(bytesPerSec int64) Opt {
+	return func(c *Cache) {
+		c.BandwidthLimit = bytesPerSec
+	}
+}
+
+// rateLimiter is a token bucket of bytes.
+type rateLimiter struct {
+	mu     sync.Mutex
+	clock  Clock
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(clock Clock, rate int64) *rateLimiter {
+	return &rateLimiter{clock: clock, rate: float64(rate), tokens: float64(rate), last: clock.Now()}
+}
+
+// wait takes n bytes from the bucket and sleeps until it is no longer in
+// debt. Bursts are limited to one second of transfer.
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := l.clock.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var d time.Duration
+	if l.tokens < 0 {
+		d = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+	if d == 0 {
+		return nil
+	}
+	return l.clock.Sleep(ctx, d)
+}
+
+// limiter returns the shared limiter of c or nil without a limit.
+func (c *Cache) limiter() *rateLimiter {
+	if c == nil || c.BandwidthLimit <= 0 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rateLimiter == nil || c.rateLimiter.rate != float64(c.BandwidthLimit) {
+		c.rateLimiter = newRateLimiter(c.clock(), c.BandwidthLimit)
+	}
+	return c.rateLimiter
+}
+
+// throttle limits the request and response bodies of a request sent by c.
+func (c *Cache) throttle(req *http.Request, send func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	l := c.limiter()
+	if l == nil {
+		return send(req)
+	}
+	ctx := req.Context()
+	if req.Body != nil && req.Body != http.NoBody {
+		req.Body = &throttledBody{ctx: ctx, rc: req.Body, l: l}
+	}
+	resp, err := send(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &throttledBody{ctx: ctx, rc: resp.Body, l: l}
+	return resp, nil
+}
+
+type throttledBody struct {
+	ctx context.Context
+	rc  io.ReadCloser
+	l   *rateLimiter
+}
+
+func (b *throttledBody) Read(p []byte) (int, error) {
+	if len(p) > throttleBlock {
+		p = p[:throttleBlock]
+	}
+	n, err := b.rc.Read(p)
+	if n > 0 {
+		if err := b.l.wait(b.ctx, n); err != nil {
+			return n, err
+		}
+	}
+	return n, err
+}
+
+func (b *throttledBody) Close() error {
+	return b.rc.Close()
+}
@@ -0,0 +1,38 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBandwidthLimit(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	clock := newTestClock()
+	c.Clock = clock
+	c.UploadConcurrency = 1
+	WithBandwidthLimit(64 * 1024)(c)
+
+	ctx := context.TODO()
+	dt := bytes.Repeat([]byte("a"), 256*1024)
+	start := clock.Now()
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+	ce, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	require.NoError(t, ce.Download(ctx, ioutil.Discard))
+
+	// 512KiB at 64KiB/s after a burst of one second
+	elapsed := clock.Now().Sub(start)
+	require.GreaterOrEqual(t, int64(elapsed), int64(7*time.Second))
+	require.Less(t, int64(elapsed), int64(8*time.Second))
+
+	c.BandwidthLimit = 0
+	start = clock.Now()
+	require.NoError(t, ce.Download(ctx, ioutil.Discard))
+	require.Equal(t, start, clock.Now())
+}
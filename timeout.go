@@ -0,0 +1,65 @@
+package actionscache
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrTimedOutSoft is returned when an operation exceeds Cache.SoftTimeout.
+// Callers can treat it as a cache miss.
+var ErrTimedOutSoft = errors.New("cache operation exceeded soft timeout")
+
+// withTimeouts runs fn with the hard timeout of the Cache applied to its
+// context and returns ErrTimedOutSoft if fn does not finish within the soft
+// timeout. If abandon is set fn keeps running in the background until the
+// hard deadline, otherwise it is canceled and waited for before returning.
+func (c *Cache) withTimeouts(ctx context.Context, abandon bool, fn func(context.Context) error) error {
+	var cancel func()
+	if c.HardTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, c.HardTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	if c.SoftTimeout <= 0 || (c.HardTimeout > 0 && c.SoftTimeout >= c.HardTimeout) {
+		defer cancel()
+		return fn(ctx)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- fn(ctx)
+		cancel()
+	}()
+
+	expired := make(chan struct{})
+	stop := c.afterFunc(c.SoftTimeout, func() { close(expired) })
+	defer stop()
+	select {
+	case err := <-done:
+		return err
+	case <-expired:
+	}
+	if !abandon {
+		cancel()
+		<-done
+	}
+	c.warn(ctx, "cache operation exceeded soft timeout", F("timeout", c.SoftTimeout))
+	return ErrTimedOutSoft
+}
+
+// withStepTimeout runs a single step of an operation, named by what, with
+// its context canceled after d when d is positive.
+func withStepTimeout(ctx context.Context, d time.Duration, what string, fn func(context.Context) error) error {
+	if d <= 0 {
+		return fn(ctx)
+	}
+	sctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+	err := fn(sctx)
+	if err != nil && ctx.Err() == nil && errors.Is(sctx.Err(), context.DeadlineExceeded) {
+		return errors.Wrapf(context.DeadlineExceeded, "%s timed out after %v", what, d)
+	}
+	return err
+}
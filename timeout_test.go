@@ -0,0 +1,116 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSoftTimeout(t *testing.T) {
+	canceled := make(chan struct{}, 2)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		canceled <- struct{}{}
+	}))
+	defer ts.Close()
+
+	c, err := New(testToken(t, Scope{Scope: "refs/heads/main", Permission: PermissionRead}), ts.URL+"/")
+	require.NoError(t, err)
+	c.SoftTimeout = 50 * time.Millisecond
+	c.HardTimeout = 200 * time.Millisecond
+
+	ctx := context.TODO()
+	start := time.Now()
+	_, err = c.Load(ctx, "foo")
+	require.ErrorIs(t, err, ErrTimedOutSoft)
+	require.Less(t, int64(time.Since(start)), int64(c.HardTimeout))
+
+	select {
+	case <-canceled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request not canceled at hard deadline")
+	}
+	require.GreaterOrEqual(t, int64(time.Since(start)), int64(c.HardTimeout))
+
+	ce := &Entry{Key: "foo", URL: ts.URL + "/blob", c: c}
+	err = ce.Download(ctx, &bytes.Buffer{})
+	require.ErrorIs(t, err, ErrTimedOutSoft)
+
+	c.SoftTimeout = 0
+	_, err = c.Load(ctx, "foo")
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrTimedOutSoft)
+}
+
+func TestTimeoutsUseClock(t *testing.T) {
+	c := &Cache{Clock: newTestClock(), SoftTimeout: time.Hour}
+	err := c.withTimeouts(context.TODO(), false, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	require.ErrorIs(t, err, ErrTimedOutSoft)
+
+	release := make(chan struct{})
+	defer close(release)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "6")
+		w.Write([]byte("foo"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer ts.Close()
+	c = &Cache{Clock: newTestClock(), DownloadIdleTimeout: time.Hour}
+	ce := &Entry{Key: "foo", URL: ts.URL + "/blob", c: c}
+	err = ce.Download(context.TODO(), &bytes.Buffer{})
+	require.ErrorIs(t, err, ErrDownloadStalled)
+}
+
+func TestStepTimeouts(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	c.Clock = newTestClock()
+	WithStepTimeouts(50*time.Millisecond, 0, 50*time.Millisecond)(c)
+
+	release := make(chan struct{})
+	defer close(release)
+	var stuck int32 = 1
+	h := ts.Config.Handler
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PATCH" && atomic.CompareAndSwapInt32(&stuck, 1, 0) {
+			select {
+			case <-r.Context().Done():
+			case <-release:
+			}
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+
+	ctx := context.TODO()
+	dt := []byte("foobar")
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+	require.Equal(t, dt, ts.entry("foo").Data)
+
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" && r.URL.Path == "/_apis/artifactcache/caches" {
+			select {
+			case <-r.Context().Done():
+			case <-release:
+			}
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+	err := c.Save(ctx, "bar", bytes.NewReader(dt), int64(len(dt)))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Contains(t, err.Error(), "reserve cache bar timed out")
+}
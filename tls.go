@@ -0,0 +1,80 @@
+package actionscache
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// WithRootCAs sets the certificate authorities trusted for requests to the
+// cache service and blob storage, eg. the private CA of a proxy in front of
+// a self-hosted cache. It has no effect with WithHTTPClient or
+// WithTransport.
+func WithRootCAs(pool *x509.CertPool) Opt {
+	return func(c *Cache) {
+		c.RootCAs = pool
+	}
+}
+
+// WithInsecureSkipVerify disables the verification of the TLS certificates
+// of the cache service and blob storage. It is meant for test setups only,
+// every Cache created with it logs a warning. It has no effect with
+// WithHTTPClient or WithTransport.
+func WithInsecureSkipVerify() Opt {
+	return func(c *Cache) {
+		c.InsecureSkipVerify = true
+	}
+}
+
+// LoadRootCAs returns the system certificate pool with the PEM encoded
+// certificates of files added, for WithRootCAs.
+func LoadRootCAs(files ...string) (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	for _, f := range files {
+		dt, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if !pool.AppendCertsFromPEM(dt) {
+			return nil, errors.Errorf("no certificates found in %s", f)
+		}
+	}
+	return pool, nil
+}
+
+// configureTLS applies the RootCAs and InsecureSkipVerify of c to tr.
+func (c *Cache) configureTLS(tr *http.Transport) {
+	if c.RootCAs == nil && !c.InsecureSkipVerify {
+		return
+	}
+	cfg := &tls.Config{}
+	if tr.TLSClientConfig != nil {
+		cfg = tr.TLSClientConfig.Clone()
+	}
+	if c.RootCAs != nil {
+		cfg.RootCAs = c.RootCAs
+	}
+	if c.InsecureSkipVerify {
+		cfg.InsecureSkipVerify = true
+	}
+	tr.TLSClientConfig = cfg
+}
+
+// warnInsecure logs that certificates are not verified, so the setting is
+// not left enabled unnoticed.
+func (c *Cache) warnInsecure(ctx context.Context) {
+	switch {
+	case !c.InsecureSkipVerify:
+	case c.HTTPClient != nil:
+		c.warn(ctx, "TLS certificate verification is only disabled for the client of a Cache without an HTTPClient, ignoring", F("url", c.URL))
+	default:
+		c.warn(ctx, "TLS certificate verification of cache requests is disabled, do not use this outside of tests", F("url", c.URL))
+	}
+}
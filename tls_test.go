@@ -0,0 +1,78 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newTLSTestServer returns a test server serving with a self-signed
+// certificate.
+func newTLSTestServer(t *testing.T) *testServer {
+	ts := newTestServer(t)
+	ts.Close()
+	ts.Server = httptest.NewTLSServer(http.HandlerFunc(ts.serveHTTP))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestRootCAs(t *testing.T) {
+	ts := newTLSTestServer(t)
+	ctx := context.TODO()
+	token := testToken(t, Scope{Scope: "refs/heads/main", Permission: PermissionRead | PermissionWrite})
+
+	c, err := New(token, ts.URL+"/")
+	require.NoError(t, err)
+	c.RetryPolicy = &RetryPolicy{MaxAttempts: 1}
+	_, err = c.Load(ctx, "foo")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "certificate")
+
+	f := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, ioutil.WriteFile(f, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0600))
+	pool, err := LoadRootCAs(f)
+	require.NoError(t, err)
+
+	c, err = New(token, ts.URL+"/", WithRootCAs(pool))
+	require.NoError(t, err)
+	dt := []byte("private ca")
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+	ce, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	buf := &bytes.Buffer{}
+	require.NoError(t, ce.Download(ctx, buf))
+	require.Equal(t, dt, buf.Bytes())
+
+	_, err = LoadRootCAs(filepath.Join(t.TempDir(), "missing.pem"))
+	require.Error(t, err)
+	require.NoError(t, ioutil.WriteFile(f, []byte("not a certificate"), 0600))
+	_, err = LoadRootCAs(f)
+	require.Error(t, err)
+}
+
+func TestInsecureSkipVerify(t *testing.T) {
+	ts := newTLSTestServer(t)
+	ctx := context.TODO()
+	l := &recordingLogger{}
+	c, err := New(testToken(t, Scope{Scope: "refs/heads/main", Permission: PermissionRead | PermissionWrite}), ts.URL+"/", WithLogger(l), WithInsecureSkipVerify())
+	require.NoError(t, err)
+	e := l.find("TLS certificate verification of cache requests is disabled, do not use this outside of tests")
+	require.NotNil(t, e)
+	require.Equal(t, "warn", e.level)
+
+	dt := []byte("insecure")
+	require.NoError(t, c.Save(ctx, "foo", bytes.NewReader(dt), int64(len(dt))))
+	ce, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	buf := &bytes.Buffer{}
+	require.NoError(t, ce.Download(ctx, buf))
+	require.Equal(t, dt, buf.Bytes())
+}
@@ -0,0 +1,145 @@
+package actionscache
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// tokenExpirySkew is how long before its expiry a token is refreshed.
+const tokenExpirySkew = time.Minute
+
+// ErrTokenExpired is matched by errors of requests that failed because the
+// runtime token expired.
+var ErrTokenExpired = errors.New("runtime token expired")
+
+// TokenExpiredError is returned for requests made with an expired token
+// when no TokenSource can refresh it.
+type TokenExpiredError struct {
+	Expiry time.Time
+}
+
+func (e *TokenExpiredError) Error() string {
+	return "runtime token expired at " + e.Expiry.Format(time.RFC3339)
+}
+
+func (e *TokenExpiredError) Is(target error) bool {
+	return target == ErrTokenExpired
+}
+
+// TokenSource supplies fresh runtime tokens when the current one expires
+// or is rejected by the service.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// TokenSourceFunc adapts a function to a TokenSource.
+type TokenSourceFunc func(ctx context.Context) (string, error)
+
+func (fn TokenSourceFunc) Token(ctx context.Context) (string, error) {
+	return fn(ctx)
+}
+
+// WithTokenSource sets the TokenSource that refreshes the runtime token.
+func WithTokenSource(ts TokenSource) Opt {
+	return func(c *Cache) {
+		c.TokenSource = ts
+	}
+}
+
+// parseToken returns the parsed runtime token and the scopes it grants. If
+// relaxed is set tokens that are not JWTs or have no access controls are
+// returned with nil scopes instead of failing.
+func parseToken(token string, relaxed bool) (*jwt.Token, []Scope, error) {
+	tk, _, err := new(jwt.Parser).ParseUnverified(token, jwt.MapClaims{})
+	if err != nil {
+		if relaxed {
+			return &jwt.Token{Raw: token, Claims: jwt.MapClaims{}}, nil, nil
+		}
+		return nil, nil, errors.WithStack(err)
+	}
+	claims, ok := tk.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, nil, errors.Errorf("invalid token without claims map")
+	}
+	ac, ok := claims["ac"]
+	if !ok {
+		if relaxed {
+			return tk, nil, nil
+		}
+		return nil, nil, errors.Errorf("invalid token without access controls")
+	}
+	acs, ok := ac.(string)
+	if !ok {
+		return nil, nil, errors.Errorf("invalid token without access controls type")
+	}
+
+	scopes := []Scope{}
+	if err := json.Unmarshal([]byte(acs), &scopes); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to parse token access controls")
+	}
+	return tk, scopes, nil
+}
+
+func (c *Cache) token() *jwt.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Token
+}
+
+// TokenExpiry returns the expiry of the runtime token, the zero time if it
+// has none.
+func (c *Cache) TokenExpiry() time.Time {
+	return c.claims().ExpiresAt
+}
+
+// tokenExpired reports if the token expires within skew.
+func (c *Cache) tokenExpired(skew time.Duration) bool {
+	exp := c.TokenExpiry()
+	return !exp.IsZero() && !c.clock().Now().Add(skew).Before(exp)
+}
+
+// refreshToken replaces the token with one from the TokenSource. Unless
+// force is set it is only replaced if it is about to expire.
+func (c *Cache) refreshToken(ctx context.Context, force bool) error {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+	if !force && !c.tokenExpired(tokenExpirySkew) {
+		return nil
+	}
+	raw, err := c.TokenSource.Token(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to refresh runtime token")
+	}
+	tk, scopes, err := parseToken(raw, c.RelaxedToken)
+	if err != nil {
+		return errors.Wrap(err, "failed to refresh runtime token")
+	}
+	c.mu.Lock()
+	c.Token = tk
+	c.scopes = scopes
+	c.mu.Unlock()
+	c.info(ctx, "refreshed runtime token", F("expiry", c.TokenExpiry()))
+	return nil
+}
+
+// authorize sets the current token on a request to the cache service,
+// refreshing it first if it is about to expire.
+func (c *Cache) authorize(req *http.Request) error {
+	if req.Header.Get("Authorization") == "" {
+		return nil
+	}
+	if c.TokenSource != nil {
+		if err := c.refreshToken(req.Context(), false); err != nil {
+			return err
+		}
+	} else if c.tokenExpired(0) {
+		return errors.WithStack(&TokenExpiredError{Expiry: c.TokenExpiry()})
+	}
+	req.Header.Set("Authorization", c.authorization())
+	return nil
+}
@@ -0,0 +1,128 @@
+package actionscache
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/require"
+)
+
+func expiringToken(t *testing.T, exp time.Time) string {
+	dt, err := json.Marshal([]Scope{{Scope: "refs/heads/main", Permission: PermissionRead | PermissionWrite}})
+	require.NoError(t, err)
+	tk, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"ac":  string(dt),
+		"exp": exp.Unix(),
+	}).SignedString([]byte("secret"))
+	require.NoError(t, err)
+	return tk
+}
+
+func TestTokenExpired(t *testing.T) {
+	ts := newTestServer(t)
+	exp := time.Now().Add(-time.Hour).Truncate(time.Second)
+	c, err := New(expiringToken(t, exp), ts.URL+"/")
+	require.NoError(t, err)
+	require.Equal(t, exp, c.TokenExpiry())
+
+	_, err = c.Load(context.TODO(), "foo")
+	require.ErrorIs(t, err, ErrTokenExpired)
+	require.Equal(t, 0, ts.count("GET "))
+}
+
+func TestTokenSource(t *testing.T) {
+	ts := newTestServer(t)
+	var auth []string
+	ts.verify = func(r *http.Request, _ []byte) {
+		auth = append(auth, r.Header.Get("Authorization"))
+	}
+	var issued []string
+	source := TokenSourceFunc(func(ctx context.Context) (string, error) {
+		tk := expiringToken(t, time.Now().Add(time.Duration(len(issued)+1)*time.Hour))
+		issued = append(issued, tk)
+		return tk, nil
+	})
+	c, err := New(expiringToken(t, time.Now().Add(-time.Minute)), ts.URL+"/", WithTokenSource(source))
+	require.NoError(t, err)
+
+	ctx := context.TODO()
+	_, err = c.Load(ctx, "foo")
+	require.NoError(t, err)
+	require.Len(t, issued, 1)
+	require.Equal(t, []string{"Bearer " + issued[0]}, auth)
+
+	// a rejected token is refreshed once and the request is sent again
+	rejected := false
+	ts.fail = func(r *http.Request) int {
+		if !rejected {
+			rejected = true
+			return http.StatusUnauthorized
+		}
+		return 0
+	}
+	_, err = c.Load(ctx, "bar")
+	require.NoError(t, err)
+	require.Len(t, issued, 2)
+	require.Equal(t, "Bearer "+issued[1], auth[len(auth)-1])
+
+	ts.fail = func(r *http.Request) int {
+		return http.StatusUnauthorized
+	}
+	_, err = c.Load(ctx, "baz")
+	require.Error(t, err)
+	require.Len(t, issued, 3)
+}
+
+func TestTokenClaims(t *testing.T) {
+	dt, err := json.Marshal([]Scope{{Scope: "refs/heads/main", Permission: PermissionRead}})
+	require.NoError(t, err)
+	exp := time.Now().Add(time.Hour).Truncate(time.Second)
+	tk, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"ac":              string(dt),
+		"iss":             "vstoken.actions.githubusercontent.com",
+		"aud":             "vso:5b3b7e3c-3b1c-4e4e-9a8e-7d1c2f0a9e11",
+		"exp":             exp.Unix(),
+		"Actions.Results": "ce7f54c7-61c7-4aae-887f-30da475f5f1a:ca395085-040a-526b-2ce8-bdc85f692774",
+		"orchid":          "ce7f54c7.build.__default",
+		"billingOwnerId":  "U_kgDOAbc",
+	}).SignedString([]byte("secret"))
+	require.NoError(t, err)
+	c, err := New(tk, "http://localhost/")
+	require.NoError(t, err)
+
+	tc := c.Claims()
+	require.Equal(t, "vstoken.actions.githubusercontent.com", tc.Issuer)
+	require.Equal(t, []string{"vso:5b3b7e3c-3b1c-4e4e-9a8e-7d1c2f0a9e11"}, tc.Audience)
+	require.Equal(t, exp, tc.ExpiresAt)
+	require.Equal(t, "ce7f54c7-61c7-4aae-887f-30da475f5f1a", tc.WorkflowRunBackendID)
+	require.Equal(t, "ca395085-040a-526b-2ce8-bdc85f692774", tc.WorkflowJobRunBackendID)
+	require.Equal(t, "ce7f54c7.build.__default", tc.OrchestrationID)
+	require.Equal(t, "U_kgDOAbc", tc.BillingOwnerID)
+	require.Len(t, tc.Scopes, 1)
+	require.Equal(t, "", c.Repository())
+
+	tk, err = jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"ac":               string(dt),
+		"aud":              []string{"a", "b"},
+		"sub":              "repo:octo/hello:ref:refs/heads/main",
+		"run_id":           1234567890,
+		"repository":       "octo/hello",
+		"repository_id":    "42",
+		"repository_owner": "octo",
+	}).SignedString([]byte("secret"))
+	require.NoError(t, err)
+	c, err = New(tk, "http://localhost/")
+	require.NoError(t, err)
+	tc = c.Claims()
+	require.Equal(t, []string{"a", "b"}, tc.Audience)
+	require.Equal(t, "repo:octo/hello:ref:refs/heads/main", tc.Subject)
+	require.Equal(t, "1234567890", tc.RunID)
+	require.Equal(t, "octo/hello", c.Repository())
+	require.Equal(t, "42", tc.RepositoryID)
+	require.Equal(t, "octo", tc.RepositoryOwner)
+	require.True(t, tc.ExpiresAt.IsZero())
+}
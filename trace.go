@@ -0,0 +1,38 @@
+package actionscache
+
+import "context"
+
+// Tracer starts spans for cache operations, eg. adapting an OpenTelemetry
+// tracer. Spans are named after the operation: actionscache.Load,
+// actionscache.Save, actionscache.reserve, actionscache.uploadChunk,
+// actionscache.commit and actionscache.Download.
+type Tracer interface {
+	Start(ctx context.Context, name string, fields ...Field) (context.Context, Span)
+}
+
+// Span is a traced operation. End is called once with the result of the
+// operation.
+type Span interface {
+	SetAttributes(fields ...Field)
+	End(err error)
+}
+
+// WithTracer sets the Tracer of the Cache.
+func WithTracer(t Tracer) Opt {
+	return func(c *Cache) {
+		c.Tracer = t
+	}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(...Field) {}
+func (noopSpan) End(error)              {}
+
+// startSpan starts a span for op if the Cache has a Tracer.
+func (c *Cache) startSpan(ctx context.Context, op string, fields ...Field) (context.Context, Span) {
+	if c == nil || c.Tracer == nil {
+		return ctx, noopSpan{}
+	}
+	return c.Tracer.Start(ctx, "actionscache."+op, fields...)
+}
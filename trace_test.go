@@ -0,0 +1,75 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testSpan struct {
+	name   string
+	fields map[string]interface{}
+	ended  bool
+	err    error
+}
+
+func (s *testSpan) SetAttributes(fields ...Field) {
+	for _, f := range fields {
+		s.fields[f.Key] = f.Value
+	}
+}
+
+func (s *testSpan) End(err error) {
+	s.ended = true
+	s.err = err
+}
+
+type testTracer struct {
+	mu    sync.Mutex
+	spans []*testSpan
+}
+
+func (tr *testTracer) Start(ctx context.Context, name string, fields ...Field) (context.Context, Span) {
+	s := &testSpan{name: name, fields: map[string]interface{}{}}
+	s.SetAttributes(fields...)
+	tr.mu.Lock()
+	tr.spans = append(tr.spans, s)
+	tr.mu.Unlock()
+	return ctx, s
+}
+
+func TestTracer(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	tr := &testTracer{}
+	WithTracer(tr)(c)
+
+	ctx := context.TODO()
+	dt := []byte("traced")
+	require.NoError(t, c.Save(ctx, "traced", bytes.NewReader(dt), int64(len(dt))))
+	ce, err := c.Load(ctx, "traced")
+	require.NoError(t, err)
+	require.NoError(t, ce.Download(ctx, &bytes.Buffer{}))
+
+	var names []string
+	for _, s := range tr.spans {
+		require.True(t, s.ended, s.name)
+		require.NoError(t, s.err, s.name)
+		names = append(names, s.name)
+	}
+	require.Equal(t, []string{
+		"actionscache.Save",
+		"actionscache.reserve",
+		"actionscache.uploadChunk",
+		"actionscache.commit",
+		"actionscache.Load",
+		"actionscache.Download",
+	}, names)
+	require.Equal(t, 1, tr.spans[1].fields["cache.id"])
+	require.Equal(t, int64(len(dt)), tr.spans[3].fields["cache.bytes"])
+	require.Equal(t, true, tr.spans[4].fields["cache.hit"])
+	require.Equal(t, int64(len(dt)), tr.spans[5].fields["cache.bytes"])
+}
@@ -0,0 +1,142 @@
+package actionscache
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// UploadIdleTimeout is the default time a chunk or blob upload may send no
+// data and receive no response before the request is aborted with
+// ErrUploadStalled and sent again. It detects half-dead connections that
+// would otherwise block the upload until the TCP timeout of the OS. Zero
+// disables it.
+var UploadIdleTimeout time.Duration
+
+// WithUploadIdleTimeout sets the time a chunk or blob upload may make no
+// progress before it is aborted and retried.
+func WithUploadIdleTimeout(d time.Duration) Opt {
+	return func(c *Cache) {
+		c.UploadIdleTimeout = d
+	}
+}
+
+func (c *Cache) uploadIdleTimeout() time.Duration {
+	if c != nil && c.UploadIdleTimeout > 0 {
+		return c.UploadIdleTimeout
+	}
+	return UploadIdleTimeout
+}
+
+// isUploadRequest reports if req sends a chunk of the v1 API or a blob or
+// block to storage.
+func isUploadRequest(req *http.Request) bool {
+	switch endpointOf(req.Context()) {
+	case endpointUpload:
+		return true
+	case endpointBlob:
+		return req.Method == http.MethodPut
+	}
+	return false
+}
+
+// sendUpload sends req with do and cancels it once its body was not read
+// and no response arrived for the upload idle timeout. The request is then
+// failed with an error that is retried like other network errors.
+func (c *Cache) sendUpload(req *http.Request, do func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	timeout := c.uploadIdleTimeout()
+	if timeout <= 0 || req.Body == nil || req.Body == http.NoBody || !isUploadRequest(req) {
+		return do(req)
+	}
+	ctx, cancel := context.WithCancel(req.Context())
+	wctx, stop := context.WithCancel(ctx)
+	w := &uploadWatch{timeout: timeout, clock: c.clock(), cancel: cancel}
+	w.touch()
+	go w.watch(wctx)
+
+	r := req.WithContext(ctx)
+	r.Body = &watchedBody{ReadCloser: req.Body, w: w}
+	resp, err := do(r)
+	stop()
+	if err != nil {
+		cancel()
+		if w.isStalled() {
+			return nil, &uploadStalledError{timeout: timeout}
+		}
+		return nil, err
+	}
+	// the response body is read with the canceled context after Close
+	resp.Body = &idleBody{ctx: ctx, body: resp.Body, clock: c.clock(), cancel: cancel}
+	return resp, nil
+}
+
+// uploadWatch cancels a request that makes no progress for timeout.
+type uploadWatch struct {
+	timeout time.Duration
+	clock   Clock
+	cancel  func()
+	stalled int32
+	// last is the time the body was last read in UnixNano
+	last int64
+}
+
+func (w *uploadWatch) touch() {
+	atomic.StoreInt64(&w.last, w.clock.Now().UnixNano())
+}
+
+func (w *uploadWatch) isStalled() bool {
+	return atomic.LoadInt32(&w.stalled) == 1
+}
+
+// watch cancels the request once its body was not read for timeout. It
+// returns when ctx is done.
+func (w *uploadWatch) watch(ctx context.Context) {
+	d := w.timeout
+	for {
+		if err := w.clock.Sleep(ctx, d); err != nil {
+			return
+		}
+		idle := w.clock.Now().Sub(time.Unix(0, atomic.LoadInt64(&w.last)))
+		if idle >= w.timeout {
+			atomic.StoreInt32(&w.stalled, 1)
+			w.cancel()
+			return
+		}
+		d = w.timeout - idle
+	}
+}
+
+// watchedBody is the body of an upload request that reports its reads to
+// an uploadWatch.
+type watchedBody struct {
+	io.ReadCloser
+	w *uploadWatch
+}
+
+func (b *watchedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.w.touch()
+	}
+	return n, err
+}
+
+// uploadStalledError is returned for a request canceled by its
+// uploadWatch. It is a net.Error so the request is retried.
+type uploadStalledError struct {
+	timeout time.Duration
+}
+
+func (e *uploadStalledError) Error() string {
+	return fmt.Sprintf("%v: no progress for %v", ErrUploadStalled, e.timeout)
+}
+
+func (e *uploadStalledError) Is(target error) bool {
+	return target == ErrUploadStalled
+}
+
+func (e *uploadStalledError) Timeout() bool   { return true }
+func (e *uploadStalledError) Temporary() bool { return true }
@@ -0,0 +1,78 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadIdleTimeout(t *testing.T) {
+	ts := newTestServer(t)
+	var stalls int32
+	hang := int32(1)
+	h := ts.Config.Handler
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upload := r.Method == "PATCH" || r.Method == "PUT" && strings.HasPrefix(r.URL.Path, "/upload/")
+		if upload && atomic.LoadInt32(&hang) > 0 {
+			// accept the chunk and never respond
+			atomic.AddInt32(&hang, -1)
+			atomic.AddInt32(&stalls, 1)
+			io.Copy(ioutil.Discard, r.Body)
+			<-r.Context().Done()
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+
+	ctx := context.TODO()
+	dt := []byte("foobar")
+	for _, v2 := range []bool{false, true} {
+		c := ts.newCache(t)
+		key := "upload-idle-v1"
+		if v2 {
+			c = ts.newCacheV2(t)
+			key = "upload-idle-v2"
+		}
+		WithUploadIdleTimeout(50 * time.Millisecond)(c)
+		c.RetryPolicy = &RetryPolicy{MaxAttempts: 2}
+
+		atomic.StoreInt32(&hang, 1)
+		atomic.StoreInt32(&stalls, 0)
+		require.NoError(t, c.Save(ctx, key, bytes.NewReader(dt), int64(len(dt))))
+		require.Equal(t, int32(1), atomic.LoadInt32(&stalls))
+		ce, err := c.Load(ctx, key)
+		require.NoError(t, err)
+		require.NotNil(t, ce)
+		buf := &bytes.Buffer{}
+		require.NoError(t, ce.Download(ctx, buf))
+		require.Equal(t, dt, buf.Bytes())
+	}
+
+	oldRetries := MaxChunkRetries
+	MaxChunkRetries = 0
+	defer func() { MaxChunkRetries = oldRetries }()
+	c := ts.newCache(t)
+	WithUploadIdleTimeout(50 * time.Millisecond)(c)
+	c.RetryPolicy = &RetryPolicy{MaxAttempts: 1}
+	atomic.StoreInt32(&hang, 1)
+	err := c.Save(ctx, "upload-idle-fail", bytes.NewReader(dt), int64(len(dt)))
+	require.ErrorIs(t, err, ErrUploadStalled)
+}
+
+func TestKeepAlive(t *testing.T) {
+	require.Equal(t, KeepAlive, (&Cache{}).keepAlive())
+	c := &Cache{}
+	WithKeepAlive(-1)(c)
+	require.Equal(t, time.Duration(-1), c.keepAlive())
+	tr := c.httpClient().Transport.(*http.Transport)
+	require.True(t, tr.ForceAttemptHTTP2)
+	require.NotNil(t, tr.DialContext)
+}
@@ -0,0 +1,69 @@
+package actionscache
+
+import (
+	"context"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// MaxUploadMemory is the default number of chunk bytes that the saves of a
+// Cache may buffer or have in flight at the same time. Zero disables the
+// limit.
+var MaxUploadMemory int64
+
+// WithMaxUploadMemory limits the chunk bytes that all saves of the Cache
+// buffer or upload at the same time to n, so the memory used by many
+// concurrent saves with large chunks stays bounded. Chunks wait for the
+// uploads of others to finish once the limit is reached.
+func WithMaxUploadMemory(n int64) Opt {
+	return func(c *Cache) {
+		c.MaxUploadMemory = n
+	}
+}
+
+func (c *Cache) maxUploadMemory() int64 {
+	if c != nil && c.MaxUploadMemory > 0 {
+		return c.MaxUploadMemory
+	}
+	return MaxUploadMemory
+}
+
+// uploadBudget returns the semaphore of the upload memory of c, nil if it
+// is not limited. The limit is read once.
+func (c *Cache) uploadBudget() (*semaphore.Weighted, int64) {
+	c.budgetOnce.Do(func() {
+		if n := c.maxUploadMemory(); n > 0 {
+			c.budget = semaphore.NewWeighted(n)
+			c.budgetSize = n
+		}
+	})
+	return c.budget, c.budgetSize
+}
+
+type uploadMemoryHeldKey struct{}
+
+// withUploadMemoryHeld marks chunks uploaded with ctx as covered by memory
+// acquired already, eg. for the buffer of a SaveWriter.
+func withUploadMemoryHeld(ctx context.Context) context.Context {
+	return context.WithValue(ctx, uploadMemoryHeldKey{}, true)
+}
+
+// acquireUploadMemory waits until n bytes of the upload memory of c are
+// free. A chunk larger than the limit waits for all of it. The returned
+// function releases the memory.
+func (c *Cache) acquireUploadMemory(ctx context.Context, n int64) (func(), error) {
+	if c == nil || ctx.Value(uploadMemoryHeldKey{}) != nil {
+		return func() {}, nil
+	}
+	sem, size := c.uploadBudget()
+	if sem == nil || n <= 0 {
+		return func() {}, nil
+	}
+	if n > size {
+		n = size
+	}
+	if err := sem.Acquire(ctx, n); err != nil {
+		return nil, err
+	}
+	return func() { sem.Release(n) }, nil
+}
@@ -0,0 +1,79 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestMaxUploadMemory(t *testing.T) {
+	ts := newTestServer(t)
+	var inflight, maxInflight int64
+	h := ts.Config.Handler
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PATCH" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		n := atomic.AddInt64(&inflight, r.ContentLength)
+		for {
+			m := atomic.LoadInt64(&maxInflight)
+			if n <= m || atomic.CompareAndSwapInt64(&maxInflight, m, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		h.ServeHTTP(w, r)
+		atomic.AddInt64(&inflight, -r.ContentLength)
+	})
+
+	c := ts.newCache(t)
+	c.UploadChunkSize = 8
+	c.UploadConcurrency = 4
+	WithMaxUploadMemory(16)(c)
+
+	ctx := context.TODO()
+	dt := bytes.Repeat([]byte("foobar"), 10)
+	var eg errgroup.Group
+	for _, key := range []string{"mem-1", "mem-2"} {
+		key := key
+		eg.Go(func() error {
+			return c.Save(ctx, key, bytes.NewReader(dt), int64(len(dt)))
+		})
+	}
+	eg.Go(func() error {
+		w, err := c.SaveWriter(ctx, "mem-3")
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, bytes.NewReader(dt)); err != nil {
+			return err
+		}
+		return w.Close()
+	})
+	require.NoError(t, eg.Wait())
+	require.LessOrEqual(t, atomic.LoadInt64(&maxInflight), int64(16))
+	require.Greater(t, atomic.LoadInt64(&maxInflight), int64(0))
+	for _, key := range []string{"mem-1", "mem-2", "mem-3"} {
+		require.Equal(t, dt, ts.entry(key).Data)
+	}
+
+	// a chunk larger than the limit waits for all of it
+	release, err := c.acquireUploadMemory(ctx, 64)
+	require.NoError(t, err)
+	cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = c.acquireUploadMemory(cctx, 1)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	release()
+	release, err = c.acquireUploadMemory(withUploadMemoryHeld(cctx), 64)
+	require.NoError(t, err)
+	release()
+}
@@ -0,0 +1,160 @@
+package actionscache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// WithVersion sets the salt of the cache version, eg. to separate entries of
+// differently shaped payloads saved under the same keys.
+func WithVersion(salt string) Opt {
+	return func(c *Cache) {
+		c.VersionSalt = salt
+	}
+}
+
+// WithVersionPaths includes paths in the cache version the way actions/toolkit
+// does with the paths of a cache.
+func WithVersionPaths(paths []string) Opt {
+	return func(c *Cache) {
+		c.VersionPaths = append([]string(nil), paths...)
+	}
+}
+
+// LoadVersion overrides the version salt of the Cache for one Load, eg. to
+// read entries of one of several cache families managed by the same Cache.
+// Download, DownloadAt and Metadata of the returned Entry use it as well.
+func LoadVersion(salt string) LoadOpt {
+	return func(o *loadOpt) {
+		o.salt = &salt
+	}
+}
+
+// SaveVersion overrides the version salt of the Cache for one save. Entries
+// saved with it are only found by loads with the same LoadVersion.
+func SaveVersion(salt string) SaveOpt {
+	return func(o *saveOpt) {
+		o.salt = &salt
+	}
+}
+
+// LoadWithVersion is LoadWithOpts with LoadVersion(salt).
+func (c *Cache) LoadWithVersion(ctx context.Context, salt string, keys ...string) (*Entry, error) {
+	return c.LoadWithOpts(ctx, keys, LoadVersion(salt))
+}
+
+// SaveWithVersion is Save with SaveVersion(salt).
+func (c *Cache) SaveWithVersion(ctx context.Context, salt, key string, ra io.ReaderAt, size int64, opts ...SaveOpt) error {
+	return c.Save(ctx, key, ra, size, append(opts, SaveVersion(salt))...)
+}
+
+type versionSaltKey struct{}
+
+// withVersionSalt overrides the version salt of the Cache for the
+// operations started from the returned context.
+func withVersionSalt(ctx context.Context, salt string) context.Context {
+	return context.WithValue(ctx, versionSaltKey{}, salt)
+}
+
+func versionSalt(ctx context.Context) (string, bool) {
+	salt, ok := ctx.Value(versionSaltKey{}).(string)
+	return salt, ok
+}
+
+// withVersion returns ctx with the version salt ce was loaded with.
+func (ce *Entry) withVersion(ctx context.Context) context.Context {
+	if ce.salt == nil {
+		return ctx
+	}
+	return withVersionSalt(ctx, *ce.salt)
+}
+
+// withLoadVersion returns ctx with the version salt set by opts, if any.
+func withLoadVersion(ctx context.Context, opts []LoadOpt) context.Context {
+	var lo loadOpt
+	for _, o := range opts {
+		o(&lo)
+	}
+	if lo.salt == nil {
+		return ctx
+	}
+	return withVersionSalt(ctx, *lo.salt)
+}
+
+// withSaveVersion returns ctx with the version salt set by opts, if any.
+func withSaveVersion(ctx context.Context, opts []SaveOpt) context.Context {
+	var so saveOpt
+	for _, o := range opts {
+		o(&so)
+	}
+	if so.salt == nil {
+		return ctx
+	}
+	return withVersionSalt(ctx, *so.salt)
+}
+
+// keyVersion returns the cache version of key for the operations of ctx,
+// using the salt set by LoadVersion or SaveVersion instead of the salt of
+// c.
+func (c *Cache) keyVersion(ctx context.Context, key string) string {
+	if salt, ok := versionSalt(ctx); ok && c != nil {
+		return c.saltedVersion(key, salt)
+	}
+	return c.version(key)
+}
+
+// version returns the cache version of key. Versions include the paths,
+// compression, encryption key fingerprint, salt and hash of c so that
+// entries saved with different values never collide. Every part is labeled
+// and length-prefixed so that values can not be mistaken for each other.
+func (c *Cache) version(key string) string {
+	if c == nil {
+		return version(key)
+	}
+	return c.saltedVersion(key, c.VersionSalt)
+}
+
+func (c *Cache) saltedVersion(key, salt string) string {
+	var components []string
+	add := func(label, v string) {
+		components = append(components, label+"="+strconv.Itoa(len(v))+":"+v)
+	}
+	for _, p := range c.VersionPaths {
+		add("paths", p)
+	}
+	if name := c.compression(); name != "" {
+		add("compression", name)
+	}
+	if key := c.encryptionKey(); key != nil {
+		add("encryption", keyFingerprint(key))
+	}
+	if salt != "" {
+		add("salt", salt)
+	}
+	if name := c.hashName(); name != DefaultHash {
+		add("hash", name)
+	}
+	if len(components) == 0 {
+		return version(key)
+	}
+	h, err := c.newHash()
+	if err != nil {
+		// New refuses unregistered hashes, only Caches created otherwise
+		// get here
+		h = sha256.New()
+	}
+	h.Write([]byte("|go-actionscache-1.0|" + strings.Join(components, "|")))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func version(k string) string {
+	h := sha256.New()
+	// h.Write([]byte(k))
+	// upstream uses paths in version, we don't seem to have anything that is unique like this
+	h.Write([]byte("|go-actionscache-1.0"))
+	return hex.EncodeToString(h.Sum(nil))
+}
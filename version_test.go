@@ -0,0 +1,89 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersion(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+	require.Equal(t, version("foo"), c.version("foo"))
+
+	WithVersionPaths([]string{"/go/pkg/mod"})(c)
+	pathVersion := c.version("foo")
+	require.NotEqual(t, version("foo"), pathVersion)
+	WithVersion("v2")(c)
+	require.NotEqual(t, pathVersion, c.version("foo"))
+
+	// parts can not be shifted between paths and the salt
+	a := &Cache{VersionPaths: []string{"a|b"}}
+	b := &Cache{VersionPaths: []string{"a"}, VersionSalt: "b"}
+	require.NotEqual(t, a.version("foo"), b.version("foo"))
+	b = &Cache{VersionPaths: []string{"a", "b"}}
+	require.NotEqual(t, a.version("foo"), b.version("foo"))
+
+	ctx := context.TODO()
+	dt := []byte("modules")
+	require.NoError(t, c.Save(ctx, "mod", bytes.NewReader(dt), int64(len(dt))))
+
+	ce, err := c.Load(ctx, "mod")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+
+	other := ts.newCache(t)
+	WithVersionPaths([]string{"/go/pkg/mod"})(other)
+	ce, err = other.Load(ctx, "mod")
+	require.NoError(t, err)
+	require.Nil(t, ce)
+}
+
+func TestVersionOverride(t *testing.T) {
+	ts := newTestServer(t)
+	ctx := context.TODO()
+
+	for _, v2 := range []bool{false, true} {
+		c := ts.newCache(t)
+		key := "family"
+		if v2 {
+			c = ts.newCacheV2(t)
+			key = "family-v2"
+		}
+		WithVersion("client")(c)
+		c.LocalCacheDir = t.TempDir()
+
+		dt := []byte("linux")
+		require.NoError(t, c.SaveWithVersion(ctx, "linux", key, bytes.NewReader(dt), int64(len(dt))))
+
+		ce, err := c.Load(ctx, key)
+		require.NoError(t, err)
+		require.Nil(t, ce)
+		ce, err = c.LoadWithOpts(ctx, []string{key}, LoadVersion("darwin"))
+		require.NoError(t, err)
+		require.Nil(t, ce)
+
+		ce, err = c.LoadWithVersion(ctx, "linux", key)
+		require.NoError(t, err)
+		require.NotNil(t, ce)
+		var buf bytes.Buffer
+		require.NoError(t, ce.Download(ctx, &buf))
+		require.Equal(t, dt, buf.Bytes())
+		_, err = os.Stat(c.localPath(withVersionSalt(ctx, "linux"), key))
+		require.NoError(t, err)
+
+		// an empty override drops the salt of the Cache
+		plain := ts.newCache(t)
+		if v2 {
+			plain = ts.newCacheV2(t)
+		}
+		dt = []byte("plain")
+		require.NoError(t, plain.Save(ctx, key, bytes.NewReader(dt), int64(len(dt))))
+		ce, err = c.LoadWithVersion(ctx, "", key)
+		require.NoError(t, err)
+		require.NotNil(t, ce)
+	}
+}
@@ -0,0 +1,54 @@
+package actionscache
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// WarmUp makes New and TryEnv resolve and connect to the cache service in
+// the background so the first Load does not pay for DNS and TLS setup.
+var WarmUp = false
+
+// WithWarmUp makes New and TryEnv warm up the connection to the cache
+// service of the Cache, like the package WarmUp.
+func WithWarmUp() Opt {
+	return func(c *Cache) {
+		c.WarmUpOnCreate = true
+	}
+}
+
+func (c *Cache) warmUp() bool {
+	return WarmUp || c.WarmUpOnCreate
+}
+
+// startWarmUp warms c up in the background if enabled. It is called once
+// the protocol and URL of c are final, as the warm-up reads them.
+func (c *Cache) startWarmUp() {
+	if c.warmUp() {
+		go c.WarmUp(context.Background())
+	}
+}
+
+// WarmUp resolves and connects to the cache service and leaves the
+// connection open for reuse by later requests. Blob storage hosts are not
+// known before the first Load or Save and are not warmed up.
+func (c *Cache) WarmUp(ctx context.Context) error {
+	req, err := http.NewRequest("HEAD", c.URL, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	resp, err := c.doHTTP(req.WithContext(ctx))
+	if err != nil {
+		c.warn(ctx, "warm-up failed", F("url", c.URL), F("error", err))
+		return errors.WithStack(err)
+	}
+	// any response means the connection is established, drain it so it
+	// can be reused
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,32 @@
+package actionscache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWarmUp(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+
+	require.NoError(t, c.WarmUp(context.TODO()))
+	require.Equal(t, 1, ts.count("HEAD /"))
+
+	c.URL = "http://127.0.0.1:0/"
+	require.Error(t, c.WarmUp(context.TODO()))
+}
+
+func TestWithWarmUp(t *testing.T) {
+	ts := newTestServer(t)
+	token := testToken(t, Scope{Scope: "refs/heads/main", Permission: PermissionRead | PermissionWrite})
+	// the warm-up starts once the protocol is set
+	c, err := NewV2(token, ts.URL+"/", WithWarmUp())
+	require.NoError(t, err)
+	require.Equal(t, "v2", c.Protocol())
+	require.Eventually(t, func() bool {
+		return ts.count("HEAD /") == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,281 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// SaveWriter reserves key and returns a writer that uploads data in chunks
+// of the upload chunk size as it is written. The entry is committed on Close.
+// Up to the upload concurrency chunks are buffered and uploaded at the same time.
+func (c *Cache) SaveWriter(ctx context.Context, key string, opts ...SaveOpt) (io.WriteCloser, error) {
+	ctx = withSaveVersion(ctx, opts)
+	ctx, done := c.startOp(ctx, "save", key)
+	w, err := c.checksumWriter(ctx, key, opts)
+	if err != nil {
+		return nil, done(0, false, err)
+	}
+	return &opLogWriter{w: w, done: done}, nil
+}
+
+func (c *Cache) saveWriter(ctx context.Context, key string, opts []SaveOpt) (io.WriteCloser, error) {
+	if err := c.validateKeys(key); err != nil {
+		return nil, err
+	}
+	so, err := c.saveOpts(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	if c.DryRun {
+		c.logDryRun(ctx, key, -1, so)
+		return nopWriteCloser{ioutil.Discard}, nil
+	}
+	if so.headers != nil {
+		ctx = withHeaderSink(ctx, so.headers)
+	}
+	if c.v2 {
+		return nil, errors.Errorf("streaming saves are not supported by the v2 cache service")
+	}
+	if c.SingleChunkUploads {
+		return nil, errors.Errorf("streaming saves are not supported with single chunk uploads")
+	}
+	if so.ifMissing {
+		ok, err := c.exists(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return nopWriteCloser{ioutil.Discard}, nil
+		}
+		opts = append(opts, existenceChecked)
+	}
+	if enc := c.encoding(); !enc.plain() && !so.raw {
+		w, err := c.saveWriter(ctx, key, append(opts, noEncode))
+		if err != nil {
+			return nil, err
+		}
+		ew, err := enc.newWriter(w)
+		if err != nil {
+			if a, ok := w.(interface{ abort(error) }); ok {
+				a.abort(err)
+			}
+			return nil, err
+		}
+		return &encodedWriter{ew: ew, w: w}, nil
+	}
+	ctx, finishStats := c.withSaveStats(ctx, so.result)
+	id, err := c.reserve(ctx, key)
+	if err != nil {
+		finishStats()
+		if so.skipExisting(err) {
+			c.info(ctx, "save cache: already exists, skipping", F("key", key))
+			return nopWriteCloser{ioutil.Discard}, nil
+		}
+		return nil, err
+	}
+	r := c.trackReserve(id, key)
+	ctx = c.withProgress(ctx, "save", key, -1)
+	eg, egctx := errgroup.WithContext(ctx)
+	return &saveWriter{
+		c:     c,
+		ctx:   ctx,
+		egctx: egctx,
+		eg:    eg,
+		key:   key,
+		id:    id,
+		r:     r,
+		sem:   make(chan struct{}, c.uploadConcurrency()),
+		chunk: c.uploadChunkSize(),
+		so:    so,
+		stats: finishStats,
+	}, nil
+}
+
+type saveWriter struct {
+	c     *Cache
+	ctx   context.Context
+	egctx context.Context
+	eg    *errgroup.Group
+	key   string
+	id    int
+	r     *ReservationInfo
+	sem   chan struct{}
+	chunk int
+	so    *saveOpt
+	stats func()
+
+	buf    []byte
+	offset int64
+	closed bool
+	// release frees the upload memory of buf
+	release func()
+	// failed is the error of a write that makes Close fail
+	failed error
+
+	mu    sync.Mutex
+	acked rangeSet
+}
+
+func (w *saveWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.Errorf("write to closed cache writer")
+	}
+	if w.failed != nil {
+		return 0, w.failed
+	}
+	if err := w.so.checkSize(w.key, w.offset+int64(len(w.buf)+len(p))); err != nil {
+		w.failed = err
+		return 0, err
+	}
+	n := 0
+	for len(p) > 0 {
+		if err := w.egctx.Err(); err != nil {
+			return n, w.wait(err)
+		}
+		if w.buf == nil {
+			release, err := w.c.acquireUploadMemory(w.egctx, int64(w.chunk))
+			if err != nil {
+				return n, w.wait(err)
+			}
+			w.buf, w.release = getChunkBuffer(w.chunk), release
+		}
+		l := cap(w.buf) - len(w.buf)
+		if l > len(p) {
+			l = len(p)
+		}
+		w.buf = append(w.buf, p[:l]...)
+		p = p[l:]
+		n += l
+		if len(w.buf) == cap(w.buf) {
+			if err := w.flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (w *saveWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	select {
+	case w.sem <- struct{}{}:
+	case <-w.egctx.Done():
+		return w.wait(w.egctx.Err())
+	}
+	buf, off, release := w.buf, w.offset, w.release
+	w.buf, w.release = nil, nil
+	w.offset += int64(len(buf))
+	w.eg.Go(func() error {
+		defer func() { <-w.sem }()
+		defer release()
+		if err := w.c.uploadChunk(withUploadMemoryHeld(w.egctx), w.id, &offsetReaderAt{bytes.NewReader(buf), off}, off, int64(len(buf))); err != nil {
+			// the transport may still read the body of a failed request
+			return err
+		}
+		putChunkBuffer(buf)
+		w.mu.Lock()
+		w.acked.add(off, off+int64(len(buf)))
+		w.mu.Unlock()
+		reportProgress(w.egctx, int64(len(buf)))
+		return nil
+	})
+	return nil
+}
+
+// wait returns the upload error that canceled the writer, falling back to err.
+func (w *saveWriter) wait(err error) error {
+	if err2 := w.eg.Wait(); err2 != nil {
+		return err2
+	}
+	return err
+}
+
+func (w *saveWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	defer w.stats()
+	if err := w.close(); err != nil {
+		w.freeBuffer()
+		err = interrupted(w.ctx, err, w.key, w.id, w.offset, &w.acked)
+		w.c.trackOrphan(w.ctx, w.r, err)
+		w.c.abandonFailed(w.ctx, *w.r, nil)
+		return err
+	}
+	recordCacheID(w.ctx, int64(w.id))
+	w.c.trackCommit(w.r)
+	w.c.clearMisses(w.key)
+	if err := w.c.verifyCommit(w.ctx, w.key, w.offset); err != nil {
+		return err
+	}
+	w.so.saved(w.key)
+	return nil
+}
+
+// abort stops the writer without committing after the data to write
+// could not be read.
+func (w *saveWriter) abort(err error) {
+	if w.closed {
+		return
+	}
+	w.closed = true
+	defer w.stats()
+	w.eg.Wait()
+	w.freeBuffer()
+	w.c.trackOrphan(w.ctx, w.r, err)
+	w.c.abandonFailed(w.ctx, *w.r, nil)
+}
+
+// freeBuffer returns the buffer that was not uploaded and its memory.
+func (w *saveWriter) freeBuffer() {
+	putChunkBuffer(w.buf)
+	w.buf = nil
+	if w.release != nil {
+		w.release()
+		w.release = nil
+	}
+}
+
+func (w *saveWriter) close() error {
+	if w.failed != nil {
+		w.eg.Wait()
+		return w.failed
+	}
+	if err := w.flush(); err != nil {
+		return err
+	}
+	if err := w.eg.Wait(); err != nil {
+		return err
+	}
+	if err := verifyUploaded(w.id, &w.acked, w.offset); err != nil {
+		return err
+	}
+	return emptyRejected(w.key, w.offset, w.c.commitEntry(w.ctx, w.key, w.id, w.offset))
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// offsetReaderAt exposes a buffered chunk at its position in the payload.
+type offsetReaderAt struct {
+	ra   io.ReaderAt
+	base int64
+}
+
+func (r *offsetReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	return r.ra.ReadAt(p, off-r.base)
+}
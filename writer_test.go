@@ -0,0 +1,42 @@
+package actionscache
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaveWriter(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.newCache(t)
+
+	oldSize := UploadChunkSize
+	UploadChunkSize = 4
+	defer func() { UploadChunkSize = oldSize }()
+
+	ctx := context.TODO()
+	w, err := c.SaveWriter(ctx, "foo")
+	require.NoError(t, err)
+
+	pr, pw := io.Pipe()
+	go func() {
+		for _, s := range []string{"foo", "barbaz", "", "0123456789", "x"} {
+			pw.Write([]byte(s))
+		}
+		pw.Close()
+	}()
+	_, err = io.Copy(w, pr)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.Equal(t, 5, ts.count("PATCH "))
+
+	ce, err := c.Load(ctx, "foo")
+	require.NoError(t, err)
+	require.NotNil(t, ce)
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, ce.Download(ctx, buf))
+	require.Equal(t, "foobarbaz0123456789x", buf.String())
+}